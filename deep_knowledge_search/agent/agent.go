@@ -3,9 +3,12 @@ package agent
 
 import (
 	"deepknowledgesearch/config"
+	"deepknowledgesearch/embedding"
 	"deepknowledgesearch/llm"
 	"deepknowledgesearch/mcp"
 	"fmt"
+	"path/filepath"
+	"time"
 )
 
 // Init initializes the Agent module (legacy compatibility)
@@ -32,10 +35,17 @@ func InitWithConfig(cfg *config.AppConfig) error {
 	for _, m := range cfg.Models {
 		llmModels = append(llmModels, llm.ModelConfig{
 			Name:        m.Name,
+			Provider:    m.Provider,
 			APIKey:      m.APIKey,
 			BaseURL:     m.BaseURL,
 			Model:       m.Model,
 			Temperature: m.Temperature,
+			MaxTokens:   m.MaxTokens,
+			InputPrice:  m.InputPrice,
+			OutputPrice: m.OutputPrice,
+
+			MaxConcurrency:   m.MaxConcurrency,
+			StructuredOutput: m.StructuredOutput,
 		})
 	}
 
@@ -43,11 +53,197 @@ func InitWithConfig(cfg *config.AppConfig) error {
 	if err := llm.InitWithConfig(llmModels, cfg.DefaultModel); err != nil {
 		return fmt.Errorf("failed to initialize LLM: %w", err)
 	}
+	llm.SetPhaseModels(cfg.PhaseModels)
+	llm.SetFallbackModels(cfg.FallbackModels)
+
+	// 默认任务预算
+	SetDefaultBudget(BudgetConfig{
+		MaxTokens:      cfg.Budget.MaxTokens,
+		MaxCost:        cfg.Budget.MaxCost,
+		MaxDurationSec: cfg.Budget.MaxDurationSec,
+		MaxLLMCalls:    cfg.Budget.MaxLLMCalls,
+	})
+
+	SetDefaultMaxConcurrency(cfg.MaxConcurrency)
+
+	// 网络搜索后端
+	var backends []mcp.SearchBackend
+	for _, b := range cfg.Search.Backends {
+		backend, err := mcp.NewSearchBackend(mcp.SearchBackendConfig{
+			Type:        b.Type,
+			BaseURL:     b.BaseURL,
+			APIKey:      b.APIKey,
+			FixtureFile: b.FixtureFile,
+			Timeout:     time.Duration(b.TimeoutSec) * time.Second,
+		})
+		if err != nil {
+			fmt.Printf("[Agent] ⚠️ 搜索后端 %s 配置无效: %v\n", b.Type, err)
+			continue
+		}
+		backends = append(backends, backend)
+	}
+	mcp.SetSearchBackends(backends, cfg.Search.MaxResults)
+
+	// 网页抓取
+	mcp.SetFetchConfig(mcp.FetchConfig{
+		Allow:        cfg.Fetch.Allow,
+		Deny:         cfg.Fetch.Deny,
+		MaxBytes:     cfg.Fetch.MaxBytes,
+		Timeout:      time.Duration(cfg.Fetch.TimeoutSec) * time.Second,
+		ExcerptChars: cfg.Fetch.ExcerptChars,
+		UserAgent:    cfg.Fetch.UserAgent,
+		IgnoreRobots: cfg.Fetch.IgnoreRobots,
+	})
+
+	// 本地文档库
+	docPaths := append([]string(nil), cfg.LocalDocs.Paths...)
+	if cfg.LocalDocs.IncludeOutput {
+		docPaths = append(docPaths, config.GetOutputDir())
+	}
+	mcp.SetLocalDocsConfig(mcp.LocalDocsConfig{
+		Paths:        docPaths,
+		Extensions:   cfg.LocalDocs.Extensions,
+		ExcludeDirs:  cfg.LocalDocs.ExcludeDirs,
+		PassageLines: cfg.LocalDocs.PassageLines,
+		MaxResults:   cfg.LocalDocs.MaxResults,
+		IndexOnStart: cfg.LocalDocs.IndexOnStart,
+	})
+
+	// 以往调研语义检索
+	initPriorResearch(cfg.Embedding)
+
+	// 外部 MCP server（重新加载配置时先断开已有连接）
+	var servers []mcp.ServerConfig
+	for _, s := range cfg.MCPServers {
+		if s.Disabled {
+			continue
+		}
+		servers = append(servers, mcp.ServerConfig{
+			Name:    s.Name,
+			Command: s.Command,
+			Args:    s.Args,
+			Env:     s.Env,
+			Dir:     s.Dir,
+			URL:     s.URL,
+			Headers: s.Headers,
+			Timeout: time.Duration(s.TimeoutSec) * time.Second,
+		})
+	}
+	mcp.SetExternalServers(servers)
+
+	// 代码执行
+	interpreters := make(map[string]mcp.Interpreter, len(cfg.RunCode.Interpreters))
+	for lang, in := range cfg.RunCode.Interpreters {
+		interpreters[lang] = mcp.Interpreter{Command: in.Command, Args: in.Args, Ext: in.Ext, Mounts: in.Mounts}
+	}
+	mcp.SetRunCodeConfig(mcp.RunCodeConfig{
+		Interpreters:   interpreters,
+		Timeout:        time.Duration(cfg.RunCode.TimeoutSec) * time.Second,
+		CPUTime:        time.Duration(cfg.RunCode.CPUSec) * time.Second,
+		MemoryMB:       cfg.RunCode.MemoryMB,
+		MaxOutputBytes: cfg.RunCode.MaxOutputBytes,
+		MaxFileBytes:   int64(cfg.RunCode.MaxFileMB) << 20,
+		MaxProcesses:   cfg.RunCode.MaxProcesses,
+	})
+	SetDefaultRunCode(cfg.RunCode.Enabled)
+
+	// 工具调用权限策略（无效时拒绝初始化，避免在没有约束的情况下执行工具）
+	var rules []mcp.PolicyRule
+	for _, r := range cfg.ToolPolicy.Rules {
+		rules = append(rules, mcp.PolicyRule{Tool: r.Tool, Args: r.Args, Action: mcp.PolicyAction(r.Action)})
+	}
+	if err := mcp.SetToolPolicy(mcp.ToolPolicy{
+		Rules:           rules,
+		Default:         mcp.PolicyAction(cfg.ToolPolicy.Default),
+		ApprovalTimeout: time.Duration(cfg.ToolPolicy.ApprovalTimeoutSec) * time.Second,
+	}); err != nil {
+		return fmt.Errorf("工具权限策略无效: %w", err)
+	}
+	if !ToolApprovalsEnabled() && hasAskRule(cfg.ToolPolicy) {
+		fmt.Println("[Agent] ⚠️ 工具策略包含 ask 规则，但没有人工批准渠道（Web Dashboard 未启用），这些调用将被直接拒绝")
+	}
+
+	// 工具调用循环限制
+	llm.SetMaxToolIterations(cfg.ToolLoop.MaxIterations)
+	SetDefaultMaxToolCalls(cfg.ToolLoop.MaxToolCalls)
+	toolTimeouts := make(map[string]time.Duration, len(cfg.ToolLoop.Timeouts))
+	for name, sec := range cfg.ToolLoop.Timeouts {
+		toolTimeouts[name] = time.Duration(sec) * time.Second
+	}
+	mcp.SetToolTimeouts(time.Duration(cfg.ToolLoop.TimeoutSec)*time.Second, toolTimeouts)
+	mcp.SetMaxToolResultChars(cfg.ToolLoop.MaxResultChars)
+
+	// 响应缓存
+	llm.SetCacheDir(cfg.CacheDir)
+	cacheMode, err := llm.ParseCacheMode(cfg.LLMCache)
+	if err != nil {
+		fmt.Printf("[Agent] ⚠️ %v，已关闭缓存\n", err)
+	}
+	SetDefaultCacheMode(cacheMode)
 
 	fmt.Println("[Agent] Initialized")
 	return nil
 }
 
+// hasAskRule 策略中是否有需要人工批准的规则或默认处理方式
+func hasAskRule(policy config.ToolPolicyConfig) bool {
+	if mcp.PolicyAction(policy.Default) == mcp.PolicyAsk {
+		return true
+	}
+	for _, r := range policy.Rules {
+		if mcp.PolicyAction(r.Action) == mcp.PolicyAsk {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPriorResearchInject 新任务默认注入上下文的以往调研段落数
+const DefaultPriorResearchInject = 3
+
+// initPriorResearch 按配置创建向量库索引，注册 recallPriorResearch 工具并设置自动注入的段落数
+func initPriorResearch(cfg config.EmbeddingConfig) {
+	mcp.SetRecallIndex(nil, 0, 0)
+	SetDefaultPriorResearch(0)
+	if cfg.Provider == "" {
+		return
+	}
+
+	provider, err := embedding.NewProvider(embedding.Config{
+		Provider:   cfg.Provider,
+		BaseURL:    cfg.BaseURL,
+		APIKey:     cfg.APIKey,
+		Model:      cfg.Model,
+		Dimensions: cfg.Dimensions,
+		Timeout:    time.Duration(cfg.TimeoutSec) * time.Second,
+	})
+	if err != nil {
+		fmt.Printf("[Agent] ⚠️ embedding 配置无效: %v\n", err)
+		return
+	}
+
+	storeFile := cfg.StoreFile
+	if storeFile == "" {
+		storeFile = filepath.Join("cache", "embeddings.json")
+	}
+	paths := cfg.Paths
+	if len(paths) == 0 {
+		paths = []string{config.GetOutputDir()}
+	}
+	index, err := embedding.NewIndex(provider, storeFile, paths, embedding.SyncOptions{ChunkChars: cfg.ChunkChars})
+	if err != nil {
+		fmt.Printf("[Agent] ⚠️ 打开向量库失败: %v\n", err)
+		return
+	}
+	mcp.SetRecallIndex(index, cfg.TopK, cfg.MinScore)
+
+	inject := DefaultPriorResearchInject
+	if cfg.InjectTopK != nil {
+		inject = *cfg.InjectTopK
+	}
+	SetDefaultPriorResearch(inject)
+}
+
 // ExecutorRegistrationFunc 执行器注册函数类型
 type ExecutorRegistrationFunc func(taskID string, executor interface{})
 
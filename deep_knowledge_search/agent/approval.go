@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrApprovalNotFound 批准请求不存在或已处理
+var ErrApprovalNotFound = errors.New("批准请求不存在或已处理")
+
+// 是否有人工批准渠道（Web Dashboard）
+var (
+	toolApprovalsEnabled   bool
+	toolApprovalsEnabledMu sync.RWMutex
+)
+
+// SetToolApprovalsEnabled 设置是否有人工批准渠道（Web Dashboard 启动后由 main 设置）
+// 没有渠道时新任务不接受批准请求，ask 规则的调用直接拒绝，不等待批准超时
+func SetToolApprovalsEnabled(enabled bool) {
+	toolApprovalsEnabledMu.Lock()
+	defer toolApprovalsEnabledMu.Unlock()
+	toolApprovalsEnabled = enabled
+}
+
+// ToolApprovalsEnabled 是否有人工批准渠道
+func ToolApprovalsEnabled() bool {
+	toolApprovalsEnabledMu.RLock()
+	defer toolApprovalsEnabledMu.RUnlock()
+	return toolApprovalsEnabled
+}
+
+// ToolApproval 等待人工批准的工具调用
+type ToolApproval struct {
+	ID        string                 `json:"id"`
+	TaskID    string                 `json:"task_id"`
+	NodeID    string                 `json:"node_id"`
+	NodeTitle string                 `json:"node_title"`
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Rule      string                 `json:"rule"`
+	CreatedAt time.Time              `json:"created_at"`
+
+	answer chan approvalAnswer
+}
+
+// approvalAnswer 批准结果
+type approvalAnswer struct {
+	approved bool
+	reason   string
+}
+
+// ApprovalBroker 管理一个任务中等待批准的工具调用
+type ApprovalBroker struct {
+	taskID  string
+	mu      sync.Mutex
+	pending map[string]*ToolApproval
+}
+
+// NewApprovalBroker 创建批准管理器，taskID 为根节点 ID
+func NewApprovalBroker(taskID string) *ApprovalBroker {
+	return &ApprovalBroker{
+		taskID:  taskID,
+		pending: make(map[string]*ToolApproval),
+	}
+}
+
+// Request 登记批准请求并等待回复；等待期间节点状态为 awaiting_approval，ctx 结束时视为拒绝
+func (b *ApprovalBroker) Request(ctx context.Context, node *TaskNode, req mcp.ApprovalRequest) (bool, string) {
+	approval := &ToolApproval{
+		ID:        uuid.New().String()[:8],
+		TaskID:    b.taskID,
+		NodeID:    node.ID,
+		NodeTitle: node.Title,
+		Tool:      req.Tool,
+		Arguments: req.Arguments,
+		Rule:      req.Rule,
+		CreatedAt: time.Now(),
+		answer:    make(chan approvalAnswer, 1),
+	}
+	b.mu.Lock()
+	b.pending[approval.ID] = approval
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, approval.ID)
+		b.mu.Unlock()
+	}()
+
+	prevStatus := node.GetStatus()
+	node.SetStatus(NodeAwaitingApproval)
+	node.AddLog(LogInfo, "tool_policy", fmt.Sprintf("等待批准工具调用 %s (%s, 请求 %s): %s",
+		req.Tool, req.Rule, approval.ID, mcp.FormatArguments(req.Arguments, 200)))
+	Display.ToolApproval(node, approval)
+
+	var answer approvalAnswer
+	select {
+	case answer = <-approval.answer:
+	case <-ctx.Done():
+		answer = approvalAnswer{reason: ctx.Err().Error()}
+	}
+
+	if node.GetStatus() == NodeAwaitingApproval {
+		node.SetStatus(prevStatus)
+	}
+	Display.ToolApprovalResolved(node, approval, answer.approved, answer.reason)
+	return answer.approved, answer.reason
+}
+
+// Answer 批准或拒绝一个请求
+func (b *ApprovalBroker) Answer(id string, approved bool, reason string) error {
+	b.mu.Lock()
+	approval, ok := b.pending[id]
+	if ok {
+		delete(b.pending, id)
+	}
+	b.mu.Unlock()
+	if !ok {
+		return ErrApprovalNotFound
+	}
+	approval.answer <- approvalAnswer{approved: approved, reason: reason}
+	return nil
+}
+
+// Pending 等待批准的请求（按创建时间排序）
+func (b *ApprovalBroker) Pending() []*ToolApproval {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]*ToolApproval, 0, len(b.pending))
+	for _, approval := range b.pending {
+		list = append(list, approval)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// approvalData 批准请求的广播 / API 数据
+func approvalData(approval *ToolApproval) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         approval.ID,
+		"task_id":    approval.TaskID,
+		"node_id":    approval.NodeID,
+		"node_title": approval.NodeTitle,
+		"tool":       approval.Tool,
+		"arguments":  approval.Arguments,
+		"rule":       approval.Rule,
+		"created_at": approval.CreatedAt,
+	}
+}
+
+// approvalBrokerContextKey context 中批准管理器的键
+type approvalBrokerContextKey struct{}
+
+// withApprovalBroker 把批准管理器放入 context
+func withApprovalBroker(ctx context.Context, broker *ApprovalBroker) context.Context {
+	return context.WithValue(ctx, approvalBrokerContextKey{}, broker)
+}
+
+// approvalBrokerFromContext 从 context 取出批准管理器
+func approvalBrokerFromContext(ctx context.Context) *ApprovalBroker {
+	broker, _ := ctx.Value(approvalBrokerContextKey{}).(*ApprovalBroker)
+	return broker
+}
+
+// withToolPolicy 为节点的工具调用设置策略审计（写入节点日志）和人工批准（需要任务的批准管理器）
+func withToolPolicy(ctx context.Context, node *TaskNode) context.Context {
+	ctx = context.WithValue(ctx, mcp.ContextKeyToolAuditor, mcp.ToolAuditor(func(audit mcp.PolicyAudit) {
+		level := LogInfo
+		verdict := "允许"
+		switch {
+		case !audit.Allowed:
+			level = LogWarn
+			verdict = "拒绝"
+		case audit.Action == mcp.PolicyAsk:
+			verdict = "已批准"
+		}
+		msg := fmt.Sprintf("工具调用 %s %s (%s): %s", audit.Tool, verdict, audit.Rule, mcp.FormatArguments(audit.Arguments, 200))
+		if audit.Reason != "" {
+			msg += "，说明: " + audit.Reason
+		}
+		node.AddLog(level, "tool_policy", msg)
+	}))
+
+	if broker := approvalBrokerFromContext(ctx); broker != nil {
+		ctx = context.WithValue(ctx, mcp.ContextKeyToolApprover, mcp.ToolApprover(func(ctx context.Context, req mcp.ApprovalRequest) (bool, string) {
+			return broker.Request(ctx, node, req)
+		}))
+	}
+	return ctx
+}
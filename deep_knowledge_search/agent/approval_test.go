@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApprovalBroker(t *testing.T) {
+	broker := NewApprovalBroker("task")
+	node := NewTaskNode("运行脚本", "")
+	node.SetStatus(NodeRunning)
+
+	type result struct {
+		approved bool
+		reason   string
+	}
+	done := make(chan result)
+	go func() {
+		approved, reason := broker.Request(context.Background(), node, mcp.ApprovalRequest{Tool: "runCode", Rule: "规则 #1 runCode"})
+		done <- result{approved, reason}
+	}()
+
+	var pending []*ToolApproval
+	for i := 0; i < 100 && len(pending) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		pending = broker.Pending()
+	}
+	if len(pending) != 1 || node.GetStatus() != NodeAwaitingApproval {
+		t.Fatalf("pending = %v, status = %s", pending, node.GetStatus())
+	}
+	if err := broker.Answer(pending[0].ID, true, "可以"); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-done; !r.approved || r.reason != "可以" {
+		t.Errorf("Request = %+v", r)
+	}
+	if node.GetStatus() != NodeRunning {
+		t.Errorf("status after approval = %s", node.GetStatus())
+	}
+	if err := broker.Answer(pending[0].ID, false, ""); !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("second answer = %v", err)
+	}
+
+	// ctx 结束时视为拒绝
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if approved, _ := broker.Request(ctx, node, mcp.ApprovalRequest{Tool: "runCode"}); approved || len(broker.Pending()) != 0 {
+		t.Error("request should be denied and removed when ctx ends")
+	}
+}
+
+// TestToolApprovalsEnabled 没有人工批准渠道时任务不接受批准请求，ask 规则的调用直接拒绝
+func TestToolApprovalsEnabled(t *testing.T) {
+	t.Cleanup(func() { SetToolApprovalsEnabled(false) })
+	srv := llmtest.NewServer(t)
+
+	SetToolApprovalsEnabled(false)
+	if e := newTestExecutor(NewTaskNode(testTaskTitle, testTaskDescription), srv); approvalBrokerFromContext(e.ctx) != nil {
+		t.Error("approvals should not be requested without an approval channel")
+	}
+	SetToolApprovalsEnabled(true)
+	if e := newTestExecutor(NewTaskNode(testTaskTitle, testTaskDescription), srv); approvalBrokerFromContext(e.ctx) == nil {
+		t.Error("approvals should be requested when the dashboard is available")
+	}
+}
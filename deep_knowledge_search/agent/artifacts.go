@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"fmt"
+	"sync"
+)
+
+// artifactList 一次执行中登记的产出文件（去重，保持登记顺序）
+type artifactList struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// add 登记产出文件，已登记过时返回 false
+func (l *artifactList) add(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range l.paths {
+		if p == path {
+			return false
+		}
+	}
+	l.paths = append(l.paths, path)
+	return true
+}
+
+// list 产出文件列表的副本
+func (l *artifactList) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.paths...)
+}
+
+// withArtifactRecorder 工具（如 runCode）产出的文件交给 record 登记，新登记的记入节点日志
+func withArtifactRecorder(ctx context.Context, node *TaskNode, record func(path string) bool) context.Context {
+	return context.WithValue(ctx, mcp.ContextKeyArtifactRecorder, mcp.ArtifactRecorder(func(path string) {
+		if record(path) {
+			node.AddLog(LogInfo, "artifact", fmt.Sprintf("产出文件: %s", path))
+		}
+	}))
+}
+
+// AddResultArtifact 把产出文件追加到节点结果，结果尚未生成或已登记过时返回 false
+func (n *TaskNode) AddResultArtifact(path string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.Result == nil {
+		return false
+	}
+	count := len(n.Result.Artifacts)
+	n.Result.Artifacts = mergeArtifacts(n.Result.Artifacts, path)
+	return len(n.Result.Artifacts) > count
+}
+
+// mergeArtifacts 把 paths 中未出现过的文件追加到 dst
+func mergeArtifacts(dst []string, paths ...string) []string {
+	seen := make(map[string]bool, len(dst))
+	for _, p := range dst {
+		seen[p] = true
+	}
+	for _, p := range paths {
+		if !seen[p] {
+			seen[p] = true
+			dst = append(dst, p)
+		}
+	}
+	return dst
+}
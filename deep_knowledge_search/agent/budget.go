@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BudgetConfig 单个任务的预算上限（0 表示不限制）
+type BudgetConfig struct {
+	MaxTokens      int     `json:"max_tokens,omitempty"`       // 最大 token 总量
+	MaxCost        float64 `json:"max_cost,omitempty"`         // 最大费用
+	MaxDurationSec int     `json:"max_duration_sec,omitempty"` // 最大执行时长（秒）
+	MaxLLMCalls    int     `json:"max_llm_calls,omitempty"`    // 最大 LLM 调用次数
+}
+
+// IsZero 是否未设置任何预算
+func (b BudgetConfig) IsZero() bool {
+	return b.MaxTokens <= 0 && b.MaxCost <= 0 && b.MaxDurationSec <= 0 && b.MaxLLMCalls <= 0
+}
+
+// String 预算描述
+func (b BudgetConfig) String() string {
+	if b.IsZero() {
+		return "不限制"
+	}
+	desc := ""
+	if b.MaxTokens > 0 {
+		desc += fmt.Sprintf("tokens≤%d ", b.MaxTokens)
+	}
+	if b.MaxCost > 0 {
+		desc += fmt.Sprintf("cost≤%.4f ", b.MaxCost)
+	}
+	if b.MaxDurationSec > 0 {
+		desc += fmt.Sprintf("time≤%ds ", b.MaxDurationSec)
+	}
+	if b.MaxLLMCalls > 0 {
+		desc += fmt.Sprintf("calls≤%d ", b.MaxLLMCalls)
+	}
+	return desc[:len(desc)-1]
+}
+
+// BudgetTracker 预算跟踪器（基于根节点的用量汇总和墙钟时间）
+type BudgetTracker struct {
+	mu        sync.Mutex
+	limits    BudgetConfig
+	root      *TaskNode
+	startTime time.Time
+	exhausted bool
+	reason    string
+}
+
+// NewBudgetTracker 创建预算跟踪器
+func NewBudgetTracker(root *TaskNode, limits BudgetConfig) *BudgetTracker {
+	return &BudgetTracker{
+		limits:    limits,
+		root:      root,
+		startTime: time.Now(),
+	}
+}
+
+// Start 重置计时起点（任务真正开始执行时调用）
+func (b *BudgetTracker) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.startTime = time.Now()
+}
+
+// SetLimits 更新预算上限（已耗尽的状态不会因提高上限而恢复）
+func (b *BudgetTracker) SetLimits(limits BudgetConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = limits
+}
+
+// Limits 获取当前预算上限
+func (b *BudgetTracker) Limits() BudgetConfig {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limits
+}
+
+// Exhausted 检查预算是否已耗尽（一旦耗尽保持耗尽）
+func (b *BudgetTracker) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exhausted {
+		return true
+	}
+
+	usage := b.root.TotalUsage()
+	elapsed := time.Since(b.startTime)
+	switch {
+	case b.limits.MaxTokens > 0 && usage.TotalTokens >= b.limits.MaxTokens:
+		b.reason = fmt.Sprintf("token 用量 %d 达到上限 %d", usage.TotalTokens, b.limits.MaxTokens)
+	case b.limits.MaxCost > 0 && usage.Cost >= b.limits.MaxCost:
+		b.reason = fmt.Sprintf("费用 %.4f 达到上限 %.4f", usage.Cost, b.limits.MaxCost)
+	case b.limits.MaxDurationSec > 0 && elapsed >= time.Duration(b.limits.MaxDurationSec)*time.Second:
+		b.reason = fmt.Sprintf("执行时长 %s 达到上限 %ds", elapsed.Round(time.Second), b.limits.MaxDurationSec)
+	case b.limits.MaxLLMCalls > 0 && usage.Calls >= b.limits.MaxLLMCalls:
+		b.reason = fmt.Sprintf("LLM 调用 %d 次达到上限 %d", usage.Calls, b.limits.MaxLLMCalls)
+	default:
+		return false
+	}
+
+	b.exhausted = true
+	return true
+}
+
+// Deadline 时长上限对应的截止时间（未限制时长时 ok 为 false）
+func (b *BudgetTracker) Deadline() (deadline time.Time, ok bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxDurationSec <= 0 {
+		return time.Time{}, false
+	}
+	return b.startTime.Add(time.Duration(b.limits.MaxDurationSec) * time.Second), true
+}
+
+// Reason 预算耗尽原因
+func (b *BudgetTracker) Reason() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reason
+}
+
+// Status 预算状态（用于 Web API）
+func (b *BudgetTracker) Status() map[string]interface{} {
+	exhausted := b.Exhausted()
+	usage := b.root.TotalUsage()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"limits":      b.limits,
+		"exhausted":   exhausted,
+		"reason":      b.reason,
+		"usage":       usage,
+		"elapsed_sec": int(time.Since(b.startTime).Seconds()),
+	}
+}
+
+// budgetContextKey 预算跟踪器的 Context Key
+type budgetContextKey struct{}
+
+// withBudget 把预算跟踪器放入 Context
+func withBudget(ctx context.Context, b *BudgetTracker) context.Context {
+	return context.WithValue(ctx, budgetContextKey{}, b)
+}
+
+// budgetFromContext 从 Context 获取预算跟踪器（可能为 nil）
+func budgetFromContext(ctx context.Context) *BudgetTracker {
+	b, _ := ctx.Value(budgetContextKey{}).(*BudgetTracker)
+	return b
+}
+
+// withBudgetDeadline 按预算时长上限为一次调用设置截止时间，避免长时间的工具调用越过上限
+func withBudgetDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := budgetFromContext(ctx).Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBudgetDeadlineStopsToolCall 时长预算在工具调用进行中到期时取消调用，不等工具自己结束
+func TestBudgetDeadlineStopsToolCall(t *testing.T) {
+	mcp.Init()
+	mcp.RegisterTool("slowTool", mcp.LLMTool{Type: "function", Function: mcp.LLMFunction{Name: "slowTool"}},
+		func(ctx context.Context, arguments map[string]interface{}) mcp.MCPToolResponse {
+			select {
+			case <-ctx.Done():
+				return mcp.MCPToolResponse{Success: false, Error: ctx.Err().Error()}
+			case <-time.After(time.Minute):
+				return mcp.MCPToolResponse{Success: true, Result: "done"}
+			}
+		})
+	t.Cleanup(func() { mcp.UnregisterTool("slowTool") })
+
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("fake-model"))
+	srv.On(llmtest.System("任务执行助手"), llmtest.ToolCall("slowTool", nil))
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	node := NewTaskNode("慢任务", "调用耗时很长的工具")
+	budget := NewBudgetTracker(node, BudgetConfig{MaxDurationSec: 1})
+
+	start := time.Now()
+	_, err := planner.ExecuteNode(withBudget(context.Background(), budget), node)
+	if err == nil || !strings.Contains(err.Error(), "执行时长达到预算上限") {
+		t.Fatalf("err = %v, want budget deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("ExecuteNode took %s after a 1s budget", elapsed)
+	}
+	if !budget.Exhausted() {
+		t.Error("budget not exhausted")
+	}
+}
+
+// TestDefaultsConcurrentReload 任务运行期间重新加载配置：默认配置与 llm 全局设置的读写并发安全（配合 -race）
+func TestDefaultsConcurrentReload(t *testing.T) {
+	prevBudget := GetDefaultBudget()
+	t.Cleanup(func() {
+		SetDefaultBudget(prevBudget)
+		SetDefaultMaxToolCalls(0)
+		llm.SetRetryPolicy(llm.DefaultRetryPolicy)
+		llm.SetMaxToolIterations(0)
+	})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			SetDefaultBudget(BudgetConfig{MaxLLMCalls: n})
+			SetDefaultMaxToolCalls(n)
+			llm.SetRetryPolicy(llm.RetryPolicy{MaxRetries: n})
+			llm.SetMaxToolIterations(n)
+			llm.SetFallbackModels(nil)
+		}(i)
+		go func() {
+			defer wg.Done()
+			_ = DefaultExecutionConfig()
+			_ = llm.GetMaxToolIterations()
+			_ = llm.ModelsForPhase("execute")
+		}()
+	}
+	wg.Wait()
+}
@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
 	"deepknowledgesearch/web"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 // ConsoleDisplay 控制台显示器
@@ -12,6 +15,41 @@ type ConsoleDisplay struct{}
 // Display 全局显示器实例
 var Display = &ConsoleDisplay{}
 
+// DisplayListener 接收显示事件（与广播到 Web 的事件相同）
+type DisplayListener func(eventType string, data interface{})
+
+// 已注册的显示事件监听器
+var (
+	displayListeners = make(map[int]DisplayListener)
+	nextListenerID   int
+	listenersMu      sync.RWMutex
+)
+
+// AddDisplayListener 注册显示事件监听器，返回注销函数
+func AddDisplayListener(fn DisplayListener) func() {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	nextListenerID++
+	id := nextListenerID
+	displayListeners[id] = fn
+	return func() {
+		listenersMu.Lock()
+		defer listenersMu.Unlock()
+		delete(displayListeners, id)
+	}
+}
+
+// broadcast 把显示事件广播到 Web 和监听器
+func broadcast(eventType string, data interface{}) {
+	web.BroadcastEvent(eventType, data)
+
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, fn := range displayListeners {
+		fn(eventType, data)
+	}
+}
+
 // TaskStart 显示任务开始
 func (d *ConsoleDisplay) TaskStart(title string) {
 	fmt.Println()
@@ -21,7 +59,7 @@ func (d *ConsoleDisplay) TaskStart(title string) {
 	fmt.Println()
 
 	// 广播到 Web
-	web.BroadcastEvent("task_start", map[string]interface{}{
+	broadcast("task_start", map[string]interface{}{
 		"title": title,
 	})
 }
@@ -33,7 +71,7 @@ func (d *ConsoleDisplay) TaskComplete(title string) {
 	fmt.Printf("║  ✅ 任务完成: %-44s║\n", truncateString(title, 44))
 	fmt.Println("╚══════════════════════════════════════════════════════════╝")
 
-	web.BroadcastEvent("task_complete", map[string]interface{}{
+	broadcast("task_complete", map[string]interface{}{
 		"title": title,
 	})
 }
@@ -46,7 +84,7 @@ func (d *ConsoleDisplay) TaskFailed(title string, err error) {
 	fmt.Printf("║  错误: %-51s║\n", truncateString(err.Error(), 51))
 	fmt.Println("╚══════════════════════════════════════════════════════════╝")
 
-	web.BroadcastEvent("task_failed", map[string]interface{}{
+	broadcast("task_failed", map[string]interface{}{
 		"title": title,
 		"error": err.Error(),
 	})
@@ -57,7 +95,7 @@ func (d *ConsoleDisplay) NodeStart(node *TaskNode) {
 	indent := strings.Repeat("  ", node.Depth)
 	fmt.Printf("%s├─ 🔄 [%s] %s\n", indent, node.ID[:4], node.Title)
 
-	web.BroadcastEvent("node_start", buildNodeData(node))
+	broadcast("node_start", buildNodeData(node))
 }
 
 // NodeComplete 显示节点完成
@@ -69,7 +107,7 @@ func (d *ConsoleDisplay) NodeComplete(node *TaskNode) {
 	}
 	fmt.Printf("%s├─ ✅ [%s] %s%s\n", indent, node.ID[:4], node.Title, summary)
 
-	web.BroadcastEvent("node_complete", buildNodeData(node))
+	broadcast("node_complete", buildNodeData(node))
 }
 
 // NodeFailed 显示节点失败
@@ -77,25 +115,84 @@ func (d *ConsoleDisplay) NodeFailed(node *TaskNode, err error) {
 	indent := strings.Repeat("  ", node.Depth)
 	fmt.Printf("%s├─ ❌ [%s] %s: %s\n", indent, node.ID[:4], node.Title, err.Error())
 
-	web.BroadcastEvent("node_failed", map[string]interface{}{
+	broadcast("node_failed", map[string]interface{}{
 		"title": node.Title,
 		"error": err.Error(),
 	})
 }
 
+// NodeSkipped 显示节点跳过（预算耗尽）
+func (d *ConsoleDisplay) NodeSkipped(node *TaskNode) {
+	indent := strings.Repeat("  ", node.Depth)
+	fmt.Printf("%s├─ ⏭️ [%s] %s (预算耗尽，已跳过)\n", indent, node.ID[:4], node.Title)
+
+	broadcast("node_skipped", buildNodeData(node))
+}
+
+// NodeQueued 显示节点排队等待并发名额
+func (d *ConsoleDisplay) NodeQueued(node *TaskNode) {
+	indent := strings.Repeat("  ", node.Depth)
+	fmt.Printf("%s├─ ⏳ [%s] %s (排队中)\n", indent, node.ID[:4], node.Title)
+
+	broadcast("node_queued", buildNodeData(node))
+}
+
+// NodeDequeued 节点获得并发名额，恢复原状态
+func (d *ConsoleDisplay) NodeDequeued(node *TaskNode) {
+	broadcast("node_data", buildNodeData(node))
+}
+
+// ToolApproval 显示等待人工批准的工具调用
+func (d *ConsoleDisplay) ToolApproval(node *TaskNode, approval *ToolApproval) {
+	indent := strings.Repeat("  ", node.Depth)
+	fmt.Printf("%s├─ 🔐 [%s] %s 等待批准工具调用 %s (%s)\n", indent, node.ID[:4], node.Title, approval.Tool, approval.Rule)
+	fmt.Printf("%s│    参数: %s\n", indent, mcp.FormatArguments(approval.Arguments, 300))
+	fmt.Printf("%s│    批准: POST /api/task/approve/%s/%s  拒绝: POST /api/task/deny/%s/%s\n",
+		indent, approval.TaskID, approval.ID, approval.TaskID, approval.ID)
+
+	broadcast("tool_approval", approvalData(approval))
+	broadcast("node_data", buildNodeData(node))
+}
+
+// ToolApprovalResolved 显示批准结果
+func (d *ConsoleDisplay) ToolApprovalResolved(node *TaskNode, approval *ToolApproval, approved bool, reason string) {
+	indent := strings.Repeat("  ", node.Depth)
+	verdict := "✅ 已批准"
+	if !approved {
+		verdict = "⛔ 已拒绝"
+	}
+	if reason != "" {
+		verdict += ": " + reason
+	}
+	fmt.Printf("%s├─ 🔐 [%s] %s %s\n", indent, node.ID[:4], approval.Tool, verdict)
+
+	data := approvalData(approval)
+	data["approved"] = approved
+	data["reason"] = reason
+	broadcast("tool_approval_resolved", data)
+	broadcast("node_data", buildNodeData(node))
+}
+
 // ShowSubtasks 显示子任务
 func (d *ConsoleDisplay) ShowSubtasks(subtasks []SubTaskPlan, mode ExecutionMode) {
 	modeStr := "串行"
-	if mode == ModeParallel {
+	switch mode {
+	case ModeParallel:
 		modeStr = "并行"
+	case ModeDAG:
+		modeStr = "按依赖"
 	}
 	fmt.Printf("   📋 分解为 %d 个子任务 (%s执行):\n", len(subtasks), modeStr)
 	for i, st := range subtasks {
-		fmt.Printf("      %d. %s\n", i+1, st.Title)
+		if len(st.DependsOn) > 0 {
+			fmt.Printf("      %d. %s (依赖: %v)\n", i+1, st.Title, st.DependsOn)
+		} else {
+			fmt.Printf("      %d. %s\n", i+1, st.Title)
+		}
 	}
 	fmt.Println()
 
-	web.BroadcastEvent("subtasks", map[string]interface{}{
+	broadcast("subtasks", map[string]interface{}{
 		"count": len(subtasks),
 		"mode":  mode,
 	})
@@ -106,14 +203,46 @@ func (d *ConsoleDisplay) BroadcastTree(rootNode *TaskNode) {
 	if rootNode == nil {
 		return
 	}
-	web.BroadcastEvent("tree_update", buildNodeData(rootNode))
+	broadcast("tree_update", buildNodeData(rootNode))
+}
+
+// LLMDelta 推送节点 LLM 流式输出的增量内容（仅 Web，控制台不逐字输出）
+func (d *ConsoleDisplay) LLMDelta(node *TaskNode, callType string, delta string) {
+	broadcast("llm_delta", map[string]interface{}{
+		"node_id":   node.ID,
+		"call_type": callType,
+		"delta":     delta,
+	})
+}
+
+// LLMStreamReset 通知前端丢弃节点已推送的流式输出（回退到其他模型重新生成时，仅 Web）
+func (d *ConsoleDisplay) LLMStreamReset(node *TaskNode, callType string, model string) {
+	broadcast("llm_stream_reset", map[string]interface{}{
+		"node_id":   node.ID,
+		"call_type": callType,
+		"model":     model,
+	})
+}
+
+// LLMFallback 显示模型回退
+func (d *ConsoleDisplay) LLMFallback(node *TaskNode, callType string, from string, to string, reason string) {
+	indent := strings.Repeat("  ", node.Depth)
+	fmt.Printf("%s│  ↪️ [%s] %s: %s → %s (%s)\n", indent, node.ID[:4], callType, from, to, truncateString(reason, 60))
+
+	broadcast("llm_fallback", map[string]interface{}{
+		"node_id":   node.ID,
+		"call_type": callType,
+		"from":      from,
+		"to":        to,
+		"reason":    reason,
+	})
 }
 
 // ShowMessage 显示消息
 func (d *ConsoleDisplay) ShowMessage(icon string, message string) {
 	fmt.Printf("   %s %s\n", icon, message)
 
-	web.BroadcastEvent("log", map[string]interface{}{
+	broadcast("log", map[string]interface{}{
 		"level":   "info",
 		"message": message,
 	})
@@ -142,14 +271,19 @@ func (d *ConsoleDisplay) ShowResult(result string) {
 // buildNodeData 构建节点数据用于广播
 func buildNodeData(node *TaskNode) map[string]interface{} {
 	data := map[string]interface{}{
-		"id":          node.ID,
-		"parent_id":   node.ParentID,
-		"title":       node.Title,
-		"description": node.Description,
-		"goal":        node.Goal,
-		"status":      string(node.Status),
-		"depth":       node.Depth,
-		"created_at":  node.CreatedAt,
+		"id":             node.ID,
+		"parent_id":      node.ParentID,
+		"title":          node.Title,
+		"description":    node.Description,
+		"goal":           node.Goal,
+		"status":         string(node.Status),
+		"depth":          node.Depth,
+		"execution_mode": string(node.ExecutionMode),
+		"created_at":     node.CreatedAt,
+	}
+
+	if len(node.DependsOn) > 0 {
+		data["depends_on"] = node.DependsOn
 	}
 
 	if node.StartedAt != nil {
@@ -158,29 +292,60 @@ func buildNodeData(node *TaskNode) map[string]interface{} {
 	if node.FinishedAt != nil {
 		data["finished_at"] = node.FinishedAt
 	}
+	if node.BudgetExhausted != "" {
+		data["budget_exhausted"] = node.BudgetExhausted
+	}
 
 	// 添加 LLM 调用记录
 	if len(node.LLMCalls) > 0 {
 		llmCalls := make([]map[string]interface{}, 0, len(node.LLMCalls))
 		for _, call := range node.LLMCalls {
-			llmCalls = append(llmCalls, map[string]interface{}{
-				"type":        call.Type,
-				"messages":    call.Messages,
-				"response":    call.Response,
-				"start_time":  call.StartTime.Format("15:04:05"),
-				"duration_ms": call.DurationMs,
-			})
+			callData := map[string]interface{}{
+				"type":         call.Type,
+				"model":        call.Model,
+				"messages":     call.Messages,
+				"response":     call.Response,
+				"start_time":   call.StartTime.Format("15:04:05"),
+				"duration_ms":  call.DurationMs,
+				"total_tokens": call.TotalTokens,
+				"cost":         call.Cost,
+			}
+			if call.CacheHit {
+				callData["cache_hit"] = true
+			}
+			if call.Error != "" {
+				callData["error"] = call.Error
+			}
+			if call.FallbackFrom != "" {
+				callData["fallback_from"] = call.FallbackFrom
+				callData["fallback_reason"] = call.FallbackReason
+			}
+			if len(call.Turns) > 0 {
+				callData["tool_calls"] = call.ToolCalls
+				callData["turns"] = turnsData(call.Turns)
+			}
+			llmCalls = append(llmCalls, callData)
 		}
 		data["llm_calls"] = llmCalls
 	}
 
+	// 添加用量汇总（包含子节点）
+	usage := node.TotalUsage()
+	data["usage"] = map[string]interface{}{
+		"calls":             usage.Calls,
+		"prompt_tokens":     usage.PromptTokens,
+		"completion_tokens": usage.CompletionTokens,
+		"total_tokens":      usage.TotalTokens,
+		"cost":              usage.Cost,
+	}
+
 	// 添加结果
-	if node.Result != nil {
+	if result := node.GetResult(); result != nil {
 		data["result"] = map[string]interface{}{
-			"success": node.Result.Success,
-			"summary": node.Result.Summary,
-			"output":  node.Result.Output,
-			"error":   node.Result.Error,
+			"success": result.Success,
+			"summary": result.Summary,
+			"output":  result.Output,
+			"error":   result.Error,
 		}
 	}
 
@@ -213,6 +378,36 @@ func buildNodeData(node *TaskNode) map[string]interface{} {
 	return data
 }
 
+// maxTurnResultChars Dashboard 中每个工具结果显示的最大字符数（完整内容见 execution.json）
+const maxTurnResultChars = 2000
+
+// turnsData 工具调用循环的时间线数据
+func turnsData(turns []llm.Turn) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(turns))
+	for _, turn := range turns {
+		toolCalls := make([]map[string]interface{}, 0, len(turn.ToolCalls))
+		for _, call := range turn.ToolCalls {
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"name":        call.Name,
+				"arguments":   call.Arguments,
+				"result":      truncateString(call.Result, maxTurnResultChars),
+				"success":     call.Success,
+				"start_time":  call.StartTime.Format("15:04:05"),
+				"duration_ms": call.DurationMs,
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"content":      turn.Content,
+			"tool_calls":   toolCalls,
+			"start_time":   turn.StartTime.Format("15:04:05"),
+			"duration_ms":  turn.DurationMs,
+			"total_tokens": turn.Usage.TotalTokens,
+			"cache_hit":    turn.CacheHit,
+		})
+	}
+	return list
+}
+
 // ============================================================================
 // 辅助函数
 // ============================================================================
@@ -3,6 +3,9 @@ package agent
 import (
 	"context"
 	"deepknowledgesearch/config"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"sync"
@@ -26,12 +29,30 @@ type TaskExecutor struct {
 	// 恢复控制
 	recovering bool
 	taskFolder string
+
+	// 预算控制
+	budget     *BudgetTracker
+	budgetOnce sync.Once
+
+	// 并发控制
+	slots *SlotPool
+
+	// 等待人工批准的工具调用
+	approvals *ApprovalBroker
 }
 
 // NewTaskExecutor 创建任务执行器
 func NewTaskExecutor(root *TaskNode, planner *TaskPlanner, config *ExecutionConfig) *TaskExecutor {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &TaskExecutor{
+	e := &TaskExecutor{}
+	budget := NewBudgetTracker(root, config.Budget)
+	slots := NewSlotPool(config.MaxConcurrency, e.IsPaused)
+	approvals := NewApprovalBroker(root.ID)
+	ctx := withCacheMode(withSlotPool(withBudget(context.Background(), budget), slots), config.CacheMode)
+	if ToolApprovalsEnabled() {
+		ctx = withApprovalBroker(ctx, approvals)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	*e = TaskExecutor{
 		root:       root,
 		planner:    planner,
 		config:     config,
@@ -42,7 +63,11 @@ func NewTaskExecutor(root *TaskNode, planner *TaskPlanner, config *ExecutionConf
 		resumeCh:   make(chan struct{}),
 		recovering: false,
 		taskFolder: "",
+		budget:     budget,
+		slots:      slots,
+		approvals:  approvals,
 	}
+	return e
 }
 
 // Execute 执行任务图
@@ -57,17 +82,42 @@ func (e *TaskExecutor) Execute() error {
 		// 恢复模式：使用已有的任务文件夹
 		taskFolderName = e.taskFolder
 		Display.ShowMessage("🔄", fmt.Sprintf("恢复任务: %s", taskFolderName))
+	} else if e.taskFolder != "" {
+		// 指定了任务文件夹（如回放）
+		taskFolderName = e.taskFolder
 	} else {
 		// 正常模式：创建新的任务文件夹
 		taskFolderName = fmt.Sprintf("%s_%s", sanitizeForFilename(e.root.Title), time.Now().Format("20060102_150405"))
+		e.mu.Lock()
 		e.taskFolder = taskFolderName // 保存以便任务完成后清理检查点
+		e.mu.Unlock()
 	}
 
 	// mcp.SetTaskOutputDir(taskFolderName) // 移除全局设置
 	// defer mcp.ClearTaskOutputDir()       // 移除全局清理
 
+	// 任务目录放入 context（fetchURL 等工具在其下缓存数据）
+	e.ctx = context.WithValue(e.ctx, mcp.ContextKeyTaskDir, filepath.Join(config.GetOutputDir(), taskFolderName))
+	// 按任务配置开放 runCode 工具
+	e.ctx = context.WithValue(e.ctx, mcp.ContextKeyRunCode, e.config.RunCode)
+	e.ctx = withMaxToolCalls(e.ctx, e.config.MaxToolCalls)
+
 	Display.TaskStart(e.root.Title)
 	e.root.AddLog(LogInfo, "starting", fmt.Sprintf("开始执行任务: %s", e.root.Title))
+	if e.config.RunCode {
+		e.root.AddLog(LogInfo, "starting", "已允许使用 runCode 执行代码")
+	}
+
+	// 恢复的任务已带有注入的内容
+	if !e.recovering {
+		e.injectPriorResearch()
+	}
+
+	// 预算从任务真正开始时计时
+	e.budget.Start()
+	if limits := e.budget.Limits(); !limits.IsZero() {
+		e.root.AddLog(LogInfo, "budget", fmt.Sprintf("任务预算: %s", limits))
+	}
 
 	// 启动周期性检查点保存（每30秒）
 	checkpointTicker := time.NewTicker(30 * time.Second)
@@ -98,14 +148,19 @@ func (e *TaskExecutor) Execute() error {
 		return err
 	}
 
-	// 验证任务结果
-	if e.root.Result != nil && e.root.Result.Success {
+	// 验证任务结果（预算耗尽时跳过）
+	if e.root.Result != nil && e.root.Result.Success && e.budgetExhausted() {
+		e.root.AddLog(LogWarn, "verification", "预算耗尽，跳过验证")
+		Display.ShowMessage("💸", "预算耗尽，跳过验证，使用已有结果")
+	} else if e.root.Result != nil && e.root.Result.Success {
 		Display.ShowMessage("📋", "开始验证任务结果...")
 
 		verifyResult, verifyErr := e.planner.VerifyResult(e.ctx, e.root, e.root.Result.Summary)
 		if verifyErr != nil {
 			e.root.AddLog(LogError, "verification", fmt.Sprintf("验证失败: %v", verifyErr))
 			Display.ShowMessage("⚠️", fmt.Sprintf("验证过程出错: %v", verifyErr))
+		} else if !verifyResult.Passed && e.budgetExhausted() {
+			e.root.AddLog(LogWarn, "verification", "预算耗尽，验证提前结束")
 		} else if !verifyResult.Passed {
 			e.root.AddLog(LogWarn, "verification", "任务未通过验证")
 			Display.ShowMessage("⚠️", "任务未通过验证，请检查结果")
@@ -177,6 +232,14 @@ func (e *TaskExecutor) executeNode(node *TaskNode) error {
 		return nil
 	}
 
+	// 预算耗尽：未执行的节点直接跳过，保留已有结果
+	if e.budgetExhausted() && node.GetStatus() == NodePending {
+		node.SetStatus(NodeSkipped)
+		node.AddLog(LogWarn, "budget", "预算耗尽，跳过执行")
+		Display.NodeSkipped(node)
+		return nil
+	}
+
 	// 设置运行状态
 	node.SetStatus(NodeRunning)
 	node.AddLog(LogInfo, "executing", fmt.Sprintf("开始执行: %s", node.Title))
@@ -196,6 +259,8 @@ func (e *TaskExecutor) executeNode(node *TaskNode) error {
 		switch node.ExecutionMode {
 		case ModeParallel:
 			err = e.executeParallel(node)
+		case ModeDAG:
+			err = e.executeDAG(node)
 		default:
 			err = e.executeSequential(node)
 		}
@@ -236,6 +301,10 @@ func (e *TaskExecutor) shouldDecompose(node *TaskNode) bool {
 	if !node.CanDecompose {
 		return false
 	}
+	if e.budgetExhausted() {
+		node.AddLog(LogInfo, "planning", "预算耗尽，不再拆解")
+		return false
+	}
 	if node.Depth >= e.config.MaxDepth {
 		node.AddLog(LogInfo, "planning", fmt.Sprintf("达到最大深度 %d，不再拆解", e.config.MaxDepth))
 		return false
@@ -250,6 +319,13 @@ func (e *TaskExecutor) decomposeNode(node *TaskNode) error {
 
 	// 调用 planner 进行拆解
 	result, err := e.planner.PlanNode(e.ctx, node)
+	if errors.Is(err, ErrInvalidPlan) {
+		// 修复后仍无效：记录错误并直接执行该节点
+		node.CanDecompose = false
+		node.AddLog(LogError, "planning", fmt.Sprintf("%v，不再拆解，直接执行", err))
+		Display.ShowMessage("⚠️", fmt.Sprintf("任务 %s 的规划响应无效，直接执行: %v", node.Title, err))
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -263,14 +339,44 @@ func (e *TaskExecutor) decomposeNode(node *TaskNode) error {
 
 	// 创建子节点
 	node.ExecutionMode = result.ExecutionMode
-	Display.ShowSubtasks(result.SubTasks, result.ExecutionMode)
 
+	children := make([]*TaskNode, 0, len(result.SubTasks))
 	for _, st := range result.SubTasks {
 		child := node.NewChildNode(st.Title, st.Description, st.Goal)
 		child.ToolCalls = st.Tools
 		child.CanDecompose = st.CanDecompose
+		children = append(children, child)
+	}
+
+	// 建立子任务依赖关系（序号从 1 开始）
+	hasDeps := false
+	for i, st := range result.SubTasks {
+		for _, dep := range st.DependsOn {
+			if dep < 1 || dep > len(children) || dep == i+1 {
+				node.AddLog(LogWarn, "planning", fmt.Sprintf("子任务 %q 的依赖序号 %d 无效，已忽略", st.Title, dep))
+				continue
+			}
+			children[i].DependsOn = append(children[i].DependsOn, children[dep-1].ID)
+			hasDeps = true
+		}
+	}
+	if hasDeps {
+		if cycle := findDependencyCycle(children); cycle != nil {
+			// 依赖成环时退化为串行执行
+			node.AddLog(LogWarn, "planning", fmt.Sprintf("子任务依赖存在环 (%s)，改为串行执行", joinStrings(cycle, " → ")))
+			for _, child := range children {
+				child.DependsOn = nil
+			}
+			node.ExecutionMode = ModeSequential
+		} else {
+			node.ExecutionMode = ModeDAG
+		}
+	} else if node.ExecutionMode == ModeDAG {
+		node.ExecutionMode = ModeParallel
 	}
 
+	Display.ShowSubtasks(result.SubTasks, node.ExecutionMode)
+
 	node.AddLog(LogInfo, "planning", fmt.Sprintf("任务拆解完成: %d 个子任务，模式: %s", len(node.Children), node.ExecutionMode))
 	return nil
 }
@@ -335,6 +441,182 @@ func (e *TaskExecutor) executeParallel(node *TaskNode) error {
 	return nil
 }
 
+// executeDAG 按依赖关系调度子节点：依赖全部完成后立即执行，互不依赖的子节点并行
+func (e *TaskExecutor) executeDAG(node *TaskNode) error {
+	if cycle := findDependencyCycle(node.Children); cycle != nil {
+		return fmt.Errorf("子任务依赖存在环: %s", joinStrings(cycle, " → "))
+	}
+
+	node.AddLog(LogInfo, "executing", fmt.Sprintf("按依赖关系执行 %d 个子任务", len(node.Children)))
+
+	// 每个子节点完成（无论成功失败）时关闭对应 channel
+	done := make(map[string]chan struct{}, len(node.Children))
+	byID := make(map[string]*TaskNode, len(node.Children))
+	for _, child := range node.Children {
+		done[child.ID] = make(chan struct{})
+		byID[child.ID] = child
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	completed := 0
+
+	for _, child := range node.Children {
+		wg.Add(1)
+		go func(c *TaskNode) {
+			defer wg.Done()
+			defer close(done[c.ID])
+
+			// 等待依赖完成
+			for _, depID := range c.DependsOn {
+				select {
+				case <-done[depID]:
+				case <-e.ctx.Done():
+					return
+				}
+			}
+
+			// 依赖被跳过则一并跳过；依赖失败则不执行
+			for _, depID := range c.DependsOn {
+				dep := byID[depID]
+				if dep.GetStatus() == NodeSkipped && c.GetStatus() == NodePending {
+					c.SetStatus(NodeSkipped)
+					c.AddLog(LogWarn, "executing", fmt.Sprintf("依赖任务已跳过: %s", dep.Title))
+					Display.NodeSkipped(c)
+					return
+				}
+				if dep.GetStatus() != NodeDone || dep.Result == nil || !dep.Result.Success {
+					err := fmt.Errorf("依赖任务未成功完成: %s", dep.Title)
+					c.AddLog(LogError, "executing", err.Error())
+					c.SetStatus(NodeCanceled)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return
+				}
+			}
+
+			// 把依赖结果注入上下文（已完成的节点无需重复注入）
+			for _, depID := range c.DependsOn {
+				if c.GetStatus() == NodeDone {
+					break
+				}
+				dep := byID[depID]
+				c.Context.AddDependencyResult(dep.ID, dep.Title, dep.GetStatus(), truncateString(dep.Result.Output, 2000))
+			}
+
+			// 与串行执行一致：失败的子节点在重试次数内重新执行
+			err := e.executeNode(c)
+			for err != nil && c.CanRetry() && e.ctx.Err() == nil {
+				c.IncrementRetry()
+				c.AddLog(LogWarn, "retry", fmt.Sprintf("重试第 %d 次", c.RetryCount))
+				c.SetStatus(NodePending)
+				err = e.executeNode(c)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			completed++
+			node.SetProgress(float64(completed) / float64(len(node.Children)) * 100)
+			mu.Unlock()
+		}(child)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("dag execution failed: %v", errs[0])
+	}
+
+	return nil
+}
+
+// findDependencyCycle 检测同级节点的依赖环，返回环上的节点标题（无环返回 nil）
+func findDependencyCycle(nodes []*TaskNode) []string {
+	byID := make(map[string]*TaskNode, len(nodes))
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(nodes))
+	var stack []string
+
+	var visit func(n *TaskNode) []string
+	visit = func(n *TaskNode) []string {
+		state[n.ID] = visiting
+		stack = append(stack, n.ID)
+		for _, depID := range n.DependsOn {
+			dep, ok := byID[depID]
+			if !ok {
+				continue
+			}
+			switch state[depID] {
+			case visiting:
+				// 截取从依赖节点开始的环
+				var cycle []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append([]string{byID[stack[i]].Title}, cycle...)
+					if stack[i] == depID {
+						break
+					}
+				}
+				return append(cycle, dep.Title)
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n.ID] = visited
+		return nil
+	}
+
+	for _, n := range nodes {
+		if state[n.ID] == unvisited {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// injectPriorResearch 检索以往任务输出中与本任务相关的段落，放入根节点上下文（子节点创建时继承）
+func (e *TaskExecutor) injectPriorResearch() {
+	if e.config.PriorResearch <= 0 || len(e.root.Context.PriorResearch) > 0 {
+		return
+	}
+	hits, err := mcp.RecallPriorResearch(e.ctx, e.root.Description, e.config.PriorResearch)
+	if err != nil {
+		e.root.AddLog(LogWarn, "prior_research", fmt.Sprintf("检索以往调研失败: %v", err))
+		return
+	}
+	if len(hits) == 0 {
+		return
+	}
+	for _, h := range hits {
+		e.root.Context.PriorResearch = append(e.root.Context.PriorResearch, PriorPassage{
+			Ref:   h.Chunk.Ref(),
+			Text:  truncateString(h.Chunk.Text, 1000),
+			Score: h.Score,
+		})
+	}
+	e.root.AddLog(LogInfo, "prior_research", fmt.Sprintf("注入 %d 段以往调研内容", len(hits)))
+	Display.ShowMessage("🧠", fmt.Sprintf("找到 %d 段相关的以往调研", len(hits)))
+}
+
 // executeLeafNode 执行叶子节点
 func (e *TaskExecutor) executeLeafNode(node *TaskNode) error {
 	node.AddLog(LogInfo, "executing", fmt.Sprintf("执行叶子节点: %s", node.Title))
@@ -342,11 +624,11 @@ func (e *TaskExecutor) executeLeafNode(node *TaskNode) error {
 	// 调用 planner 执行
 	result, err := e.planner.ExecuteNode(e.ctx, node)
 	if err != nil {
-		node.Result = NewTaskResultError(err.Error())
+		node.SetResult(NewTaskResultError(err.Error()))
 		return err
 	}
 
-	node.Result = result
+	node.SetResult(result)
 	node.AddLog(LogInfo, "completed", fmt.Sprintf("执行结果: %s", result.Summary))
 
 	return nil
@@ -374,38 +656,120 @@ func (e *TaskExecutor) propagateSiblingResult(completed *TaskNode, parent *TaskN
 func (e *TaskExecutor) aggregateChildResults(node *TaskNode) {
 	var summaries []string
 	var allSuccess = true
+	var artifacts []string
+	skipped := 0
 
 	for _, child := range node.Children {
+		if child.GetStatus() == NodeSkipped {
+			skipped++
+			continue
+		}
 		if child.Result != nil {
-			summaries = append(summaries, fmt.Sprintf("%s: %s", child.Title, child.Result.Summary))
+			// 合并子节点来源并把摘要中的引用改写为父节点编号
+			summary := renumberCitations(child.Result.Summary, mergeChildSources(node, child))
+			summaries = append(summaries, fmt.Sprintf("%s: %s", child.Title, summary))
+			artifacts = mergeArtifacts(artifacts, child.Result.Artifacts...)
 			if !child.Result.Success {
 				allSuccess = false
 			}
 		}
 	}
 
-	// 尝试使用 LLM 整合结果
-	synthesized, err := e.planner.SynthesizeResults(e.ctx, node, summaries)
-	if err != nil {
-		synthesized = fmt.Sprintf("完成 %d 个子任务", len(node.Children))
+	// 尝试使用 LLM 整合结果（预算耗尽时直接拼接已有结果）
+	var synthesized string
+	if e.budgetExhausted() {
+		synthesized = joinStrings(summaries, "\n")
+		if skipped > 0 {
+			synthesized += fmt.Sprintf("\n（%d 个子任务因预算耗尽被跳过）", skipped)
+		}
+	} else {
+		var err error
+		synthesized, err = e.planner.SynthesizeResults(e.ctx, node, summaries)
+		if err != nil {
+			synthesized = fmt.Sprintf("完成 %d 个子任务", len(node.Children))
+		}
 	}
 
 	node.Result = &TaskResult{
-		Success: allSuccess,
-		Summary: synthesized,
-		Output:  joinStrings(summaries, "\n"),
+		Success:   allSuccess,
+		Summary:   synthesized,
+		Output:    joinStrings(summaries, "\n"),
+		Artifacts: artifacts,
 	}
 }
 
 // handleNodeError 处理节点错误
 func (e *TaskExecutor) handleNodeError(node *TaskNode, err error) error {
 	node.SetStatus(NodeFailed)
-	node.Result = NewTaskResultError(err.Error())
+	node.SetResult(NewTaskResultError(err.Error()))
 	node.AddLog(LogError, "failed", fmt.Sprintf("执行失败: %v", err))
 	Display.NodeFailed(node, err)
+
+	switch llm.ErrorKindOf(err) {
+	case llm.ErrAuth:
+		// 认证失败时其他节点的调用同样会失败，直接取消整个任务
+		e.root.AddLog(LogError, "failed", "LLM 认证失败，取消任务")
+		Display.ShowMessage("🔑", "LLM 认证失败，请检查 API 密钥，任务已取消")
+		e.cancel()
+	case llm.ErrContextLength:
+		node.AddLog(LogWarn, "failed", "输入超出模型上下文限制，可降低上下文长度或换用更大上下文的模型")
+	case llm.ErrRateLimit, llm.ErrServer, llm.ErrNetwork:
+		node.AddLog(LogWarn, "failed", "LLM 服务在多次重试后仍不可用")
+	}
 	return err
 }
 
+// budgetExhausted 检查预算是否耗尽，首次耗尽时标记根节点
+func (e *TaskExecutor) budgetExhausted() bool {
+	if !e.budget.Exhausted() {
+		return false
+	}
+	e.budgetOnce.Do(func() {
+		reason := e.budget.Reason()
+		e.root.SetBudgetExhausted(reason)
+		e.root.AddLog(LogWarn, "budget", fmt.Sprintf("预算耗尽: %s，停止拆解并使用已有结果", reason))
+		Display.ShowMessage("💸", fmt.Sprintf("预算耗尽: %s", reason))
+	})
+	return true
+}
+
+// SetBudgetLimits 更新任务预算（供 Web API 调用，0 表示不限制）
+func (e *TaskExecutor) SetBudgetLimits(maxTokens int, maxCost float64, maxDurationSec int, maxLLMCalls int) {
+	limits := BudgetConfig{
+		MaxTokens:      maxTokens,
+		MaxCost:        maxCost,
+		MaxDurationSec: maxDurationSec,
+		MaxLLMCalls:    maxLLMCalls,
+	}
+	e.budget.SetLimits(limits)
+	e.root.AddLog(LogInfo, "budget", fmt.Sprintf("预算已更新: %s", limits))
+}
+
+// SetRunCode 设置任务是否允许执行代码（在 Execute 之前调用）
+func (e *TaskExecutor) SetRunCode(enabled bool) {
+	e.config.RunCode = enabled
+}
+
+// AnswerToolApproval 批准或拒绝等待中的工具调用（供 Web API 调用）
+func (e *TaskExecutor) AnswerToolApproval(id string, approved bool, reason string) error {
+	return e.approvals.Answer(id, approved, reason)
+}
+
+// PendingToolApprovals 等待批准的工具调用
+func (e *TaskExecutor) PendingToolApprovals() []map[string]interface{} {
+	pending := e.approvals.Pending()
+	list := make([]map[string]interface{}, 0, len(pending))
+	for _, approval := range pending {
+		list = append(list, approvalData(approval))
+	}
+	return list
+}
+
+// BudgetStatus 获取预算状态
+func (e *TaskExecutor) BudgetStatus() map[string]interface{} {
+	return e.budget.Status()
+}
+
 // Cancel 取消执行
 func (e *TaskExecutor) Cancel() {
 	e.cancel()
@@ -498,6 +862,20 @@ func (e *TaskExecutor) SetRecoveryMode(taskFolder string) {
 	e.taskFolder = taskFolder
 }
 
+// SetTaskFolder 指定新任务使用的输出文件夹（不进入恢复模式）
+func (e *TaskExecutor) SetTaskFolder(taskFolder string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.taskFolder = taskFolder
+}
+
+// TaskFolder 任务输出文件夹名（相对输出目录，任务开始执行前可能为空）
+func (e *TaskExecutor) TaskFolder() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.taskFolder
+}
+
 // joinStrings 连接字符串
 func joinStrings(strs []string, sep string) string {
 	if len(strs) == 0 {
@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/embedding"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExecute 完整执行一次任务：规划 -> 两个串行子任务（含工具调用）-> 整合 -> 验证
+func TestExecute(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	srv := newFakeLLM(t)
+
+	root := NewTaskNode(testTaskTitle, testTaskDescription)
+	root.Goal = "完成调研报告"
+	executor := newTestExecutor(root, srv)
+	executor.SetTaskFolder("task")
+
+	if err := executor.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if root.Status != NodeDone || root.Result == nil || !root.Result.Success {
+		t.Fatalf("root not completed successfully: status=%s result=%+v", root.Status, root.Result)
+	}
+	toolRounds := 0
+	for _, req := range srv.Requests() {
+		if req.LastMessage().Role == "tool" {
+			toolRounds++
+		}
+	}
+	if toolRounds != 1 {
+		t.Errorf("requests after tool result = %d, want 1", toolRounds)
+	}
+	if got := countRequests(srv, "VERIFICATION_PASSED"); got != 1 {
+		t.Errorf("verification requests = %d, want 1", got)
+	}
+
+	taskDir := filepath.Join(outputDir, "task")
+	docs, _ := filepath.Glob(filepath.Join(taskDir, "doc", "收集资料", "*.md"))
+	if len(docs) != 1 {
+		t.Errorf("saveToDisk documents = %v, want exactly one", docs)
+	}
+	if _, err := os.Stat(filepath.Join(taskDir, LogSubDir, "checkpoint.json")); !os.IsNotExist(err) {
+		t.Errorf("checkpoint should be cleaned up after completion, stat err = %v", err)
+	}
+
+	assertGolden(t, "execute_tree", dumpTree(root))
+	assertGolden(t, "execute_execution_json", normalize(readFile(t, filepath.Join(taskDir, LogSubDir, "execution.json")), outputDir))
+	assertGolden(t, "execute_summary", normalize(readFile(t, filepath.Join(taskDir, LogSubDir, "summary.txt")), outputDir))
+	assertGolden(t, "execute_index", normalize(readFile(t, filepath.Join(taskDir, LogSubDir, "INDEX.md")), outputDir))
+	assertGolden(t, "execute_readme", normalize(readFile(t, filepath.Join(taskDir, "README.md")), outputDir))
+}
+
+// TestRecoverFromCheckpoint 从检查点恢复：已完成的子任务不再调用 LLM，只执行剩余子任务
+func TestRecoverFromCheckpoint(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	srv := newFakeLLM(t)
+
+	root := NewTaskNode(testTaskTitle, testTaskDescription)
+	root.Goal = "完成调研报告"
+	root.Status = NodeRunning
+	collect := root.NewChildNode("收集资料", "收集 goroutine 和 channel 资料", "资料清单")
+	collect.CanDecompose = false
+	collect.Status = NodeDone
+	collect.Result = NewTaskResult("- goroutine\n- channel\n", "资料已保存")
+	write := root.NewChildNode("撰写报告", "根据资料撰写报告", "调研报告")
+	write.CanDecompose = false
+	write.Status = NodeRunning // 中断时正在执行，恢复后应重置为 pending
+
+	if _, err := SaveCheckpoint(root, "task"); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	tasks, err := ListRecoverableTasks()
+	if err != nil || len(tasks) != 1 || tasks[0].TaskFolder != "task" {
+		t.Fatalf("ListRecoverableTasks = %+v, %v; want the saved task", tasks, err)
+	}
+
+	recovered, executor, err := RecoverTaskByFolder("task")
+	if err != nil {
+		t.Fatalf("RecoverTaskByFolder: %v", err)
+	}
+	if got := recovered.Children[1].Status; got != NodePending {
+		t.Errorf("interrupted child status = %s, want %s", got, NodePending)
+	}
+	executor.planner.SetProvider(srv.Provider())
+
+	if err := executor.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	for _, req := range srv.Requests() {
+		if strings.Contains(req.Text(), "标题: 收集资料") && !strings.Contains(req.Text(), "标题: 撰写报告") {
+			t.Errorf("completed subtask was executed again: %.200q", req.LastMessage().Content)
+		}
+	}
+	if got := countRequests(srv, "任务规划专家"); got != 0 {
+		t.Errorf("planning requests = %d, want 0 (tree restored from checkpoint)", got)
+	}
+
+	assertGolden(t, "recover_tree", dumpTree(recovered))
+	assertGolden(t, "recover_summary", normalize(readFile(t, filepath.Join(outputDir, "task", LogSubDir, "summary.txt")), outputDir))
+}
+
+// TestExecuteInjectsPriorResearch 以往任务输出中的相关段落注入到执行提示词，当前任务目录不参与检索
+func TestExecuteInjectsPriorResearch(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	srv := newFakeLLM(t)
+
+	prior := filepath.Join(outputDir, "旧任务", "doc", "goroutine.md")
+	os.MkdirAll(filepath.Dir(prior), 0755)
+	os.WriteFile(prior, []byte("# Go 并发模型\n\ngoroutine 由 GMP 调度器调度。\n"), 0644)
+
+	index, err := embedding.NewIndex(embedding.NewHashProvider(0), filepath.Join(t.TempDir(), "store.json"), []string{outputDir}, embedding.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcp.SetRecallIndex(index, 0, 0)
+	t.Cleanup(func() { mcp.SetRecallIndex(nil, 0, 0) })
+
+	root := NewTaskNode(testTaskTitle, testTaskDescription)
+	root.Goal = "完成调研报告"
+	executor := newTestExecutor(root, srv)
+	executor.config.PriorResearch = 2
+	executor.SetTaskFolder("task")
+	if err := executor.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if len(root.Context.PriorResearch) != 1 || root.Context.PriorResearch[0].Ref != prior+":1-3" {
+		t.Fatalf("prior research = %+v", root.Context.PriorResearch)
+	}
+	if got := countRequests(srv, "## 以往调研的相关内容\n### "+prior+":1-3"); got < 2 {
+		t.Errorf("requests with prior research = %d, want all execute requests", got)
+	}
+
+	// 工具检索时排除 ctx 中的当前任务目录
+	ctx := context.WithValue(context.Background(), mcp.ContextKeyTaskDir, filepath.Join(outputDir, "task"))
+	resp := mcp.CallMCPTool(ctx, "recallPriorResearch", map[string]interface{}{"query": "资料清单 goroutine"})
+	if !resp.Success || !strings.Contains(resp.Result.(string), "1. "+prior) || strings.Contains(resp.Result.(string), filepath.Join(outputDir, "task")) {
+		t.Errorf("recallPriorResearch = %+v", resp)
+	}
+}
+
+// TestExecuteDAG 按依赖调度：依赖完成后才开始并带上依赖结果，失败的子任务像串行模式一样重试
+func TestExecuteDAG(t *testing.T) {
+	useTempOutputDir(t)
+	mcp.Init()
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("fake-model"))
+	srv.On(llmtest.Contains("标题: 采集数据"), llmtest.Text("采集结果：样本 42 条"))
+	srv.On(llmtest.Contains("标题: 分析数据"), llmtest.Text("分析完成"))
+	// 第一次返回不可在节点内重试的错误，节点级重试后成功
+	srv.On(llmtest.Contains("标题: 查阅文献"), llmtest.Error(400, `{"error":{"message":"maximum context length exceeded"}}`), llmtest.Text("文献综述"))
+
+	root := NewTaskNode("数据分析", "采集并分析数据")
+	root.ExecutionMode = ModeDAG
+	collect := root.NewChildNode("采集数据", "采集样本", "样本")
+	analyze := root.NewChildNode("分析数据", "分析样本", "结论")
+	review := root.NewChildNode("查阅文献", "查阅相关文献", "综述")
+	for _, child := range root.Children {
+		child.CanDecompose = false
+	}
+	analyze.DependsOn = []string{collect.ID}
+
+	executor := newTestExecutor(root, srv)
+	if err := executor.executeDAG(root); err != nil {
+		t.Fatalf("executeDAG: %v", err)
+	}
+
+	for _, child := range root.Children {
+		if child.Status != NodeDone {
+			t.Errorf("%s status = %s", child.Title, child.Status)
+		}
+	}
+	if review.RetryCount != 1 {
+		t.Errorf("retry count = %d, want 1", review.RetryCount)
+	}
+
+	// 分析数据的请求在采集数据完成之后发出，且包含其结果
+	collectDone := collect.LLMCalls[0].StartTime.Add(time.Duration(collect.LLMCalls[0].DurationMs) * time.Millisecond)
+	if start := analyze.LLMCalls[0].StartTime; start.Before(collectDone) {
+		t.Errorf("dependent started at %s before dependency finished at %s", start, collectDone)
+	}
+	for _, req := range srv.Requests() {
+		if strings.Contains(req.Text(), "标题: 分析数据") && !strings.Contains(req.Text(), "样本 42 条") {
+			t.Error("dependency result missing from dependent prompt")
+		}
+	}
+}
+
+// TestFindDependencyCycle 依赖环检测：无环、链式依赖和忽略同级之外的依赖返回 nil，成环时返回环上的节点
+func TestFindDependencyCycle(t *testing.T) {
+	newNodes := func(deps map[string][]string) []*TaskNode {
+		var nodes []*TaskNode
+		for _, title := range []string{"A", "B", "C"} {
+			n := NewTaskNode(title, "")
+			n.ID = title
+			n.DependsOn = deps[title]
+			nodes = append(nodes, n)
+		}
+		return nodes
+	}
+
+	tests := []struct {
+		name string
+		deps map[string][]string
+		want string
+	}{
+		{"independent", nil, ""},
+		{"chain", map[string][]string{"B": {"A"}, "C": {"B", "A"}}, ""},
+		{"unknown dependency", map[string][]string{"A": {"Z"}}, ""},
+		{"two nodes", map[string][]string{"A": {"B"}, "B": {"A"}}, "A → B → A"},
+		{"three nodes", map[string][]string{"A": {"C"}, "B": {"A"}, "C": {"B"}}, "A → C → B → A"},
+		{"cycle behind acyclic node", map[string][]string{"A": {"B"}, "B": {"C"}, "C": {"B"}}, "B → C → B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := joinStrings(findDependencyCycle(newNodes(tt.deps)), " → ")
+			if got != tt.want {
+				t.Errorf("cycle = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// executeDAG 拒绝执行成环的子任务
+	root := NewTaskNode("root", "")
+	root.Children = newNodes(map[string][]string{"A": {"B"}, "B": {"A"}})
+	executor := NewTaskExecutor(root, NewTaskPlanner(), DefaultExecutionConfig())
+	if err := executor.executeDAG(root); err == nil || !strings.Contains(err.Error(), "依赖存在环") {
+		t.Errorf("executeDAG with cycle: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"deepknowledgesearch/config"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update 使用 go test ./agent -update 重新生成 golden 文件
+var update = flag.Bool("update", false, "update golden files")
+
+// 测试任务
+const (
+	testTaskTitle       = "Go并发模型调研"
+	testTaskDescription = "调研 Go 语言的并发模型并撰写报告"
+)
+
+// fixedTime 构造树时使用的固定时间
+var fixedTime = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// normalizers 去掉 golden 输出中每次运行都会变化的部分
+var normalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`), "<ID>"},
+	{regexp.MustCompile(`"(task_id|node_id)": "[0-9a-f]{8}"`), `"$1": "<ID>"`},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`), "<TIME>"},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}(:\d{2})?`), "<TIME>"},
+	{regexp.MustCompile(`\d{8}_\d{6}`), "<TS>"},
+	{regexp.MustCompile(`"duration_ms": \d+`), `"duration_ms": 0`},
+}
+
+// normalize 规范化输出，并把临时目录替换为 <OUT>
+func normalize(s string, outputDir string) string {
+	if outputDir != "" {
+		s = strings.ReplaceAll(s, outputDir, "<OUT>")
+	}
+	for _, n := range normalizers {
+		s = n.re.ReplaceAllString(s, n.repl)
+	}
+	return s
+}
+
+// assertGolden 与 testdata/<name>.golden 比较（-update 时重写）
+func assertGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch (run with -update to accept)\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+// readFile 读取文件内容
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// useTempOutputDir 把输出目录指向临时目录，测试结束后恢复
+func useTempOutputDir(t *testing.T) string {
+	t.Helper()
+	cfg := config.GetConfig()
+	prev := cfg.OutputDir
+	dir := t.TempDir()
+	cfg.OutputDir = dir
+	t.Cleanup(func() { cfg.OutputDir = prev })
+	return dir
+}
+
+// newFakeLLM 启动按标准剧本应答的假 LLM：
+// 根任务拆为两个串行子任务；"收集资料" 先调用 saveToDisk 再返回结果；整合和验证直接通过
+func newFakeLLM(t *testing.T) *llmtest.Server {
+	t.Helper()
+	mcp.Init()
+
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("fake-model"))
+
+	srv.On(llmtest.All(llmtest.System("任务规划专家"), llmtest.Contains("标题: "+testTaskTitle)), llmtest.JSON(map[string]interface{}{
+		"title":          testTaskTitle,
+		"goal":           "完成调研报告",
+		"execution_mode": "sequential",
+		"subtasks": []map[string]interface{}{
+			{"title": "收集资料", "description": "收集 goroutine 和 channel 资料", "goal": "资料清单", "can_decompose": false},
+			{"title": "撰写报告", "description": "根据资料撰写报告", "goal": "调研报告", "can_decompose": false},
+		},
+		"reasoning": "先收集后撰写",
+	}))
+	srv.On(llmtest.All(llmtest.System("任务执行助手"), llmtest.ToolResult()), llmtest.Text("资料已保存"))
+	srv.On(llmtest.All(llmtest.System("任务执行助手"), llmtest.Contains("标题: 撰写报告")), llmtest.Text("# Go 并发报告\n\ngoroutine 是轻量级线程，channel 用于通信。"))
+	srv.On(llmtest.All(llmtest.System("任务执行助手"), llmtest.Contains("标题: 收集资料")), llmtest.ToolCall("saveToDisk", map[string]interface{}{
+		"title":   "资料清单",
+		"content": "- goroutine\n- channel\n",
+	}))
+	srv.On(llmtest.System("结果整合专家"), llmtest.Text("完成了 Go 并发模型调研：收集资料并撰写了报告。"))
+	srv.On(llmtest.System("任务验证专家"), llmtest.Text("结果完整。VERIFICATION_PASSED"))
+	return srv
+}
+
+// useModel 让所有阶段只使用给定模型，测试结束后恢复原配置
+func useModel(t *testing.T, model llm.ModelConfig) {
+	t.Helper()
+	cfg := llm.GetConfig()
+	prevModels, prevCurrent := cfg.Models, cfg.CurrentModel
+	prevPhases, prevFallbacks := cfg.PhaseModels, cfg.FallbackModels
+
+	llm.InitWithConfig([]llm.ModelConfig{model}, model.Name)
+	llm.SetPhaseModels(nil)
+	llm.SetFallbackModels(nil)
+	llm.SetRetryPolicy(llm.RetryPolicy{})
+
+	t.Cleanup(func() {
+		cfg.Models, cfg.CurrentModel = prevModels, prevCurrent
+		cfg.PhaseModels, cfg.FallbackModels = prevPhases, prevFallbacks
+		llm.SetRetryPolicy(llm.DefaultRetryPolicy)
+	})
+}
+
+// newTestExecutor 创建使用假 LLM 的执行器（无预算、无缓存、串行调用）
+func newTestExecutor(root *TaskNode, srv *llmtest.Server) *TaskExecutor {
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+
+	execConfig := DefaultExecutionConfig()
+	execConfig.Budget = BudgetConfig{}
+	execConfig.CacheMode = llm.CacheOff
+	execConfig.MaxConcurrency = 1
+	return NewTaskExecutor(root, planner, execConfig)
+}
+
+// dumpTree 输出任务树的稳定文本表示（用于 golden 比较）
+func dumpTree(node *TaskNode) string {
+	var sb strings.Builder
+	var walk func(n *TaskNode, depth int)
+	walk = func(n *TaskNode, depth int) {
+		indent := strings.Repeat("  ", depth)
+		fmt.Fprintf(&sb, "%s- %s [%s]", indent, n.Title, n.Status)
+		if len(n.Children) > 0 {
+			fmt.Fprintf(&sb, " mode=%s", n.ExecutionMode)
+		}
+		sb.WriteString("\n")
+		if n.Result != nil {
+			fmt.Fprintf(&sb, "%s  result: success=%v summary=%q\n", indent, n.Result.Success, n.Result.Summary)
+		}
+		for _, call := range n.LLMCalls {
+			fmt.Fprintf(&sb, "%s  llm: %s model=%s tokens=%d", indent, call.Type, call.Model, call.TotalTokens)
+			if call.Error != "" {
+				fmt.Fprintf(&sb, " error=%q", call.Error)
+			}
+			sb.WriteString("\n")
+		}
+		for _, child := range n.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(node, 0)
+	return sb.String()
+}
+
+// countRequests 统计包含 substr 的请求数
+func countRequests(srv *llmtest.Server, substr string) int {
+	n := 0
+	for _, req := range srv.Requests() {
+		if strings.Contains(req.Text(), substr) {
+			n++
+		}
+	}
+	return n
+}
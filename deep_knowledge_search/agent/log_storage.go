@@ -2,6 +2,7 @@ package agent
 
 import (
 	"deepknowledgesearch/config"
+	"deepknowledgesearch/mcp"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -15,15 +16,20 @@ const LogSubDir = "logs"
 
 // TaskExecutionLog 任务执行日志（用于保存和回放）
 type TaskExecutionLog struct {
-	TaskID      string             `json:"task_id"`
-	Title       string             `json:"title"`
-	Description string             `json:"description"`
-	StartTime   time.Time          `json:"start_time"`
-	EndTime     time.Time          `json:"end_time"`
-	Success     bool               `json:"success"`
-	Logs        []ExecutionLog     `json:"logs"`
-	Result      *TaskResult        `json:"result,omitempty"`
-	Children    []TaskExecutionLog `json:"children,omitempty"`
+	TaskID          string             `json:"task_id"`
+	Title           string             `json:"title"`
+	Description     string             `json:"description"`
+	Goal            string             `json:"goal,omitempty"`
+	StartTime       time.Time          `json:"start_time"`
+	EndTime         time.Time          `json:"end_time"`
+	Success         bool               `json:"success"`
+	Logs            []ExecutionLog     `json:"logs"`
+	Result          *TaskResult        `json:"result,omitempty"`
+	Usage           UsageStats         `json:"usage"` // 节点及子孙节点的用量汇总
+	BudgetExhausted string             `json:"budget_exhausted,omitempty"`
+	LLMCalls        []LLMCallRecord    `json:"llm_calls,omitempty"` // 节点自身的 LLM 调用（用于回放）
+	Sources         []mcp.Source       `json:"sources,omitempty"`   // 引用来源（父节点为合并后的列表）
+	Children        []TaskExecutionLog `json:"children,omitempty"`
 }
 
 // SaveExecutionLog 保存任务执行日志
@@ -104,7 +110,7 @@ func GenerateArticleIndex(node *TaskNode, taskFolder string) string {
 
 	// 输出文件列表
 	sb.WriteString("## 📁 输出文件\n\n")
-	outputDir := filepath.Join("output", taskFolder, "doc")
+	outputDir := filepath.Join(config.GetOutputDir(), taskFolder, "doc")
 	files := listOutputFiles(outputDir)
 	if len(files) > 0 {
 		for _, f := range files {
@@ -137,6 +143,8 @@ func buildTaskTree(sb *strings.Builder, node *TaskNode, depth int) {
 	if node.Result == nil || !node.Result.Success {
 		if node.Status == NodeFailed {
 			status = "❌"
+		} else if node.Status == NodeSkipped {
+			status = "⏭️"
 		} else if node.Status == NodeRunning {
 			status = "🔄"
 		} else {
@@ -201,6 +209,14 @@ func buildSummary(node *TaskNode) string {
 	}
 	summary += fmt.Sprintf("子任务数: %d\n", len(node.Children))
 
+	usage := node.TotalUsage()
+	summary += fmt.Sprintf("LLM 调用: %d 次\n", usage.Calls)
+	summary += fmt.Sprintf("Token 用量: %d (输入 %d / 输出 %d)\n", usage.TotalTokens, usage.PromptTokens, usage.CompletionTokens)
+	summary += fmt.Sprintf("费用: %.4f\n", usage.Cost)
+	if node.BudgetExhausted != "" {
+		summary += fmt.Sprintf("预算耗尽: %s（结果为部分结果）\n", node.BudgetExhausted)
+	}
+
 	if node.Result != nil && node.Result.Summary != "" {
 		summary += fmt.Sprintf("\n结果摘要:\n%s\n", node.Result.Summary)
 	}
@@ -211,12 +227,17 @@ func buildSummary(node *TaskNode) string {
 // buildExecutionLog 从 TaskNode 构建执行日志
 func buildExecutionLog(node *TaskNode) TaskExecutionLog {
 	log := TaskExecutionLog{
-		TaskID:      node.ID,
-		Title:       node.Title,
-		Description: node.Description,
-		StartTime:   node.CreatedAt,
-		Logs:        node.Logs,
-		Result:      node.Result,
+		TaskID:          node.ID,
+		Title:           node.Title,
+		Description:     node.Description,
+		Goal:            node.Goal,
+		StartTime:       node.CreatedAt,
+		Logs:            node.Logs,
+		Result:          node.Result,
+		Usage:           node.TotalUsage(),
+		BudgetExhausted: node.BudgetExhausted,
+		LLMCalls:        node.GetLLMCalls(),
+		Sources:         node.GetSources(),
 	}
 
 	if node.FinishedAt != nil {
@@ -398,6 +419,15 @@ func GenerateOutputReadme(node *TaskNode, outputDir string) error {
 		sb.WriteString("\n")
 	}
 
+	// 参考来源（子任务来源已逐级合并到根节点）
+	if sources := node.GetSources(); len(sources) > 0 {
+		sb.WriteString("---\n\n## 📚 参考来源\n\n")
+		for _, src := range sources {
+			sb.WriteString(formatSourceLink(src, outputDir) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
 	// 完整树形视图
 	sb.WriteString("---\n\n## 🌳 完整树形视图\n\n```\n")
 	sb.WriteString(filepath.Base(outputDir) + "/\n│\n")
@@ -432,6 +462,28 @@ func GenerateOutputReadme(node *TaskNode, outputDir string) error {
 	return os.WriteFile(readmePath, []byte(sb.String()), 0644)
 }
 
+// formatSourceLink 把来源格式化为 markdown 列表项，输出目录内的缓存文件使用相对链接
+func formatSourceLink(src mcp.Source, outputDir string) string {
+	title := src.Title
+	if title == "" {
+		title = src.Key()
+	}
+	line := fmt.Sprintf("- [%d] ", src.ID)
+	if src.URL != "" {
+		line += fmt.Sprintf("[%s](%s)", title, src.URL)
+	} else {
+		line += title
+	}
+	if src.Path != "" {
+		if rel, err := filepath.Rel(outputDir, src.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			line += fmt.Sprintf(" （[本地副本](./%s)）", filepath.ToSlash(rel))
+		} else if src.URL == "" {
+			line += fmt.Sprintf(" （`%s`）", src.Path)
+		}
+	}
+	return line
+}
+
 // buildOutputTree 构建输出目录的任务树
 func buildOutputTree(sb *strings.Builder, node *TaskNode, depth int, isLast bool) {
 	indent := ""
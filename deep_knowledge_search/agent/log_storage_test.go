@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"deepknowledgesearch/mcp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newFixtureTree 构造一棵固定内容的任务树：根任务完成，一个子任务完成、一个失败、一个跳过
+func newFixtureTree() *TaskNode {
+	finished := fixedTime.Add(90 * time.Second)
+
+	root := NewTaskNode(testTaskTitle, testTaskDescription)
+	root.ID = "root0001"
+	root.Goal = "完成调研报告"
+	root.CreatedAt = fixedTime
+	root.FinishedAt = &finished
+	root.Status = NodeDone
+	root.Result = NewTaskResult("完整报告", "完成了 Go 并发模型调研。")
+	root.AddSource(mcp.Source{Title: "Effective Go", URL: "https://go.dev/doc/effective_go", Tool: "webSearch"})
+	root.AddSource(mcp.Source{Title: "团队笔记", Path: "/docs/notes.md", Tool: "searchLocalDocs"})
+	root.AddLLMCall(LLMCallRecord{Type: "plan", Model: "fake-model", Response: "{}", StartTime: fixedTime, PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150, Cost: 0.0015})
+
+	collect := root.NewChildNode("收集资料", "收集 goroutine 和 channel 资料", "资料清单")
+	collect.ID = "child001"
+	collect.CreatedAt = fixedTime
+	collect.FinishedAt = &finished
+	collect.Status = NodeDone
+	collect.Result = NewTaskResult("- goroutine\n- channel", "资料已保存")
+	collect.AddLLMCall(LLMCallRecord{Type: "execute", Model: "fake-model", Response: "资料已保存", StartTime: fixedTime, PromptTokens: 200, CompletionTokens: 80, TotalTokens: 280, Cost: 0.0028})
+
+	write := root.NewChildNode("撰写报告", "根据资料撰写报告", "调研报告")
+	write.ID = "child002"
+	write.CreatedAt = fixedTime
+	write.Status = NodeFailed
+	write.Result = NewTaskResultError("LLM 执行失败")
+	write.AddLLMCall(LLMCallRecord{Type: "execute", Model: "fake-model", StartTime: fixedTime, Error: "LLM API error (rate_limit): 429", ErrorKind: "rate_limit"})
+
+	review := root.NewChildNode("审阅", "审阅报告", "审阅意见")
+	review.ID = "child003"
+	review.CreatedAt = fixedTime
+	review.Status = NodeSkipped
+
+	for _, n := range []*TaskNode{root, collect, write, review} {
+		n.Logs = []ExecutionLog{{Time: fixedTime, Level: LogInfo, Phase: "executing", Message: "开始执行: " + n.Title, NodeID: n.ID}}
+	}
+	return root
+}
+
+// writeFixtureOutputs 在任务目录中写入输出文件
+func writeFixtureOutputs(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		"Go并发模型调研_最终报告.md":        "# 最终报告\n",
+		"收集资料_20250102_030405.md": "- goroutine\n- channel\n",
+		"验证报告.md":                 "VERIFICATION_PASSED\n",
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveExecutionLog(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	root := newFixtureTree()
+
+	logsDir, err := SaveExecutionLog(root, "task")
+	if err != nil {
+		t.Fatalf("SaveExecutionLog: %v", err)
+	}
+	if want := filepath.Join(outputDir, "task", LogSubDir); logsDir != want {
+		t.Errorf("logs dir = %s, want %s", logsDir, want)
+	}
+
+	execLog, err := LoadExecutionLog(filepath.Join(logsDir, "execution.json"))
+	if err != nil {
+		t.Fatalf("LoadExecutionLog: %v", err)
+	}
+	if len(execLog.Children) != 3 || execLog.Usage.TotalTokens != 430 {
+		t.Errorf("loaded log: children=%d total_tokens=%d, want 3 and 430", len(execLog.Children), execLog.Usage.TotalTokens)
+	}
+
+	assertGolden(t, "log_execution_json", normalize(readFile(t, filepath.Join(logsDir, "execution.json")), outputDir))
+	assertGolden(t, "log_summary", readFile(t, filepath.Join(logsDir, "summary.txt")))
+	assertGolden(t, "log_order_json", readFile(t, filepath.Join(outputDir, "task", "doc", ".order.json")))
+}
+
+func TestGenerateArticleIndex(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	writeFixtureOutputs(t, filepath.Join(outputDir, "task", "doc"))
+
+	assertGolden(t, "article_index", GenerateArticleIndex(newFixtureTree(), "task"))
+}
+
+func TestGenerateOutputReadme(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "task")
+	writeFixtureOutputs(t, dir)
+
+	if err := GenerateOutputReadme(newFixtureTree(), dir); err != nil {
+		t.Fatalf("GenerateOutputReadme: %v", err)
+	}
+	assertGolden(t, "output_readme", normalize(readFile(t, filepath.Join(dir, "README.md")), ""))
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxPlanSubtasks 一次规划最多拆出的子任务数
+const MaxPlanSubtasks = 5
+
+// ErrInvalidPlan 规划响应经过修复后仍不符合 schema
+var ErrInvalidPlan = errors.New("规划响应无效")
+
+// availableToolNames 获取任务可用工具名称（排序后）
+func availableToolNames(ctx context.Context) []string {
+	tools := mcp.GetLLMToolsForContext(ctx)
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// planningResponseFormat 构建 NodePlanningResult 的 JSON Schema（tools 限定为已注册工具）
+func planningResponseFormat(toolNames []string) *llm.ResponseFormat {
+	toolItems := map[string]interface{}{"type": "string"}
+	if len(toolNames) > 0 {
+		toolItems["enum"] = toolNames
+	}
+	tools := map[string]interface{}{"type": "array", "items": toolItems}
+	if len(toolNames) == 0 {
+		tools["maxItems"] = 0
+	}
+
+	subtask := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title":         map[string]interface{}{"type": "string", "minLength": 1},
+			"description":   map[string]interface{}{"type": "string"},
+			"goal":          map[string]interface{}{"type": "string"},
+			"tools":         tools,
+			"can_decompose": map[string]interface{}{"type": "boolean"},
+			"depends_on": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": MaxPlanSubtasks},
+			},
+		},
+		"required": []string{"title", "description", "goal", "can_decompose"},
+	}
+
+	return &llm.ResponseFormat{
+		Name: "node_planning_result",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"title": map[string]interface{}{"type": "string"},
+				"goal":  map[string]interface{}{"type": "string"},
+				"execution_mode": map[string]interface{}{
+					"type": "string",
+					"enum": []string{string(ModeSequential), string(ModeParallel), string(ModeDAG)},
+				},
+				"subtasks": map[string]interface{}{
+					"type":     "array",
+					"maxItems": MaxPlanSubtasks,
+					"items":    subtask,
+				},
+				"reasoning": map[string]interface{}{"type": "string"},
+			},
+			"required": []string{"execution_mode", "subtasks"},
+		},
+	}
+}
+
+// validatePlanningResult 按 schema 校验规划结果，返回发现的所有问题
+// 空 subtasks 表示任务无需拆解，是合法结果
+func validatePlanningResult(result *NodePlanningResult, toolNames []string) []string {
+	var problems []string
+
+	switch result.ExecutionMode {
+	case ModeSequential, ModeParallel, ModeDAG:
+	case "":
+		problems = append(problems, "缺少 execution_mode")
+	default:
+		problems = append(problems, fmt.Sprintf("execution_mode %q 无效", result.ExecutionMode))
+	}
+
+	if len(result.SubTasks) > MaxPlanSubtasks {
+		problems = append(problems, fmt.Sprintf("子任务有 %d 个，最多 %d 个", len(result.SubTasks), MaxPlanSubtasks))
+	}
+
+	known := make(map[string]bool, len(toolNames))
+	for _, name := range toolNames {
+		known[name] = true
+	}
+
+	titles := make(map[string]int)
+	for i, st := range result.SubTasks {
+		idx := i + 1
+		title := strings.TrimSpace(st.Title)
+		if title == "" {
+			problems = append(problems, fmt.Sprintf("子任务 %d 缺少 title", idx))
+		} else if prev, ok := titles[title]; ok {
+			problems = append(problems, fmt.Sprintf("子任务 %d 与子任务 %d 标题重复: %q", idx, prev, title))
+		} else {
+			titles[title] = idx
+		}
+
+		for _, tool := range st.Tools {
+			if !known[tool] {
+				problems = append(problems, fmt.Sprintf("子任务 %d 使用了未知工具 %q", idx, tool))
+			}
+		}
+
+		for _, dep := range st.DependsOn {
+			if dep < 1 || dep > len(result.SubTasks) {
+				problems = append(problems, fmt.Sprintf("子任务 %d 的依赖序号 %d 超出范围 1-%d", idx, dep, len(result.SubTasks)))
+			} else if dep == idx {
+				problems = append(problems, fmt.Sprintf("子任务 %d 依赖了自己", idx))
+			}
+		}
+	}
+
+	return problems
+}
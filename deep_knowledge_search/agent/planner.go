@@ -5,6 +5,7 @@ import (
 	"deepknowledgesearch/llm"
 	"deepknowledgesearch/mcp"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ import (
 // TaskPlanner 任务规划器
 type TaskPlanner struct {
 	maxDepth int
+	provider llm.Provider // LLM 适配器，为空时按当前模型配置解析
 }
 
 // NewTaskPlanner 创建任务规划器
@@ -22,6 +24,181 @@ func NewTaskPlanner() *TaskPlanner {
 	}
 }
 
+// SetProvider 指定 LLM 适配器（覆盖模型配置中的 provider）
+func (p *TaskPlanner) SetProvider(provider llm.Provider) {
+	p.provider = provider
+}
+
+// chat 通过 provider 接口调用 LLM（onDelta 非空时使用流式）
+func (p *TaskPlanner) chat(ctx context.Context, modelConfig llm.ModelConfig, messages []llm.Message, onDelta llm.DeltaFunc) (*llm.ChatResult, error) {
+	provider := p.provider
+	if provider == nil {
+		var err error
+		provider, err = llm.ProviderFor(modelConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return llm.Chat(ctx, provider, messages, llm.ChatOptions{
+		Model:   modelConfig,
+		OnDelta: onDelta,
+		Cache:   cacheModeFromContext(ctx),
+
+		ResponseFormat: responseFormatFromContext(ctx),
+	})
+}
+
+// callInfo 当前 LLM 调用所属的节点和调用类型（供回放等 provider 使用）
+type callInfo struct {
+	NodeTitle string
+	CallType  string
+}
+
+// callInfoContextKey context 中调用信息的键
+type callInfoContextKey struct{}
+
+// withCallInfo 把调用信息放入 context
+func withCallInfo(ctx context.Context, node *TaskNode, callType string) context.Context {
+	return context.WithValue(ctx, callInfoContextKey{}, callInfo{NodeTitle: node.Title, CallType: callType})
+}
+
+// callInfoFromContext 从 context 取出调用信息
+func callInfoFromContext(ctx context.Context) (callInfo, bool) {
+	info, ok := ctx.Value(callInfoContextKey{}).(callInfo)
+	return info, ok
+}
+
+// cacheModeContextKey context 中缓存模式的键
+type cacheModeContextKey struct{}
+
+// withCacheMode 把任务的缓存模式放入 context
+func withCacheMode(ctx context.Context, mode llm.CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeContextKey{}, mode)
+}
+
+// cacheModeFromContext 从 context 取出缓存模式（未设置时不使用缓存）
+func cacheModeFromContext(ctx context.Context) llm.CacheMode {
+	mode, _ := ctx.Value(cacheModeContextKey{}).(llm.CacheMode)
+	return mode
+}
+
+// responseFormatContextKey context 中结构化输出要求的键
+type responseFormatContextKey struct{}
+
+// withResponseFormat 要求本次调用按 JSON Schema 返回
+func withResponseFormat(ctx context.Context, format *llm.ResponseFormat) context.Context {
+	return context.WithValue(ctx, responseFormatContextKey{}, format)
+}
+
+// responseFormatFromContext 从 context 取出结构化输出要求（未设置时为普通文本）
+func responseFormatFromContext(ctx context.Context) *llm.ResponseFormat {
+	format, _ := ctx.Value(responseFormatContextKey{}).(*llm.ResponseFormat)
+	return format
+}
+
+// callLLM 按调用阶段的模型回退链调用 LLM，同时把每次调用记录到节点
+// 当前模型重试后仍失败（或上下文超长）时自动切换到链上的下一个模型
+func (p *TaskPlanner) callLLM(ctx context.Context, node *TaskNode, callType string, messages []llm.Message, onDelta llm.DeltaFunc) (string, error) {
+	models := llm.ModelsForPhase(callPhase(callType))
+
+	var fallbackFrom, fallbackReason string
+	var lastErr error
+	for i, modelConfig := range models {
+		if i > 0 && onDelta != nil {
+			// 回退模型从头输出，丢弃失败模型已推送的部分内容
+			Display.LLMStreamReset(node, callType, modelConfig.Name)
+		}
+		content, err := p.callModel(ctx, node, callType, modelConfig, messages, onDelta, fallbackFrom, fallbackReason)
+		if err == nil {
+			return content, nil
+		}
+		lastErr = err
+
+		if i == len(models)-1 || !llm.ShouldFallback(err) || ctx.Err() != nil || budgetFromContext(ctx).Exhausted() {
+			break
+		}
+
+		next := models[i+1].Name
+		fallbackFrom = modelConfig.Name
+		fallbackReason = truncateString(err.Error(), 200)
+		node.AddLog(LogWarn, "fallback", fmt.Sprintf("模型 %s 调用失败 (%s)，切换到 %s", modelConfig.Name, llm.ErrorKindOf(err), next))
+		Display.LLMFallback(node, callType, modelConfig.Name, next, fallbackReason)
+	}
+
+	return "", lastErr
+}
+
+// callModel 使用指定模型调用一次 LLM 并记录调用（含用量与费用）
+func (p *TaskPlanner) callModel(ctx context.Context, node *TaskNode, callType string, modelConfig llm.ModelConfig, messages []llm.Message, onDelta llm.DeltaFunc, fallbackFrom, fallbackReason string) (string, error) {
+	// 等待并发名额（排队时间不计入调用耗时）
+	release, err := acquireLLMSlot(ctx, node, modelConfig)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// 记录开始时间
+	startTime := time.Now()
+
+	callCtx, cancel := withBudgetDeadline(withToolCallBudget(withToolPolicy(ctx, node), node))
+	defer cancel()
+	result, err := p.chat(withCallInfo(callCtx, node, callType), modelConfig, messages, onDelta)
+	if result == nil {
+		result = &llm.ChatResult{}
+	}
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("执行时长达到预算上限: %w", err)
+	}
+
+	record := LLMCallRecord{
+		Type:             callType,
+		Model:            modelConfig.Name,
+		Messages:         recordMessages(messages),
+		Response:         result.Content,
+		StartTime:        startTime,
+		DurationMs:       time.Since(startTime).Milliseconds(),
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		TotalTokens:      result.Usage.TotalTokens,
+		Cost:             modelConfig.Cost(result.Usage),
+		CacheHit:         result.Rounds > 0 && result.CacheHits == result.Rounds,
+		FallbackFrom:     fallbackFrom,
+		FallbackReason:   fallbackReason,
+	}
+	if n := result.ToolCallCount(); n > 0 {
+		record.Turns = result.Turns
+		record.ToolCalls = n
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.ErrorKind = string(llm.ErrorKindOf(err))
+	}
+	node.AddLLMCall(record)
+
+	return result.Content, err
+}
+
+// callPhase 从调用类型中提取阶段名（如 "execute_retry_1" -> "execute"，"improve_2" -> "improve"）
+func callPhase(callType string) string {
+	if idx := strings.Index(callType, "_"); idx > 0 {
+		return callType[:idx]
+	}
+	return callType
+}
+
+// recordMessages 把请求消息转换为记录格式
+func recordMessages(messages []llm.Message) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+	return result
+}
+
 // ============================================================================
 // 规划结果结构
 // ============================================================================
@@ -42,6 +219,7 @@ type SubTaskPlan struct {
 	Goal         string   `json:"goal"`
 	Tools        []string `json:"tools"`
 	CanDecompose bool     `json:"can_decompose"`
+	DependsOn    []int    `json:"depends_on,omitempty"` // 依赖的同级子任务序号（从 1 开始）
 }
 
 // ============================================================================
@@ -51,7 +229,8 @@ type SubTaskPlan struct {
 // PlanNode 规划任务节点
 func (p *TaskPlanner) PlanNode(ctx context.Context, node *TaskNode) (*NodePlanningResult, error) {
 	// 获取可用工具列表
-	tools := p.getAvailableToolsDescription()
+	tools := p.getAvailableToolsDescription(ctx)
+	toolNames := availableToolNames(ctx)
 
 	// 构建上下文
 	contextStr := node.Context.BuildLLMContext()
@@ -71,48 +250,54 @@ func (p *TaskPlanner) PlanNode(ctx context.Context, node *TaskNode) (*NodePlanni
 		{Role: "user", Content: prompt},
 	}
 
-	// 记录开始时间
-	startTime := time.Now()
-
 	// 注入 OutputPath 到 Context
 	if node.OutputPath != "" {
 		ctx = context.WithValue(ctx, mcp.ContextKeyOutputPath, node.OutputPath)
 	}
 
-	response, err := llm.SendSyncLLMRequest(ctx, messages)
+	// 支持结构化输出的模型按 schema 返回
+	ctx = withResponseFormat(ctx, planningResponseFormat(toolNames))
 
-	// 计算耗时并记录 LLM 调用
-	durationMs := time.Since(startTime).Milliseconds()
-	llmMessages := []map[string]interface{}{
-		{"role": "system", "content": PromptPlanningSystem},
-		{"role": "user", "content": prompt},
-	}
-	node.AddLLMCall("plan", llmMessages, response, startTime, durationMs)
+	response, err := p.callLLM(ctx, node, "plan", messages, nil)
 
 	if err != nil {
 		return nil, fmt.Errorf("LLM 规划失败: %w", err)
 	}
 
-	// 解析 JSON 响应
-	result, err := p.parsePlanningResponse(response)
+	// 解析并校验 JSON 响应
+	result, problems := p.checkPlanningResponse(response, toolNames)
+	if len(problems) == 0 {
+		return result, nil
+	}
+
+	// 带上校验错误请求修复一次
+	node.AddLog(LogWarn, "planning", fmt.Sprintf("规划响应未通过校验，请求修复: %s", strings.Join(problems, "; ")))
+	Display.ShowMessage("🛠️", fmt.Sprintf("规划响应未通过校验 (%d 个问题)，请求修复", len(problems)))
+
+	messages = append(messages,
+		llm.Message{Role: "assistant", Content: response},
+		llm.Message{Role: "user", Content: BuildPlanRepairPrompt(problems, toolNames)},
+	)
+	response, err = p.callLLM(ctx, node, "plan_repair", messages, nil)
 	if err != nil {
-		// 如果解析失败，返回空子任务（直接执行）
-		node.AddLog(LogWarn, "planning", fmt.Sprintf("规划响应解析失败，直接执行: %v", err))
-		return &NodePlanningResult{
-			Title:         node.Title,
-			Goal:          node.Goal,
-			ExecutionMode: ModeSequential,
-			SubTasks:      []SubTaskPlan{},
-		}, nil
+		return nil, fmt.Errorf("LLM 规划修复失败: %w", err)
 	}
 
+	result, problems = p.checkPlanningResponse(response, toolNames)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPlan, strings.Join(problems, "; "))
+	}
+	node.AddLog(LogInfo, "planning", "规划响应修复成功")
 	return result, nil
 }
 
 // ExecuteNode 执行任务节点
 func (p *TaskPlanner) ExecuteNode(ctx context.Context, node *TaskNode) (*TaskResult, error) {
-	// 构建上下文
+	// 构建上下文（附上规划时为该子任务建议的工具）
 	contextStr := node.Context.BuildLLMContext()
+	if suggested := p.suggestedToolsDescription(ctx, node.ToolCalls); suggested != "" {
+		contextStr += "\n## 建议使用的工具\n" + suggested
+	}
 
 	// 构建 prompt
 	prompt := BuildNodeExecutionPrompt(
@@ -128,42 +313,53 @@ func (p *TaskPlanner) ExecuteNode(ctx context.Context, node *TaskNode) (*TaskRes
 		{Role: "user", Content: prompt},
 	}
 
-	// 记录开始时间 (已移至循环内)
-	// startTime := time.Now()
-
 	// 注入 OutputPath 到 Context
 	if node.OutputPath != "" {
 		ctx = context.WithValue(ctx, mcp.ContextKeyOutputPath, node.OutputPath)
 	}
+	ctx = withSourceRecorder(ctx, node)
+	artifacts := &artifactList{}
+	ctx = withArtifactRecorder(ctx, node, artifacts.add)
 
 	var response string
 	var err error
 	maxRetries := 3
 
+	attempts := 0
+	loopHinted := false
 	for i := 0; i < maxRetries; i++ {
-		// 每次重试重新计时
-		callStartTime := time.Now()
-
-		response, err = llm.SendSyncLLMRequest(ctx, messages)
-
-		// 计算耗时并记录 LLM 调用
-		durationMs := time.Since(callStartTime).Milliseconds()
-		llmMessages := []map[string]interface{}{
-			{"role": "system", "content": PromptExecutionSystem},
-			{"role": "user", "content": prompt},
-		}
-
+		attempts++
 		// 记录调用（包含重试信息）
 		callType := "execute"
 		if i > 0 {
 			callType = fmt.Sprintf("execute_retry_%d", i)
 		}
-		node.AddLLMCall(callType, llmMessages, response, callStartTime, durationMs)
+
+		// 流式执行，增量内容实时推送到 Dashboard
+		response, err = p.callLLM(ctx, node, callType, messages, func(delta string) {
+			Display.LLMDelta(node, callType, delta)
+		})
 
 		if err == nil {
 			break
 		}
 
+		// 任务取消或预算耗尽时不再重试
+		if ctx.Err() != nil || budgetFromContext(ctx).Exhausted() {
+			break
+		}
+
+		// 限流、服务端和网络错误已在 llm 内按退避策略重试过；认证、上下文超长等错误重试无意义
+		if llm.IsRetryable(err) || llm.IsFatal(err) {
+			break
+		}
+
+		// 工具调用轮数用完：不把中途的回复当作结果，提示模型减少工具调用后重试
+		if errors.Is(err, llm.ErrMaxIterations) && !loopHinted {
+			loopHinted = true
+			messages = append(messages, llm.Message{Role: "user", Content: BuildToolLoopExhaustedPrompt(llm.GetMaxToolIterations())})
+		}
+
 		if i < maxRetries-1 {
 			node.AddLog(LogWarn, "retry", fmt.Sprintf("LLM 执行失败，准备重试 (%d/%d): %v", i+1, maxRetries, err))
 			time.Sleep(time.Second * 2)
@@ -171,13 +367,15 @@ func (p *TaskPlanner) ExecuteNode(ctx context.Context, node *TaskNode) (*TaskRes
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("LLM 执行失败 (重试 %d 次后): %w", maxRetries, err)
+		return nil, fmt.Errorf("LLM 执行失败 (尝试 %d 次后): %w", attempts, err)
 	}
 
 	// 生成摘要
 	summary := p.summarizeResponse(response)
 
-	return NewTaskResult(response, summary), nil
+	result := NewTaskResult(response, summary)
+	result.Artifacts = artifacts.list()
+	return result, nil
 }
 
 // SynthesizeResults 整合子任务结果
@@ -188,10 +386,17 @@ func (p *TaskPlanner) SynthesizeResults(ctx context.Context, node *TaskNode, sum
 
 	childResults := strings.Join(summaries, "\n")
 
+	// 子节点来源已合并到本节点并重新编号
+	sources := formatBibliography(node.GetSources())
+	if sources == "" {
+		sources = "无"
+	}
+
 	prompt := BuildResultSynthesisPrompt(
 		node.Title,
 		node.Goal,
 		childResults,
+		sources,
 	)
 
 	messages := []llm.Message{
@@ -199,23 +404,12 @@ func (p *TaskPlanner) SynthesizeResults(ctx context.Context, node *TaskNode, sum
 		{Role: "user", Content: prompt},
 	}
 
-	// 记录开始时间
-	startTime := time.Now()
-
 	// 注入 OutputPath 到 Context (虽然整合阶段可能不需要写文件，但保持一致)
 	if node.OutputPath != "" {
 		ctx = context.WithValue(ctx, mcp.ContextKeyOutputPath, node.OutputPath)
 	}
 
-	response, err := llm.SendSyncLLMRequest(ctx, messages)
-
-	// 计算耗时并记录 LLM 调用
-	durationMs := time.Since(startTime).Milliseconds()
-	llmMessages := []map[string]interface{}{
-		{"role": "system", "content": "你是一个结果整合专家。"},
-		{"role": "user", "content": prompt},
-	}
-	node.AddLLMCall("synthesize", llmMessages, response, startTime, durationMs)
+	response, err := p.callLLM(ctx, node, "synthesize", messages, nil)
 
 	if err != nil {
 		return childResults, err
@@ -247,8 +441,19 @@ func (p *TaskPlanner) VerifyResult(ctx context.Context, node *TaskNode, result s
 	if node.OutputPath != "" {
 		ctx = context.WithValue(ctx, mcp.ContextKeyOutputPath, node.OutputPath)
 	}
+	ctx = withSourceRecorder(ctx, node)
+	ctx = withArtifactRecorder(ctx, node, node.AddResultArtifact)
 
 	for iteration := 0; iteration < maxVerificationIterations; iteration++ {
+		// 预算耗尽时停止迭代验证
+		if iteration > 0 && budgetFromContext(ctx).Exhausted() {
+			node.AddLog(LogWarn, "verification", "预算耗尽，停止验证")
+			return &VerificationResult{
+				Passed:   false,
+				Feedback: "预算耗尽，停止验证",
+			}, nil
+		}
+
 		Display.ShowMessage("🔍", fmt.Sprintf("验证任务结果 (第 %d 次)...", iteration+1))
 		node.AddLog(LogInfo, "verification", fmt.Sprintf("开始第 %d 次验证", iteration+1))
 
@@ -264,18 +469,7 @@ func (p *TaskPlanner) VerifyResult(ctx context.Context, node *TaskNode, result s
 			{Role: "user", Content: prompt},
 		}
 
-		// 记录开始时间
-		startTime := time.Now()
-
-		response, err := llm.SendSyncLLMRequest(ctx, messages)
-
-		// 记录 LLM 调用
-		durationMs := time.Since(startTime).Milliseconds()
-		llmMessages := []map[string]interface{}{
-			{"role": "system", "content": PromptVerificationSystem},
-			{"role": "user", "content": prompt},
-		}
-		node.AddLLMCall("verify", llmMessages, response, startTime, durationMs)
+		response, err := p.callLLM(ctx, node, "verify", messages, nil)
 
 		if err != nil {
 			// 记录验证尝试（失败）
@@ -343,7 +537,7 @@ func (p *TaskPlanner) VerifyResult(ctx context.Context, node *TaskNode, result s
 ## 验证反馈
 %s
 
-请根据反馈改进结果，确保满足任务目标。`, node.Title, node.Goal, currentResult, response)
+请根据反馈改进结果，确保满足任务目标。如果需要修改已保存的文档，使用 updateFile 编辑原文件，不要用 saveToDisk 另存新文件。`, node.Title, node.Goal, currentResult, response)
 
 			improveMessages := []llm.Message{
 				{Role: "system", Content: PromptExecutionSystem},
@@ -353,18 +547,11 @@ func (p *TaskPlanner) VerifyResult(ctx context.Context, node *TaskNode, result s
 			// 记录改进开始时间
 			improveStartTime := time.Now()
 
-			improvedResult, err := llm.SendSyncLLMRequest(ctx, improveMessages)
+			improvedResult, err := p.callLLM(ctx, node, fmt.Sprintf("improve_%d", iteration+1), improveMessages, nil)
 
 			// 计算改进耗时
 			improveDurationMs := time.Since(improveStartTime).Milliseconds()
 
-			// 记录改进的 LLM 调用
-			improveLLMMessages := []map[string]interface{}{
-				{"role": "system", "content": PromptExecutionSystem},
-				{"role": "user", "content": improvePrompt},
-			}
-			node.AddLLMCall(fmt.Sprintf("improve_%d", iteration+1), improveLLMMessages, improvedResult, improveStartTime, improveDurationMs)
-
 			if err != nil {
 				node.AddLog(LogError, "verification", fmt.Sprintf("改进失败: %v", err))
 				// 更新当前验证尝试，记录改进失败
@@ -409,9 +596,9 @@ func findRootNode(node *TaskNode) *TaskNode {
 // 辅助方法
 // ============================================================================
 
-// getAvailableToolsDescription 获取可用工具描述
-func (p *TaskPlanner) getAvailableToolsDescription() string {
-	tools := mcp.GetAvailableLLMTools()
+// getAvailableToolsDescription 获取任务可用工具描述
+func (p *TaskPlanner) getAvailableToolsDescription(ctx context.Context) string {
+	tools := mcp.GetLLMToolsForContext(ctx)
 	if len(tools) == 0 {
 		return "无可用工具"
 	}
@@ -423,6 +610,25 @@ func (p *TaskPlanner) getAvailableToolsDescription() string {
 	return sb.String()
 }
 
+// suggestedToolsDescription 获取规划建议工具中已注册工具的描述
+func (p *TaskPlanner) suggestedToolsDescription(ctx context.Context, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	suggested := make(map[string]bool, len(names))
+	for _, name := range names {
+		suggested[name] = true
+	}
+
+	var sb strings.Builder
+	for _, tool := range mcp.GetLLMToolsForContext(ctx) {
+		if suggested[tool.Function.Name] {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", tool.Function.Name, tool.Function.Description))
+		}
+	}
+	return sb.String()
+}
+
 // parsePlanningResponse 解析规划响应
 func (p *TaskPlanner) parsePlanningResponse(response string) (*NodePlanningResult, error) {
 	// 清理 JSON
@@ -436,6 +642,15 @@ func (p *TaskPlanner) parsePlanningResponse(response string) (*NodePlanningResul
 	return &result, nil
 }
 
+// checkPlanningResponse 解析并校验规划响应，返回结果和发现的问题
+func (p *TaskPlanner) checkPlanningResponse(response string, toolNames []string) (*NodePlanningResult, []string) {
+	result, err := p.parsePlanningResponse(response)
+	if err != nil {
+		return nil, []string{truncateString(err.Error(), 500)}
+	}
+	return result, validatePlanningResult(result, toolNames)
+}
+
 // summarizeResponse 生成响应摘要
 func (p *TaskPlanner) summarizeResponse(response string) string {
 	// 简单截断作为摘要
@@ -502,8 +717,8 @@ done:
 	return strings.TrimSpace(title)
 }
 
-// ExecuteTask 执行任务（旧 API，使用新的执行器）
-func (p *TaskPlanner) ExecuteTask(description string) (string, error) {
+// NewTask 创建任务根节点和执行器（不执行），maxDepth <= 0 时使用规划器的默认深度
+func (p *TaskPlanner) NewTask(description string, maxDepth int) (*TaskNode, *TaskExecutor) {
 	// 创建根节点 - 使用任务描述提取标题
 	taskTitle := extractTaskTitle(description)
 	node := NewTaskNode(taskTitle, description)
@@ -512,9 +727,16 @@ func (p *TaskPlanner) ExecuteTask(description string) (string, error) {
 	// 创建执行配置
 	config := DefaultExecutionConfig()
 	config.MaxDepth = p.maxDepth
+	if maxDepth > 0 {
+		config.MaxDepth = maxDepth
+	}
 
-	// 创建执行器
-	executor := NewTaskExecutor(node, p, config)
+	return node, NewTaskExecutor(node, p, config)
+}
+
+// ExecuteTask 执行任务（旧 API，使用新的执行器）
+func (p *TaskPlanner) ExecuteTask(description string) (string, error) {
+	node, executor := p.NewTask(description, 0)
 
 	// 注册执行器（如果设置了回调）
 	if OnExecutorCreated != nil {
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// invalidPlan 违反 schema 的规划：执行模式无效、使用未知工具、依赖自己
+var invalidPlan = map[string]interface{}{
+	"execution_mode": "whatever",
+	"subtasks": []map[string]interface{}{
+		{"title": "收集资料", "description": "d", "goal": "g", "tools": []string{"browseWeb"}, "can_decompose": false, "depends_on": []int{1}},
+	},
+}
+
+// validPlan 合法的规划
+var validPlan = map[string]interface{}{
+	"execution_mode": "parallel",
+	"subtasks": []map[string]interface{}{
+		{"title": "收集资料", "description": "d", "goal": "g", "tools": []string{"saveToDisk"}, "can_decompose": false},
+		{"title": "撰写报告", "description": "d", "goal": "g", "can_decompose": false},
+	},
+}
+
+// newPlanningLLM 启动只应答规划和修复请求的假 LLM
+func newPlanningLLM(t *testing.T, plan, repaired interface{}) *llmtest.Server {
+	t.Helper()
+	mcp.Init()
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("fake-model"))
+	srv.On(llmtest.Contains("上一次返回的规划不符合要求"), llmtest.JSON(repaired))
+	srv.On(llmtest.System("任务规划专家"), llmtest.JSON(plan))
+	return srv
+}
+
+func TestPlanNodeRequestsSchema(t *testing.T) {
+	srv := newPlanningLLM(t, validPlan, validPlan)
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+
+	result, err := planner.PlanNode(context.Background(), NewTaskNode(testTaskTitle, testTaskDescription))
+	if err != nil {
+		t.Fatalf("PlanNode: %v", err)
+	}
+	if len(result.SubTasks) != 2 {
+		t.Errorf("subtasks = %d, want 2", len(result.SubTasks))
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("requests = %d, want 1", len(reqs))
+	}
+	format := reqs[0].ResponseFormat
+	if format == nil || format["type"] != "json_schema" {
+		t.Fatalf("response_format = %v, want json_schema", format)
+	}
+}
+
+func TestPlanNodeRepair(t *testing.T) {
+	srv := newPlanningLLM(t, invalidPlan, validPlan)
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	node := NewTaskNode(testTaskTitle, testTaskDescription)
+
+	result, err := planner.PlanNode(context.Background(), node)
+	if err != nil {
+		t.Fatalf("PlanNode: %v", err)
+	}
+	if result.ExecutionMode != ModeParallel || len(result.SubTasks) != 2 {
+		t.Errorf("result = %+v, want the repaired plan", result)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("requests = %d, want plan + repair", len(reqs))
+	}
+	repair := reqs[1].LastMessage().Content
+	for _, want := range []string{`execution_mode "whatever" 无效`, `未知工具 "browseWeb"`, "依赖了自己"} {
+		if !strings.Contains(repair, want) {
+			t.Errorf("repair prompt missing %q:\n%s", want, repair)
+		}
+	}
+
+	calls := node.GetLLMCalls()
+	if len(calls) != 2 || calls[1].Type != "plan_repair" {
+		t.Errorf("llm calls = %+v, want plan and plan_repair", calls)
+	}
+}
+
+func TestPlanNodeInvalidAfterRepair(t *testing.T) {
+	srv := newPlanningLLM(t, invalidPlan, "不是 JSON")
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+
+	_, err := planner.PlanNode(context.Background(), NewTaskNode(testTaskTitle, testTaskDescription))
+	if !errors.Is(err, ErrInvalidPlan) {
+		t.Fatalf("err = %v, want ErrInvalidPlan", err)
+	}
+}
+
+func TestPlanNodeStructuredOutputOff(t *testing.T) {
+	srv := newPlanningLLM(t, validPlan, validPlan)
+	model := srv.Model("fake-model")
+	model.StructuredOutput = llm.StructuredOff
+	useModel(t, model)
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	if _, err := planner.PlanNode(context.Background(), NewTaskNode(testTaskTitle, testTaskDescription)); err != nil {
+		t.Fatalf("PlanNode: %v", err)
+	}
+	if format := srv.Requests()[0].ResponseFormat; format != nil {
+		t.Errorf("response_format = %v, want none", format)
+	}
+}
+
+func TestValidatePlanningResult(t *testing.T) {
+	tools := []string{"saveToDisk"}
+	tests := []struct {
+		name   string
+		result NodePlanningResult
+		want   []string
+	}{
+		{"no subtasks", NodePlanningResult{ExecutionMode: ModeSequential}, nil},
+		{"missing mode", NodePlanningResult{}, []string{"缺少 execution_mode"}},
+		{"too many", NodePlanningResult{ExecutionMode: ModeParallel, SubTasks: []SubTaskPlan{
+			{Title: "1"}, {Title: "2"}, {Title: "3"}, {Title: "4"}, {Title: "5"}, {Title: "6"},
+		}}, []string{"子任务有 6 个，最多 5 个"}},
+		{"duplicate and blank titles", NodePlanningResult{ExecutionMode: ModeParallel, SubTasks: []SubTaskPlan{
+			{Title: "a"}, {Title: " "}, {Title: "a"},
+		}}, []string{"子任务 2 缺少 title", `子任务 3 与子任务 1 标题重复: "a"`}},
+		{"bad dependency", NodePlanningResult{ExecutionMode: ModeDAG, SubTasks: []SubTaskPlan{
+			{Title: "a", DependsOn: []int{3}}, {Title: "b", DependsOn: []int{1}},
+		}}, []string{"子任务 1 的依赖序号 3 超出范围 1-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validatePlanningResult(&tt.result, tools)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanNodeFormatDowngrade(t *testing.T) {
+	mcp.Init()
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("no-schema-model"))
+	srv.On(func(req llmtest.Request) bool { return req.ResponseFormat != nil },
+		llmtest.Error(400, `{"error":{"message":"response_format type is unavailable"}}`))
+	srv.On(llmtest.Any(), llmtest.JSON(validPlan))
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	for i := 0; i < 2; i++ {
+		if _, err := planner.PlanNode(context.Background(), NewTaskNode(testTaskTitle, testTaskDescription)); err != nil {
+			t.Fatalf("PlanNode: %v", err)
+		}
+	}
+
+	// 第一次请求被拒绝后降级重发，之后不再发送 response_format
+	reqs := srv.Requests()
+	if len(reqs) != 3 || reqs[0].ResponseFormat == nil || reqs[1].ResponseFormat != nil || reqs[2].ResponseFormat != nil {
+		t.Errorf("requests = %d, want rejected + downgraded + plain", len(reqs))
+	}
+}
+
+// TestCallLLMFallbackResetsStream 回退到下一个模型前通知前端丢弃失败模型已推送的流式输出
+func TestCallLLMFallbackResetsStream(t *testing.T) {
+	mcp.Init()
+	mcp.RegisterTool("noop", mcp.LLMTool{Type: "function", Function: mcp.LLMFunction{Name: "noop"}},
+		func(ctx context.Context, arguments map[string]interface{}) mcp.MCPToolResponse {
+			return mcp.MCPToolResponse{Success: true, Result: "ok"}
+		})
+	t.Cleanup(func() { mcp.UnregisterTool("noop") })
+
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("primary"))
+	llm.InitWithConfig([]llm.ModelConfig{srv.Model("primary"), srv.Model("secondary")}, "primary")
+	llm.SetPhaseModels(map[string][]string{"execute": {"primary", "secondary"}})
+
+	// primary 先输出部分内容并调用工具，下一轮请求失败；secondary 正常返回
+	isModel := func(name string) llmtest.Matcher {
+		return func(req llmtest.Request) bool { return req.Model == name }
+	}
+	partial := llmtest.ToolCall("noop", nil)
+	partial.Content = "先查一下\n"
+	srv.On(llmtest.All(isModel("primary"), llmtest.ToolResult()), llmtest.Error(500, "upstream failure"))
+	srv.On(isModel("primary"), partial)
+	srv.On(isModel("secondary"), llmtest.Text("最终结果"))
+
+	var events []string
+	remove := AddDisplayListener(func(eventType string, data interface{}) {
+		m, _ := data.(map[string]interface{})
+		switch eventType {
+		case "llm_delta":
+			events = append(events, "delta:"+m["delta"].(string))
+		case "llm_stream_reset":
+			events = append(events, "reset:"+m["model"].(string))
+		}
+	})
+	defer remove()
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	node := NewTaskNode("查询", "查询资料")
+	result, err := planner.ExecuteNode(context.Background(), node)
+	if err != nil {
+		t.Fatalf("ExecuteNode: %v", err)
+	}
+	if result.Output != "最终结果" {
+		t.Errorf("output = %q", result.Output)
+	}
+	if got := strings.Join(events, "|"); got != "delta:先查一下\n|reset:secondary|delta:最终结果" {
+		t.Errorf("stream events = %q", got)
+	}
+	if calls := node.LLMCalls; len(calls) != 2 || calls[0].Model != "primary" || calls[1].Model != "secondary" || calls[1].FallbackFrom != "primary" {
+		t.Errorf("llm calls = %+v", calls)
+	}
+}
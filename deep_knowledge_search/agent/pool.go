@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"time"
+)
+
+// DefaultMaxConcurrency 默认单个任务同时进行的 LLM 调用数
+const DefaultMaxConcurrency = 4
+
+// pausePollInterval 暂停期间检查恢复的间隔
+const pausePollInterval = 200 * time.Millisecond
+
+// SlotPool 执行器级别的并发池，限制整棵任务树同时进行的 LLM 调用数
+// 只有 LLM 调用持有名额，等待子任务完成的父节点不占用名额
+type SlotPool struct {
+	slots  chan struct{}
+	paused func() bool
+}
+
+// NewSlotPool 创建并发池（size <= 0 时使用默认值）
+func NewSlotPool(size int, paused func() bool) *SlotPool {
+	if size <= 0 {
+		size = DefaultMaxConcurrency
+	}
+	if paused == nil {
+		paused = func() bool { return false }
+	}
+	return &SlotPool{
+		slots:  make(chan struct{}, size),
+		paused: paused,
+	}
+}
+
+// Size 并发上限
+func (p *SlotPool) Size() int {
+	return cap(p.slots)
+}
+
+// Acquire 为节点占用一个名额（同时受模型并发限制），需要等待时节点状态显示为排队中
+// 先等模型名额再占用任务名额，避免等待某个已满模型的调用占住任务名额、阻塞其他模型的调用
+// 等待期间响应取消；任务暂停时不会获取新名额
+func (p *SlotPool) Acquire(ctx context.Context, node *TaskNode, model llm.ModelConfig) (func(), error) {
+	// 快速路径：名额充足时直接占用
+	if !p.paused() {
+		if releaseModel := llm.TryAcquireModelSlot(model); releaseModel != nil {
+			select {
+			case p.slots <- struct{}{}:
+				return p.releaseFunc(releaseModel), nil
+			default:
+				releaseModel()
+			}
+		}
+	}
+
+	// 进入排队
+	prevStatus := node.GetStatus()
+	node.SetStatus(NodeQueued)
+	Display.NodeQueued(node)
+	defer func() {
+		if node.GetStatus() == NodeQueued {
+			node.SetStatus(prevStatus)
+			Display.NodeDequeued(node)
+		}
+	}()
+
+	for {
+		// 暂停期间不获取名额
+		for p.paused() {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(pausePollInterval):
+			}
+		}
+
+		releaseModel, err := llm.AcquireModelSlot(ctx, model)
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			releaseModel()
+			return nil, ctx.Err()
+		}
+
+		// 等待期间被暂停：归还名额继续等待
+		if p.paused() {
+			<-p.slots
+			releaseModel()
+			continue
+		}
+		return p.releaseFunc(releaseModel), nil
+	}
+}
+
+// releaseFunc 组合释放函数（先释放模型名额再归还执行名额）
+func (p *SlotPool) releaseFunc(releaseModel func()) func() {
+	released := false
+	return func() {
+		if released {
+			return
+		}
+		released = true
+		releaseModel()
+		<-p.slots
+	}
+}
+
+// slotPoolContextKey context 中并发池的键
+type slotPoolContextKey struct{}
+
+// withSlotPool 把并发池放入 context
+func withSlotPool(ctx context.Context, pool *SlotPool) context.Context {
+	return context.WithValue(ctx, slotPoolContextKey{}, pool)
+}
+
+// slotPoolFromContext 从 context 取出并发池
+func slotPoolFromContext(ctx context.Context) *SlotPool {
+	pool, _ := ctx.Value(slotPoolContextKey{}).(*SlotPool)
+	return pool
+}
+
+// acquireLLMSlot 为一次 LLM 调用占用名额；没有并发池时只受模型并发限制
+func acquireLLMSlot(ctx context.Context, node *TaskNode, model llm.ModelConfig) (func(), error) {
+	if pool := slotPoolFromContext(ctx); pool != nil {
+		return pool.Acquire(ctx, node, model)
+	}
+	return llm.AcquireModelSlot(ctx, model)
+}
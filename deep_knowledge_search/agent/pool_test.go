@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"testing"
+	"time"
+)
+
+// TestSlotPoolModelsDoNotBlock 等待已满模型的调用不占用任务名额，其他模型的调用照常获取名额
+func TestSlotPoolModelsDoNotBlock(t *testing.T) {
+	pool := NewSlotPool(2, nil)
+	busy := llm.ModelConfig{Name: "pool-test-busy", MaxConcurrency: 1}
+	idle := llm.ModelConfig{Name: "pool-test-idle", MaxConcurrency: 1}
+
+	release, err := pool.Acquire(context.Background(), NewTaskNode("a", ""), busy)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// 第二个 busy 调用排队等待模型名额
+	waitCtx, cancelWait := context.WithCancel(context.Background())
+	defer cancelWait()
+	waiting := NewTaskNode("b", "")
+	acquired := make(chan error, 1)
+	go func() {
+		r, err := pool.Acquire(waitCtx, waiting, busy)
+		if err == nil {
+			r()
+		}
+		acquired <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for waiting.GetStatus() != NodeQueued && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	releaseIdle, err := pool.Acquire(ctx, NewTaskNode("c", ""), idle)
+	if err != nil {
+		t.Fatalf("idle model blocked behind saturated model: %v", err)
+	}
+	releaseIdle()
+
+	// 释放后排队的调用获得名额
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("queued Acquire: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("queued Acquire did not return after release")
+	}
+}
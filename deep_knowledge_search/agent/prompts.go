@@ -1,6 +1,9 @@
 package agent
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ============================================================================
 // 提示词模板
@@ -19,7 +22,8 @@ var PromptExecutionSystem = `你是一个任务执行助手。
 
 重要规则:
 1. 使用可用工具完成任务
-2. 调用完工具后返回简洁的执行结果`
+2. 调用完工具后返回简洁的执行结果
+3. 引用检索工具（搜索、网页抓取、本地文档）返回的信息时，必须在相应句子后用 [n] 标注来源编号，编号以工具结果中的 [n] 为准，不要编造编号`
 
 // PromptNodePlanning 节点规划提示词模板
 var PromptNodePlanning = `请将以下任务分解为子任务。
@@ -38,9 +42,11 @@ var PromptNodePlanning = `请将以下任务分解为子任务。
 ## 规则
 1. 子任务 1-5 个
 2. **优先使用并行模式**：execution_mode 默认选择 "parallel"
-3. 仅当子任务之间有明确的依赖关系时才选择 "sequential"
-4. can_decompose: true 表示复杂子任务可继续拆解
-5. 简单任务返回空 subtasks 数组
+3. 如果部分子任务依赖其他子任务的结果，在 depends_on 中填写被依赖子任务的序号（从 1 开始），并选择 "dag"；例如 "1 和 2 并行，然后 3"，则第 3 个子任务的 depends_on 为 [1, 2]
+4. 仅当所有子任务必须严格依次执行时才选择 "sequential"
+5. 依赖关系不能形成环，没有依赖的子任务 depends_on 为空数组
+6. can_decompose: true 表示复杂子任务可继续拆解
+7. 简单任务返回空 subtasks 数组
 
 ## 返回 JSON 格式（无 markdown 代码块）
 {
@@ -53,7 +59,8 @@ var PromptNodePlanning = `请将以下任务分解为子任务。
       "description": "详细描述",
       "goal": "子任务目标",
       "tools": ["工具名"],
-      "can_decompose": false
+      "can_decompose": false,
+      "depends_on": []
     }
   ],
   "reasoning": "选择执行模式的原因"
@@ -73,7 +80,8 @@ var PromptNodeExecution = `执行以下任务并返回结果。
 ## 规则
 1. 使用可用工具完成任务
 2. 返回的结果需要简单易懂,概念需要通俗易懂.专业术语需要详细解释。
-3. 如果需要保存内容，使用 saveToDisk 工具`
+3. 如果需要保存内容，使用 saveToDisk 工具；修改已保存的文档用 updateFile 或 appendToFile，不要重复保存；其他子任务保存的文档可用 listFiles、readFile、searchInFiles 查看
+4. 来自检索工具的内容在句后用 [n] 标注来源；保存的文档末尾附「参考来源」列表，逐条列出引用过的 [n] 标题和地址`
 
 // PromptResultSynthesis 结果整合提示词模板
 var PromptResultSynthesis = `请将以下子任务结果整合为一个清晰的最终结果。
@@ -85,10 +93,14 @@ var PromptResultSynthesis = `请将以下子任务结果整合为一个清晰的
 ## 子任务结果
 %s
 
+## 来源列表
+%s
+
 ## 规则
 1. 提取关键信息
 2. 合并相关内容
-3. 返回简洁的结果摘要`
+3. 返回简洁的结果摘要
+4. 保留子任务结果中的 [n] 来源引用（编号已与来源列表一致），不要添加列表中没有的编号`
 
 // PromptVerificationSystem 验证系统提示词
 var PromptVerificationSystem = `你是一个任务验证专家。你的职责是验证任务执行结果是否符合预期目标。
@@ -121,6 +133,22 @@ var PromptVerification = `请验证以下任务执行结果是否符合目标要
 - 改进建议
 - 需要补充的内容`
 
+// PromptPlanRepair 规划响应修复提示词模板（附上校验错误，要求重新返回 JSON）
+var PromptPlanRepair = `你上一次返回的规划不符合要求，问题如下:
+%s
+
+请修正以上问题后重新返回完整的规划 JSON。
+
+## 要求
+1. 只返回 JSON，不要 markdown 代码块或其他说明
+2. execution_mode 只能是 "sequential"、"parallel" 或 "dag"
+3. subtasks 最多 %d 个，每个子任务必须有 title，标题不能重复
+4. tools 只能使用可用工具: %s
+5. depends_on 只能填写其他子任务的序号（从 1 开始）`
+
+// PromptToolLoopExhausted 执行重试提示词模板（上一次尝试的工具调用轮数用完仍没有给出结果）
+var PromptToolLoopExhausted = `上一次尝试调用了 %d 轮工具仍没有给出最终结果。请只做必要的少量工具调用，然后根据已有信息直接返回完整的执行结果。`
+
 // ============================================================================
 // 提示词构建函数
 // ============================================================================
@@ -136,8 +164,26 @@ func BuildNodeExecutionPrompt(title, description, goal, context string) string {
 }
 
 // BuildResultSynthesisPrompt 构建结果整合提示词
-func BuildResultSynthesisPrompt(title, goal, childResults string) string {
-	return fmt.Sprintf(PromptResultSynthesis, title, goal, childResults)
+func BuildResultSynthesisPrompt(title, goal, childResults, sources string) string {
+	return fmt.Sprintf(PromptResultSynthesis, title, goal, childResults, strings.TrimRight(sources, "\n"))
+}
+
+// BuildPlanRepairPrompt 构建规划修复提示词
+func BuildPlanRepairPrompt(problems []string, toolNames []string) string {
+	var sb strings.Builder
+	for _, p := range problems {
+		sb.WriteString("- " + p + "\n")
+	}
+	tools := "无"
+	if len(toolNames) > 0 {
+		tools = strings.Join(toolNames, ", ")
+	}
+	return fmt.Sprintf(PromptPlanRepair, strings.TrimRight(sb.String(), "\n"), MaxPlanSubtasks, tools)
+}
+
+// BuildToolLoopExhaustedPrompt 构建工具调用轮数用完后的重试提示词
+func BuildToolLoopExhaustedPrompt(iterations int) string {
+	return fmt.Sprintf(PromptToolLoopExhausted, iterations)
 }
 
 // BuildVerificationPrompt 构建验证提示词
@@ -0,0 +1,325 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"deepknowledgesearch/config"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ReplayProvider 回放 provider：按请求返回历史执行日志中记录的响应，不访问网络
+// 优先按请求消息精确匹配；消息不一致时（如并行执行导致兄弟结果顺序不同）按节点标题和调用类型顺序匹配
+// 有工具调用的记录按 turns 逐轮回放，工具结果也来自记录（实现 llm.ToolCaller，不实际执行工具）
+type ReplayProvider struct {
+	mu         sync.Mutex
+	byMessages map[string][]*replayEntry
+	byCall     map[string][]*replayEntry
+	stats      ReplayStats
+
+	sessions    map[string]*replaySession     // 进行中的工具调用循环：下一轮请求的消息键 -> 会话
+	toolResults map[string]llm.ToolCallRecord // 已回放、等待执行的工具调用 ID -> 记录
+}
+
+// replayEntry 一条待回放的调用记录
+type replayEntry struct {
+	record LLMCallRecord
+	used   bool
+}
+
+// replaySession 正在逐轮回放的调用记录
+type replaySession struct {
+	record LLMCallRecord
+	turn   int // 下一轮在 record.Turns 中的序号
+}
+
+// ReplayStats 回放匹配统计
+type ReplayStats struct {
+	Recorded int `json:"recorded"` // 记录的调用数
+	Exact    int `json:"exact"`    // 按消息精确匹配
+	Fuzzy    int `json:"fuzzy"`    // 按节点和调用类型匹配
+	Misses   int `json:"misses"`   // 没有匹配的记录
+
+	ToolCalls int `json:"tool_calls"` // 按记录回放的工具调用数
+}
+
+// NewReplayProvider 从执行日志创建回放 provider
+func NewReplayProvider(execLog *TaskExecutionLog) *ReplayProvider {
+	p := &ReplayProvider{
+		byMessages:  make(map[string][]*replayEntry),
+		byCall:      make(map[string][]*replayEntry),
+		sessions:    make(map[string]*replaySession),
+		toolResults: make(map[string]llm.ToolCallRecord),
+	}
+	p.index(execLog)
+	return p
+}
+
+// index 按执行顺序索引日志树中的调用记录
+func (p *ReplayProvider) index(execLog *TaskExecutionLog) {
+	for _, record := range execLog.LLMCalls {
+		entry := &replayEntry{record: record}
+		key := replayMessagesKey(record.Messages)
+		p.byMessages[key] = append(p.byMessages[key], entry)
+		callKey := replayCallKey(execLog.Title, record.Type)
+		p.byCall[callKey] = append(p.byCall[callKey], entry)
+		p.stats.Recorded++
+	}
+	for i := range execLog.Children {
+		p.index(&execLog.Children[i])
+	}
+}
+
+// Name 返回 provider 名称
+func (p *ReplayProvider) Name() string { return "replay" }
+
+// Complete 返回记录的响应（工具调用循环中按轮次返回）
+func (p *ReplayProvider) Complete(ctx context.Context, cfg llm.ModelConfig, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 工具调用循环的后续轮次
+	key := replayTurnKey(req.Messages)
+	if session, ok := p.sessions[key]; ok {
+		delete(p.sessions, key)
+		return p.replayTurn(req, session.record, session.turn)
+	}
+
+	record, err := p.next(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return p.replayTurn(req, record, 0)
+}
+
+// CallTool 返回记录的工具调用结果，不实际执行工具
+func (p *ReplayProvider) CallTool(ctx context.Context, call mcp.ToolCall) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	record, ok := p.toolResults[call.ID]
+	if !ok {
+		return fmt.Sprintf("Error: replay: 没有工具调用 %s (%s) 的记录", call.Function.Name, call.ID), false
+	}
+	delete(p.toolResults, call.ID)
+	p.stats.ToolCalls++
+	return record.Result, record.Success
+}
+
+// replayTurn 返回记录的第 turn 轮回复：有工具调用时登记下一轮请求，否则返回最终响应
+func (p *ReplayProvider) replayTurn(req *llm.ChatRequest, record LLMCallRecord, turn int) (*llm.ChatResponse, error) {
+	if turn < len(record.Turns) && len(record.Turns[turn].ToolCalls) > 0 {
+		recorded := record.Turns[turn]
+		msg := llm.Message{Role: "assistant", Content: recorded.Content}
+		for _, call := range recorded.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, mcp.ToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: mcp.Function{Name: call.Name, Arguments: call.Arguments},
+			})
+			p.toolResults[call.ID] = call
+		}
+
+		// 下一轮请求 = 本轮请求 + 助手消息 + 工具结果
+		next := append(append([]llm.Message(nil), req.Messages...), msg)
+		for _, call := range msg.ToolCalls {
+			next = append(next, llm.Message{Role: "tool", ToolCallId: call.ID})
+		}
+		p.sessions[replayTurnKey(next)] = &replaySession{record: record, turn: turn + 1}
+
+		return &llm.ChatResponse{Message: msg, FinishReason: "tool_calls", Usage: recorded.Usage}, nil
+	}
+
+	resp, err := replayResponse(record)
+	if resp != nil && turn < len(record.Turns) {
+		resp.Usage = record.Turns[turn].Usage
+	}
+	return resp, err
+}
+
+// Stream 返回记录的响应，并把内容作为一次增量推送
+func (p *ReplayProvider) Stream(ctx context.Context, cfg llm.ModelConfig, req *llm.ChatRequest, onDelta llm.DeltaFunc) (*llm.ChatResponse, error) {
+	resp, err := p.Complete(ctx, cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	if onDelta != nil && resp.Message.Content != "" {
+		onDelta(resp.Message.Content)
+	}
+	return resp, nil
+}
+
+// Stats 获取回放匹配统计
+func (p *ReplayProvider) Stats() ReplayStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// next 取出与请求匹配的下一条未使用记录（调用方持有 p.mu）
+func (p *ReplayProvider) next(ctx context.Context, req *llm.ChatRequest) (LLMCallRecord, error) {
+	messages := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		messages = append(messages, map[string]interface{}{"role": msg.Role, "content": msg.Content})
+	}
+	if entry := takeUnused(p.byMessages[replayMessagesKey(messages)]); entry != nil {
+		p.stats.Exact++
+		return entry.record, nil
+	}
+
+	info, ok := callInfoFromContext(ctx)
+	if ok {
+		if entry := takeUnused(p.byCall[replayCallKey(info.NodeTitle, info.CallType)]); entry != nil {
+			p.stats.Fuzzy++
+			fmt.Printf("[Replay] 请求消息与记录不一致，按节点匹配: %s (%s)\n", info.NodeTitle, info.CallType)
+			return entry.record, nil
+		}
+	}
+
+	p.stats.Misses++
+	return LLMCallRecord{}, fmt.Errorf("replay: 没有匹配的 LLM 调用记录 (%s / %s)", info.NodeTitle, info.CallType)
+}
+
+// takeUnused 取出第一条未使用的记录
+func takeUnused(entries []*replayEntry) *replayEntry {
+	for _, entry := range entries {
+		if !entry.used {
+			entry.used = true
+			return entry
+		}
+	}
+	return nil
+}
+
+// replayResponse 把调用记录还原为响应（失败的记录还原为同类错误）
+func replayResponse(record LLMCallRecord) (*llm.ChatResponse, error) {
+	if record.Error != "" {
+		if record.ErrorKind == "" {
+			return nil, errors.New(record.Error)
+		}
+		return nil, &llm.APIError{Kind: llm.ErrorKind(record.ErrorKind), Provider: "replay", Message: record.Error}
+	}
+	return &llm.ChatResponse{
+		Message:      llm.Message{Role: "assistant", Content: record.Response},
+		FinishReason: "stop",
+		Usage: llm.Usage{
+			PromptTokens:     record.PromptTokens,
+			CompletionTokens: record.CompletionTokens,
+			TotalTokens:      record.TotalTokens,
+		},
+	}, nil
+}
+
+// replayMessagesKey 按消息角色和内容计算匹配键
+func replayMessagesKey(messages []map[string]interface{}) string {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	normalized := make([]message, 0, len(messages))
+	for _, m := range messages {
+		role, _ := m["role"].(string)
+		content, _ := m["content"].(string)
+		normalized = append(normalized, message{Role: role, Content: content})
+	}
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayTurnKey 工具调用循环中一轮请求的匹配键：消息角色、内容和工具调用（工具结果只比较调用 ID）
+func replayTurnKey(messages []llm.Message) string {
+	type message struct {
+		Role       string         `json:"role"`
+		Content    string         `json:"content,omitempty"`
+		ToolCallID string         `json:"tool_call_id,omitempty"`
+		ToolCalls  []mcp.ToolCall `json:"tool_calls,omitempty"`
+	}
+	normalized := make([]message, 0, len(messages))
+	for _, m := range messages {
+		msg := message{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallId, ToolCalls: m.ToolCalls}
+		if m.Role == "tool" {
+			msg.Content = ""
+		}
+		normalized = append(normalized, msg)
+	}
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayCallKey 按节点标题和调用类型计算匹配键
+func replayCallKey(title, callType string) string {
+	return title + "\x00" + callType
+}
+
+// ResolveTaskFolder 把任务文件夹名或路径解析为任务目录（包含 logs/execution.json）
+func ResolveTaskFolder(taskFolder string) (string, error) {
+	candidates := []string{taskFolder, filepath.Join(config.GetOutputDir(), taskFolder)}
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, LogSubDir, "execution.json")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("找不到任务执行日志: %s", taskFolder)
+}
+
+// ReplayTask 使用历史执行日志离线回放任务：重新执行规划和执行逻辑，LLM 响应全部来自日志，输出写入新的任务文件夹
+// 回放期间关闭 LLM 重试，使失败的调用与记录一一对应
+func ReplayTask(taskFolder string) (*TaskNode, ReplayStats, error) {
+	dir, err := ResolveTaskFolder(taskFolder)
+	if err != nil {
+		return nil, ReplayStats{}, err
+	}
+
+	execLog, err := LoadExecutionLog(filepath.Join(dir, LogSubDir, "execution.json"))
+	if err != nil {
+		return nil, ReplayStats{}, err
+	}
+
+	provider := NewReplayProvider(execLog)
+	if provider.Stats().Recorded == 0 {
+		return nil, ReplayStats{}, fmt.Errorf("执行日志中没有 LLM 调用记录，无法回放: %s", dir)
+	}
+	Display.ShowMessage("⏪", fmt.Sprintf("回放任务: %s (%d 条 LLM 调用记录)", execLog.Title, provider.Stats().Recorded))
+
+	// 回放不重试、不使用缓存和预算，串行调用 LLM 以尽量复现原始顺序
+	llm.SetRetryPolicy(llm.RetryPolicy{})
+	defer llm.SetRetryPolicy(llm.DefaultRetryPolicy)
+
+	node := NewTaskNode(execLog.Title, execLog.Description)
+	node.Goal = execLog.Goal
+	if node.Goal == "" {
+		node.Goal = "完成用户请求的任务"
+	}
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(provider)
+
+	execConfig := DefaultExecutionConfig()
+	execConfig.Budget = BudgetConfig{}
+	execConfig.CacheMode = llm.CacheOff
+	execConfig.MaxConcurrency = 1
+	execConfig.PriorResearch = 0 // 回放完全离线，不检索以往调研
+
+	executor := NewTaskExecutor(node, planner, execConfig)
+	executor.SetTaskFolder(fmt.Sprintf("%s_replay_%s", filepath.Base(dir), time.Now().Format("20060102_150405")))
+
+	if OnExecutorCreated != nil {
+		OnExecutorCreated(node.ID, executor)
+		if OnExecutorFinished != nil {
+			defer OnExecutorFinished(node.ID, nil)
+		}
+	}
+
+	err = executor.Execute()
+	return node, provider.Stats(), err
+}
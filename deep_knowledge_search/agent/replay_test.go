@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestReplayTask 回放执行日志：不访问 LLM、不执行工具，工具调用轮次和工具结果按记录重现，结果与原始执行一致
+func TestReplayTask(t *testing.T) {
+	outputDir := useTempOutputDir(t)
+	srv := newFakeLLM(t)
+
+	root := NewTaskNode(testTaskTitle, testTaskDescription)
+	root.Goal = "完成调研报告"
+	executor := newTestExecutor(root, srv)
+	executor.SetTaskFolder("task")
+	if err := executor.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	requests := len(srv.Requests())
+
+	replayed, stats, err := ReplayTask("task")
+	if err != nil {
+		t.Fatalf("ReplayTask: %v", err)
+	}
+	if len(srv.Requests()) != requests {
+		t.Errorf("replay sent %d requests to the LLM", len(srv.Requests())-requests)
+	}
+	if stats.Misses != 0 || stats.Exact+stats.Fuzzy != stats.Recorded || stats.ToolCalls != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if replayed.Result == nil || replayed.Result.Summary != root.Result.Summary {
+		t.Errorf("replayed result = %+v, want summary %q", replayed.Result, root.Result.Summary)
+	}
+	if got, want := dumpTree(replayed), dumpTree(root); got != want {
+		t.Errorf("replayed tree differs:\n%s\nwant:\n%s", got, want)
+	}
+
+	// 工具调用轮次按记录重现，saveToDisk 没有实际执行
+	original := root.Children[0].LLMCalls[0]
+	call := replayed.Children[0].LLMCalls[0]
+	if call.ToolCalls != 1 || len(call.Turns) != len(original.Turns) {
+		t.Fatalf("replayed turns = %+v", call.Turns)
+	}
+	got, want := call.Turns[0].ToolCalls[0], original.Turns[0].ToolCalls[0]
+	if got.Name != "saveToDisk" || got.Arguments != want.Arguments || got.Result != want.Result || got.Success != want.Success {
+		t.Errorf("replayed tool call = %+v, want %+v", got, want)
+	}
+	replayDirs, _ := filepath.Glob(filepath.Join(outputDir, "task_replay_*"))
+	if len(replayDirs) != 1 {
+		t.Fatalf("replay dirs = %v", replayDirs)
+	}
+	if docs, _ := filepath.Glob(filepath.Join(replayDirs[0], "doc", "*", "*.md")); len(docs) != 0 {
+		t.Errorf("replay executed saveToDisk: %v", docs)
+	}
+}
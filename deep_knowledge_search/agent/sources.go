@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// citationRe 正文中的 [n] 引用
+var citationRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// withSourceRecorder 检索工具的结果登记为节点的来源
+func withSourceRecorder(ctx context.Context, node *TaskNode) context.Context {
+	return context.WithValue(ctx, mcp.ContextKeySourceRecorder, mcp.SourceRecorder(node.AddSource))
+}
+
+// mergeChildSources 把子节点的来源合并到父节点，返回子节点编号到父节点编号的映射
+func mergeChildSources(parent, child *TaskNode) map[int]int {
+	sources := child.GetSources()
+	if len(sources) == 0 {
+		return nil
+	}
+	mapping := make(map[int]int, len(sources))
+	for _, src := range sources {
+		mapping[src.ID] = parent.AddSource(src)
+	}
+	return mapping
+}
+
+// renumberCitations 按映射改写正文中的 [n] 引用，映射中没有的编号保持不变
+func renumberCitations(text string, mapping map[int]int) string {
+	if len(mapping) == 0 {
+		return text
+	}
+	return citationRe.ReplaceAllStringFunc(text, func(m string) string {
+		n, _ := strconv.Atoi(m[1 : len(m)-1])
+		if id, ok := mapping[n]; ok {
+			return fmt.Sprintf("[%d]", id)
+		}
+		return m
+	})
+}
+
+// formatBibliography 把来源格式化为参考列表，每行一条
+func formatBibliography(sources []mcp.Source) string {
+	var sb strings.Builder
+	for _, src := range sources {
+		sb.WriteString(fmt.Sprintf("[%d] %s\n", src.ID, src.Label()))
+	}
+	return sb.String()
+}
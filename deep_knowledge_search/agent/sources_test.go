@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"testing"
+)
+
+func TestMergeChildSources(t *testing.T) {
+	parent := NewTaskNode("父任务", "")
+	a := parent.NewChildNode("A", "", "")
+	b := parent.NewChildNode("B", "", "")
+
+	// 子节点通过 ctx 中的登记函数登记来源
+	ctxA := withSourceRecorder(context.Background(), a)
+	mcp.RecordSource(ctxA, mcp.Source{Title: "Go", URL: "https://go.dev"})
+	mcp.RecordSource(ctxA, mcp.Source{Title: "Tour", URL: "https://go.dev/tour"})
+	if id := mcp.RecordSource(ctxA, mcp.Source{Title: "Go again", URL: "https://go.dev"}); id != 1 {
+		t.Errorf("duplicate source id = %d, want 1", id)
+	}
+	ctxB := withSourceRecorder(context.Background(), b)
+	mcp.RecordSource(ctxB, mcp.Source{Title: "Blog", URL: "https://go.dev/blog"})
+	mcp.RecordSource(ctxB, mcp.Source{Title: "Go", URL: "https://go.dev"})
+
+	if got := renumberCitations("goroutine [1]，tour [2]", mergeChildSources(parent, a)); got != "goroutine [1]，tour [2]" {
+		t.Errorf("A summary = %q", got)
+	}
+	if got := renumberCitations("blog [1]，go [2]，数组 a[0] [9]", mergeChildSources(parent, b)); got != "blog [3]，go [1]，数组 a[0] [9]" {
+		t.Errorf("B summary = %q", got)
+	}
+
+	want := "[1] Go - https://go.dev\n[2] Tour - https://go.dev/tour\n[3] Blog - https://go.dev/blog\n"
+	if got := formatBibliography(parent.GetSources()); got != want {
+		t.Errorf("bibliography:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRecordSourceWithoutRecorder(t *testing.T) {
+	if id := mcp.RecordSource(context.Background(), mcp.Source{URL: "https://go.dev"}); id != 0 {
+		t.Errorf("id = %d, want 0", id)
+	}
+}
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +20,7 @@ type ExecutionMode string
 const (
 	ModeSequential ExecutionMode = "sequential" // 串行执行
 	ModeParallel   ExecutionMode = "parallel"   // 并行执行
+	ModeDAG        ExecutionMode = "dag"        // 按依赖关系调度
 )
 
 // NodeStatus 节点状态
@@ -25,11 +28,15 @@ type NodeStatus string
 
 const (
 	NodePending  NodeStatus = "pending"  // 等待中
+	NodeQueued   NodeStatus = "queued"   // 排队等待并发名额
 	NodeRunning  NodeStatus = "running"  // 执行中
 	NodePaused   NodeStatus = "paused"   // 已暂停
 	NodeDone     NodeStatus = "done"     // 已完成
 	NodeFailed   NodeStatus = "failed"   // 失败
 	NodeCanceled NodeStatus = "canceled" // 已取消
+	NodeSkipped  NodeStatus = "skipped"  // 已跳过（预算耗尽）
+
+	NodeAwaitingApproval NodeStatus = "awaiting_approval" // 工具调用等待人工批准
 )
 
 // LogLevel 日志级别
@@ -88,6 +95,9 @@ type TaskNode struct {
 	// 验证结果
 	Verification *VerificationInfo `json:"verification,omitempty"`
 
+	// 预算耗尽原因（仅根节点，为空表示未耗尽）
+	BudgetExhausted string `json:"budget_exhausted,omitempty"`
+
 	// 时间信息
 	CreatedAt  time.Time  `json:"created_at"`
 	StartedAt  *time.Time `json:"started_at,omitempty"`
@@ -99,9 +109,13 @@ type TaskNode struct {
 	// LLM 调用记录
 	LLMCalls []LLMCallRecord `json:"llm_calls,omitempty"`
 
+	// 引用来源（叶子节点由检索工具登记，父节点由子节点合并后重新编号）
+	Sources []mcp.Source `json:"sources,omitempty"`
+
 	// 内部控制
-	mu       sync.RWMutex  `json:"-"`
-	cancelCh chan struct{} `json:"-"`
+	mu        sync.RWMutex        `json:"-"`
+	cancelCh  chan struct{}       `json:"-"`
+	toolCalls *mcp.ToolCallBudget `json:"-"` // 工具调用预算（见 toolCallBudget）
 }
 
 // VerificationInfo 验证信息
@@ -123,11 +137,47 @@ type VerificationAttempt struct {
 
 // LLMCallRecord LLM 调用记录
 type LLMCallRecord struct {
-	Type       string                   `json:"type"`        // "plan", "execute", "synthesize", "verify"
-	Messages   []map[string]interface{} `json:"messages"`    // 请求消息
-	Response   string                   `json:"response"`    // 响应内容
-	StartTime  time.Time                `json:"start_time"`  // 开始时间
-	DurationMs int64                    `json:"duration_ms"` // 耗时（毫秒）
+	Type       string                   `json:"type"`            // "plan", "execute", "synthesize", "verify", "improve"
+	Model      string                   `json:"model,omitempty"` // 实际使用的模型名称
+	Messages   []map[string]interface{} `json:"messages"`        // 请求消息
+	Response   string                   `json:"response"`        // 响应内容
+	StartTime  time.Time                `json:"start_time"`      // 开始时间
+	DurationMs int64                    `json:"duration_ms"`     // 耗时（毫秒）
+
+	// 用量与费用
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	CacheHit         bool    `json:"cache_hit,omitempty"` // 所有轮次均命中响应缓存（未产生费用）
+
+	// 失败与模型回退
+	Error          string `json:"error,omitempty"`           // 调用失败的错误
+	ErrorKind      string `json:"error_kind,omitempty"`      // 错误类别（见 llm.ErrorKind）
+	FallbackFrom   string `json:"fallback_from,omitempty"`   // 回退前失败的模型
+	FallbackReason string `json:"fallback_reason,omitempty"` // 回退原因
+
+	// 工具调用循环（有工具调用时记录每一轮的回复、工具参数、结果和耗时）
+	Turns     []llm.Turn `json:"turns,omitempty"`
+	ToolCalls int        `json:"tool_calls,omitempty"` // 工具调用次数
+}
+
+// UsageStats token 用量与费用统计
+type UsageStats struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Add 累加用量
+func (u *UsageStats) Add(other UsageStats) {
+	u.Calls += other.Calls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
 }
 
 // NewTaskNode 创建新任务节点
@@ -170,8 +220,9 @@ func (n *TaskNode) NewChildNode(title, description, goal string) *TaskNode {
 		cancelCh:      make(chan struct{}, 1),
 	}
 
-	// 继承父节点的用户输入
+	// 继承父节点的用户输入和以往调研
 	child.Context.UserInput = n.Context.UserInput
+	child.Context.PriorResearch = n.Context.PriorResearch
 
 	n.mu.Lock()
 	n.Children = append(n.Children, child)
@@ -194,16 +245,74 @@ func (n *TaskNode) AddLog(level LogLevel, phase, message string) {
 }
 
 // AddLLMCall 添加 LLM 调用记录
-func (n *TaskNode) AddLLMCall(callType string, messages []map[string]interface{}, response string, startTime time.Time, durationMs int64) {
+func (n *TaskNode) AddLLMCall(record LLMCallRecord) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	n.LLMCalls = append(n.LLMCalls, LLMCallRecord{
-		Type:       callType,
-		Messages:   messages,
-		Response:   response,
-		StartTime:  startTime,
-		DurationMs: durationMs,
-	})
+	n.LLMCalls = append(n.LLMCalls, record)
+}
+
+// AddSource 登记来源并返回编号，同一 URL（或路径）只登记一次
+func (n *TaskNode) AddSource(src mcp.Source) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if key := src.Key(); key != "" {
+		for _, s := range n.Sources {
+			if s.Key() == key {
+				return s.ID
+			}
+		}
+	}
+	src.ID = len(n.Sources) + 1
+	n.Sources = append(n.Sources, src)
+	return src.ID
+}
+
+// GetSources 获取来源列表的副本
+func (n *TaskNode) GetSources() []mcp.Source {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	sources := make([]mcp.Source, len(n.Sources))
+	copy(sources, n.Sources)
+	return sources
+}
+
+// GetLLMCalls 获取 LLM 调用记录的副本
+func (n *TaskNode) GetLLMCalls() []LLMCallRecord {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]LLMCallRecord(nil), n.LLMCalls...)
+}
+
+// OwnUsage 统计节点自身 LLM 调用的用量
+func (n *TaskNode) OwnUsage() UsageStats {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	var stats UsageStats
+	for _, call := range n.LLMCalls {
+		stats.Add(UsageStats{
+			Calls:            1,
+			PromptTokens:     call.PromptTokens,
+			CompletionTokens: call.CompletionTokens,
+			TotalTokens:      call.TotalTokens,
+			Cost:             call.Cost,
+		})
+	}
+	return stats
+}
+
+// TotalUsage 统计节点及所有子孙节点的用量
+func (n *TaskNode) TotalUsage() UsageStats {
+	stats := n.OwnUsage()
+
+	n.mu.RLock()
+	children := append([]*TaskNode(nil), n.Children...)
+	n.mu.RUnlock()
+
+	for _, child := range children {
+		stats.Add(child.TotalUsage())
+	}
+	return stats
 }
 
 // SetStatus 设置状态
@@ -215,12 +324,19 @@ func (n *TaskNode) SetStatus(status NodeStatus) {
 		now := time.Now()
 		n.StartedAt = &now
 	}
-	if status == NodeDone || status == NodeFailed || status == NodeCanceled {
+	if status == NodeDone || status == NodeFailed || status == NodeCanceled || status == NodeSkipped {
 		now := time.Now()
 		n.FinishedAt = &now
 	}
 }
 
+// SetBudgetExhausted 标记预算耗尽
+func (n *TaskNode) SetBudgetExhausted(reason string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.BudgetExhausted = reason
+}
+
 // SetProgress 设置进度
 func (n *TaskNode) SetProgress(progress float64) {
 	n.mu.Lock()
@@ -228,6 +344,20 @@ func (n *TaskNode) SetProgress(progress float64) {
 	n.Progress = progress
 }
 
+// SetResult 设置执行结果
+func (n *TaskNode) SetResult(result *TaskResult) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.Result = result
+}
+
+// GetResult 获取执行结果（线程安全）
+func (n *TaskNode) GetResult() *TaskResult {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.Result
+}
+
 // GetStatus 获取状态（线程安全）
 func (n *TaskNode) GetStatus() NodeStatus {
 	n.mu.RLock()
@@ -316,10 +446,19 @@ func generateNodeID() string {
 
 // TaskContext 任务上下文
 type TaskContext struct {
-	UserInput      string                 `json:"user_input"`
-	ParentResults  []ParentResult         `json:"parent_results,omitempty"`
-	SiblingResults []SiblingResult        `json:"sibling_results,omitempty"`
-	Variables      map[string]interface{} `json:"variables,omitempty"`
+	UserInput         string                 `json:"user_input"`
+	ParentResults     []ParentResult         `json:"parent_results,omitempty"`
+	SiblingResults    []SiblingResult        `json:"sibling_results,omitempty"`
+	DependencyResults []SiblingResult        `json:"dependency_results,omitempty"`
+	PriorResearch     []PriorPassage         `json:"prior_research,omitempty"`
+	Variables         map[string]interface{} `json:"variables,omitempty"`
+}
+
+// PriorPassage 以往任务输出中与当前任务相关的段落
+type PriorPassage struct {
+	Ref   string  `json:"ref"` // 文件:行号
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
 }
 
 // ParentResult 父任务结果摘要
@@ -366,6 +505,23 @@ func (c *TaskContext) AddSiblingResult(nodeID, title string, status NodeStatus,
 	})
 }
 
+// AddDependencyResult 添加依赖任务结果（重复添加同一节点时覆盖）
+func (c *TaskContext) AddDependencyResult(nodeID, title string, status NodeStatus, output string) {
+	for i, dr := range c.DependencyResults {
+		if dr.NodeID == nodeID {
+			c.DependencyResults[i].Status = status
+			c.DependencyResults[i].Summary = output
+			return
+		}
+	}
+	c.DependencyResults = append(c.DependencyResults, SiblingResult{
+		NodeID:  nodeID,
+		Title:   title,
+		Status:  status,
+		Summary: output,
+	})
+}
+
 // BuildLLMContext 构建 LLM 请求的上下文字符串
 func (c *TaskContext) BuildLLMContext() string {
 	var sb strings.Builder
@@ -374,6 +530,17 @@ func (c *TaskContext) BuildLLMContext() string {
 	sb.WriteString(c.UserInput)
 	sb.WriteString("\n\n")
 
+	if len(c.PriorResearch) > 0 {
+		sb.WriteString("## 以往调研的相关内容\n")
+		for _, p := range c.PriorResearch {
+			sb.WriteString("### ")
+			sb.WriteString(p.Ref)
+			sb.WriteString("\n")
+			sb.WriteString(p.Text)
+			sb.WriteString("\n\n")
+		}
+	}
+
 	if len(c.ParentResults) > 0 {
 		sb.WriteString("## 父任务执行结果\n")
 		for _, pr := range c.ParentResults {
@@ -386,6 +553,17 @@ func (c *TaskContext) BuildLLMContext() string {
 		sb.WriteString("\n")
 	}
 
+	if len(c.DependencyResults) > 0 {
+		sb.WriteString("## 依赖任务结果\n")
+		for _, dr := range c.DependencyResults {
+			sb.WriteString("### ")
+			sb.WriteString(dr.Title)
+			sb.WriteString("\n")
+			sb.WriteString(dr.Summary)
+			sb.WriteString("\n\n")
+		}
+	}
+
 	if len(c.SiblingResults) > 0 {
 		sb.WriteString("## 已完成的同级任务\n")
 		for _, sr := range c.SiblingResults {
@@ -454,16 +632,125 @@ type ExecutionLog struct {
 
 // ExecutionConfig 执行配置
 type ExecutionConfig struct {
-	MaxDepth      int  `json:"max_depth"`
-	MaxRetries    int  `json:"max_retries"`
-	EnableLogging bool `json:"enable_logging"`
+	MaxDepth      int          `json:"max_depth"`
+	MaxRetries    int          `json:"max_retries"`
+	EnableLogging bool         `json:"enable_logging"`
+	Budget        BudgetConfig `json:"budget"`
+
+	MaxConcurrency int           `json:"max_concurrency"` // 同时进行的 LLM 调用数上限
+	CacheMode      llm.CacheMode `json:"cache_mode"`      // LLM 响应缓存模式
+	PriorResearch  int           `json:"prior_research"`  // 自动注入上下文的以往调研段落数（0 不注入）
+	RunCode        bool          `json:"run_code"`        // 允许使用 runCode 工具执行脚本
+	MaxToolCalls   int           `json:"max_tool_calls"`  // 每个节点的工具调用次数上限
+}
+
+// defaultsMu 保护新任务的默认配置（/reload、交互命令和 Web 接口会在任务运行时修改）
+var defaultsMu sync.RWMutex
+
+// defaultBudget 默认任务预算（来自配置文件或命令行）
+var defaultBudget BudgetConfig
+
+// SetDefaultBudget 设置新任务的默认预算
+func SetDefaultBudget(budget BudgetConfig) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultBudget = budget
+}
+
+// GetDefaultBudget 获取新任务的默认预算
+func GetDefaultBudget() BudgetConfig {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return defaultBudget
+}
+
+// defaultMaxConcurrency 新任务的默认并发上限（来自配置文件）
+var defaultMaxConcurrency = DefaultMaxConcurrency
+
+// SetDefaultMaxConcurrency 设置新任务的默认并发上限（<= 0 时使用 DefaultMaxConcurrency）
+func SetDefaultMaxConcurrency(n int) {
+	if n <= 0 {
+		n = DefaultMaxConcurrency
+	}
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultMaxConcurrency = n
+}
+
+// defaultCacheMode 新任务的默认缓存模式（来自配置文件或命令行）
+var defaultCacheMode = llm.CacheOff
+
+// SetDefaultCacheMode 设置新任务的默认缓存模式
+func SetDefaultCacheMode(mode llm.CacheMode) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultCacheMode = mode
+}
+
+// GetDefaultCacheMode 获取新任务的默认缓存模式
+func GetDefaultCacheMode() llm.CacheMode {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return defaultCacheMode
+}
+
+// defaultPriorResearch 新任务自动注入的以往调研段落数（来自配置文件）
+var defaultPriorResearch int
+
+// SetDefaultPriorResearch 设置新任务自动注入的以往调研段落数（0 不注入）
+func SetDefaultPriorResearch(n int) {
+	if n < 0 {
+		n = 0
+	}
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultPriorResearch = n
+}
+
+// defaultRunCode 新任务是否默认允许执行代码（来自配置文件或命令行，默认关闭）
+var defaultRunCode bool
+
+// SetDefaultRunCode 设置新任务是否默认允许执行代码
+func SetDefaultRunCode(enabled bool) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultRunCode = enabled
+}
+
+// GetDefaultRunCode 新任务是否默认允许执行代码
+func GetDefaultRunCode() bool {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return defaultRunCode
+}
+
+// defaultMaxToolCalls 新任务每个节点的工具调用次数上限（来自配置文件）
+var defaultMaxToolCalls = DefaultMaxToolCalls
+
+// SetDefaultMaxToolCalls 设置新任务每个节点的工具调用次数上限（<= 0 时使用 DefaultMaxToolCalls）
+func SetDefaultMaxToolCalls(n int) {
+	if n <= 0 {
+		n = DefaultMaxToolCalls
+	}
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaultMaxToolCalls = n
 }
 
 // DefaultExecutionConfig 默认执行配置
 func DefaultExecutionConfig() *ExecutionConfig {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
 	return &ExecutionConfig{
 		MaxDepth:      DefaultMaxDepth,
 		MaxRetries:    DefaultMaxRetries,
 		EnableLogging: true,
+		Budget:        defaultBudget,
+
+		MaxConcurrency: defaultMaxConcurrency,
+		CacheMode:      defaultCacheMode,
+		PriorResearch:  defaultPriorResearch,
+		RunCode:        defaultRunCode,
+		MaxToolCalls:   defaultMaxToolCalls,
 	}
 }
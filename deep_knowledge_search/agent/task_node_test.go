@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+// TestTotalUsage 根节点的总用量包含自身和所有子孙节点的 LLM 调用，OwnUsage 只统计节点自身
+func TestTotalUsage(t *testing.T) {
+	root := NewTaskNode("根任务", "汇总用量")
+	childA := root.NewChildNode("子任务 A", "", "")
+	childB := root.NewChildNode("子任务 B", "", "")
+	grandchild := childA.NewChildNode("孙任务", "", "")
+
+	root.AddLLMCall(LLMCallRecord{Type: "plan", PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150, Cost: 0.01})
+	root.AddLLMCall(LLMCallRecord{Type: "synthesize", PromptTokens: 200, CompletionTokens: 100, TotalTokens: 300, Cost: 0.02})
+	childA.AddLLMCall(LLMCallRecord{Type: "execute", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, Cost: 0.001})
+	childB.AddLLMCall(LLMCallRecord{Type: "execute", PromptTokens: 20, CompletionTokens: 10, TotalTokens: 30, Cost: 0.002})
+	grandchild.AddLLMCall(LLMCallRecord{Type: "execute", PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3, CacheHit: true})
+
+	own := root.OwnUsage()
+	if own.Calls != 2 || own.TotalTokens != 450 || math.Abs(own.Cost-0.03) > 1e-12 {
+		t.Errorf("root OwnUsage = %+v", own)
+	}
+
+	total := root.TotalUsage()
+	want := UsageStats{Calls: 5, PromptTokens: 331, CompletionTokens: 167, TotalTokens: 498, Cost: 0.033}
+	if math.Abs(total.Cost-want.Cost) > 1e-12 {
+		t.Errorf("root TotalUsage cost = %v, want %v", total.Cost, want.Cost)
+	}
+	total.Cost = want.Cost
+	if total != want {
+		t.Errorf("root TotalUsage = %+v, want %+v", total, want)
+	}
+
+	if sub := childA.TotalUsage(); sub.Calls != 2 || sub.TotalTokens != 18 {
+		t.Errorf("child A TotalUsage = %+v", sub)
+	}
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+)
+
+// DefaultMaxToolCalls 每个节点默认的工具调用次数上限
+const DefaultMaxToolCalls = 30
+
+// maxToolCallsContextKey context 中节点工具调用次数上限的键
+type maxToolCallsContextKey struct{}
+
+// withMaxToolCalls 把任务的节点工具调用次数上限放入 context
+func withMaxToolCalls(ctx context.Context, max int) context.Context {
+	return context.WithValue(ctx, maxToolCallsContextKey{}, max)
+}
+
+// withToolCallBudget 把节点的工具调用预算放入 context（未设置上限时不限制）
+func withToolCallBudget(ctx context.Context, node *TaskNode) context.Context {
+	max, _ := ctx.Value(maxToolCallsContextKey{}).(int)
+	if max <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, mcp.ContextKeyToolCallBudget, node.toolCallBudget(max))
+}
+
+// toolCallBudget 节点的工具调用预算，首次使用时创建（执行、重试和改进共用）
+func (n *TaskNode) toolCallBudget(max int) *mcp.ToolCallBudget {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.toolCalls == nil {
+		n.toolCalls = mcp.NewToolCallBudget(max)
+	}
+	return n.toolCalls
+}
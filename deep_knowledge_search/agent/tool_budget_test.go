@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestExecuteNodeToolLoopLimit 工具调用轮数用完时带提示重试，节点的工具调用预算在重试间共用，每轮调用都记录在 turns 中
+func TestExecuteNodeToolLoopLimit(t *testing.T) {
+	mcp.Init()
+	var calls atomic.Int32
+	mcp.RegisterTool("countCalls", mcp.LLMTool{Type: "function", Function: mcp.LLMFunction{Name: "countCalls"}},
+		func(ctx context.Context, arguments map[string]interface{}) mcp.MCPToolResponse {
+			calls.Add(1)
+			return mcp.MCPToolResponse{Success: true, Result: "ok"}
+		})
+	t.Cleanup(func() { mcp.UnregisterTool("countCalls") })
+	llm.SetMaxToolIterations(3)
+	t.Cleanup(func() { llm.SetMaxToolIterations(0) })
+
+	// 模型一直调用工具，直到收到重试提示
+	srv := llmtest.NewServer(t)
+	useModel(t, srv.Model("fake-model"))
+	srv.On(llmtest.Contains("上一次尝试调用了 3 轮工具"), llmtest.Text("根据已有信息的结果"))
+	srv.On(llmtest.System("任务执行助手"), llmtest.ToolCall("countCalls", nil))
+
+	planner := NewTaskPlanner()
+	planner.SetProvider(srv.Provider())
+	node := NewTaskNode("统计", "反复调用工具")
+	result, err := planner.ExecuteNode(withMaxToolCalls(context.Background(), 2), node)
+	if err != nil {
+		t.Fatalf("ExecuteNode: %v", err)
+	}
+	if result.Output != "根据已有信息的结果" {
+		t.Errorf("output = %q", result.Output)
+	}
+
+	// 第一次调用因轮数用完失败，不把中途回复当作结果；工具只执行到节点预算上限
+	if len(node.LLMCalls) != 2 || node.LLMCalls[0].ErrorKind != string(llm.ErrToolLoop) || node.LLMCalls[1].Error != "" {
+		t.Errorf("llm calls = %+v", node.LLMCalls)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("tool executed %d times, want 2 (budget)", n)
+	}
+
+	// 每轮的工具调用记录在调用记录中，超出预算的调用记为失败
+	turns := node.LLMCalls[0].Turns
+	if node.LLMCalls[0].ToolCalls != 3 || len(turns) != 3 {
+		t.Fatalf("tool calls = %d, turns = %d, want 3", node.LLMCalls[0].ToolCalls, len(turns))
+	}
+	if call := turns[0].ToolCalls[0]; call.Name != "countCalls" || !call.Success || call.Result != "ok" {
+		t.Errorf("first tool call = %+v", call)
+	}
+	if call := turns[2].ToolCalls[0]; call.Success || !strings.Contains(call.Result, "budget exhausted") {
+		t.Errorf("third tool call = %+v", call)
+	}
+}
@@ -13,10 +13,134 @@ const ConfigFileName = "config.json"
 // ModelConfig 模型配置
 type ModelConfig struct {
 	Name        string  `json:"name"`
+	Provider    string  `json:"provider,omitempty"` // openai(默认) / anthropic / ollama
 	APIKey      string  `json:"api_key"`
 	BaseURL     string  `json:"base_url"`
 	Model       string  `json:"model"`
 	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`   // 最大输出 token（anthropic 必填，默认 4096）
+	InputPrice  float64 `json:"input_price,omitempty"`  // 输入价格（每百万 token）
+	OutputPrice float64 `json:"output_price,omitempty"` // 输出价格（每百万 token）
+
+	MaxConcurrency   int    `json:"max_concurrency,omitempty"`   // 该模型的最大并发请求数（0 表示不限制）
+	StructuredOutput string `json:"structured_output,omitempty"` // 结构化输出模式：空(自动) / json_schema / json_object / off
+}
+
+// BudgetConfig 任务预算配置
+type BudgetConfig struct {
+	MaxTokens      int     `json:"max_tokens,omitempty"`
+	MaxCost        float64 `json:"max_cost,omitempty"`
+	MaxDurationSec int     `json:"max_duration_sec,omitempty"`
+	MaxLLMCalls    int     `json:"max_llm_calls,omitempty"`
+}
+
+// SearchBackendConfig 搜索后端配置
+type SearchBackendConfig struct {
+	Type        string `json:"type"`                   // searxng / bing / brave / fixture
+	BaseURL     string `json:"base_url,omitempty"`     // 接口地址（bing、brave 可省略）
+	APIKey      string `json:"api_key,omitempty"`      // bing、brave 的订阅密钥
+	FixtureFile string `json:"fixture_file,omitempty"` // fixture 后端的 JSON 文件
+	TimeoutSec  int    `json:"timeout_sec,omitempty"`  // 请求超时（秒）
+}
+
+// SearchConfig 网络搜索配置
+type SearchConfig struct {
+	Backends   []SearchBackendConfig `json:"backends,omitempty"`    // 按顺序尝试，前一个失败时使用下一个
+	MaxResults int                   `json:"max_results,omitempty"` // 每次搜索默认返回的结果数
+}
+
+// FetchConfig 网页抓取（fetchURL）配置
+type FetchConfig struct {
+	Allow        []string `json:"allow,omitempty"`         // 允许的域名（为空表示不限制），包含子域名
+	Deny         []string `json:"deny,omitempty"`          // 禁止的域名，优先于 allow
+	MaxBytes     int64    `json:"max_bytes,omitempty"`     // 单个页面最大下载字节数
+	TimeoutSec   int      `json:"timeout_sec,omitempty"`   // 单次请求超时（秒）
+	ExcerptChars int      `json:"excerpt_chars,omitempty"` // 返回给 LLM 的摘录长度（字符）
+	UserAgent    string   `json:"user_agent,omitempty"`
+	IgnoreRobots bool     `json:"ignore_robots,omitempty"` // 不检查 robots.txt
+}
+
+// LocalDocsConfig 本地文档库（searchLocalDocs）配置
+type LocalDocsConfig struct {
+	Paths         []string `json:"paths,omitempty"`          // 要索引的文件或目录
+	IncludeOutput bool     `json:"include_output,omitempty"` // 同时索引输出目录中以往任务的结果
+	Extensions    []string `json:"extensions,omitempty"`     // 索引的扩展名，默认 .md / .markdown / .txt / .text
+	ExcludeDirs   []string `json:"exclude_dirs,omitempty"`   // 跳过的目录名，默认 .git / node_modules / logs
+	PassageLines  int      `json:"passage_lines,omitempty"`  // 每个段落的最大行数
+	MaxResults    int      `json:"max_results,omitempty"`    // 每次检索默认返回的段落数
+	IndexOnStart  bool     `json:"index_on_start,omitempty"` // 启动时建索引（默认在第一次检索时建立）
+}
+
+// EmbeddingConfig 以往调研语义检索（recallPriorResearch）配置
+type EmbeddingConfig struct {
+	Provider   string   `json:"provider,omitempty"`     // openai / hash，为空表示不启用
+	BaseURL    string   `json:"base_url,omitempty"`     // openai 接口地址（不含 /embeddings）
+	APIKey     string   `json:"api_key,omitempty"`      // openai 接口密钥
+	Model      string   `json:"model,omitempty"`        // openai 模型名称
+	Dimensions int      `json:"dimensions,omitempty"`   // hash 向量维度
+	TimeoutSec int      `json:"timeout_sec,omitempty"`  // 请求超时（秒）
+	StoreFile  string   `json:"store_file,omitempty"`   // 向量库文件，默认为 "cache/embeddings.json"
+	Paths      []string `json:"paths,omitempty"`        // 向量化的目录，默认为输出目录
+	ChunkChars int      `json:"chunk_chars,omitempty"`  // 每段最大字符数
+	TopK       int      `json:"top_k,omitempty"`        // 工具默认返回的段落数
+	MinScore   float64  `json:"min_score,omitempty"`    // 最低相似度
+	InjectTopK *int     `json:"inject_top_k,omitempty"` // 新任务自动注入上下文的段落数，默认 3，0 不注入
+}
+
+// MCPServerConfig 外部 MCP server 配置（command 与 url 二选一）
+type MCPServerConfig struct {
+	Name       string            `json:"name"`                  // 名称，作为工具名前缀 <name>__<tool>
+	Command    string            `json:"command,omitempty"`     // stdio server 的启动命令
+	Args       []string          `json:"args,omitempty"`        // 启动参数
+	Env        map[string]string `json:"env,omitempty"`         // 额外的环境变量
+	Dir        string            `json:"dir,omitempty"`         // 工作目录
+	URL        string            `json:"url,omitempty"`         // streamable HTTP server 的地址
+	Headers    map[string]string `json:"headers,omitempty"`     // HTTP 请求头（如认证）
+	TimeoutSec int               `json:"timeout_sec,omitempty"` // 单次工具调用超时（秒）
+	Disabled   bool              `json:"disabled,omitempty"`
+}
+
+// InterpreterConfig runCode 的解释器配置：<command> <args...> <脚本文件>
+type InterpreterConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Ext     string   `json:"ext"`              // 脚本文件扩展名，如 .py
+	Mounts  []string `json:"mounts,omitempty"` // 沙箱中额外只读挂载的目录（系统目录之外的解释器安装目录等）
+}
+
+// RunCodeConfig 代码执行（runCode）配置
+type RunCodeConfig struct {
+	Enabled        bool                         `json:"enabled,omitempty"`          // 新任务默认允许执行代码（默认关闭，也可按任务开启）
+	Interpreters   map[string]InterpreterConfig `json:"interpreters,omitempty"`     // 语言 -> 解释器，内置 go（go run）
+	TimeoutSec     int                          `json:"timeout_sec,omitempty"`      // 单次执行的最长时间（秒）
+	CPUSec         int                          `json:"cpu_sec,omitempty"`          // CPU 时间上限（秒）
+	MemoryMB       int                          `json:"memory_mb,omitempty"`        // 虚拟内存上限（MB）
+	MaxOutputBytes int                          `json:"max_output_bytes,omitempty"` // stdout / stderr 各自保留的最大字节数
+	MaxFileMB      int                          `json:"max_file_mb,omitempty"`      // 脚本写入的单个文件的最大大小（MB）
+	MaxProcesses   int                          `json:"max_processes,omitempty"`    // 进程数上限
+}
+
+// ToolPolicyRuleConfig 工具调用权限规则
+type ToolPolicyRuleConfig struct {
+	Tool   string            `json:"tool"`           // 工具名，支持 * 通配（如 github__*）
+	Args   map[string]string `json:"args,omitempty"` // 参数名 -> 正则，全部匹配时规则生效
+	Action string            `json:"action"`         // allow / deny / ask
+}
+
+// ToolPolicyConfig 工具调用权限策略
+type ToolPolicyConfig struct {
+	Default            string                 `json:"default,omitempty"`              // 没有规则匹配时的处理方式，默认 allow
+	Rules              []ToolPolicyRuleConfig `json:"rules,omitempty"`                // 按顺序匹配，第一条匹配的规则生效
+	ApprovalTimeoutSec int                    `json:"approval_timeout_sec,omitempty"` // ask 等待批准的最长时间（秒），超时视为拒绝
+}
+
+// ToolLoopConfig 工具调用循环的限制
+type ToolLoopConfig struct {
+	MaxIterations  int            `json:"max_iterations,omitempty"`   // 一次 LLM 调用中的最大请求轮数，默认 10
+	MaxToolCalls   int            `json:"max_tool_calls,omitempty"`   // 每个节点的工具调用次数上限，默认 30
+	TimeoutSec     int            `json:"timeout_sec,omitempty"`      // 单次工具调用的默认超时（秒），默认 120
+	Timeouts       map[string]int `json:"timeouts,omitempty"`         // 工具名 -> 超时（秒），覆盖默认值
+	MaxResultChars int            `json:"max_result_chars,omitempty"` // 返回给模型的工具结果最大字符数，默认 12000
 }
 
 // AppConfig 应用配置
@@ -25,12 +149,52 @@ type AppConfig struct {
 	Models       []ModelConfig `json:"models"`
 	DefaultModel string        `json:"default_model"`
 
+	// 阶段模型路由：plan / execute / synthesize / verify / improve -> 模型名称列表（按优先级，最后回退到默认模型）
+	PhaseModels map[string][]string `json:"phase_models,omitempty"`
+
+	// 通用回退模型：阶段模型和默认模型都失败后按顺序尝试
+	FallbackModels []string `json:"fallback_models,omitempty"`
+
 	// 兼容旧配置 (Deprecated)
 	APIKey      string  `json:"api_key,omitempty"`
 	BaseURL     string  `json:"base_url,omitempty"`
 	Model       string  `json:"model,omitempty"`
 	Temperature float64 `json:"temperature,omitempty"`
 
+	// 任务预算（0 表示不限制）
+	Budget BudgetConfig `json:"budget,omitempty"`
+
+	// LLM 响应缓存：off(默认) / read-write / read-only
+	LLMCache string `json:"llm_cache,omitempty"`
+	CacheDir string `json:"cache_dir,omitempty"` // 缓存目录，默认为 "cache/llm"
+
+	// 单个任务同时进行的 LLM 调用数上限（0 使用默认值）
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	// 网络搜索（未配置后端时不提供 webSearch 工具）
+	Search SearchConfig `json:"search,omitempty"`
+
+	// 网页抓取
+	Fetch FetchConfig `json:"fetch,omitempty"`
+
+	// 本地文档库（未配置路径时不提供 searchLocalDocs 工具）
+	LocalDocs LocalDocsConfig `json:"local_docs,omitempty"`
+
+	// 以往调研语义检索（未配置 provider 时不提供 recallPriorResearch 工具）
+	Embedding EmbeddingConfig `json:"embedding,omitempty"`
+
+	// 外部 MCP server，其工具以 <name>__<tool> 注册
+	MCPServers []MCPServerConfig `json:"mcp_servers,omitempty"`
+
+	// 代码执行（runCode 工具只对允许执行代码的任务开放）
+	RunCode RunCodeConfig `json:"run_code,omitempty"`
+
+	// 工具调用权限策略（未配置时允许所有调用）
+	ToolPolicy ToolPolicyConfig `json:"tool_policy,omitempty"`
+
+	// 工具调用循环限制（轮数、次数、超时、结果长度）
+	ToolLoop ToolLoopConfig `json:"tool_loop,omitempty"`
+
 	// Web 配置
 	WebPort    int  `json:"web_port"`
 	WebEnabled bool `json:"web_enabled"`
@@ -149,6 +313,7 @@ func createExampleConfig() {
 		Models: []ModelConfig{
 			{
 				Name:        "deepseek",
+				Provider:    "openai",
 				APIKey:      "your-api-key-here",
 				BaseURL:     "https://api.deepseek.com/v1/chat/completions",
 				Model:       "deepseek-chat",
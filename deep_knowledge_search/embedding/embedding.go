@@ -0,0 +1,93 @@
+// Package embedding provides text embedding providers and a local vector store
+// used for semantic retrieval over past task outputs.
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// 内置 provider 类型
+const (
+	ProviderOpenAI = "openai" // OpenAI 兼容的 /embeddings 接口
+	ProviderHash   = "hash"   // 确定性的特征哈希（测试和离线使用，无需网络）
+)
+
+// 默认值
+const (
+	DefaultHashDimensions = 256
+	defaultTimeout        = 30 * time.Second
+)
+
+// Provider 文本向量化接口
+type Provider interface {
+	// ID 返回 provider 与模型的标识，向量库据此判断已有向量是否可用
+	ID() string
+	// Embed 把文本批量转换为向量，返回的向量与输入一一对应
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Config provider 配置
+type Config struct {
+	Provider   string        // openai / hash
+	BaseURL    string        // openai 接口地址（不含 /embeddings）
+	APIKey     string        // openai 接口密钥
+	Model      string        // openai 模型名称
+	Dimensions int           // hash 向量维度
+	Timeout    time.Duration // 请求超时（0 使用默认值）
+}
+
+// NewProvider 按配置创建 provider
+func NewProvider(cfg Config) (Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Provider)) {
+	case ProviderOpenAI:
+		if cfg.BaseURL == "" || cfg.Model == "" {
+			return nil, fmt.Errorf("openai embedding provider requires base_url and model")
+		}
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		return NewOpenAIProvider(cfg.BaseURL, cfg.APIKey, cfg.Model, timeout), nil
+	case ProviderHash:
+		return NewHashProvider(cfg.Dimensions), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider: %s", cfg.Provider)
+	}
+}
+
+// Cosine 两个向量的余弦相似度（长度不同或为零向量时返回 0）
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// normalize 把向量缩放为单位长度
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	n := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= n
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingProvider 记录 Embed 调用次数的 hash provider
+type countingProvider struct {
+	*HashProvider
+	texts int
+}
+
+func (p *countingProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	p.texts += len(texts)
+	return p.HashProvider.Embed(ctx, texts)
+}
+
+func TestHashProvider(t *testing.T) {
+	p := NewHashProvider(64)
+	vs, _ := p.Embed(context.Background(), []string{"goroutine 调度模型", "goroutine 调度模型", "垃圾回收 三色标记"})
+	if len(vs[0]) != 64 || !reflect.DeepEqual(vs[0], vs[1]) {
+		t.Fatalf("hash vectors not deterministic")
+	}
+	q, _ := p.Embed(context.Background(), []string{"调度"})
+	if Cosine(q[0], vs[0]) <= Cosine(q[0], vs[2]) {
+		t.Errorf("query should be closer to the matching text")
+	}
+	if p.ID() != "hash:64" {
+		t.Errorf("ID = %s", p.ID())
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s auth=%q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		// 倒序返回，验证按 index 排列
+		var data []map[string]interface{}
+		for i := len(body.Input) - 1; i >= 0; i-- {
+			data = append(data, map[string]interface{}{"index": i, "embedding": []float32{float32(len(body.Input[i])), 1}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(Config{Provider: ProviderOpenAI, BaseURL: srv.URL + "/v1/", APIKey: "key", Model: "text-embedding-3-small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := p.Embed(context.Background(), []string{"a", "bbb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float32{{1, 1}, {3, 1}}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("vectors = %v, want %v", vs, want)
+	}
+	if p.ID() != "openai:text-embedding-3-small" {
+		t.Errorf("ID = %s", p.ID())
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	content := "# 标题\n\n" + strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30) + "\n"
+	var got []string
+	for _, c := range ChunkText(content, 40) {
+		got = append(got, c.Ref())
+	}
+	if want := []string{":1-3", ":5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+}
+
+// writeFile 写入文件并设置修改时间
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, mtime, mtime)
+}
+
+func TestStoreSyncIncremental(t *testing.T) {
+	dir := t.TempDir()
+	storeFile := filepath.Join(dir, "cache", "embeddings.json")
+	docs := filepath.Join(dir, "output")
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(docs, "task1", "报告.md"), "goroutine 调度", mtime)
+	writeFile(t, filepath.Join(docs, "task1", "logs", "summary.txt"), "日志", mtime)
+	writeFile(t, filepath.Join(docs, "task2", "笔记.md"), "channel 通信", mtime)
+
+	p := &countingProvider{HashProvider: NewHashProvider(32)}
+	store, _ := OpenStore(storeFile)
+	stats, err := store.Sync(context.Background(), p, []string{docs}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Added != 2 || p.texts != 2 {
+		t.Fatalf("first sync stats = %+v, embedded %d texts", stats, p.texts)
+	}
+
+	// 重新打开后没有变化的文件不再向量化
+	store, _ = OpenStore(storeFile)
+	if stats, _ := store.Sync(context.Background(), p, []string{docs}, SyncOptions{}); stats.Changed() || p.texts != 2 {
+		t.Errorf("unchanged sync stats = %+v, embedded %d texts", stats, p.texts)
+	}
+
+	writeFile(t, filepath.Join(docs, "task2", "笔记.md"), "channel 带缓冲通信", time.Now())
+	os.Remove(filepath.Join(docs, "task1", "报告.md"))
+	stats, _ = store.Sync(context.Background(), p, []string{docs}, SyncOptions{})
+	if stats.Updated != 1 || stats.Removed != 1 || store.Len() != 1 {
+		t.Errorf("update sync stats = %+v, chunks = %d", stats, store.Len())
+	}
+
+	// provider 变化时重建
+	other := &countingProvider{HashProvider: NewHashProvider(16)}
+	if stats, _ := store.Sync(context.Background(), other, []string{docs}, SyncOptions{}); stats.Added != 1 || other.texts != 1 {
+		t.Errorf("provider change stats = %+v", stats)
+	}
+}
+
+func TestIndexRecallExcludesDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "old", "调度.md"), "GMP 调度模型把 goroutine 分配到线程", time.Now())
+	writeFile(t, filepath.Join(dir, "current", "调度.md"), "GMP 调度模型", time.Now())
+	writeFile(t, filepath.Join(dir, "old", "gc.md"), "垃圾回收使用三色标记", time.Now())
+
+	index, err := NewIndex(NewHashProvider(0), filepath.Join(t.TempDir(), "store.json"), []string{dir}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err := index.Recall(context.Background(), "goroutine 调度", 5, 0.1, filepath.Join(dir, "current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Chunk.Path != filepath.Join(dir, "old", "调度.md") || hits[0].Chunk.Vector != nil {
+		t.Errorf("hits = %+v", hits)
+	}
+}
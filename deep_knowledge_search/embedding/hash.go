@@ -0,0 +1,88 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"unicode"
+)
+
+// HashProvider 确定性的特征哈希向量：词（中文为相邻两字）哈希到固定维度，不需要网络
+// 只反映词面重合，语义效果远不如真实模型，用于测试和离线演示
+type HashProvider struct {
+	dims int
+}
+
+// NewHashProvider 创建特征哈希 provider（dims <= 0 时使用默认维度）
+func NewHashProvider(dims int) *HashProvider {
+	if dims <= 0 {
+		dims = DefaultHashDimensions
+	}
+	return &HashProvider{dims: dims}
+}
+
+// ID 返回 provider 标识
+func (p *HashProvider) ID() string {
+	return fmt.Sprintf("%s:%d", ProviderHash, p.dims)
+}
+
+// Embed 计算每段文本的哈希向量
+func (p *HashProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, p.dims)
+		for _, term := range hashTerms(text) {
+			h := fnv.New64a()
+			h.Write([]byte(term))
+			sum := h.Sum64()
+			// 高位决定符号，减少哈希冲突带来的偏差
+			if sum>>63 == 1 {
+				v[sum%uint64(p.dims)]--
+			} else {
+				v[sum%uint64(p.dims)]++
+			}
+		}
+		vectors[i] = normalize(v)
+	}
+	return vectors, nil
+}
+
+// hashTerms 切分文本：字母数字按单词，中日韩文字按相邻两字（单字成段时取单字）
+func hashTerms(text string) []string {
+	var terms []string
+	var word, cjk []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+		if len(cjk) == 1 {
+			terms = append(terms, string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			terms = append(terms, string(cjk[i:i+2]))
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			if len(word) > 0 {
+				terms = append(terms, string(word))
+				word = word[:0]
+			}
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(cjk) > 0 {
+				flush()
+			}
+			word = append(word, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
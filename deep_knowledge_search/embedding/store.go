@@ -0,0 +1,411 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 向量库默认值
+const (
+	DefaultChunkChars = 1200
+	maxDocumentBytes  = 4 << 20 // 超过该大小的文件不向量化
+)
+
+// DefaultExtensions 默认向量化的文件扩展名
+var DefaultExtensions = []string{".md", ".markdown", ".txt"}
+
+// DefaultExcludeDirs 默认跳过的目录（执行日志、网页抓取缓存和完整工具结果）
+var DefaultExcludeDirs = []string{".git", "logs", "sources", "tool_results"}
+
+// Chunk 文档中的一段及其向量
+type Chunk struct {
+	Path      string    `json:"path"`
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Text      string    `json:"text"`
+	Vector    []float32 `json:"vector,omitempty"`
+}
+
+// Ref 分段的 "文件:行号" 引用
+func (c Chunk) Ref() string {
+	if c.StartLine == c.EndLine {
+		return fmt.Sprintf("%s:%d", c.Path, c.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", c.Path, c.StartLine, c.EndLine)
+}
+
+// Hit 一条检索结果（不含向量）
+type Hit struct {
+	Chunk Chunk
+	Score float64 // 余弦相似度
+}
+
+// fileState 已向量化文件的状态，修改时间或大小变化时重新向量化
+type fileState struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// SyncOptions 同步选项
+type SyncOptions struct {
+	Extensions  []string // 向量化的扩展名（为空使用默认值）
+	ExcludeDirs []string // 跳过的目录名（为空使用默认值）
+	ChunkChars  int      // 每段最大字符数
+}
+
+// withDefaults 填充默认值
+func (o SyncOptions) withDefaults() SyncOptions {
+	if len(o.Extensions) == 0 {
+		o.Extensions = DefaultExtensions
+	}
+	if len(o.ExcludeDirs) == 0 {
+		o.ExcludeDirs = DefaultExcludeDirs
+	}
+	if o.ChunkChars <= 0 {
+		o.ChunkChars = DefaultChunkChars
+	}
+	return o
+}
+
+// SyncStats 一次同步的变化
+type SyncStats struct {
+	Added   int // 新增的文件
+	Updated int // 内容变化后重新向量化的文件
+	Removed int // 已删除的文件
+	Chunks  int // 新生成的分段
+}
+
+// Changed 是否有变化
+func (s SyncStats) Changed() bool {
+	return s.Added+s.Updated+s.Removed > 0
+}
+
+// Store 保存在本地 JSON 文件中的向量库
+type Store struct {
+	ProviderID string               `json:"provider_id"`
+	Files      map[string]fileState `json:"files"`
+	Chunks     []Chunk              `json:"chunks"`
+
+	path string
+	mu   sync.RWMutex
+}
+
+// OpenStore 打开向量库文件，文件不存在时返回空库
+func OpenStore(path string) (*Store, error) {
+	s := &Store{Files: make(map[string]fileState), path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read vector store: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse vector store %s: %w", path, err)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]fileState)
+	}
+	return s, nil
+}
+
+// Len 分段数
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.Chunks)
+}
+
+// Save 写入向量库文件（先写临时文件再改名，避免中途失败损坏已有文件）
+func (s *Store) Save() error {
+	s.mu.RLock()
+	data, err := json.Marshal(s)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Sync 把 roots 下新增或修改的文档分段并向量化，移除已删除文档的分段；有变化时保存
+// provider 与库中记录的不同时清空重建
+func (s *Store) Sync(ctx context.Context, provider Provider, roots []string, opts SyncOptions) (SyncStats, error) {
+	opts = opts.withDefaults()
+	var stats SyncStats
+	reset := false // provider 变化后清空了向量库
+
+	s.mu.Lock()
+	if s.ProviderID != provider.ID() {
+		if len(s.Chunks) > 0 {
+			fmt.Printf("[Embedding] provider 变化 (%s -> %s)，重建向量库\n", s.ProviderID, provider.ID())
+		}
+		s.ProviderID = provider.ID()
+		s.Files = make(map[string]fileState)
+		s.Chunks = nil
+		reset = true
+	}
+	s.mu.Unlock()
+
+	files, err := listDocuments(roots, opts)
+	if err != nil {
+		return stats, err
+	}
+
+	// 移除已删除的文件
+	s.mu.Lock()
+	for path := range s.Files {
+		if _, ok := files[path]; !ok {
+			s.removeFileLocked(path)
+			stats.Removed++
+		}
+	}
+	s.mu.Unlock()
+
+	// 按路径顺序处理，保证结果稳定
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		state := files[path]
+		s.mu.RLock()
+		old, known := s.Files[path]
+		s.mu.RUnlock()
+		if known && old.ModTime.Equal(state.ModTime) && old.Size == state.Size {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		chunks := ChunkText(string(data), opts.ChunkChars)
+		texts := make([]string, len(chunks))
+		for i, c := range chunks {
+			texts[i] = c.Text
+		}
+		vectors, err := provider.Embed(ctx, texts)
+		if err != nil {
+			// 保存已完成的部分，下次同步从失败的文件继续
+			if reset || stats.Changed() {
+				s.Save()
+			}
+			return stats, fmt.Errorf("embed %s: %w", path, err)
+		}
+
+		s.mu.Lock()
+		s.removeFileLocked(path)
+		for i := range chunks {
+			chunks[i].Path = path
+			chunks[i].Vector = vectors[i]
+		}
+		s.Chunks = append(s.Chunks, chunks...)
+		s.Files[path] = state
+		s.mu.Unlock()
+
+		if known {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+		stats.Chunks += len(chunks)
+	}
+
+	if reset || stats.Changed() {
+		if err := s.Save(); err != nil {
+			return stats, fmt.Errorf("save vector store: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// removeFileLocked 移除文件的所有分段（调用方持有写锁）
+func (s *Store) removeFileLocked(path string) {
+	kept := s.Chunks[:0]
+	for _, c := range s.Chunks {
+		if c.Path != path {
+			kept = append(kept, c)
+		}
+	}
+	s.Chunks = kept
+	delete(s.Files, path)
+}
+
+// Search 返回与查询向量最相似的 k 个分段；skip 返回 true 的文件不参与检索
+func (s *Store) Search(query []float32, k int, minScore float64, skip func(path string) bool) []Hit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hits []Hit
+	for _, c := range s.Chunks {
+		if skip != nil && skip(c.Path) {
+			continue
+		}
+		score := Cosine(query, c.Vector)
+		if score <= 0 || score < minScore {
+			continue
+		}
+		chunk := c
+		chunk.Vector = nil
+		hits = append(hits, Hit{Chunk: chunk, Score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+// listDocuments 列出 roots 下需要向量化的文件及其状态
+func listDocuments(roots []string, opts SyncOptions) (map[string]fileState, error) {
+	exts := make(map[string]bool, len(opts.Extensions))
+	for _, ext := range opts.Extensions {
+		exts[strings.ToLower(ext)] = true
+	}
+	exclude := make(map[string]bool, len(opts.ExcludeDirs))
+	for _, dir := range opts.ExcludeDirs {
+		exclude[dir] = true
+	}
+
+	files := make(map[string]fileState)
+	for _, root := range roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && exclude[d.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !exts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() == 0 || info.Size() > maxDocumentBytes {
+				return nil
+			}
+			files[path] = fileState{ModTime: info.ModTime(), Size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", root, err)
+		}
+	}
+	return files, nil
+}
+
+// ChunkText 按空行把文本切分为段落，相邻段落合并到不超过 maxChars 字符；单个段落过长时按行切分
+func ChunkText(content string, maxChars int) []Chunk {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var chunks []Chunk
+	var buf []string
+	start, size := 0, 0
+
+	flush := func(end int) {
+		text := strings.TrimSpace(strings.Join(buf, "\n"))
+		if text != "" {
+			// 去掉首尾空行后修正行号
+			first := 0
+			for first < len(buf) && strings.TrimSpace(buf[first]) == "" {
+				first++
+			}
+			last := len(buf) - 1
+			for last > first && strings.TrimSpace(buf[last]) == "" {
+				last--
+			}
+			chunks = append(chunks, Chunk{StartLine: start + first + 1, EndLine: start + last + 1, Text: text})
+		}
+		buf, size = nil, 0
+		start = end
+	}
+
+	for i, line := range lines {
+		n := len([]rune(line)) + 1
+		// 在空行处切分，或当前段已满
+		if size > 0 && size+n > maxChars && (strings.TrimSpace(line) == "" || size >= maxChars/2) {
+			flush(i)
+		}
+		buf = append(buf, line)
+		size += n
+	}
+	flush(len(lines))
+	return chunks
+}
+
+// ============================================================================
+// Index - 向量库 + provider + 文档目录
+// ============================================================================
+
+// Index 对一组目录的语义检索：检索前自动同步新增和修改的文档
+type Index struct {
+	provider Provider
+	store    *Store
+	roots    []string
+	opts     SyncOptions
+	mu       sync.Mutex // 串行化同步
+}
+
+// NewIndex 创建检索索引，storeFile 为向量库文件路径
+func NewIndex(provider Provider, storeFile string, roots []string, opts SyncOptions) (*Index, error) {
+	store, err := OpenStore(storeFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{provider: provider, store: store, roots: roots, opts: opts}, nil
+}
+
+// Sync 同步文档目录
+func (x *Index) Sync(ctx context.Context) (SyncStats, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	stats, err := x.store.Sync(ctx, x.provider, x.roots, x.opts)
+	if stats.Added+stats.Updated > 0 {
+		fmt.Printf("[Embedding] 向量库已更新: 新增 %d, 更新 %d, 删除 %d 个文件 (%d 个分段)\n", stats.Added, stats.Updated, stats.Removed, stats.Chunks)
+	}
+	return stats, err
+}
+
+// Recall 同步后检索与 query 最相关的 k 个分段；excludeDir 下的文件（如当前任务目录）不参与检索
+func (x *Index) Recall(ctx context.Context, query string, k int, minScore float64, excludeDir string) ([]Hit, error) {
+	if _, err := x.Sync(ctx); err != nil {
+		// 同步失败时仍可检索已有向量
+		fmt.Printf("[Embedding] ⚠️ 同步失败: %v\n", err)
+	}
+	if x.store.Len() == 0 {
+		return nil, nil
+	}
+
+	vectors, err := x.provider.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	var skip func(string) bool
+	if excludeDir != "" {
+		prefix := filepath.Clean(excludeDir) + string(filepath.Separator)
+		skip = func(path string) bool { return strings.HasPrefix(filepath.Clean(path), prefix) }
+	}
+	return x.store.Search(vectors[0], k, minScore, skip), nil
+}
@@ -0,0 +1,294 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Anthropic Messages API 默认值
+const (
+	anthropicDefaultURL     = "https://api.anthropic.com/v1/messages"
+	anthropicVersion        = "2023-06-01"
+	anthropicDefaultMaxToks = 4096
+)
+
+// AnthropicProvider Anthropic Messages API 适配器
+type AnthropicProvider struct{}
+
+// anthropicBlock Messages API 内容块
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// anthropicMessage Messages API 消息
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicUsage Messages API 用量
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicResponse Messages API 非流式响应
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// anthropicStreamEvent Messages API 流式事件
+type anthropicStreamEvent struct {
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	ContentBlock anthropicBlock  `json:"content_block"`
+	Message      json.RawMessage `json:"message"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+}
+
+// Name 返回 provider 名称
+func (p *AnthropicProvider) Name() string { return ProviderAnthropic }
+
+// Complete 发送一轮非流式请求
+func (p *AnthropicProvider) Complete(ctx context.Context, cfg ModelConfig, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := p.post(ctx, cfg, p.buildBody(cfg, req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newNetworkError(p.Name(), "read response failed", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.Name(), resp, body)
+	}
+
+	var ar anthropicResponse
+	if err := json.Unmarshal(body, &ar); err != nil {
+		return nil, newMalformedError(p.Name(), "parse response failed: "+string(body), err)
+	}
+
+	result := &ChatResponse{
+		Message:      Message{Role: "assistant"},
+		FinishReason: ar.StopReason,
+		Usage: Usage{
+			PromptTokens:     ar.Usage.InputTokens,
+			CompletionTokens: ar.Usage.OutputTokens,
+			TotalTokens:      ar.Usage.InputTokens + ar.Usage.OutputTokens,
+		},
+	}
+	var text strings.Builder
+	for _, block := range ar.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			result.Message.ToolCalls = append(result.Message.ToolCalls, mcp.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: mcp.Function{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	result.Message.Content = text.String()
+	return result, nil
+}
+
+// Stream 发送一轮流式请求
+func (p *AnthropicProvider) Stream(ctx context.Context, cfg ModelConfig, req *ChatRequest, onDelta DeltaFunc) (*ChatResponse, error) {
+	resp, err := p.post(ctx, cfg, p.buildBody(cfg, req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(p.Name(), resp, body)
+	}
+
+	var text strings.Builder
+	toolCalls := make(map[int]*mcp.ToolCall)
+	result := &ChatResponse{Message: Message{Role: "assistant"}}
+
+	err = scanSSE(resp.Body, func(_ string, data string) (bool, error) {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, newMalformedError(p.Name(), "parse stream event failed: "+data, err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			var start struct {
+				Usage anthropicUsage `json:"usage"`
+			}
+			if json.Unmarshal(event.Message, &start) == nil {
+				result.Usage.PromptTokens = start.Usage.InputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				toolCalls[event.Index] = &mcp.ToolCall{
+					ID:       event.ContentBlock.ID,
+					Type:     "function",
+					Function: mcp.Function{Name: event.ContentBlock.Name},
+				}
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				text.WriteString(event.Delta.Text)
+				if onDelta != nil {
+					onDelta(event.Delta.Text)
+				}
+			case "input_json_delta":
+				if tc, ok := toolCalls[event.Index]; ok {
+					tc.Function.Arguments += event.Delta.PartialJSON
+				}
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				result.FinishReason = event.Delta.StopReason
+			}
+			result.Usage.CompletionTokens = event.Usage.OutputTokens
+		case "message_stop":
+			return false, nil
+		case "error":
+			return false, newMessageError(p.Name(), data)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Usage.TotalTokens = result.Usage.PromptTokens + result.Usage.CompletionTokens
+	result.Message.Content = text.String()
+	result.Message.ToolCalls = sortedToolCalls(toolCalls)
+	for i := range result.Message.ToolCalls {
+		if result.Message.ToolCalls[i].Function.Arguments == "" {
+			result.Message.ToolCalls[i].Function.Arguments = "{}"
+		}
+	}
+	return result, nil
+}
+
+// buildBody 构建 Messages API 请求体
+// Messages API 没有与 response_format 对应的参数，req.ResponseFormat 不会发送，结构化输出只依赖提示词中的 JSON 要求
+func (p *AnthropicProvider) buildBody(cfg ModelConfig, req *ChatRequest, stream bool) map[string]interface{} {
+	var system []string
+	var messages []anthropicMessage
+
+	appendBlock := func(role string, block anthropicBlock) {
+		// 连续同角色消息需要合并（工具结果必须放在同一条 user 消息中）
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, block)
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: []anthropicBlock{block}})
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Content)
+		case "tool":
+			appendBlock("user", anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallId, Content: msg.Content})
+		case "assistant":
+			if msg.Content != "" {
+				appendBlock("assistant", anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				appendBlock("assistant", anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+		default:
+			appendBlock("user", anthropicBlock{Type: "text", Text: msg.Content})
+		}
+	}
+
+	maxTokens := cfg.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxToks
+	}
+
+	body := map[string]interface{}{
+		"model":       cfg.Model,
+		"messages":    messages,
+		"max_tokens":  maxTokens,
+		"temperature": req.Temperature,
+		"stream":      stream,
+	}
+	if len(system) > 0 {
+		body["system"] = strings.Join(system, "\n\n")
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]interface{}{
+				"name":         t.Function.Name,
+				"description":  t.Function.Description,
+				"input_schema": t.Function.Parameters,
+			})
+		}
+		body["tools"] = tools
+	}
+	return body
+}
+
+// post 发送 HTTP 请求
+func (p *AnthropicProvider) post(ctx context.Context, cfg ModelConfig, requestBody map[string]interface{}) (*http.Response, error) {
+	if cfg.APIKey == "" {
+		return nil, &APIError{Kind: ErrAuth, Provider: p.Name(), Message: "API key not configured for model " + cfg.Name}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	url := cfg.BaseURL
+	if url == "" {
+		url = anthropicDefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", cfg.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	client := &http.Client{Timeout: 3600 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, newNetworkError(p.Name(), "send request failed", err)
+	}
+	return resp, nil
+}
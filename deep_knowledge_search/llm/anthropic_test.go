@@ -0,0 +1,109 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAnthropicComplete 非流式请求：system 单独传递、工具结果转为 tool_result 块，tool_use 块还原为工具调用
+func TestAnthropicComplete(t *testing.T) {
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, `{"content":[{"type":"text","text":"需要再查"},{"type":"tool_use","id":"toolu_1","name":"fetchURL","input":{"url":"https://go.dev"}}],"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":6}}`)
+	})
+	req := testRequest()
+	// Messages API 没有对应的结构化输出参数，规划只依赖提示词中的 JSON 要求
+	req.ResponseFormat = &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+
+	resp, err := (&AnthropicProvider{}).Complete(context.Background(), srv.model(ProviderAnthropic), req)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if srv.header.Get("x-api-key") != "test-key" || srv.header.Get("anthropic-version") == "" {
+		t.Errorf("headers = %v", srv.header)
+	}
+	if srv.body["system"] != "你是助手" || srv.body["max_tokens"] != float64(256) {
+		t.Errorf("request body = %v", srv.body)
+	}
+	for _, key := range []string{"response_format", "output_format", "format"} {
+		if _, ok := srv.body[key]; ok {
+			t.Errorf("unexpected %s in request body", key)
+		}
+	}
+	messages := srv.body["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("messages = %v", messages)
+	}
+	toolUse := messages[1].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	toolResult := messages[2].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	if toolUse["type"] != "tool_use" || toolUse["id"] != "call_1" || toolResult["type"] != "tool_result" || toolResult["tool_use_id"] != "call_1" {
+		t.Errorf("tool blocks = %v / %v", toolUse, toolResult)
+	}
+	tool := srv.body["tools"].([]interface{})[0].(map[string]interface{})
+	if tool["name"] != "webSearch" || tool["input_schema"] == nil {
+		t.Errorf("tool = %v", tool)
+	}
+
+	if resp.Message.Content != "需要再查" || resp.FinishReason != "tool_use" || resp.Usage.TotalTokens != 26 {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].ID != "toolu_1" || resp.Message.ToolCalls[0].Function.Arguments != `{"url":"https://go.dev"}` {
+		t.Errorf("tool calls = %+v", resp.Message.ToolCalls)
+	}
+}
+
+// TestAnthropicStream 流式事件：文本增量、input_json_delta 拼接参数、用量来自 message_start / message_delta
+func TestAnthropicStream(t *testing.T) {
+	events := []string{
+		`event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":15,"output_tokens":1}}}`,
+		`event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"我来"}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"搜索"}}`,
+		`event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_2","name":"webSearch","input":{}}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\": \"go"}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"routine\"}"}}`,
+		`event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`,
+		`event: message_stop
+data: {"type":"message_stop"}`,
+	}
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, strings.Join(events, "\n\n")+"\n\n")
+	})
+
+	var deltas []string
+	resp, err := (&AnthropicProvider{}).Stream(context.Background(), srv.model(ProviderAnthropic), testRequest(), func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if srv.body["stream"] != true {
+		t.Errorf("stream = %v", srv.body["stream"])
+	}
+	if strings.Join(deltas, "|") != "我来|搜索" || resp.Message.Content != "我来搜索" {
+		t.Errorf("content = %q, deltas = %v", resp.Message.Content, deltas)
+	}
+	if resp.Usage != (Usage{PromptTokens: 15, CompletionTokens: 9, TotalTokens: 24}) || resp.FinishReason != "tool_use" {
+		t.Errorf("usage = %+v, finish = %q", resp.Usage, resp.FinishReason)
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Arguments != `{"query": "goroutine"}` {
+		t.Errorf("tool calls = %+v", resp.Message.ToolCalls)
+	}
+
+	// 流中的 error 事件按消息分类
+	overloaded := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	})
+	if _, err := (&AnthropicProvider{}).Stream(context.Background(), overloaded.model(ProviderAnthropic), testRequest(), nil); ErrorKindOf(err) != ErrServer {
+		t.Errorf("error event: %v", err)
+	}
+}
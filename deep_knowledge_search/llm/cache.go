@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheMode 响应缓存模式
+// 缓存的是单轮模型回复：命中的回复中包含工具调用时，工具仍会实际执行，结果作为下一轮请求的一部分参与缓存键
+type CacheMode string
+
+const (
+	CacheOff       CacheMode = "off"        // 不使用缓存
+	CacheReadWrite CacheMode = "read-write" // 命中则直接返回，未命中时请求并写入
+	CacheReadOnly  CacheMode = "read-only"  // 只读取已有缓存，不写入新结果
+)
+
+// ParseCacheMode 解析缓存模式（空字符串视为 off）
+func ParseCacheMode(s string) (CacheMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "none":
+		return CacheOff, nil
+	case "read-write", "rw", "on":
+		return CacheReadWrite, nil
+	case "read-only", "ro":
+		return CacheReadOnly, nil
+	}
+	return CacheOff, fmt.Errorf("unknown cache mode: %s (off / read-write / read-only)", s)
+}
+
+// readable 是否读取缓存
+func (m CacheMode) readable() bool {
+	return m == CacheReadWrite || m == CacheReadOnly
+}
+
+// writable 是否写入缓存
+func (m CacheMode) writable() bool {
+	return m == CacheReadWrite
+}
+
+// DefaultCacheDir 默认缓存目录
+const DefaultCacheDir = "cache/llm"
+
+var (
+	cacheDir   = DefaultCacheDir
+	cacheDirMu sync.RWMutex
+)
+
+// SetCacheDir 设置缓存目录
+func SetCacheDir(dir string) {
+	cacheDirMu.Lock()
+	defer cacheDirMu.Unlock()
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+	cacheDir = dir
+}
+
+// getCacheDir 获取缓存目录
+func getCacheDir() string {
+	cacheDirMu.RLock()
+	defer cacheDirMu.RUnlock()
+	return cacheDir
+}
+
+// cacheEntry 缓存文件内容
+type cacheEntry struct {
+	Key       string       `json:"key"`
+	Provider  string       `json:"provider"`
+	Model     string       `json:"model"`
+	CreatedAt time.Time    `json:"created_at"`
+	Response  ChatResponse `json:"response"`
+}
+
+// cacheKey 计算一轮请求的缓存键：provider、模型、温度、消息（API 格式）和工具定义的 sha256
+func cacheKey(provider string, cfg ModelConfig, req *ChatRequest) (string, error) {
+	fields := map[string]interface{}{
+		"provider":    provider,
+		"model":       cfg.Model,
+		"temperature": req.Temperature,
+		"messages":    convertMessagesToAPI(req.Messages),
+		"tools":       req.Tools,
+	}
+	if req.ResponseFormat != nil {
+		fields["response_format"] = req.ResponseFormat.Schema
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachePath 缓存文件路径（按前两位分目录）
+func cachePath(key string) string {
+	return filepath.Join(getCacheDir(), key[:2], key+".json")
+}
+
+// cacheLoad 读取缓存，未命中返回 false
+func cacheLoad(key string) (*ChatResponse, bool) {
+	data, err := os.ReadFile(cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		return nil, false
+	}
+	return &entry.Response, true
+}
+
+// cacheStore 写入缓存（先写临时文件再重命名，避免并发读到半个文件）
+func cacheStore(key string, provider string, cfg ModelConfig, resp *ChatResponse) error {
+	path := cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cacheEntry{
+		Key:       key,
+		Provider:  provider,
+		Model:     cfg.Model,
+		CreatedAt: time.Now(),
+		Response:  *resp,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
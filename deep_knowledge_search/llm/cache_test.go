@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestParseCacheMode 缓存模式的别名和未知值
+func TestParseCacheMode(t *testing.T) {
+	tests := map[string]CacheMode{
+		"": CacheOff, "off": CacheOff, "none": CacheOff,
+		"read-write": CacheReadWrite, "RW": CacheReadWrite, " on ": CacheReadWrite,
+		"read-only": CacheReadOnly, "ro": CacheReadOnly,
+	}
+	for in, want := range tests {
+		if got, err := ParseCacheMode(in); err != nil || got != want {
+			t.Errorf("ParseCacheMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseCacheMode("sometimes"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+// TestCacheKey 缓存键由 provider、模型、温度、消息、工具和结构化输出决定，与模型配置名称无关
+func TestCacheKey(t *testing.T) {
+	cfg := ModelConfig{Name: "a", Model: "test-model"}
+	key := func(provider string, cfg ModelConfig, mutate func(*ChatRequest)) string {
+		req := testRequest()
+		if mutate != nil {
+			mutate(req)
+		}
+		k, err := cacheKey(provider, cfg, req)
+		if err != nil {
+			t.Fatalf("cacheKey: %v", err)
+		}
+		return k
+	}
+	base := key(ProviderOpenAI, cfg, nil)
+
+	if got := key(ProviderOpenAI, ModelConfig{Name: "b", Model: "test-model"}, nil); got != base {
+		t.Error("key depends on the model config name")
+	}
+	variants := map[string]string{
+		"provider":    key(ProviderAnthropic, cfg, nil),
+		"model":       key(ProviderOpenAI, ModelConfig{Name: "a", Model: "other-model"}, nil),
+		"temperature": key(ProviderOpenAI, cfg, func(r *ChatRequest) { r.Temperature = 0.7 }),
+		"messages":    key(ProviderOpenAI, cfg, func(r *ChatRequest) { r.Messages[1].Content = "查一下 channel" }),
+		"tool result": key(ProviderOpenAI, cfg, func(r *ChatRequest) { r.Messages[3].Content = "[1] 另一个结果" }),
+		"tools":       key(ProviderOpenAI, cfg, func(r *ChatRequest) { r.Tools = nil }),
+		"format": key(ProviderOpenAI, cfg, func(r *ChatRequest) {
+			r.ResponseFormat = &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+		}),
+	}
+	for name, k := range variants {
+		if k == base {
+			t.Errorf("changing %s does not change the key", name)
+		}
+	}
+}
+
+// TestChatCacheModes read-write 命中时不再请求接口，read-only 不写入，off 不读写；命中的工具调用轮次仍执行工具
+func TestChatCacheModes(t *testing.T) {
+	SetCacheDir(t.TempDir())
+	t.Cleanup(func() { SetCacheDir("") })
+
+	var toolRuns atomic.Int32
+	mcp.RegisterTool("cacheProbe", mcp.LLMTool{Type: "function", Function: mcp.LLMFunction{Name: "cacheProbe"}},
+		func(ctx context.Context, arguments map[string]interface{}) mcp.MCPToolResponse {
+			toolRuns.Add(1)
+			return mcp.MCPToolResponse{Success: true, Result: "probe result"}
+		})
+	t.Cleanup(func() { mcp.UnregisterTool("cacheProbe") })
+
+	// 第一轮调用工具，收到工具结果后返回最终回复
+	var requests int
+	var srv *standIn
+	srv = newStandIn(t, func(w http.ResponseWriter) {
+		requests++
+		messages, _ := srv.body["messages"].([]interface{})
+		last, _ := messages[len(messages)-1].(map[string]interface{})
+		if last["role"] == "tool" {
+			io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"最终回复"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"cacheProbe","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":8,"completion_tokens":3,"total_tokens":11}}`)
+	})
+
+	chat := func(mode CacheMode) *ChatResult {
+		t.Helper()
+		result, err := Chat(context.Background(), &OpenAIProvider{}, []Message{{Role: "user", Content: "缓存测试"}}, ChatOptions{Model: srv.model(ProviderOpenAI), Cache: mode})
+		if err != nil {
+			t.Fatalf("Chat(%s): %v", mode, err)
+		}
+		if result.Content != "最终回复" || result.Rounds != 2 {
+			t.Fatalf("Chat(%s) = %+v", mode, result)
+		}
+		return result
+	}
+	cacheFiles := func() int {
+		files, _ := filepath.Glob(filepath.Join(getCacheDir(), "*", "*.json"))
+		return len(files)
+	}
+
+	// read-only 未命中：请求接口但不写入
+	if r := chat(CacheReadOnly); r.CacheHits != 0 || requests != 2 || cacheFiles() != 0 {
+		t.Errorf("read-only miss: hits = %d, requests = %d, files = %d", r.CacheHits, requests, cacheFiles())
+	}
+
+	// read-write：第一次写入两轮，第二次全部命中，用量不计入
+	chat(CacheReadWrite)
+	if requests != 4 || cacheFiles() != 2 {
+		t.Errorf("read-write miss: requests = %d, files = %d", requests, cacheFiles())
+	}
+	if r := chat(CacheReadWrite); r.CacheHits != 2 || requests != 4 || r.Usage.TotalTokens != 0 || !r.Turns[0].CacheHit {
+		t.Errorf("read-write hit: hits = %d, requests = %d, usage = %+v", r.CacheHits, requests, r.Usage)
+	}
+
+	// read-only 命中已有缓存
+	if r := chat(CacheReadOnly); r.CacheHits != 2 || requests != 4 {
+		t.Errorf("read-only hit: hits = %d, requests = %d", r.CacheHits, requests)
+	}
+
+	// off 不读取缓存
+	if r := chat(CacheOff); r.CacheHits != 0 || requests != 6 {
+		t.Errorf("off: hits = %d, requests = %d", r.CacheHits, requests)
+	}
+
+	// 工具结果不缓存：每次对话（包括全部命中缓存的对话）都实际执行了工具
+	if n := toolRuns.Load(); n != 5 {
+		t.Errorf("tool runs = %d, want 5", n)
+	}
+
+	// 损坏的缓存文件视为未命中
+	files, _ := filepath.Glob(filepath.Join(getCacheDir(), "*", "*.json"))
+	for _, f := range files {
+		os.WriteFile(f, []byte("{"), 0644)
+	}
+	if r := chat(CacheReadOnly); r.CacheHits != 0 || requests != 8 {
+		t.Errorf("corrupt cache: hits = %d, requests = %d", r.CacheHits, requests)
+	}
+}
@@ -1,101 +1,212 @@
 package llm
 
 import (
-	"bytes"
 	"context"
 	"deepknowledgesearch/mcp"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
+// DefaultMaxToolIterations 一次对话中默认的最大请求轮数（每轮可包含多个工具调用）
+const DefaultMaxToolIterations = 10
+
+// maxToolIterations 当前的最大请求轮数
+var (
+	maxToolIterations   = DefaultMaxToolIterations
+	maxToolIterationsMu sync.RWMutex
+)
+
+// SetMaxToolIterations 设置一次对话的最大请求轮数（<= 0 时使用 DefaultMaxToolIterations）
+func SetMaxToolIterations(n int) {
+	if n <= 0 {
+		n = DefaultMaxToolIterations
+	}
+	maxToolIterationsMu.Lock()
+	defer maxToolIterationsMu.Unlock()
+	maxToolIterations = n
+}
+
+// GetMaxToolIterations 一次对话的最大请求轮数
+func GetMaxToolIterations() int {
+	maxToolIterationsMu.RLock()
+	defer maxToolIterationsMu.RUnlock()
+	return maxToolIterations
+}
+
+// ChatOptions 对话调用选项
+type ChatOptions struct {
+	Model   ModelConfig // 使用的模型配置
+	OnDelta DeltaFunc   // 非空时使用流式请求并回调增量内容
+	Cache   CacheMode   // 响应缓存模式（空表示不使用）
+
+	ResponseFormat *ResponseFormat // 结构化输出要求（模型不支持时只依赖提示词）
+}
+
+// ToolCaller 由 provider 实现时，Chat 用它代替实际执行工具（如回放 provider 返回记录的工具结果）
+// 返回的 result 直接作为工具消息内容，不再截断
+type ToolCaller interface {
+	CallTool(ctx context.Context, call mcp.ToolCall) (result string, success bool)
+}
+
+// ChatResult 一次对话（含工具调用循环）的结果
+type ChatResult struct {
+	Content string // 最终回复内容
+	Usage   Usage  // 所有轮次累计的 token 用量
+	Rounds  int    // 请求轮数
+
+	CacheHits int // 命中缓存的轮数（命中的轮次不计入 Usage）
+
+	Turns []Turn // 每轮的记录（最后一轮为最终回复；失败时只包含已完成的轮次）
+}
+
+// Turn 工具调用循环中的一轮：模型的回复及其请求的工具调用
+type Turn struct {
+	Content    string           `json:"content,omitempty"`    // 模型回复的文本（调用工具的轮次通常为空）
+	ToolCalls  []ToolCallRecord `json:"tool_calls,omitempty"` // 本轮执行的工具调用（按模型给出的顺序）
+	StartTime  time.Time        `json:"start_time"`
+	DurationMs int64            `json:"duration_ms"` // 模型请求耗时（不含工具执行）
+	Usage      Usage            `json:"usage"`
+	CacheHit   bool             `json:"cache_hit,omitempty"`
+}
+
+// ToolCallRecord 一次工具调用的记录
+type ToolCallRecord struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Arguments  string    `json:"arguments"` // 模型给出的参数（JSON 文本）
+	Result     string    `json:"result"`    // 返回给模型的内容（失败时为错误说明，超长时为截断后的内容）
+	Success    bool      `json:"success"`
+	StartTime  time.Time `json:"start_time"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// ToolCallCount 所有轮次的工具调用次数
+func (r *ChatResult) ToolCallCount() int {
+	n := 0
+	for _, turn := range r.Turns {
+		n += len(turn.ToolCalls)
+	}
+	return n
+}
+
 // SendSyncLLMRequest sends a synchronous LLM request with tool calling support
 func SendSyncLLMRequest(ctx context.Context, messages []Message) (string, error) {
-	// Get current model config
 	modelConfig := GetCurrentModelConfig()
-	if modelConfig.APIKey == "" {
-		return "", fmt.Errorf("LLM API key not configured for model %s", modelConfig.Name)
+	provider, err := ProviderFor(modelConfig)
+	if err != nil {
+		return "", err
 	}
+	result, err := Chat(ctx, provider, messages, ChatOptions{Model: modelConfig})
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// Chat 通过 provider 执行对话，并处理工具调用循环
+// 达到最大轮数时模型仍在调用工具则返回 ErrMaxIterations（result 中保留已累计的用量）
+func Chat(ctx context.Context, provider Provider, messages []Message, opts ChatOptions) (*ChatResult, error) {
+	modelConfig := opts.Model
 
-	// Get available MCP tools
-	availableTools := mcp.GetAvailableLLMTools()
-	fmt.Printf("[LLM] Available tools: %d\n", len(availableTools))
+	// Get MCP tools available to this task
+	availableTools := mcp.GetLLMToolsForContext(ctx)
+	fmt.Printf("[LLM] Available tools: %d (provider=%s)\n", len(availableTools), provider.Name())
 
 	// Keep track of messages
 	currentMessages := make([]Message, len(messages))
 	copy(currentMessages, messages)
 
 	// Tool calling loop
-	maxIterations := 10
-	var finalResponse string
+	maxIterations := GetMaxToolIterations()
+	result := &ChatResult{}
 
 	for iteration := 0; iteration < maxIterations; iteration++ {
-		// Convert messages to API format
-		apiMessages := convertMessagesToAPI(currentMessages)
-
-		// Build request body
-		requestBody := map[string]interface{}{
-			"model":       modelConfig.Model,
-			"messages":    apiMessages,
-			"tools":       availableTools,
-			"temperature": modelConfig.Temperature,
-			"stream":      false,
-		}
+		req := &ChatRequest{
+			Messages:    currentMessages,
+			Tools:       availableTools,
+			Temperature: modelConfig.Temperature,
 
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			return "", fmt.Errorf("marshal request failed: %w", err)
+			ResponseFormat: responseFormatFor(modelConfig, opts.ResponseFormat),
 		}
 
 		fmt.Printf("[LLM] Sending request (iteration %d)...\n", iteration+1)
+		turnStart := time.Now()
 
-		// Create HTTP request with context
-		req, err := http.NewRequestWithContext(ctx, "POST", modelConfig.BaseURL, bytes.NewBuffer(jsonData))
-		if err != nil {
-			return "", fmt.Errorf("create request failed: %w", err)
+		// 查询响应缓存（只缓存模型回复，命中后回复中的工具调用仍会实际执行）
+		var resp *ChatResponse
+		cacheHit := false
+		var key string
+		if opts.Cache.readable() {
+			var err error
+			if key, err = cacheKey(provider.Name(), modelConfig, req); err != nil {
+				fmt.Printf("[LLM] Cache key failed: %v\n", err)
+			}
 		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+modelConfig.APIKey)
-
-		// Send request (1 hour timeout)
-		client := &http.Client{Timeout: 3600 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("send request failed: %w", err)
+		if key != "" && opts.Cache.readable() {
+			if cached, ok := cacheLoad(key); ok {
+				fmt.Printf("[LLM] Cache hit: %s\n", key[:12])
+				resp = cached
+				cacheHit = true
+				result.CacheHits++
+				if opts.OnDelta != nil && resp.Message.Content != "" {
+					opts.OnDelta(resp.Message.Content)
+				}
+			}
 		}
-		defer resp.Body.Close()
 
-		// Read response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", fmt.Errorf("read response failed: %w", err)
-		}
+		if resp == nil {
+			// 限流、服务端错误和网络错误按退避策略重试
+			var err error
+			send := func(onDelta DeltaFunc) (*ChatResponse, error) {
+				if onDelta != nil {
+					return provider.Stream(ctx, modelConfig, req, onDelta)
+				}
+				return provider.Complete(ctx, modelConfig, req)
+			}
+			resp, err = withRetry(ctx, provider.Name(), modelConfig.Name, send, opts.OnDelta)
+			if err != nil && shouldDowngradeFormat(modelConfig, req, err) {
+				// 接口不接受结构化输出参数：记录后去掉该参数重发，缓存键随之变化
+				structuredUnsupported.Store(modelConfig.Name, true)
+				fmt.Printf("[LLM] Model %s rejected structured output, retrying without it: %v\n", modelConfig.Name, err)
+				req.ResponseFormat = nil
+				if key != "" {
+					key, _ = cacheKey(provider.Name(), modelConfig, req)
+				}
+				resp, err = withRetry(ctx, provider.Name(), modelConfig.Name, send, opts.OnDelta)
+			}
+			if err != nil {
+				return result, err
+			}
+			result.Usage.Add(resp.Usage)
 
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("LLM API error: %d, body: %s", resp.StatusCode, string(body))
+			if key != "" && opts.Cache.writable() {
+				if err := cacheStore(key, provider.Name(), modelConfig, resp); err != nil {
+					fmt.Printf("[LLM] Cache store failed: %v\n", err)
+				}
+			}
 		}
+		result.Rounds++
 
-		// Parse response
-		var llmResp LLMResponse
-		if err := json.Unmarshal(body, &llmResp); err != nil {
-			return "", fmt.Errorf("parse response failed: %w, body: %s", err, string(body))
+		turn := Turn{
+			Content:    resp.Message.Content,
+			StartTime:  turnStart,
+			DurationMs: time.Since(turnStart).Milliseconds(),
+			CacheHit:   cacheHit,
 		}
-
-		if len(llmResp.Choices) == 0 {
-			return "", fmt.Errorf("empty response from LLM")
+		if !cacheHit {
+			turn.Usage = resp.Usage
 		}
 
-		choice := llmResp.Choices[0]
-		toolCalls := choice.Message.ToolCalls
+		toolCalls := resp.Message.ToolCalls
 
 		// If no tool calls, return content
 		if len(toolCalls) == 0 {
-			finalResponse = choice.Message.Content
+			result.Turns = append(result.Turns, turn)
+			result.Content = resp.Message.Content
 			fmt.Printf("[LLM] Response received (no tool calls)\n")
-			break
+			return result, nil
 		}
 
 		// Process tool calls
@@ -104,8 +215,8 @@ func SendSyncLLMRequest(ctx context.Context, messages []Message) (string, error)
 		// Add assistant message (with tool calls) to history
 		assistantMsg := Message{
 			Role:      "assistant",
-			Content:   choice.Message.Content,
-			ToolCalls: choice.Message.ToolCalls,
+			Content:   resp.Message.Content,
+			ToolCalls: resp.Message.ToolCalls,
 		}
 		currentMessages = append(currentMessages, assistantMsg)
 
@@ -116,20 +227,43 @@ func SendSyncLLMRequest(ctx context.Context, messages []Message) (string, error)
 
 			fmt.Printf("[LLM] Calling tool: %s\n", toolName)
 
-			// Parse tool arguments
-			parsedArgs, err := mcp.ParseToolArguments(toolArgs)
-			if err != nil {
-				parsedArgs = make(map[string]interface{})
+			// Parse tool arguments (empty arguments mean no parameters)
+			parsedArgs := make(map[string]interface{})
+			var argsErr error
+			if strings.TrimSpace(toolArgs) != "" {
+				parsedArgs, argsErr = mcp.ParseToolArguments(toolArgs)
 			}
 
 			// Call tool with context
-			result := mcp.CallMCPTool(ctx, toolName, parsedArgs)
+			toolStart := time.Now()
+			var toolResult string
+			var success bool
+			if argsErr != nil {
+				// 参数不是合法的 JSON 对象：不执行工具，把错误返回给模型修正
+				fmt.Printf("[LLM] Invalid arguments for tool %s: %v\n", toolName, argsErr)
+				toolResult = fmt.Sprintf("Error: invalid tool arguments: %v", argsErr)
+			} else if caller, ok := provider.(ToolCaller); ok {
+				toolResult, success = caller.CallTool(ctx, toolCall)
+			} else {
+				toolResp := mcp.CallMCPTool(ctx, toolName, parsedArgs)
 
-			// Add tool result to messages
-			toolResult := fmt.Sprintf("%v", result.Result)
-			if !result.Success {
-				toolResult = "Error: " + result.Error
+				// Add tool result to messages (oversized results are truncated, full text kept on disk)
+				toolResult = fmt.Sprintf("%v", toolResp.Result)
+				if !toolResp.Success {
+					toolResult = "Error: " + toolResp.Error
+				}
+				toolResult = mcp.LimitToolResult(ctx, toolName, toolResult)
+				success = toolResp.Success
 			}
+			turn.ToolCalls = append(turn.ToolCalls, ToolCallRecord{
+				ID:         toolCall.ID,
+				Name:       toolName,
+				Arguments:  toolArgs,
+				Result:     toolResult,
+				Success:    success,
+				StartTime:  toolStart,
+				DurationMs: time.Since(toolStart).Milliseconds(),
+			})
 
 			toolMsg := Message{
 				Role:       "tool",
@@ -138,14 +272,11 @@ func SendSyncLLMRequest(ctx context.Context, messages []Message) (string, error)
 			}
 			currentMessages = append(currentMessages, toolMsg)
 		}
-
-		// If last iteration, return what we have
-		if iteration == maxIterations-1 {
-			finalResponse = "工具调用已完成（达到最大迭代限制）"
-		}
+		result.Turns = append(result.Turns, turn)
 	}
 
-	return finalResponse, nil
+	fmt.Printf("[LLM] Tool loop reached %d iterations without a final response\n", maxIterations)
+	return result, fmt.Errorf("%w (%d)", ErrMaxIterations, maxIterations)
 }
 
 // convertMessagesToAPI converts Message slice to API format
@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestChatInvalidToolArguments 参数无法解析的工具调用不执行，错误记入调用记录并返回给模型；空参数视为无参数
+func TestChatInvalidToolArguments(t *testing.T) {
+	var toolRuns atomic.Int32
+	mcp.RegisterTool("argsProbe", mcp.LLMTool{Type: "function", Function: mcp.LLMFunction{Name: "argsProbe"}},
+		func(ctx context.Context, arguments map[string]interface{}) mcp.MCPToolResponse {
+			toolRuns.Add(1)
+			return mcp.MCPToolResponse{Success: true, Result: "probe result"}
+		})
+	t.Cleanup(func() { mcp.UnregisterTool("argsProbe") })
+
+	// 第一轮给出一个截断的参数和一个空参数，收到工具结果后返回最终回复
+	var toolMessages []map[string]interface{}
+	var srv *standIn
+	srv = newStandIn(t, func(w http.ResponseWriter) {
+		messages, _ := srv.body["messages"].([]interface{})
+		last, _ := messages[len(messages)-1].(map[string]interface{})
+		if last["role"] == "tool" {
+			for _, m := range messages {
+				if msg, _ := m.(map[string]interface{}); msg["role"] == "tool" {
+					toolMessages = append(toolMessages, msg)
+				}
+			}
+			io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"最终回复"},"finish_reason":"stop"}]}`)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[`+
+			`{"id":"call_bad","type":"function","function":{"name":"argsProbe","arguments":"{\"query\":"}},`+
+			`{"id":"call_empty","type":"function","function":{"name":"argsProbe","arguments":""}}]},"finish_reason":"tool_calls"}]}`)
+	})
+
+	result, err := Chat(context.Background(), &OpenAIProvider{}, []Message{{Role: "user", Content: "参数测试"}}, ChatOptions{Model: srv.model(ProviderOpenAI)})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if n := toolRuns.Load(); n != 1 {
+		t.Errorf("tool runs = %d, want 1 (only the call with empty arguments)", n)
+	}
+	if len(result.Turns) != 2 || len(result.Turns[0].ToolCalls) != 2 {
+		t.Fatalf("turns = %+v", result.Turns)
+	}
+	bad, empty := result.Turns[0].ToolCalls[0], result.Turns[0].ToolCalls[1]
+	if bad.Success || !strings.HasPrefix(bad.Result, "Error: invalid tool arguments") || bad.Arguments != `{"query":` {
+		t.Errorf("invalid call record = %+v", bad)
+	}
+	if !empty.Success || empty.Result != "probe result" {
+		t.Errorf("empty arguments call record = %+v", empty)
+	}
+	if len(toolMessages) != 2 || toolMessages[0]["tool_call_id"] != "call_bad" || toolMessages[0]["content"] != bad.Result {
+		t.Errorf("tool messages sent to the model = %v", toolMessages)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // ConfigFileName 配置文件名
@@ -14,16 +15,31 @@ const ConfigFileName = "config.json"
 // ModelConfig 模型配置
 type ModelConfig struct {
 	Name        string  `json:"name"`
+	Provider    string  `json:"provider,omitempty"` // openai(默认) / anthropic / ollama
 	APIKey      string  `json:"api_key"`
 	BaseURL     string  `json:"base_url"`
 	Model       string  `json:"model"`
 	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`   // 最大输出 token（anthropic 必填，默认 4096）
+	InputPrice  float64 `json:"input_price,omitempty"`  // 输入价格（每百万 token）
+	OutputPrice float64 `json:"output_price,omitempty"` // 输出价格（每百万 token）
+
+	MaxConcurrency   int    `json:"max_concurrency,omitempty"`   // 该模型的最大并发请求数（0 表示不限制）
+	StructuredOutput string `json:"structured_output,omitempty"` // 结构化输出模式：空(自动) / json_schema / json_object / off
+}
+
+// Cost 按模型单价计算一次调用的费用
+func (m ModelConfig) Cost(usage Usage) float64 {
+	return float64(usage.PromptTokens)/1e6*m.InputPrice + float64(usage.CompletionTokens)/1e6*m.OutputPrice
 }
 
 // LLMConfig holds LLM API configuration
 type LLMConfig struct {
 	Models       map[string]ModelConfig
 	CurrentModel string
+	PhaseModels  map[string][]string // 阶段 -> 模型名称列表（按优先级）
+
+	FallbackModels []string // 所有阶段通用的回退模型（排在默认模型之后）
 
 	// 兼容旧字段 (Deprecated)
 	APIKey      string  `json:"api_key"`
@@ -37,6 +53,9 @@ var llmConfig = LLMConfig{
 	Models: make(map[string]ModelConfig),
 }
 
+// llmConfigMu 保护模型表和阶段模型（/reload 与交互命令会在任务运行时修改）
+var llmConfigMu sync.RWMutex
+
 // GetConfig returns the current LLM configuration
 func GetConfig() *LLMConfig {
 	return &llmConfig
@@ -44,6 +63,13 @@ func GetConfig() *LLMConfig {
 
 // GetCurrentModelConfig 获取当前使用的模型配置
 func GetCurrentModelConfig() ModelConfig {
+	llmConfigMu.RLock()
+	defer llmConfigMu.RUnlock()
+	return currentModelConfig()
+}
+
+// currentModelConfig 当前模型配置（调用方持有 llmConfigMu）
+func currentModelConfig() ModelConfig {
 	if config, ok := llmConfig.Models[llmConfig.CurrentModel]; ok {
 		return config
 	}
@@ -57,8 +83,85 @@ func GetCurrentModelConfig() ModelConfig {
 	}
 }
 
+// SetPhaseModels 设置各阶段使用的模型（阶段名 -> 模型名称列表）
+func SetPhaseModels(phaseModels map[string][]string) {
+	llmConfigMu.Lock()
+	defer llmConfigMu.Unlock()
+	llmConfig.PhaseModels = make(map[string][]string, len(phaseModels))
+	for phase, names := range phaseModels {
+		llmConfig.PhaseModels[phase] = append([]string(nil), names...)
+	}
+}
+
+// SetFallbackModels 设置通用回退模型列表（按优先级）
+func SetFallbackModels(names []string) {
+	llmConfigMu.Lock()
+	defer llmConfigMu.Unlock()
+	llmConfig.FallbackModels = append([]string(nil), names...)
+}
+
+// ModelsForPhase 返回阶段的模型回退链：阶段配置的模型（按顺序，跳过不存在的），然后是当前默认模型，最后是通用回退模型
+func ModelsForPhase(phase string) []ModelConfig {
+	llmConfigMu.RLock()
+	defer llmConfigMu.RUnlock()
+
+	var chain []ModelConfig
+	seen := make(map[string]bool)
+
+	add := func(names []string, source string) {
+		for _, name := range names {
+			config, ok := llmConfig.Models[name]
+			if !ok {
+				fmt.Printf("[LLM] %s 配置的模型不存在，跳过: %s\n", source, name)
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				chain = append(chain, config)
+			}
+		}
+	}
+
+	add(llmConfig.PhaseModels[phase], "阶段 "+phase)
+
+	current := currentModelConfig()
+	if !seen[current.Name] {
+		seen[current.Name] = true
+		chain = append(chain, current)
+	}
+
+	add(llmConfig.FallbackModels, "回退列表")
+	return chain
+}
+
+// SetCurrentModel 切换当前默认模型，模型不存在时返回 false
+func SetCurrentModel(name string) (ModelConfig, bool) {
+	llmConfigMu.Lock()
+	defer llmConfigMu.Unlock()
+
+	current, ok := llmConfig.Models[name]
+	if !ok {
+		return ModelConfig{}, false
+	}
+	llmConfig.CurrentModel = name
+	// 同时更新旧字段
+	llmConfig.APIKey = current.APIKey
+	llmConfig.BaseURL = current.BaseURL
+	llmConfig.Model = current.Model
+	llmConfig.Temperature = current.Temperature
+	return current, true
+}
+
+// ModelForPhase 返回阶段首选的模型配置
+func ModelForPhase(phase string) ModelConfig {
+	return ModelsForPhase(phase)[0]
+}
+
 // InitWithConfig initializes with explicit config values
 func InitWithConfig(models []ModelConfig, defaultModel string) error {
+	llmConfigMu.Lock()
+	defer llmConfigMu.Unlock()
+
 	llmConfig.Models = make(map[string]ModelConfig)
 	for _, m := range models {
 		llmConfig.Models[m.Name] = m
@@ -66,7 +169,7 @@ func InitWithConfig(models []ModelConfig, defaultModel string) error {
 	llmConfig.CurrentModel = defaultModel
 
 	// 同时也设置旧字段作为后备
-	current := GetCurrentModelConfig()
+	current := currentModelConfig()
 	llmConfig.APIKey = current.APIKey
 	llmConfig.BaseURL = current.BaseURL
 	llmConfig.Model = current.Model
@@ -215,6 +318,7 @@ func createExampleConfig() {
 		Models: []ModelConfig{
 			{
 				Name:        "deepseek",
+				Provider:    "openai",
 				APIKey:      "your-api-key-here",
 				BaseURL:     "https://api.deepseek.com/v1/chat/completions",
 				Model:       "deepseek-chat",
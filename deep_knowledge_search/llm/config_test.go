@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestModelsForPhase 回退链顺序：阶段模型、默认模型、通用回退模型；跳过不存在的模型并去重
+func TestModelsForPhase(t *testing.T) {
+	llmConfigMu.RLock()
+	prev := llmConfig
+	llmConfigMu.RUnlock()
+	t.Cleanup(func() {
+		llmConfigMu.Lock()
+		llmConfig = prev
+		llmConfigMu.Unlock()
+	})
+
+	var models []ModelConfig
+	for _, name := range []string{"fast", "smart", "cheap", "backup"} {
+		models = append(models, ModelConfig{Name: name, Model: name})
+	}
+	InitWithConfig(models, "cheap")
+	SetPhaseModels(map[string][]string{
+		"plan":    {"smart", "missing", "fast"},
+		"execute": {"cheap", "fast"},
+	})
+	SetFallbackModels([]string{"backup", "smart", "missing"})
+
+	tests := []struct {
+		phase string
+		want  string
+	}{
+		{"plan", "smart,fast,cheap,backup"},
+		{"execute", "cheap,fast,backup,smart"},
+		{"verify", "cheap,backup,smart"},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, m := range ModelsForPhase(tt.phase) {
+			names = append(names, m.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("ModelsForPhase(%q) = %s, want %s", tt.phase, got, tt.want)
+		}
+	}
+	if got := ModelForPhase("plan").Name; got != "smart" {
+		t.Errorf("ModelForPhase(plan) = %s", got)
+	}
+
+	// 阶段配置是副本，调用方之后修改不影响回退链
+	phases := map[string][]string{"plan": {"fast"}}
+	SetPhaseModels(phases)
+	phases["plan"][0] = "smart"
+	if got := ModelForPhase("plan").Name; got != "fast" {
+		t.Errorf("after caller mutation ModelForPhase(plan) = %s, want fast", got)
+	}
+}
+
+// TestModelCost 费用按每百万 token 的输入 / 输出单价分别计算
+func TestModelCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		model ModelConfig
+		usage Usage
+		want  float64
+	}{
+		{"no price", ModelConfig{}, Usage{PromptTokens: 1000, CompletionTokens: 500}, 0},
+		{"input only", ModelConfig{InputPrice: 2}, Usage{PromptTokens: 500000, CompletionTokens: 100}, 1},
+		{"input and output", ModelConfig{InputPrice: 3, OutputPrice: 15}, Usage{PromptTokens: 1000, CompletionTokens: 2000, TotalTokens: 3000}, 0.003 + 0.03},
+		{"total tokens ignored", ModelConfig{InputPrice: 1, OutputPrice: 1}, Usage{TotalTokens: 1e6}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.model.Cost(tt.usage); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: Cost = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
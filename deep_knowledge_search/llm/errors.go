@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind LLM 请求错误类别
+type ErrorKind string
+
+const (
+	ErrRateLimit      ErrorKind = "rate_limit"      // 触发限流（429），可重试
+	ErrServer         ErrorKind = "server"          // 服务端错误或过载（5xx），可重试
+	ErrNetwork        ErrorKind = "network"         // 网络错误（连接失败、读取中断），可重试
+	ErrAuth           ErrorKind = "auth"            // 认证失败或无权限，直接失败
+	ErrContextLength  ErrorKind = "context_length"  // 上下文超出模型限制，直接失败
+	ErrMalformed      ErrorKind = "malformed"       // 响应格式错误或为空
+	ErrInvalidRequest ErrorKind = "invalid_request" // 其他请求错误（4xx），直接失败
+	ErrToolLoop       ErrorKind = "max_iterations"  // 工具调用轮数达到上限（见 ErrMaxIterations）
+)
+
+// ErrMaxIterations 工具调用循环达到最大轮数仍没有得到最终回复
+var ErrMaxIterations = errors.New("tool loop reached the maximum number of iterations")
+
+// APIError LLM 请求错误
+type APIError struct {
+	Kind       ErrorKind
+	Provider   string
+	StatusCode int           // HTTP 状态码（非 HTTP 错误为 0）
+	Message    string        // 错误描述或响应体
+	RetryAfter time.Duration // 服务端建议的重试等待时间
+	Err        error         // 底层错误
+}
+
+// Error 实现 error 接口
+func (e *APIError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("LLM API error (%s): %d, body: %s", e.Kind, e.StatusCode, e.Message)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("LLM API error (%s): %s: %v", e.Kind, e.Message, e.Err)
+	}
+	return fmt.Sprintf("LLM API error (%s): %s", e.Kind, e.Message)
+}
+
+// Unwrap 返回底层错误
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable 是否可以重试
+func (e *APIError) Retryable() bool {
+	switch e.Kind {
+	case ErrRateLimit, ErrServer, ErrNetwork:
+		return true
+	}
+	return false
+}
+
+// ErrorKindOf 返回错误类别（非 APIError 返回空字符串，ErrMaxIterations 返回 ErrToolLoop）
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	if errors.Is(err, ErrMaxIterations) {
+		return ErrToolLoop
+	}
+	return ""
+}
+
+// IsRetryable 错误是否可以重试
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+// IsFatal 错误是否不应重试（认证失败、上下文超长、请求无效）
+func IsFatal(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrAuth, ErrContextLength, ErrInvalidRequest:
+		return true
+	}
+	return false
+}
+
+// ShouldFallback 错误是否应切换到回退模型：限流、服务端、网络错误（已在当前模型上重试过）、响应异常和上下文超长
+// 换模型可能成功；认证失败和无效请求是配置或请求本身的问题，换模型只会依次耗尽回退链，取消等非接口错误也不切换
+func ShouldFallback(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrRateLimit, ErrServer, ErrNetwork, ErrMalformed, ErrContextLength:
+		return true
+	}
+	return false
+}
+
+// contextLengthMarkers 各家接口表示上下文超长的错误关键字
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context length",
+	"prompt is too long",
+	"too many tokens",
+	"input is too long",
+	"context window",
+}
+
+// newStatusError 根据 HTTP 状态码和响应体构造错误
+func newStatusError(provider string, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimit
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrAuth
+	case resp.StatusCode >= 500:
+		// 包括 Anthropic 的 529 overloaded
+		e.Kind = ErrServer
+	case isContextLengthMessage(e.Message):
+		e.Kind = ErrContextLength
+	default:
+		e.Kind = ErrInvalidRequest
+	}
+	return e
+}
+
+// newMessageError 根据错误消息构造错误（流中的 error 事件、Ollama 的 error 字段）
+func newMessageError(provider string, message string) *APIError {
+	e := &APIError{Provider: provider, Message: message}
+
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "rate_limit") || strings.Contains(lower, "rate limit"):
+		e.Kind = ErrRateLimit
+	case strings.Contains(lower, "overloaded") || strings.Contains(lower, "api_error") || strings.Contains(lower, "server_error"):
+		e.Kind = ErrServer
+	case strings.Contains(lower, "authentication") || strings.Contains(lower, "permission"):
+		e.Kind = ErrAuth
+	case isContextLengthMessage(message):
+		e.Kind = ErrContextLength
+	default:
+		e.Kind = ErrInvalidRequest
+	}
+	return e
+}
+
+// newMalformedError 响应无法解析或为空
+func newMalformedError(provider string, message string, err error) *APIError {
+	return &APIError{Kind: ErrMalformed, Provider: provider, Message: message, Err: err}
+}
+
+// newNetworkError 请求发送或读取失败
+func newNetworkError(provider string, message string, err error) *APIError {
+	return &APIError{Kind: ErrNetwork, Provider: provider, Message: message, Err: err}
+}
+
+// isContextLengthMessage 错误消息是否表示上下文超长
+func isContextLengthMessage(message string) bool {
+	lower := strings.ToLower(message)
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter 解析 retry-after-ms / Retry-After（秒数或 HTTP 日期）
+func parseRetryAfter(header http.Header) time.Duration {
+	if v := header.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestNewStatusError HTTP 状态码和响应体映射到错误类别，并带上 Retry-After
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		header    http.Header
+		kind      ErrorKind
+		retryable bool
+		fatal     bool
+	}{
+		{429, `{"error":"slow down"}`, http.Header{"Retry-After": {"3"}}, ErrRateLimit, true, false},
+		{401, `{"error":"invalid api key"}`, nil, ErrAuth, false, true},
+		{403, `forbidden`, nil, ErrAuth, false, true},
+		{500, `internal`, nil, ErrServer, true, false},
+		{503, `maximum context length is busy`, nil, ErrServer, true, false},
+		{529, `{"type":"overloaded_error"}`, nil, ErrServer, true, false},
+		{400, `{"error":{"code":"context_length_exceeded"}}`, nil, ErrContextLength, false, true},
+		{400, `prompt is too long: 210000 tokens > 200000 maximum`, nil, ErrContextLength, false, true},
+		{400, `{"error":"unknown field"}`, nil, ErrInvalidRequest, false, true},
+		{404, `model not found`, nil, ErrInvalidRequest, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d_%s", tt.status, tt.kind), func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			err := newStatusError("test", resp, []byte(tt.body))
+			if err.Kind != tt.kind {
+				t.Errorf("kind = %s, want %s", err.Kind, tt.kind)
+			}
+			if IsRetryable(err) != tt.retryable || IsFatal(err) != tt.fatal {
+				t.Errorf("retryable = %v, fatal = %v", IsRetryable(err), IsFatal(err))
+			}
+			if tt.kind == ErrRateLimit && err.RetryAfter != 3*time.Second {
+				t.Errorf("retry after = %s, want 3s", err.RetryAfter)
+			}
+		})
+	}
+}
+
+// TestNewMessageError 流中错误事件和 Ollama error 字段按消息内容分类
+func TestNewMessageError(t *testing.T) {
+	tests := []struct {
+		message string
+		kind    ErrorKind
+	}{
+		{"rate_limit_error: Number of request tokens has exceeded your per-minute rate limit", ErrRateLimit},
+		{"Overloaded", ErrServer},
+		{`{"type":"api_error","message":"Internal server error"}`, ErrServer},
+		{"authentication_error: invalid x-api-key", ErrAuth},
+		{"permission_error", ErrAuth},
+		{"input is too long for requested model", ErrContextLength},
+		{"model 'llama9' not found", ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		if got := newMessageError("test", tt.message).Kind; got != tt.kind {
+			t.Errorf("%q: kind = %s, want %s", tt.message, got, tt.kind)
+		}
+	}
+}
+
+// TestParseRetryAfter retry-after-ms 优先，其次是秒数或 HTTP 日期；无效值和过去的日期返回 0
+func TestParseRetryAfter(t *testing.T) {
+	future := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"none", http.Header{}, 0, 0},
+		{"seconds", http.Header{"Retry-After": {"2"}}, 2 * time.Second, 2 * time.Second},
+		{"fractional seconds", http.Header{"Retry-After": {"0.5"}}, 500 * time.Millisecond, 500 * time.Millisecond},
+		{"milliseconds first", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"9"}}, 250 * time.Millisecond, 250 * time.Millisecond},
+		{"invalid milliseconds", http.Header{"Retry-After-Ms": {"soon"}, "Retry-After": {"4"}}, 4 * time.Second, 4 * time.Second},
+		{"http date", http.Header{"Retry-After": {future}}, 80 * time.Second, 90 * time.Second},
+		{"past date", http.Header{"Retry-After": {past}}, 0, 0},
+		{"negative", http.Header{"Retry-After": {"-1"}}, 0, 0},
+		{"garbage", http.Header{"Retry-After": {"later"}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter = %s, want [%s, %s]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// TestShouldFallback 只有换模型可能成功的错误才切换到回退模型
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{Kind: ErrRateLimit}, true},
+		{&APIError{Kind: ErrServer}, true},
+		{&APIError{Kind: ErrNetwork}, true},
+		{&APIError{Kind: ErrMalformed}, true},
+		{fmt.Errorf("wrapped: %w", &APIError{Kind: ErrContextLength}), true},
+		{&APIError{Kind: ErrAuth}, false},
+		{&APIError{Kind: ErrInvalidRequest}, false},
+		{fmt.Errorf("%w (10)", ErrMaxIterations), false},
+		{context.Canceled, false},
+		{errors.New("plain"), false},
+	}
+	for _, tt := range tests {
+		if got := ShouldFallback(tt.err); got != tt.want {
+			t.Errorf("ShouldFallback(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// modelSlots 每个模型的并发信号量（进程内共享，按模型名称区分）
+var (
+	modelSlots   = make(map[string]chan struct{})
+	modelSlotsMu sync.Mutex
+)
+
+// modelSemaphore 获取模型的信号量，未限制并发时返回 nil
+func modelSemaphore(cfg ModelConfig) chan struct{} {
+	if cfg.MaxConcurrency <= 0 {
+		return nil
+	}
+
+	modelSlotsMu.Lock()
+	defer modelSlotsMu.Unlock()
+
+	sem, ok := modelSlots[cfg.Name]
+	if !ok || cap(sem) != cfg.MaxConcurrency {
+		// 首次使用或配置变更（/reload）时重建，旧信号量上的持有者释放后自然失效
+		sem = make(chan struct{}, cfg.MaxConcurrency)
+		modelSlots[cfg.Name] = sem
+	}
+	return sem
+}
+
+// TryAcquireModelSlot 尝试立即占用模型并发名额，成功返回释放函数，名额已满返回 nil
+func TryAcquireModelSlot(cfg ModelConfig) func() {
+	sem := modelSemaphore(cfg)
+	if sem == nil {
+		return func() {}
+	}
+	select {
+	case sem <- struct{}{}:
+		return releaseFunc(sem)
+	default:
+		return nil
+	}
+}
+
+// AcquireModelSlot 等待模型并发名额，ctx 取消时返回错误
+func AcquireModelSlot(ctx context.Context, cfg ModelConfig) (func(), error) {
+	sem := modelSemaphore(cfg)
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return releaseFunc(sem), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaseFunc 返回只生效一次的释放函数
+func releaseFunc(sem chan struct{}) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-sem })
+	}
+}
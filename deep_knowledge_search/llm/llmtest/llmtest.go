@@ -0,0 +1,342 @@
+// Package llmtest 提供可编排的 OpenAI 兼容假服务器，用于在测试中替代真实 LLM 接口
+//
+// 用法:
+//
+//	srv := llmtest.NewServer(t)
+//	srv.On(llmtest.System("任务规划专家"), llmtest.JSON(plan))
+//	srv.On(llmtest.Any(), llmtest.Text("结果"))
+//	planner.SetProvider(srv.Provider())
+package llmtest
+
+import (
+	"context"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Request 服务器收到的一次请求
+type Request struct {
+	Model    string
+	Stream   bool
+	Messages []llm.Message
+	Tools    []string // 请求中声明的工具名称
+
+	ResponseFormat map[string]interface{} // 请求中的 response_format（未设置时为 nil）
+}
+
+// Text 返回所有消息内容拼接后的文本（便于匹配）
+func (r Request) Text() string {
+	var sb strings.Builder
+	for _, m := range r.Messages {
+		sb.WriteString(m.Content)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// LastMessage 返回最后一条消息
+func (r Request) LastMessage() llm.Message {
+	if len(r.Messages) == 0 {
+		return llm.Message{}
+	}
+	return r.Messages[len(r.Messages)-1]
+}
+
+// Reply 一次编排好的响应
+type Reply struct {
+	Content   string
+	ToolCalls []mcp.ToolCall
+	Usage     llm.Usage
+
+	Status int               // 非 0 时返回该 HTTP 状态码和 Body
+	Body   string            // 错误响应体
+	Header map[string]string // 额外响应头（如 Retry-After）
+}
+
+// Text 返回文本响应
+func Text(content string) Reply {
+	return Reply{Content: content, Usage: llm.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}
+}
+
+// JSON 返回 JSON 序列化后的文本响应
+func JSON(v interface{}) Reply {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("llmtest: marshal reply: %v", err))
+	}
+	return Text(string(data))
+}
+
+// ToolCall 返回调用一个工具的响应
+func ToolCall(name string, args map[string]interface{}) Reply {
+	data, err := json.Marshal(args)
+	if err != nil {
+		panic(fmt.Sprintf("llmtest: marshal tool args: %v", err))
+	}
+	return Reply{
+		ToolCalls: []mcp.ToolCall{{
+			ID:       "call_" + name,
+			Type:     "function",
+			Function: mcp.Function{Name: name, Arguments: string(data)},
+		}},
+		Usage: llm.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+}
+
+// Error 返回 HTTP 错误响应
+func Error(status int, body string) Reply {
+	return Reply{Status: status, Body: body}
+}
+
+// Matcher 请求匹配条件
+type Matcher func(req Request) bool
+
+// Any 匹配所有请求
+func Any() Matcher {
+	return func(Request) bool { return true }
+}
+
+// Contains 任一消息内容包含 substr
+func Contains(substr string) Matcher {
+	return func(req Request) bool { return strings.Contains(req.Text(), substr) }
+}
+
+// System system 消息包含 substr
+func System(substr string) Matcher {
+	return func(req Request) bool {
+		for _, m := range req.Messages {
+			if m.Role == "system" && strings.Contains(m.Content, substr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ToolResult 最后一条消息是工具结果（工具调用后的下一轮）
+func ToolResult() Matcher {
+	return func(req Request) bool { return req.LastMessage().Role == "tool" }
+}
+
+// All 同时满足所有条件
+func All(matchers ...Matcher) Matcher {
+	return func(req Request) bool {
+		for _, m := range matchers {
+			if !m(req) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// rule 一条编排规则：依次返回 replies，用完后重复最后一个
+type rule struct {
+	match   Matcher
+	replies []Reply
+	used    int
+}
+
+// Server OpenAI 兼容的假 LLM 服务器（支持流式和工具调用）
+type Server struct {
+	*httptest.Server
+
+	t        testing.TB
+	mu       sync.Mutex
+	rules    []*rule
+	requests []Request
+}
+
+// NewServer 启动假服务器，测试结束时自动关闭
+func NewServer(t testing.TB) *Server {
+	s := &Server{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// On 添加规则：按添加顺序匹配，第一个匹配的规则生效
+func (s *Server) On(match Matcher, replies ...Reply) {
+	if len(replies) == 0 {
+		panic("llmtest: On requires at least one reply")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &rule{match: match, replies: replies})
+}
+
+// Requests 返回已收到的请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Model 返回指向该服务器的模型配置
+func (s *Server) Model(name string) llm.ModelConfig {
+	return llm.ModelConfig{
+		Name:     name,
+		Provider: llm.ProviderOpenAI,
+		APIKey:   "test-key",
+		BaseURL:  s.URL,
+		Model:    name,
+	}
+}
+
+// Provider 返回把所有请求发往该服务器的 provider（忽略模型配置中的地址和密钥）
+func (s *Server) Provider() llm.Provider {
+	return &serverProvider{server: s, openai: &llm.OpenAIProvider{}}
+}
+
+// serverProvider 改写模型配置后委托给 OpenAI 适配器
+type serverProvider struct {
+	server *Server
+	openai *llm.OpenAIProvider
+}
+
+func (p *serverProvider) Name() string { return p.openai.Name() }
+
+func (p *serverProvider) Complete(ctx context.Context, cfg llm.ModelConfig, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	return p.openai.Complete(ctx, p.rewrite(cfg), req)
+}
+
+func (p *serverProvider) Stream(ctx context.Context, cfg llm.ModelConfig, req *llm.ChatRequest, onDelta llm.DeltaFunc) (*llm.ChatResponse, error) {
+	return p.openai.Stream(ctx, p.rewrite(cfg), req, onDelta)
+}
+
+func (p *serverProvider) rewrite(cfg llm.ModelConfig) llm.ModelConfig {
+	cfg.BaseURL = p.server.URL
+	cfg.APIKey = "test-key"
+	return cfg
+}
+
+// handle 处理 chat completions 请求
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model    string        `json:"model"`
+		Stream   bool          `json:"stream"`
+		Messages []llm.Message `json:"messages"`
+		Tools    []mcp.LLMTool `json:"tools"`
+
+		ResponseFormat map[string]interface{} `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "llmtest: bad request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := Request{Model: body.Model, Stream: body.Stream, Messages: body.Messages, ResponseFormat: body.ResponseFormat}
+	for _, tool := range body.Tools {
+		req.Tools = append(req.Tools, tool.Function.Name)
+	}
+
+	reply, ok := s.next(req)
+	if !ok {
+		s.t.Errorf("llmtest: no rule matches request, last message: %.200q", req.LastMessage().Content)
+		http.Error(w, `{"error":{"message":"llmtest: no matching rule"}}`, http.StatusBadRequest)
+		return
+	}
+
+	for k, v := range reply.Header {
+		w.Header().Set(k, v)
+	}
+	if reply.Status != 0 {
+		w.WriteHeader(reply.Status)
+		fmt.Fprint(w, reply.Body)
+		return
+	}
+
+	if req.Stream {
+		writeStream(w, reply)
+		return
+	}
+	writeJSON(w, reply)
+}
+
+// next 记录请求并取出匹配规则的下一个响应
+func (s *Server) next(req Request) (Reply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+	for _, rl := range s.rules {
+		if !rl.match(req) {
+			continue
+		}
+		idx := rl.used
+		if idx >= len(rl.replies) {
+			idx = len(rl.replies) - 1
+		}
+		rl.used++
+		return rl.replies[idx], true
+	}
+	return Reply{}, false
+}
+
+// writeJSON 写非流式响应
+func writeJSON(w http.ResponseWriter, reply Reply) {
+	finish := "stop"
+	if len(reply.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(llm.LLMResponse{
+		ID:     "llmtest",
+		Object: "chat.completion",
+		Choices: []llm.Choice{{
+			Message:      llm.Message{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls},
+			FinishReason: finish,
+		}},
+		Usage: reply.Usage,
+	})
+}
+
+// writeStream 写 SSE 流式响应：内容按行拆成多个分片，工具调用拆成名称和参数两个分片
+func writeStream(w http.ResponseWriter, reply Reply) {
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	send := func(chunk llm.StreamChunk) {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	delta := func(d llm.StreamDelta, finish string) llm.StreamChunk {
+		return llm.StreamChunk{ID: "llmtest", Choices: []llm.StreamChoice{{Delta: d, FinishReason: finish}}}
+	}
+
+	if reply.Content != "" {
+		for _, part := range strings.SplitAfter(reply.Content, "\n") {
+			if part != "" {
+				send(delta(llm.StreamDelta{Content: part}, ""))
+			}
+		}
+	}
+	for i, tc := range reply.ToolCalls {
+		var head llm.ToolCallDelta
+		head.Index = i
+		head.ID = tc.ID
+		head.Type = tc.Type
+		head.Function.Name = tc.Function.Name
+		send(delta(llm.StreamDelta{ToolCalls: []llm.ToolCallDelta{head}}, ""))
+
+		var args llm.ToolCallDelta
+		args.Index = i
+		args.Function.Arguments = tc.Function.Arguments
+		send(delta(llm.StreamDelta{ToolCalls: []llm.ToolCallDelta{args}}, ""))
+	}
+
+	finish := "stop"
+	if len(reply.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	send(delta(llm.StreamDelta{}, finish))
+	usage := reply.Usage
+	send(llm.StreamChunk{ID: "llmtest", Usage: &usage})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
@@ -24,6 +24,13 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+// Add 累加用量
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
 // LLMResponse represents response from LLM API
 type LLMResponse struct {
 	ID      string   `json:"id"`
@@ -33,3 +40,36 @@ type LLMResponse struct {
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
 }
+
+// StreamChunk represents one SSE chunk of a streaming response
+type StreamChunk struct {
+	ID      string         `json:"id"`
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+}
+
+// StreamChoice represents a choice in a streaming chunk
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        StreamDelta `json:"delta"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// StreamDelta represents the incremental message content in a chunk
+type StreamDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta represents a partial tool call; fields arrive in pieces keyed by Index
+type ToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments,omitempty"`
+	} `json:"function"`
+}
@@ -0,0 +1,187 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ollamaDefaultURL Ollama 本地服务默认地址
+const ollamaDefaultURL = "http://localhost:11434/api/chat"
+
+// OllamaProvider Ollama 风格本地模型适配器（/api/chat，NDJSON 流）
+type OllamaProvider struct{}
+
+// ollamaToolCall Ollama 工具调用（arguments 为 JSON 对象而非字符串）
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaMessage Ollama 消息
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// ollamaResponse Ollama 响应（流式时每行一个）
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// Name 返回 provider 名称
+func (p *OllamaProvider) Name() string { return ProviderOllama }
+
+// Complete 发送一轮非流式请求
+func (p *OllamaProvider) Complete(ctx context.Context, cfg ModelConfig, req *ChatRequest) (*ChatResponse, error) {
+	return p.Stream(ctx, cfg, req, nil)
+}
+
+// Stream 发送一轮请求；onDelta 为空时使用非流式模式
+func (p *OllamaProvider) Stream(ctx context.Context, cfg ModelConfig, req *ChatRequest, onDelta DeltaFunc) (*ChatResponse, error) {
+	resp, err := p.post(ctx, cfg, p.buildBody(cfg, req, onDelta != nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(p.Name(), resp, body)
+	}
+
+	result := &ChatResponse{Message: Message{Role: "assistant"}}
+	var content bytes.Buffer
+	received := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, newMalformedError(p.Name(), "parse response failed: "+string(line), err)
+		}
+		if chunk.Error != "" {
+			return nil, newMessageError(p.Name(), chunk.Error)
+		}
+		received = true
+
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			args := string(tc.Function.Arguments)
+			if args == "" || args == "null" {
+				args = "{}"
+			}
+			result.Message.ToolCalls = append(result.Message.ToolCalls, mcp.ToolCall{
+				ID:       fmt.Sprintf("call_%d", len(result.Message.ToolCalls)),
+				Type:     "function",
+				Function: mcp.Function{Name: tc.Function.Name, Arguments: args},
+			})
+		}
+		if chunk.Done {
+			result.FinishReason = chunk.DoneReason
+			result.Usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newNetworkError(p.Name(), "read response failed", err)
+	}
+	if !received {
+		return nil, newMalformedError(p.Name(), "empty response from LLM", nil)
+	}
+
+	result.Message.Content = content.String()
+	return result, nil
+}
+
+// buildBody 构建 /api/chat 请求体
+func (p *OllamaProvider) buildBody(cfg ModelConfig, req *ChatRequest, stream bool) map[string]interface{} {
+	messages := make([]ollamaMessage, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		om := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		for _, tc := range msg.ToolCalls {
+			var otc ollamaToolCall
+			otc.Function.Name = tc.Function.Name
+			otc.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(otc.Function.Arguments) {
+				otc.Function.Arguments = json.RawMessage("{}")
+			}
+			om.ToolCalls = append(om.ToolCalls, otc)
+		}
+		messages = append(messages, om)
+	}
+
+	body := map[string]interface{}{
+		"model":    cfg.Model,
+		"messages": messages,
+		"stream":   stream,
+		"options": map[string]interface{}{
+			"temperature": req.Temperature,
+		},
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
+	}
+	if req.ResponseFormat != nil {
+		body["format"] = ollamaFormat(cfg, req.ResponseFormat)
+	}
+	return body
+}
+
+// post 发送 HTTP 请求（本地服务通常不需要 API Key）
+func (p *OllamaProvider) post(ctx context.Context, cfg ModelConfig, requestBody map[string]interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	url := cfg.BaseURL
+	if url == "" {
+		url = ollamaDefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+
+	client := &http.Client{Timeout: 3600 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, newNetworkError(p.Name(), "send request failed", err)
+	}
+	return resp, nil
+}
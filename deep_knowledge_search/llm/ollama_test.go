@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestOllamaComplete 非流式请求：工具调用参数为 JSON 对象，结构化输出转为 format
+func TestOllamaComplete(t *testing.T) {
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"webSearch","arguments":{"query":"channel"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":8}`)
+	})
+	req := testRequest()
+	req.ResponseFormat = &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+
+	resp, err := (&OllamaProvider{}).Complete(context.Background(), srv.model(ProviderOllama), req)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if srv.body["stream"] != false || srv.body["format"] == nil {
+		t.Errorf("request body = %v", srv.body)
+	}
+	assistant := srv.body["messages"].([]interface{})[2].(map[string]interface{})
+	args := assistant["tool_calls"].([]interface{})[0].(map[string]interface{})["function"].(map[string]interface{})["arguments"]
+	if args.(map[string]interface{})["query"] != "goroutine" {
+		t.Errorf("assistant tool call arguments = %v", args)
+	}
+
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].ID != "call_0" || resp.Message.ToolCalls[0].Function.Arguments != `{"query":"channel"}` {
+		t.Errorf("tool calls = %+v", resp.Message.ToolCalls)
+	}
+	if resp.Usage != (Usage{PromptTokens: 30, CompletionTokens: 8, TotalTokens: 38}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+// TestOllamaStream NDJSON 流：逐行回调增量内容，done 行给出用量；行内 error 字段按消息分类
+func TestOllamaStream(t *testing.T) {
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, strings.Join([]string{
+			`{"message":{"role":"assistant","content":"Go "},"done":false}`,
+			`{"message":{"role":"assistant","content":"并发"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":2}`,
+		}, "\n")+"\n")
+	})
+
+	var deltas []string
+	resp, err := (&OllamaProvider{}).Stream(context.Background(), srv.model(ProviderOllama), testRequest(), func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if srv.body["stream"] != true {
+		t.Errorf("stream = %v", srv.body["stream"])
+	}
+	if strings.Join(deltas, "|") != "Go |并发" || resp.Message.Content != "Go 并发" || resp.Usage.TotalTokens != 5 || resp.FinishReason != "stop" {
+		t.Errorf("response = %+v, deltas = %v", resp, deltas)
+	}
+
+	failing := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, `{"error":"model requires more system memory than is available; server overloaded"}`+"\n")
+	})
+	if _, err := (&OllamaProvider{}).Stream(context.Background(), failing.model(ProviderOllama), testRequest(), nil); ErrorKindOf(err) != ErrServer {
+		t.Errorf("error line: %v", err)
+	}
+}
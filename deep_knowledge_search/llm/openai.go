@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// OpenAIProvider OpenAI 兼容接口适配器（Bearer 认证，choices[0].message，tool_calls）
+type OpenAIProvider struct{}
+
+// Name 返回 provider 名称
+func (p *OpenAIProvider) Name() string { return ProviderOpenAI }
+
+// Complete 发送一轮非流式请求
+func (p *OpenAIProvider) Complete(ctx context.Context, cfg ModelConfig, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := p.post(ctx, cfg, p.buildBody(cfg, req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newNetworkError(p.Name(), "read response failed", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.Name(), resp, body)
+	}
+
+	// Parse response
+	var llmResp LLMResponse
+	if err := json.Unmarshal(body, &llmResp); err != nil {
+		return nil, newMalformedError(p.Name(), "parse response failed: "+string(body), err)
+	}
+
+	if len(llmResp.Choices) == 0 {
+		return nil, newMalformedError(p.Name(), "empty response from LLM", nil)
+	}
+
+	choice := llmResp.Choices[0]
+	return &ChatResponse{
+		Message:      choice.Message,
+		FinishReason: choice.FinishReason,
+		Usage:        llmResp.Usage,
+	}, nil
+}
+
+// Stream 发送一轮流式请求
+func (p *OpenAIProvider) Stream(ctx context.Context, cfg ModelConfig, req *ChatRequest, onDelta DeltaFunc) (*ChatResponse, error) {
+	resp, err := p.post(ctx, cfg, p.buildBody(cfg, req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(p.Name(), resp, body)
+	}
+
+	return readStream(p.Name(), resp.Body, onDelta)
+}
+
+// buildBody 构建 chat completions 请求体
+func (p *OpenAIProvider) buildBody(cfg ModelConfig, req *ChatRequest, stream bool) map[string]interface{} {
+	body := map[string]interface{}{
+		"model":       cfg.Model,
+		"messages":    convertMessagesToAPI(req.Messages),
+		"temperature": req.Temperature,
+		"stream":      stream,
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
+	}
+	if req.ResponseFormat != nil {
+		body["response_format"] = openAIResponseFormat(cfg, req.ResponseFormat)
+	}
+	if stream {
+		// 要求在最后一个分片中返回 usage
+		body["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+	return body
+}
+
+// post 发送 HTTP 请求
+func (p *OpenAIProvider) post(ctx context.Context, cfg ModelConfig, requestBody map[string]interface{}) (*http.Response, error) {
+	if cfg.APIKey == "" {
+		return nil, &APIError{Kind: ErrAuth, Provider: p.Name(), Message: "API key not configured for model " + cfg.Name}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	// Create HTTP request with context
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.BaseURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+
+	// Send request (1 hour timeout)
+	client := &http.Client{Timeout: 3600 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, newNetworkError(p.Name(), "send request failed", err)
+	}
+	return resp, nil
+}
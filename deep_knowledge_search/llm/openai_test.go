@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestOpenAIComplete 非流式请求：请求体包含消息、工具和结构化输出，响应中的工具调用和用量被还原
+func TestOpenAIComplete(t *testing.T) {
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		io.WriteString(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_2","type":"function","function":{"name":"fetchURL","arguments":"{\"url\":\"https://go.dev\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":12,"completion_tokens":4,"total_tokens":16}}`)
+	})
+	req := testRequest()
+	req.ResponseFormat = &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+
+	resp, err := (&OpenAIProvider{}).Complete(context.Background(), srv.model(ProviderOpenAI), req)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if got := srv.header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if srv.body["model"] != "test-model" || srv.body["stream"] != false || len(srv.body["tools"].([]interface{})) != 1 {
+		t.Errorf("request body = %v", srv.body)
+	}
+	messages := srv.body["messages"].([]interface{})
+	if tool := messages[3].(map[string]interface{}); tool["role"] != "tool" || tool["tool_call_id"] != "call_1" {
+		t.Errorf("tool message = %v", tool)
+	}
+	if format := srv.body["response_format"].(map[string]interface{}); format["type"] != "json_schema" {
+		t.Errorf("response_format = %v", format)
+	}
+
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Name != "fetchURL" || resp.FinishReason != "tool_calls" {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Usage != (Usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+
+	// 没有 choices 的响应按 malformed 处理
+	empty := newStandIn(t, func(w http.ResponseWriter) { io.WriteString(w, `{"choices":[]}`) })
+	if _, err := (&OpenAIProvider{}).Complete(context.Background(), empty.model(ProviderOpenAI), testRequest()); ErrorKindOf(err) != ErrMalformed {
+		t.Errorf("empty choices: %v", err)
+	}
+}
+
+// TestOpenAIStream 流式请求：要求返回 usage，增量内容和分片工具调用被组装
+func TestOpenAIStream(t *testing.T) {
+	srv := newStandIn(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, strings.Join([]string{
+			`data: {"choices":[{"index":0,"delta":{"content":"好的"}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_3","type":"function","function":{"name":"webSearch","arguments":"{\"query\":"}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"channel\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			`data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+			`data: [DONE]`,
+		}, "\n\n")+"\n\n")
+	})
+
+	var deltas strings.Builder
+	resp, err := (&OpenAIProvider{}).Stream(context.Background(), srv.model(ProviderOpenAI), testRequest(), func(d string) { deltas.WriteString(d) })
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if srv.body["stream"] != true || srv.body["stream_options"] == nil {
+		t.Errorf("request body = %v", srv.body)
+	}
+	if deltas.String() != "好的" || resp.Message.Content != "好的" || resp.Usage.TotalTokens != 7 {
+		t.Errorf("response = %+v, deltas = %q", resp, deltas.String())
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Arguments != `{"query":"channel"}` {
+		t.Errorf("tool calls = %+v", resp.Message.ToolCalls)
+	}
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 内置 provider 名称
+const (
+	ProviderOpenAI    = "openai"    // OpenAI 兼容的 chat completions 接口（DeepSeek 等）
+	ProviderAnthropic = "anthropic" // Anthropic Messages API
+	ProviderOllama    = "ollama"    // Ollama 风格的本地 /api/chat 接口
+)
+
+// ChatRequest 一轮对话请求（与具体厂商无关）
+type ChatRequest struct {
+	Messages    []Message
+	Tools       []mcp.LLMTool
+	Temperature float64
+
+	ResponseFormat *ResponseFormat // 结构化输出要求（为空表示普通文本）
+}
+
+// ChatResponse 一轮对话响应
+type ChatResponse struct {
+	Message      Message
+	FinishReason string
+	Usage        Usage
+}
+
+// Provider LLM 服务提供方适配器，负责单轮请求的协议转换
+type Provider interface {
+	// Name 返回 provider 名称
+	Name() string
+	// Complete 发送一轮非流式请求
+	Complete(ctx context.Context, cfg ModelConfig, req *ChatRequest) (*ChatResponse, error)
+	// Stream 发送一轮流式请求，增量文本通过 onDelta 回调
+	Stream(ctx context.Context, cfg ModelConfig, req *ChatRequest, onDelta DeltaFunc) (*ChatResponse, error)
+}
+
+// Provider registry
+var (
+	providers = map[string]Provider{
+		ProviderOpenAI:    &OpenAIProvider{},
+		ProviderAnthropic: &AnthropicProvider{},
+		ProviderOllama:    &OllamaProvider{},
+	}
+	providersMu sync.RWMutex
+)
+
+// RegisterProvider 注册（或替换）一个 provider
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// GetProvider 按名称获取 provider，空名称视为 openai
+func GetProvider(name string) (Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = ProviderOpenAI
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider: %s", name)
+	}
+	return p, nil
+}
+
+// ProviderFor 获取模型配置对应的 provider
+func ProviderFor(cfg ModelConfig) (Provider, error) {
+	return GetProvider(cfg.Provider)
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// standIn 本地 httptest 替身：记录收到的请求体和请求头，按 handler 应答
+type standIn struct {
+	*httptest.Server
+	body   map[string]interface{}
+	header http.Header
+}
+
+// newStandIn 启动替身服务器，测试结束时关闭
+func newStandIn(t *testing.T, handler func(w http.ResponseWriter)) *standIn {
+	t.Helper()
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		s.body = nil
+		json.Unmarshal(data, &s.body)
+		s.header = r.Header.Clone()
+		handler(w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// model 指向替身服务器的模型配置
+func (s *standIn) model(provider string) ModelConfig {
+	return ModelConfig{Name: "test", Provider: provider, APIKey: "test-key", BaseURL: s.URL, Model: "test-model", MaxTokens: 256}
+}
+
+// testRequest 带一个工具、一轮工具调用历史的请求
+func testRequest() *ChatRequest {
+	return &ChatRequest{
+		Messages: []Message{
+			{Role: "system", Content: "你是助手"},
+			{Role: "user", Content: "查一下 goroutine"},
+			{Role: "assistant", ToolCalls: []mcp.ToolCall{{ID: "call_1", Type: "function", Function: mcp.Function{Name: "webSearch", Arguments: `{"query":"goroutine"}`}}}},
+			{Role: "tool", ToolCallId: "call_1", Content: "[1] goroutine 是轻量级线程"},
+		},
+		Tools: []mcp.LLMTool{{Type: "function", Function: mcp.LLMFunction{
+			Name:        "webSearch",
+			Description: "搜索",
+			Parameters:  map[string]interface{}{"type": "object"},
+		}}},
+		Temperature: 0.3,
+	}
+}
+
+// TestProviderErrorMapping 各适配器把 HTTP 错误映射为统一的错误类别
+func TestProviderErrorMapping(t *testing.T) {
+	cases := []struct {
+		status int
+		header map[string]string
+		body   string
+		kind   ErrorKind
+	}{
+		{http.StatusTooManyRequests, map[string]string{"Retry-After": "2"}, `{"error":"slow down"}`, ErrRateLimit},
+		{http.StatusUnauthorized, nil, `{"error":"bad key"}`, ErrAuth},
+		{http.StatusForbidden, nil, `{"error":"forbidden"}`, ErrAuth},
+		{http.StatusInternalServerError, nil, `{"error":"boom"}`, ErrServer},
+		{529, nil, `{"type":"error","error":{"type":"overloaded_error"}}`, ErrServer},
+		{http.StatusBadRequest, nil, `{"error":{"message":"This model's maximum context length is 8192 tokens"}}`, ErrContextLength},
+		{http.StatusBadRequest, nil, `{"error":{"message":"invalid message role"}}`, ErrInvalidRequest},
+	}
+
+	for _, provider := range []Provider{&OpenAIProvider{}, &AnthropicProvider{}, &OllamaProvider{}} {
+		for _, c := range cases {
+			srv := newStandIn(t, func(w http.ResponseWriter) {
+				for k, v := range c.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(c.status)
+				io.WriteString(w, c.body)
+			})
+			cfg := srv.model(provider.Name())
+			for _, stream := range []bool{false, true} {
+				var err error
+				if stream {
+					_, err = provider.Stream(context.Background(), cfg, testRequest(), func(string) {})
+				} else {
+					_, err = provider.Complete(context.Background(), cfg, testRequest())
+				}
+				if ErrorKindOf(err) != c.kind {
+					t.Errorf("%s %d (stream=%v): kind = %q, want %q (err %v)", provider.Name(), c.status, stream, ErrorKindOf(err), c.kind, err)
+				}
+				if c.header["Retry-After"] != "" {
+					var apiErr *APIError
+					if !errors.As(err, &apiErr) || apiErr.RetryAfter != 2*time.Second {
+						t.Errorf("%s: RetryAfter not parsed: %v", provider.Name(), err)
+					}
+				}
+			}
+		}
+	}
+
+	// 连接失败按网络错误处理
+	srv := newStandIn(t, func(w http.ResponseWriter) {})
+	cfg := srv.model(ProviderOpenAI)
+	srv.Close()
+	if _, err := (&OpenAIProvider{}).Complete(context.Background(), cfg, testRequest()); ErrorKindOf(err) != ErrNetwork {
+		t.Errorf("closed server: kind = %q, want network", ErrorKindOf(err))
+	}
+}
@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy 可重试错误（限流、服务端错误、网络错误）的重试策略
+type RetryPolicy struct {
+	MaxRetries    int           // 最大重试次数（不含首次请求）
+	BaseDelay     time.Duration // 首次重试的基础等待时间，之后指数增长
+	MaxDelay      time.Duration // 单次等待上限
+	MaxRetryAfter time.Duration // 服务端 Retry-After 的采纳上限
+}
+
+// DefaultRetryPolicy 默认重试策略
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:    4,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// retryPolicy 当前使用的重试策略
+var (
+	retryPolicy   = DefaultRetryPolicy
+	retryPolicyMu sync.RWMutex
+)
+
+// SetRetryPolicy 设置重试策略
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicyMu.Lock()
+	defer retryPolicyMu.Unlock()
+	retryPolicy = policy
+}
+
+// currentRetryPolicy 当前使用的重试策略
+func currentRetryPolicy() RetryPolicy {
+	retryPolicyMu.RLock()
+	defer retryPolicyMu.RUnlock()
+	return retryPolicy
+}
+
+// backoff 第 attempt 次重试（从 0 开始）的等待时间：优先采用 Retry-After，否则指数退避加随机抖动
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > p.MaxRetryAfter {
+			return p.MaxRetryAfter
+		}
+		return apiErr.RetryAfter
+	}
+
+	delay := p.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// 在 [delay/2, delay) 区间内随机，避免并发请求同时重试
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// withRetry 执行一轮请求，遇到可重试错误时按策略等待后重试
+// 流式请求已输出增量内容后不再重试，避免重复推送
+func withRetry(ctx context.Context, provider string, model string, do func(onDelta DeltaFunc) (*ChatResponse, error), onDelta DeltaFunc) (*ChatResponse, error) {
+	policy := currentRetryPolicy()
+
+	for attempt := 0; ; attempt++ {
+		streamed := false
+		wrapped := onDelta
+		if onDelta != nil {
+			wrapped = func(delta string) {
+				streamed = true
+				onDelta(delta)
+			}
+		}
+
+		resp, err := do(wrapped)
+		if err == nil {
+			return resp, nil
+		}
+
+		if ctx.Err() != nil || !IsRetryable(err) || streamed || attempt >= policy.MaxRetries {
+			return nil, err
+		}
+
+		delay := policy.backoff(attempt, err)
+		fmt.Printf("[LLM] %s/%s request failed (%s), retry %d/%d in %v: %v\n",
+			provider, model, ErrorKindOf(err), attempt+1, policy.MaxRetries, delay.Round(time.Millisecond), err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
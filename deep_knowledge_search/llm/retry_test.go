@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestBackoff Retry-After 优先（不超过上限），否则指数退避并在 [delay/2, delay) 内抖动，不超过 MaxDelay
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, MaxRetryAfter: 5 * time.Second}
+	serverErr := &APIError{Kind: ErrServer}
+
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 50; i++ {
+			if got := policy.backoff(attempt, serverErr); got < want/2 || got >= want {
+				t.Fatalf("attempt %d: backoff = %s, want [%s, %s)", attempt, got, want/2, want)
+			}
+		}
+	}
+	// 位移溢出时按上限等待
+	if got := policy.backoff(80, serverErr); got < policy.MaxDelay/2 || got >= policy.MaxDelay {
+		t.Errorf("large attempt: backoff = %s", got)
+	}
+
+	if got := policy.backoff(0, &APIError{Kind: ErrRateLimit, RetryAfter: 3 * time.Second}); got != 3*time.Second {
+		t.Errorf("retry after: backoff = %s, want 3s", got)
+	}
+	if got := policy.backoff(0, &APIError{Kind: ErrRateLimit, RetryAfter: time.Minute}); got != 5*time.Second {
+		t.Errorf("capped retry after: backoff = %s, want 5s", got)
+	}
+}
+
+// TestWithRetry 可重试错误按次数上限重试，不可重试错误和已推送增量内容的流式请求不重试
+func TestWithRetry(t *testing.T) {
+	SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetryAfter: time.Millisecond})
+	t.Cleanup(func() { SetRetryPolicy(DefaultRetryPolicy) })
+
+	failing := func(errs ...error) (func(DeltaFunc) (*ChatResponse, error), *int) {
+		calls := 0
+		return func(onDelta DeltaFunc) (*ChatResponse, error) {
+			calls++
+			if calls <= len(errs) && errs[calls-1] != nil {
+				return nil, errs[calls-1]
+			}
+			return &ChatResponse{Message: Message{Content: "ok"}}, nil
+		}, &calls
+	}
+
+	rateLimit := &APIError{Kind: ErrRateLimit, RetryAfter: time.Hour}
+	do, calls := failing(rateLimit, &APIError{Kind: ErrNetwork})
+	if resp, err := withRetry(context.Background(), "test", "m", do, nil); err != nil || resp.Message.Content != "ok" || *calls != 3 {
+		t.Errorf("retryable: resp = %+v, err = %v, calls = %d", resp, err, *calls)
+	}
+
+	do, calls = failing(rateLimit, rateLimit, rateLimit, nil)
+	if _, err := withRetry(context.Background(), "test", "m", do, nil); ErrorKindOf(err) != ErrRateLimit || *calls != 3 {
+		t.Errorf("exhausted: err = %v, calls = %d", err, *calls)
+	}
+
+	do, calls = failing(&APIError{Kind: ErrAuth})
+	if _, err := withRetry(context.Background(), "test", "m", do, nil); ErrorKindOf(err) != ErrAuth || *calls != 1 {
+		t.Errorf("fatal: err = %v, calls = %d", err, *calls)
+	}
+
+	// 已推送增量内容后中断的流式请求不重试
+	streamCalls := 0
+	var deltas []string
+	streamed := func(onDelta DeltaFunc) (*ChatResponse, error) {
+		streamCalls++
+		onDelta("partial")
+		return nil, &APIError{Kind: ErrNetwork}
+	}
+	if _, err := withRetry(context.Background(), "test", "m", streamed, func(d string) { deltas = append(deltas, d) }); err == nil || streamCalls != 1 || len(deltas) != 1 {
+		t.Errorf("streamed: err = %v, calls = %d, deltas = %v", err, streamCalls, deltas)
+	}
+
+	// 等待期间取消时返回原错误
+	SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, MaxRetryAfter: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	do, calls = failing(rateLimit, rateLimit)
+	if _, err := withRetry(ctx, "test", "m", do, nil); ErrorKindOf(err) != ErrRateLimit || *calls != 1 {
+		t.Errorf("canceled: err = %v, calls = %d", err, *calls)
+	}
+}
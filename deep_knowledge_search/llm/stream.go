@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DeltaFunc 流式内容回调，每收到一段增量文本调用一次
+type DeltaFunc func(delta string)
+
+// SendStreamLLMRequest 发送流式 LLM 请求（支持工具调用），增量内容通过 onDelta 回调
+func SendStreamLLMRequest(ctx context.Context, messages []Message, onDelta DeltaFunc) (string, error) {
+	modelConfig := GetCurrentModelConfig()
+	provider, err := ProviderFor(modelConfig)
+	if err != nil {
+		return "", err
+	}
+	result, err := Chat(ctx, provider, messages, ChatOptions{Model: modelConfig, OnDelta: onDelta})
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// readStream 解析 OpenAI 风格的 SSE 流，把分片组装为完整响应
+func readStream(provider string, r io.Reader, onDelta DeltaFunc) (*ChatResponse, error) {
+	var content strings.Builder
+	toolCalls := make(map[int]*mcp.ToolCall)
+	result := &ChatResponse{Message: Message{Role: "assistant"}}
+	received := false
+
+	err := scanSSE(r, func(event, data string) (bool, error) {
+		if data == "[DONE]" {
+			return false, nil
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, newMalformedError(provider, "parse stream chunk failed: "+data, err)
+		}
+		received = true
+
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				content.WriteString(c.Delta.Content)
+				if onDelta != nil {
+					onDelta(c.Delta.Content)
+				}
+			}
+			for _, d := range c.Delta.ToolCalls {
+				tc, ok := toolCalls[d.Index]
+				if !ok {
+					tc = &mcp.ToolCall{Type: "function"}
+					toolCalls[d.Index] = tc
+				}
+				if d.ID != "" {
+					tc.ID = d.ID
+				}
+				if d.Type != "" {
+					tc.Type = d.Type
+				}
+				tc.Function.Name += d.Function.Name
+				tc.Function.Arguments += d.Function.Arguments
+			}
+			if c.FinishReason != "" {
+				result.FinishReason = c.FinishReason
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !received {
+		return nil, newMalformedError(provider, "empty response from LLM", nil)
+	}
+
+	result.Message.Content = content.String()
+	result.Message.ToolCalls = sortedToolCalls(toolCalls)
+	return result, nil
+}
+
+// scanSSE 逐条读取 SSE 事件，handle 返回 false 时停止
+func scanSSE(r io.Reader, handle func(event, data string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	event := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			cont, err := handle(event, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			if err != nil {
+				return err
+			}
+			if !cont {
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return newNetworkError("", "read stream failed", err)
+	}
+	return nil
+}
+
+// sortedToolCalls 按 index 顺序还原分片拼接的工具调用
+func sortedToolCalls(toolCalls map[int]*mcp.ToolCall) []mcp.ToolCall {
+	indexes := make([]int, 0, len(toolCalls))
+	for idx := range toolCalls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	var result []mcp.ToolCall
+	for _, idx := range indexes {
+		result = append(result, *toolCalls[idx])
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadStream 组装分片的文本和工具调用：参数跨多个事件、两个工具调用交错到达
+func TestReadStream(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"先"}}]}`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"content":"查一下"}}]}`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"fetchURL","arguments":""}}]}}]}`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"webSearch","arguments":"{\"que"}}]}}]}`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"url\":\"https://go.dev\"}"}}]}}]}`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ry\":\"goroutine\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		``,
+		`data: {"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`,
+		``,
+		`data: [DONE]`,
+		``,
+		`data: {"choices":[{"index":0,"delta":{"content":"ignored"}}]}`,
+		``,
+	}, "\n")
+
+	var deltas []string
+	resp, err := readStream(ProviderOpenAI, strings.NewReader(stream), func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatalf("readStream: %v", err)
+	}
+	if resp.Message.Content != "先查一下" || strings.Join(deltas, "|") != "先|查一下" {
+		t.Errorf("content = %q, deltas = %v", resp.Message.Content, deltas)
+	}
+	if resp.FinishReason != "tool_calls" || resp.Usage.TotalTokens != 10 {
+		t.Errorf("finish = %q, usage = %+v", resp.FinishReason, resp.Usage)
+	}
+
+	calls := resp.Message.ToolCalls
+	if len(calls) != 2 {
+		t.Fatalf("tool calls = %+v", calls)
+	}
+	if calls[0].ID != "call_a" || calls[0].Function.Name != "webSearch" || calls[0].Function.Arguments != `{"query":"goroutine"}` {
+		t.Errorf("call 0 = %+v", calls[0])
+	}
+	if calls[1].ID != "call_b" || calls[1].Function.Name != "fetchURL" || calls[1].Function.Arguments != `{"url":"https://go.dev"}` {
+		t.Errorf("call 1 = %+v", calls[1])
+	}
+}
+
+// TestReadStreamErrors 空流和无法解析的分片按 malformed 处理
+func TestReadStreamErrors(t *testing.T) {
+	for name, stream := range map[string]string{
+		"empty":   ": keep-alive\n\n",
+		"garbage": "data: {not json\n\n",
+	} {
+		if _, err := readStream(ProviderOpenAI, strings.NewReader(stream), nil); ErrorKindOf(err) != ErrMalformed {
+			t.Errorf("%s: err = %v, want malformed", name, err)
+		}
+	}
+}
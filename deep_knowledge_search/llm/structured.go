@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ResponseFormat 结构化输出要求：让模型按 JSON Schema 返回
+type ResponseFormat struct {
+	Name   string                 // schema 名称（OpenAI 要求 ^[a-zA-Z0-9_-]+$）
+	Schema map[string]interface{} // JSON Schema
+}
+
+// 结构化输出模式（ModelConfig.StructuredOutput）
+const (
+	StructuredAuto   = ""            // 自动：使用 JSON Schema 模式，接口不支持时降级为普通请求
+	StructuredSchema = "json_schema" // 始终使用 JSON Schema 模式
+	StructuredObject = "json_object" // 只要求返回 JSON 对象（如 DeepSeek）
+	StructuredOff    = "off"         // 不使用结构化输出，仅依赖提示词
+)
+
+// structuredUnsupported 自动模式下已确认不支持结构化输出的模型
+var structuredUnsupported sync.Map
+
+// structuredMode 返回模型的结构化输出模式
+func (m ModelConfig) structuredMode() string {
+	mode := strings.ToLower(strings.TrimSpace(m.StructuredOutput))
+	switch mode {
+	case StructuredSchema, StructuredObject, StructuredOff:
+		return mode
+	}
+	return StructuredAuto
+}
+
+// responseFormatFor 按模型配置决定本次请求实际使用的结构化输出要求
+func responseFormatFor(cfg ModelConfig, format *ResponseFormat) *ResponseFormat {
+	if format == nil || cfg.structuredMode() == StructuredOff {
+		return nil
+	}
+	if cfg.structuredMode() == StructuredAuto {
+		if _, unsupported := structuredUnsupported.Load(cfg.Name); unsupported {
+			return nil
+		}
+	}
+	return format
+}
+
+// formatErrorMarkers 各家接口表示不支持结构化输出参数的错误关键字
+var formatErrorMarkers = []string{
+	"response_format",
+	"json_schema",
+	"json_object",
+	"structured output",
+	"invalid format",
+	"in format",
+}
+
+// shouldDowngradeFormat 自动模式下接口是否因结构化输出参数拒绝了请求
+// 只认错误消息中明确提到结构化输出参数的无效请求，上下文超长等其他错误不影响模型的结构化输出能力
+func shouldDowngradeFormat(cfg ModelConfig, req *ChatRequest, err error) bool {
+	if req.ResponseFormat == nil || cfg.structuredMode() != StructuredAuto {
+		return false
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrInvalidRequest {
+		return false
+	}
+	lower := strings.ToLower(apiErr.Message)
+	for _, marker := range formatErrorMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// openAIResponseFormat 转换为 chat completions 的 response_format 参数
+func openAIResponseFormat(cfg ModelConfig, format *ResponseFormat) map[string]interface{} {
+	if cfg.structuredMode() == StructuredObject {
+		return map[string]interface{}{"type": "json_object"}
+	}
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   format.Name,
+			"schema": format.Schema,
+		},
+	}
+}
+
+// ollamaFormat 转换为 Ollama 的 format 参数（JSON Schema 或 "json"）
+func ollamaFormat(cfg ModelConfig, format *ResponseFormat) interface{} {
+	if cfg.structuredMode() == StructuredObject {
+		return "json"
+	}
+	return format.Schema
+}
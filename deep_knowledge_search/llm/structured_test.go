@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// TestShouldDowngradeFormat 只有明确指向结构化输出参数的无效请求才降级
+func TestShouldDowngradeFormat(t *testing.T) {
+	format := &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+	auto := ModelConfig{Name: "auto"}
+	tests := []struct {
+		name string
+		cfg  ModelConfig
+		req  *ChatRequest
+		err  error
+		want bool
+	}{
+		{"response_format rejected", auto, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrInvalidRequest, Message: `{"error":{"message":"response_format type is unavailable"}}`}, true},
+		{"json_schema unsupported", auto, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrInvalidRequest, Message: "json_schema is not supported by this model"}, true},
+		{"ollama format", auto, &ChatRequest{ResponseFormat: format},
+			newMessageError(ProviderOllama, "invalid JSON schema in format"), true},
+		{"wrapped", auto, &ChatRequest{ResponseFormat: format},
+			errors.Join(errors.New("retry"), &APIError{Kind: ErrInvalidRequest, Message: "Unsupported response_format"}), true},
+		{"bad message", auto, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrInvalidRequest, Message: "invalid message role"}, false},
+		{"context length", auto, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrContextLength, Message: "maximum context length exceeded (response_format)"}, false},
+		{"server error", auto, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrServer, Message: "response_format handler crashed"}, false},
+		{"no format", auto, &ChatRequest{},
+			&APIError{Kind: ErrInvalidRequest, Message: "response_format type is unavailable"}, false},
+		{"explicit schema mode", ModelConfig{Name: "schema", StructuredOutput: StructuredSchema}, &ChatRequest{ResponseFormat: format},
+			&APIError{Kind: ErrInvalidRequest, Message: "response_format type is unavailable"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldDowngradeFormat(tt.cfg, tt.req, tt.err); got != tt.want {
+				t.Errorf("shouldDowngradeFormat = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestChatFormatDowngrade 结构化输出参数被拒绝时去掉参数重发并记住该模型；其他无效请求直接失败且不影响后续请求
+func TestChatFormatDowngrade(t *testing.T) {
+	format := &ResponseFormat{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+	var sentFormat []bool
+	rejection := ""
+	var srv *standIn
+	srv = newStandIn(t, func(w http.ResponseWriter) {
+		_, has := srv.body["response_format"]
+		sentFormat = append(sentFormat, has)
+		if has && rejection != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, rejection)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"{}"},"finish_reason":"stop"}]}`)
+	})
+	chat := func(cfg ModelConfig) error {
+		_, err := Chat(context.Background(), &OpenAIProvider{}, []Message{{Role: "user", Content: "规划"}}, ChatOptions{Model: cfg, ResponseFormat: format})
+		return err
+	}
+
+	// 上下文超长：直接失败，模型仍使用结构化输出
+	overflow := srv.model(ProviderOpenAI)
+	overflow.Name = "downgrade-overflow"
+	t.Cleanup(func() { structuredUnsupported.Delete(overflow.Name) })
+	rejection = `{"error":{"message":"This model's maximum context length is 8192 tokens"}}`
+	if err := chat(overflow); ErrorKindOf(err) != ErrContextLength {
+		t.Fatalf("overflow err = %v", err)
+	}
+	rejection = ""
+	if err := chat(overflow); err != nil {
+		t.Fatalf("after overflow: %v", err)
+	}
+	if len(sentFormat) != 2 || !sentFormat[0] || !sentFormat[1] {
+		t.Errorf("overflow requests sent format = %v, want [true true]", sentFormat)
+	}
+
+	// 拒绝 response_format：降级重发，之后的请求不再携带
+	rejected := srv.model(ProviderOpenAI)
+	rejected.Name = "downgrade-rejected"
+	t.Cleanup(func() { structuredUnsupported.Delete(rejected.Name) })
+	sentFormat = nil
+	rejection = `{"error":{"message":"response_format type is unavailable"}}`
+	for i := 0; i < 2; i++ {
+		if err := chat(rejected); err != nil {
+			t.Fatalf("rejected chat %d: %v", i, err)
+		}
+	}
+	if len(sentFormat) != 3 || !sentFormat[0] || sentFormat[1] || sentFormat[2] {
+		t.Errorf("rejected requests sent format = %v, want [true false false]", sentFormat)
+	}
+}
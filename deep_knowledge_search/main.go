@@ -4,16 +4,53 @@ import (
 	"deepknowledgesearch/agent"
 	"deepknowledgesearch/config"
 	"deepknowledgesearch/llm"
+	"deepknowledgesearch/mcp"
+	"deepknowledgesearch/mcpserver"
 	"deepknowledgesearch/web"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 )
 
+// 命令行预算参数（0 表示使用配置文件中的值）
+var (
+	flagMaxTokens = flag.Int("max-tokens", 0, "单个任务最大 token 用量")
+	flagMaxCost   = flag.Float64("max-cost", 0, "单个任务最大费用")
+	flagMaxTime   = flag.Duration("max-time", 0, "单个任务最长执行时间，如 30m")
+	flagMaxCalls  = flag.Int("max-calls", 0, "单个任务最大 LLM 调用次数")
+)
+
+// 命令行缓存参数（空表示使用配置文件中的值）
+var flagCache = flag.String("cache", "", "LLM 响应缓存模式: off / read-write / read-only")
+
+// 命令行代码执行参数（为 true 时新任务允许使用 runCode）
+var flagRunCode = flag.Bool("run-code", false, "允许任务使用 runCode 工具执行脚本")
+
 func main() {
+	flag.Parse()
+
+	// MCP server 模式: dks mcp-serve（stdout 只用于协议消息）
+	if flag.NArg() > 0 && flag.Arg(0) == "mcp-serve" {
+		runMCPServe()
+		return
+	}
+
+	// 回放模式: dks replay <task-folder>（离线执行，不启动 Web Dashboard、不连接外部 MCP server）
+	if flag.NArg() > 0 && flag.Arg(0) == "replay" {
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "用法: dks replay <task-folder>")
+			os.Exit(2)
+		}
+		runReplay(flag.Arg(1))
+		return
+	}
+
 	fmt.Println("╔══════════════════════════════════════════════════════════╗")
 	fmt.Println("║           知识深度搜索 - Deep Knowledge Search             ║")
 	fmt.Println("║                     v1.0.0                               ║")
@@ -32,6 +69,9 @@ func main() {
 		web.InitServer(cfg.WebPort)
 		if err := web.StartServer(); err != nil {
 			fmt.Fprintf(os.Stderr, "⚠️ Web服务启动失败: %v\n", err)
+		} else {
+			// ask 规则的工具调用通过 Dashboard 批准
+			agent.SetToolApprovalsEnabled(true)
 		}
 	}
 
@@ -41,6 +81,12 @@ func main() {
 		fmt.Println("\n💡 提示: 请在 config.json 中配置 api_key")
 		os.Exit(1)
 	}
+	// 退出时关闭外部 MCP server 进程和会话
+	defer mcp.CloseExternalServers()
+
+	applyBudgetFlags()
+	applyCacheFlag()
+	applyRunCodeFlag()
 
 	// 注册任务执行器回调，用于Web API任务管理
 	agent.OnExecutorCreated = func(taskID string, executor interface{}) {
@@ -103,9 +149,9 @@ func main() {
 	}
 
 	// Check for command line arguments
-	if len(os.Args) > 1 {
+	if flag.NArg() > 0 {
 		// Join all arguments as the task description
-		taskDescription := strings.Join(os.Args[1:], " ")
+		taskDescription := strings.Join(flag.Args(), " ")
 		if err := agent.RunTask(taskDescription); err != nil {
 			fmt.Fprintf(os.Stderr, "❌ 任务执行失败: %v\n", err)
 			os.Exit(1)
@@ -123,6 +169,9 @@ func main() {
 		readline.PcItem("/exit"),
 		readline.PcItem("/quit"),
 		readline.PcItem("/reload"),
+		readline.PcItem("/budget"),
+		readline.PcItem("/cache"),
+		readline.PcItem("/runcode", readline.PcItem("on"), readline.PcItem("off")),
 		readline.PcItem("/modules",
 			readline.PcItemDynamic(func(string) []string {
 				cfg := llm.GetConfig()
@@ -187,6 +236,10 @@ func main() {
 				fmt.Println("  /modules          - 列出所有可用模型")
 				fmt.Println("  /modules <name>   - 切换到指定模型")
 				fmt.Println("  /reload           - 重新加载配置文件")
+				fmt.Println("  /budget           - 查看任务预算")
+				fmt.Println("  /budget tokens=N cost=N time=30m calls=N - 设置任务预算（0 不限制）")
+				fmt.Println("  /cache [off|read-write|read-only] - 查看或设置 LLM 响应缓存")
+				fmt.Println("  /runcode [on|off] - 查看或设置新任务是否允许执行代码")
 				fmt.Println("  /help             - 显示帮助信息")
 				fmt.Println("  /exit, /quit      - 退出程序")
 				continue
@@ -195,14 +248,7 @@ func main() {
 				if len(parts) > 1 {
 					// Switch model
 					targetModel := parts[1]
-					if _, ok := cfg.Models[targetModel]; ok {
-						cfg.CurrentModel = targetModel
-						// Update legacy fields for compatibility
-						current := llm.GetCurrentModelConfig()
-						cfg.APIKey = current.APIKey
-						cfg.BaseURL = current.BaseURL
-						cfg.Model = current.Model
-						cfg.Temperature = current.Temperature
+					if current, ok := llm.SetCurrentModel(targetModel); ok {
 						fmt.Printf("✅ 已切换到模型: %s (%s)\n", targetModel, current.Model)
 					} else {
 						fmt.Printf("❌ 未知模型: %s\n", targetModel)
@@ -234,8 +280,51 @@ func main() {
 					fmt.Printf("❌ Agent重新初始化失败: %v\n", err)
 					continue
 				}
+				applyBudgetFlags()
+				applyCacheFlag()
+				applyRunCodeFlag()
 				fmt.Println("✅ 配置已重新加载")
 				continue
+			case "/budget":
+				if len(parts) > 1 {
+					budget, err := parseBudgetArgs(agent.GetDefaultBudget(), parts[1:])
+					if err != nil {
+						fmt.Printf("❌ %v\n", err)
+						continue
+					}
+					agent.SetDefaultBudget(budget)
+				}
+				fmt.Printf("💸 任务预算: %s\n", agent.GetDefaultBudget())
+				continue
+			case "/cache":
+				if len(parts) > 1 {
+					mode, err := llm.ParseCacheMode(parts[1])
+					if err != nil {
+						fmt.Printf("❌ %v\n", err)
+						continue
+					}
+					agent.SetDefaultCacheMode(mode)
+				}
+				fmt.Printf("🗄️ 响应缓存: %s\n", agent.GetDefaultCacheMode())
+				continue
+			case "/runcode":
+				if len(parts) > 1 {
+					switch parts[1] {
+					case "on":
+						agent.SetDefaultRunCode(true)
+					case "off":
+						agent.SetDefaultRunCode(false)
+					default:
+						fmt.Printf("❌ 未知参数: %s（应为 on / off）\n", parts[1])
+						continue
+					}
+				}
+				if agent.GetDefaultRunCode() {
+					fmt.Println("🧮 代码执行: 已允许")
+				} else {
+					fmt.Println("🧮 代码执行: 未允许")
+				}
+				continue
 			default:
 				fmt.Printf("❌ 未知命令: %s\n", cmd)
 				continue
@@ -250,3 +339,116 @@ func main() {
 		fmt.Println()
 	}
 }
+
+// runReplay 离线回放历史任务
+func runReplay(taskFolder string) {
+	// 只加载配置（输出目录）和内置工具定义，LLM 响应和工具结果全部来自执行日志
+	if err := config.LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 配置加载: %v\n", err)
+	}
+	mcp.Init()
+
+	node, stats, err := agent.ReplayTask(taskFolder)
+	fmt.Printf("⏪ 回放统计: 记录 %d 条，精确匹配 %d，按节点匹配 %d，未匹配 %d，工具调用 %d\n",
+		stats.Recorded, stats.Exact, stats.Fuzzy, stats.Misses, stats.ToolCalls)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 回放失败: %v\n", err)
+		os.Exit(1)
+	}
+	if node.Result != nil {
+		fmt.Println(node.Result.Summary)
+	}
+}
+
+// runMCPServe 以 MCP server 的形式通过 stdio 提供深度搜索（不启动 Web Dashboard）
+func runMCPServe() {
+	// 各模块的日志都打印到 stdout，改写到 stderr，避免混入协议消息
+	protocolOut := os.Stdout
+	os.Stdout = os.Stderr
+
+	if err := config.LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 配置加载: %v\n", err)
+		os.Exit(1)
+	}
+	if err := agent.InitWithConfig(config.GetConfig()); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 初始化失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer mcp.CloseExternalServers()
+	applyBudgetFlags()
+	applyCacheFlag()
+	applyRunCodeFlag()
+
+	fmt.Println("[Main] MCP server 已启动 (stdio)")
+	if err := mcpserver.NewServer(protocolOut).Serve(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ MCP server: %v\n", err)
+	}
+}
+
+// applyBudgetFlags 用命令行参数覆盖配置文件中的默认预算
+func applyBudgetFlags() {
+	budget := agent.GetDefaultBudget()
+	if *flagMaxTokens > 0 {
+		budget.MaxTokens = *flagMaxTokens
+	}
+	if *flagMaxCost > 0 {
+		budget.MaxCost = *flagMaxCost
+	}
+	if *flagMaxTime > 0 {
+		budget.MaxDurationSec = int(flagMaxTime.Seconds())
+	}
+	if *flagMaxCalls > 0 {
+		budget.MaxLLMCalls = *flagMaxCalls
+	}
+	agent.SetDefaultBudget(budget)
+}
+
+// applyCacheFlag 用命令行参数覆盖配置文件中的缓存模式
+func applyCacheFlag() {
+	if *flagCache == "" {
+		return
+	}
+	mode, err := llm.ParseCacheMode(*flagCache)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		return
+	}
+	agent.SetDefaultCacheMode(mode)
+}
+
+// applyRunCodeFlag 命令行指定 -run-code 时允许新任务执行代码
+func applyRunCodeFlag() {
+	if *flagRunCode {
+		agent.SetDefaultRunCode(true)
+	}
+}
+
+// parseBudgetArgs 解析 /budget 命令参数（key=value）
+func parseBudgetArgs(budget agent.BudgetConfig, args []string) (agent.BudgetConfig, error) {
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return budget, fmt.Errorf("参数格式错误: %s（应为 key=value）", arg)
+		}
+
+		var err error
+		switch key {
+		case "tokens":
+			budget.MaxTokens, err = strconv.Atoi(value)
+		case "cost":
+			budget.MaxCost, err = strconv.ParseFloat(value, 64)
+		case "time":
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			budget.MaxDurationSec = int(d.Seconds())
+		case "calls":
+			budget.MaxLLMCalls, err = strconv.Atoi(value)
+		default:
+			return budget, fmt.Errorf("未知预算参数: %s", key)
+		}
+		if err != nil {
+			return budget, fmt.Errorf("参数 %s 无效: %v", key, err)
+		}
+	}
+	return budget, nil
+}
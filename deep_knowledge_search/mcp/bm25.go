@@ -0,0 +1,150 @@
+package mcp
+
+import (
+	"math"
+	"sort"
+	"unicode"
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25Index 基于 BM25 打分的内存倒排索引
+type BM25Index struct {
+	postings map[string][]bm25Posting // 词 -> 包含该词的文档
+	docLens  []int                    // 每个文档的词数
+	totalLen int
+}
+
+// bm25Posting 倒排表中的一项
+type bm25Posting struct {
+	doc int
+	tf  int
+}
+
+// BM25Hit 一条检索结果
+type BM25Hit struct {
+	Doc   int     // 文档编号（Add 的返回值）
+	Score float64 // BM25 得分
+}
+
+// NewBM25Index 创建空索引
+func NewBM25Index() *BM25Index {
+	return &BM25Index{postings: make(map[string][]bm25Posting)}
+}
+
+// Add 添加文档，返回文档编号（从 0 开始递增）
+func (idx *BM25Index) Add(text string) int {
+	doc := len(idx.docLens)
+	tokens := tokenize(text)
+	tf := make(map[string]int)
+	for _, t := range tokens {
+		tf[t]++
+	}
+	for term, n := range tf {
+		idx.postings[term] = append(idx.postings[term], bm25Posting{doc: doc, tf: n})
+	}
+	idx.docLens = append(idx.docLens, len(tokens))
+	idx.totalLen += len(tokens)
+	return doc
+}
+
+// Len 文档数
+func (idx *BM25Index) Len() int {
+	return len(idx.docLens)
+}
+
+// Search 返回得分最高的 limit 个文档（得分相同时编号小的在前）
+func (idx *BM25Index) Search(query string, limit int) []BM25Hit {
+	n := len(idx.docLens)
+	if n == 0 || limit <= 0 {
+		return nil
+	}
+	avgLen := float64(idx.totalLen) / float64(n)
+	if avgLen == 0 {
+		return nil
+	}
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (float64(n)-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.tf)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(idx.docLens[p.doc])/avgLen)
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+
+	hits := make([]BM25Hit, 0, len(scores))
+	for doc, score := range scores {
+		hits = append(hits, BM25Hit{Doc: doc, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc < hits[j].Doc
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// tokenize 分词：字母数字按单词切分并转小写；中日韩文字没有空格分隔，输出单字和相邻两字组合
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i, r := range cjk {
+			tokens = append(tokens, string(r))
+			if i+1 < len(cjk) {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// isCJK 是否为中日韩文字
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
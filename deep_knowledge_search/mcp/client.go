@@ -0,0 +1,470 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stdioCloseGrace 关闭 stdin 后等待 server 进程退出的时间
+const stdioCloseGrace = 2 * time.Second
+
+// Client 外部 MCP server 的客户端（JSON-RPC over stdio 或 streamable HTTP）
+type Client struct {
+	name   string
+	t      transport
+	nextID int64
+
+	// ServerName / ServerVersion initialize 返回的 server 信息
+	ServerName    string
+	ServerVersion string
+}
+
+// transport 与 server 交换 JSON-RPC 消息
+type transport interface {
+	// call 发送请求并等待 ID 相同的响应
+	call(ctx context.Context, msg *RPCMessage) (*RPCMessage, error)
+	// notify 发送通知（没有响应）
+	notify(ctx context.Context, msg *RPCMessage) error
+	close() error
+}
+
+// Connect 启动或连接 server 并完成 initialize 握手
+func Connect(ctx context.Context, cfg ServerConfig) (*Client, error) {
+	var t transport
+	var err error
+	switch {
+	case cfg.Command != "":
+		t, err = newStdioTransport(cfg)
+	case cfg.URL != "":
+		t = newHTTPTransport(cfg)
+	default:
+		return nil, fmt.Errorf("mcp server %s requires command or url", cfg.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{name: cfg.Name, t: t}
+	if err := c.initialize(ctx); err != nil {
+		t.close()
+		return nil, fmt.Errorf("initialize %s: %w", cfg.Name, err)
+	}
+	return c, nil
+}
+
+// call 发送请求，result 非 nil 时解析结果
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	id := atomic.AddInt64(&c.nextID, 1)
+	msg := &RPCMessage{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprint(id)), Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+
+	resp, err := c.t.call(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("parse %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// initialize 协议握手
+func (c *Client) initialize(ctx context.Context) error {
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	err := c.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": "deepknowledgesearch", "version": "1.0.0"},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	c.ServerName = result.ServerInfo.Name
+	c.ServerVersion = result.ServerInfo.Version
+	return c.t.notify(ctx, &RPCMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// ListTools 列出 server 提供的全部工具（自动翻页）
+func (c *Client) ListTools(ctx context.Context) ([]ToolInfo, error) {
+	var tools []ToolInfo
+	cursor := ""
+	for {
+		var params interface{}
+		if cursor != "" {
+			params = map[string]interface{}{"cursor": cursor}
+		}
+		var result struct {
+			Tools      []ToolInfo `json:"tools"`
+			NextCursor string     `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool 调用工具
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]interface{}) (*ToolCallResult, error) {
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	var result ToolCallResult
+	if err := c.call(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": arguments}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Close 关闭连接（stdio server 的进程随之退出）
+func (c *Client) Close() error {
+	return c.t.close()
+}
+
+// ============================================================================
+// stdio 传输：每行一条 JSON 消息
+// ============================================================================
+
+// stdioTransport 通过子进程的 stdin/stdout 通信
+type stdioTransport struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	pending map[string]chan *RPCMessage
+	mu      sync.Mutex
+	done    chan struct{} // 进程输出结束后关闭
+	exitErr error
+
+	stderrDone chan struct{} // stderr 读取结束后关闭（cmd.Wait 必须等它结束后调用）
+}
+
+// newStdioTransport 启动 server 进程
+func newStdioTransport(cfg ServerConfig) (*stdioTransport, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start mcp server %s: %w", cfg.Name, err)
+	}
+
+	t := &stdioTransport{
+		name:    cfg.Name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[string]chan *RPCMessage),
+		done:    make(chan struct{}),
+
+		stderrDone: make(chan struct{}),
+	}
+	go t.readLoop(stdout)
+	go func() {
+		defer close(t.stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			fmt.Printf("[MCP:%s] %s\n", cfg.Name, scanner.Text())
+		}
+	}()
+	return t, nil
+}
+
+// readLoop 读取 server 输出，把响应分发给等待的请求
+func (t *stdioTransport) readLoop(stdout io.Reader) {
+	reader := bufio.NewReaderSize(stdout, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var msg RPCMessage
+			if jsonErr := json.Unmarshal(line, &msg); jsonErr != nil {
+				fmt.Printf("[MCP:%s] ⚠️ 无法解析的输出: %s\n", t.name, truncateText(string(line), 200))
+			} else {
+				t.dispatch(&msg)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	t.mu.Lock()
+	t.exitErr = fmt.Errorf("mcp server %s exited", t.name)
+	t.mu.Unlock()
+	close(t.done)
+}
+
+// dispatch 处理一条来自 server 的消息
+func (t *stdioTransport) dispatch(msg *RPCMessage) {
+	switch {
+	case msg.IsRequest():
+		// server 发起的请求：只支持 ping
+		if msg.Method == "ping" {
+			t.write(NewRPCResult(msg.ID, map[string]interface{}{}))
+		} else {
+			t.write(NewRPCError(msg.ID, RPCMethodNotFound, "method not supported by client: "+msg.Method))
+		}
+	case msg.IsNotification():
+		// 日志、进度等通知不需要处理
+	default:
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+// write 写入一条消息
+func (t *stdioTransport) write(msg *RPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdioTransport) call(ctx context.Context, msg *RPCMessage) (*RPCMessage, error) {
+	key := string(msg.ID)
+	ch := make(chan *RPCMessage, 1)
+	t.mu.Lock()
+	if t.exitErr != nil {
+		t.mu.Unlock()
+		return nil, t.exitErr
+	}
+	t.pending[key] = ch
+	t.mu.Unlock()
+
+	cleanup := func() {
+		t.mu.Lock()
+		delete(t.pending, key)
+		t.mu.Unlock()
+	}
+
+	if err := t.write(msg); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("write to mcp server %s: %w", t.name, err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		cleanup()
+		return nil, t.exitErr
+	case <-ctx.Done():
+		cleanup()
+		// 通知 server 放弃该请求
+		t.write(&RPCMessage{JSONRPC: "2.0", Method: "notifications/cancelled", Params: json.RawMessage(fmt.Sprintf(`{"requestId":%s,"reason":"timeout"}`, msg.ID))})
+		return nil, fmt.Errorf("mcp server %s: %s: %w", t.name, msg.Method, ctx.Err())
+	}
+}
+
+func (t *stdioTransport) notify(ctx context.Context, msg *RPCMessage) error {
+	return t.write(msg)
+}
+
+func (t *stdioTransport) close() error {
+	t.stdin.Close()
+	// os/exec 要求读完 stdout/stderr 后才能调用 Wait，否则 Wait 关闭管道会截断仍在进行的读取
+	drained := make(chan struct{})
+	go func() {
+		<-t.done
+		<-t.stderrDone
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(stdioCloseGrace):
+		// 关闭 stdin 后 server 应自行退出；未退出时结束进程
+		t.cmd.Process.Kill()
+		select {
+		case <-drained:
+		case <-time.After(stdioCloseGrace):
+			// server 派生的子进程仍持有管道：不再等待，由 Wait 关闭管道
+		}
+	}
+	return t.cmd.Wait()
+}
+
+// ============================================================================
+// streamable HTTP 传输：每条消息一次 POST，响应为 JSON 或 SSE 流
+// ============================================================================
+
+// httpTransport 通过 HTTP 与 server 通信
+type httpTransport struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	sessionID string
+	mu        sync.Mutex
+}
+
+// newHTTPTransport 创建 HTTP 传输（超时由每次调用的 ctx 控制）
+func newHTTPTransport(cfg ServerConfig) *httpTransport {
+	return &httpTransport{name: cfg.Name, url: cfg.URL, headers: cfg.Headers, client: &http.Client{}}
+}
+
+// post 发送一条消息
+func (t *httpTransport) post(ctx context.Context, msg *RPCMessage) (*http.Response, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", t.name, err)
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("mcp server %s: status %d: %s", t.name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) call(ctx context.Context, msg *RPCMessage) (*RPCMessage, error) {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return t.readEventStream(resp.Body, msg.ID)
+	}
+	var reply RPCMessage
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("mcp server %s: parse response: %w", t.name, err)
+	}
+	return &reply, nil
+}
+
+// readEventStream 从 SSE 流中读取 ID 匹配的响应（流中的通知忽略）
+func (t *httpTransport) readEventStream(body io.Reader, id json.RawMessage) (*RPCMessage, error) {
+	reader := bufio.NewReaderSize(body, 64*1024)
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// 空行或流结束表示一个事件结束
+		if (line == "" || err != nil) && data.Len() > 0 {
+			var msg RPCMessage
+			if json.Unmarshal([]byte(data.String()), &msg) == nil && msg.Method == "" && string(msg.ID) == string(id) {
+				return &msg, nil
+			}
+			data.Reset()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mcp server %s: event stream ended without response", t.name)
+		}
+	}
+}
+
+func (t *httpTransport) notify(ctx context.Context, msg *RPCMessage) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+	// 通知 server 结束会话（失败无影响）
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	if resp, err := t.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServerReply 测试用 MCP server 对一条请求的响应（通知返回 nil）
+// 工具: echo 返回 text 参数，fail 返回 isError，sleep 等待 1 秒
+func fakeServerReply(msg *RPCMessage) *RPCMessage {
+	if !msg.IsRequest() {
+		return nil
+	}
+	switch msg.Method {
+	case "initialize":
+		return NewRPCResult(msg.ID, map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]interface{}{"name": "fake", "version": "0.1"},
+		})
+	case "tools/list":
+		// 分两页返回，检验翻页
+		var params struct {
+			Cursor string `json:"cursor"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		if params.Cursor == "" {
+			return NewRPCResult(msg.ID, map[string]interface{}{
+				"tools":      []ToolInfo{{Name: "echo", Description: "Echo text", InputSchema: map[string]interface{}{"type": "object"}}},
+				"nextCursor": "page2",
+			})
+		}
+		return NewRPCResult(msg.ID, map[string]interface{}{
+			"tools": []ToolInfo{{Name: "fail"}, {Name: "sleep"}},
+		})
+	case "tools/call":
+		var params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return NewRPCError(msg.ID, RPCInvalidParams, err.Error())
+		}
+		switch params.Name {
+		case "echo":
+			return NewRPCResult(msg.ID, ToolCallResult{Content: []ToolContent{{Type: "text", Text: fmt.Sprint(params.Arguments["text"])}}})
+		case "fail":
+			return NewRPCResult(msg.ID, ToolCallResult{Content: []ToolContent{{Type: "text", Text: "boom"}}, IsError: true})
+		case "sleep":
+			time.Sleep(time.Second)
+			return NewRPCResult(msg.ID, ToolCallResult{Content: []ToolContent{{Type: "text", Text: "slept"}}})
+		}
+		return NewRPCError(msg.ID, RPCInvalidParams, "unknown tool "+params.Name)
+	}
+	return NewRPCError(msg.ID, RPCMethodNotFound, msg.Method)
+}
+
+// TestHelperMCPServer 不是真正的测试：由 stdio 测试作为子进程启动，充当 MCP server
+func TestHelperMCPServer(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	var mu sync.Mutex
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg RPCMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		go func() {
+			if reply := fakeServerReply(&msg); reply != nil {
+				data, _ := json.Marshal(reply)
+				mu.Lock()
+				os.Stdout.Write(append(data, '\n'))
+				mu.Unlock()
+			}
+		}()
+	}
+	os.Exit(0)
+}
+
+func TestStdioServerTools(t *testing.T) {
+	SetExternalServers([]ServerConfig{{
+		Name:    "fake",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestHelperMCPServer$"},
+		Env:     map[string]string{"GO_WANT_HELPER_PROCESS": "1"},
+		Timeout: 200 * time.Millisecond,
+	}})
+	defer CloseExternalServers()
+
+	names := map[string]string{}
+	for _, tool := range GetAvailableLLMTools() {
+		names[tool.Function.Name] = tool.Function.Description
+	}
+	if names["fake__echo"] != "[fake] Echo text" {
+		t.Fatalf("fake__echo not registered: %v", names)
+	}
+	if _, ok := names["fake__sleep"]; !ok {
+		t.Fatalf("second page of tools/list not registered: %v", names)
+	}
+
+	ctx := context.Background()
+	if resp := CallMCPTool(ctx, "fake__echo", map[string]interface{}{"text": "hi"}); !resp.Success || resp.Result != "hi" {
+		t.Errorf("echo = %+v", resp)
+	}
+	if resp := CallMCPTool(ctx, "fake__fail", nil); resp.Success || resp.Error != "boom" {
+		t.Errorf("fail = %+v", resp)
+	}
+	if resp := CallMCPTool(ctx, "fake__sleep", nil); resp.Success || !strings.Contains(resp.Error, "deadline exceeded") {
+		t.Errorf("sleep should time out, got %+v", resp)
+	}
+
+	CloseExternalServers()
+	if resp := CallMCPTool(ctx, "fake__echo", nil); resp.Success {
+		t.Error("tools should be unregistered after close")
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	var mu sync.Mutex
+	var sessions []string
+	deleted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			deleted = r.Header.Get("Mcp-Session-Id") == "s1"
+			mu.Unlock()
+			return
+		}
+		var msg RPCMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sessions = append(sessions, r.Header.Get("Mcp-Session-Id"))
+		mu.Unlock()
+
+		reply := fakeServerReply(&msg)
+		switch {
+		case reply == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case msg.Method == "tools/call":
+			// 工具调用以 SSE 返回，响应前先推送一条通知
+			data, _ := json.Marshal(reply)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		default:
+			if msg.Method == "initialize" {
+				w.Header().Set("Mcp-Session-Id", "s1")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reply)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Connect(ctx, ServerConfig{Name: "web", URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if client.ServerName != "fake" {
+		t.Errorf("ServerName = %q", client.ServerName)
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil || len(tools) != 3 {
+		t.Fatalf("ListTools = %v, %v", tools, err)
+	}
+	result, err := client.CallTool(ctx, "echo", map[string]interface{}{"text": "over sse"})
+	if err != nil || result.Text() != "over sse" {
+		t.Fatalf("CallTool = %+v, %v", result, err)
+	}
+	client.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// initialize 之后的请求都应带上 session id
+	if sessions[0] != "" {
+		t.Errorf("initialize sent session %q", sessions[0])
+	}
+	for i, s := range sessions[1:] {
+		if s != "s1" {
+			t.Errorf("request %d session = %q", i+1, s)
+		}
+	}
+	if !deleted {
+		t.Error("Close should end the session with DELETE")
+	}
+}
+
+func TestNamespacedToolName(t *testing.T) {
+	if got := NamespacedToolName("git hub", "search.repos"); got != "git_hub__search_repos" {
+		t.Errorf("NamespacedToolName = %q", got)
+	}
+	if got := NamespacedToolName(strings.Repeat("s", 40), strings.Repeat("t", 40)); len(got) != maxToolNameLen {
+		t.Errorf("long name length = %d", len(got))
+	}
+}
+
+// TestSetExternalServersConcurrent 各 server 并发连接：两个 server 的握手互相等待，顺序连接会超时失败；无法启动的 server 被跳过
+func TestSetExternalServersConcurrent(t *testing.T) {
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	allArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrived)
+	}()
+
+	handler := func() http.HandlerFunc {
+		var once sync.Once
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				return
+			}
+			var msg RPCMessage
+			if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if msg.Method == "initialize" {
+				once.Do(arrived.Done)
+				select {
+				case <-allArrived:
+				case <-time.After(5 * time.Second):
+					http.Error(w, "servers were not connected concurrently", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			reply := fakeServerReply(&msg)
+			if reply == nil {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reply)
+		}
+	}
+	a := httptest.NewServer(handler())
+	defer a.Close()
+	b := httptest.NewServer(handler())
+	defer b.Close()
+
+	SetExternalServers([]ServerConfig{
+		{Name: "a", URL: a.URL},
+		{Name: "broken", Command: "dks-no-such-mcp-server"},
+		{Name: "b", URL: b.URL},
+		{Name: "a", URL: b.URL},
+	})
+	defer CloseExternalServers()
+
+	names := map[string]bool{}
+	for _, tool := range GetAvailableLLMTools() {
+		names[tool.Function.Name] = true
+	}
+	if !names["a__echo"] || !names["b__echo"] {
+		t.Errorf("tools of both servers should be registered: %v", names)
+	}
+	if names["broken__echo"] {
+		t.Error("broken server registered tools")
+	}
+}
@@ -0,0 +1,518 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextKeyTaskDir 任务根目录的 Context Key（fetchURL 在其下缓存抓取的页面）
+const ContextKeyTaskDir contextKey = "task_dir"
+
+// SourcesSubDir 任务目录下缓存抓取页面的子目录
+const SourcesSubDir = "sources"
+
+// 抓取参数默认值
+const (
+	DefaultFetchMaxBytes     = 2 << 20
+	DefaultFetchTimeout      = 20 * time.Second
+	DefaultFetchExcerptChars = 4000
+	DefaultFetchUserAgent    = "DeepKnowledgeSearch/1.0 (+fetchURL)"
+	robotsUserAgentToken     = "deepknowledgesearch"
+)
+
+// FetchConfig fetchURL 工具配置
+type FetchConfig struct {
+	Allow        []string      // 允许的域名（为空表示不限制），包含子域名
+	Deny         []string      // 禁止的域名，优先于 Allow
+	MaxBytes     int64         // 单个页面最大下载字节数
+	Timeout      time.Duration // 单次请求超时
+	ExcerptChars int           // 返回给 LLM 的摘录长度（字符）
+	UserAgent    string        // 请求使用的 User-Agent
+	IgnoreRobots bool          // 不检查 robots.txt
+}
+
+// withDefaults 填充默认值
+func (c FetchConfig) withDefaults() FetchConfig {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultFetchMaxBytes
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultFetchTimeout
+	}
+	if c.ExcerptChars <= 0 {
+		c.ExcerptChars = DefaultFetchExcerptChars
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = DefaultFetchUserAgent
+	}
+	return c
+}
+
+// 当前抓取配置
+var (
+	fetchConfig = FetchConfig{}.withDefaults()
+	fetchMu     sync.RWMutex
+)
+
+// SetFetchConfig 设置 fetchURL 工具配置
+func SetFetchConfig(cfg FetchConfig) {
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	fetchConfig = cfg.withDefaults()
+	robotsCache.Range(func(key, _ interface{}) bool {
+		robotsCache.Delete(key)
+		return true
+	})
+}
+
+// getFetchConfig 获取当前抓取配置
+func getFetchConfig() FetchConfig {
+	fetchMu.RLock()
+	defer fetchMu.RUnlock()
+	return fetchConfig
+}
+
+// fetchURLTool fetchURL 工具定义
+func fetchURLTool() LLMTool {
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "fetchURL",
+			Description: "下载网页并转换为 markdown 正文，返回内容摘录和完整内容的缓存路径。用于阅读搜索结果中的来源页面。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "要读取的网页地址（http/https）",
+					},
+				},
+				"required": []string{"url"},
+			},
+		},
+	}
+}
+
+// FetchedPage 抓取并转换后的页面
+type FetchedPage struct {
+	URL       string // 最终地址（跟随重定向后）
+	Title     string
+	Content   string // markdown 正文
+	Truncated bool   // 超过大小限制被截断
+	CachePath string // 缓存文件路径（没有任务目录时为空）
+	Cached    bool   // 来自缓存
+}
+
+// fetchURLHandler handles the fetchURL tool call
+func fetchURLHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	rawURL, _ := arguments["url"].(string)
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return MCPToolResponse{
+			Success: false,
+			Error:   "missing or invalid 'url' parameter",
+		}
+	}
+
+	taskDir, _ := ctx.Value(ContextKeyTaskDir).(string)
+	page, err := FetchPage(ctx, rawURL, taskDir)
+	if err != nil {
+		return MCPToolResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
+	id := RecordSource(ctx, Source{Title: page.Title, URL: page.URL, Path: page.CachePath, Tool: "fetchURL"})
+	result := formatFetchedPage(page, getFetchConfig().ExcerptChars)
+	if id > 0 {
+		result = fmt.Sprintf("来源编号: [%d]\n%s", id, result)
+	}
+
+	return MCPToolResponse{
+		Success: true,
+		Result:  result,
+	}
+}
+
+// formatFetchedPage 把页面格式化为摘录 + 缓存路径
+func formatFetchedPage(page *FetchedPage, excerptChars int) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("URL: %s\n", page.URL))
+	if page.Title != "" {
+		sb.WriteString(fmt.Sprintf("标题: %s\n", page.Title))
+	}
+	if page.CachePath != "" {
+		sb.WriteString(fmt.Sprintf("完整内容: %s\n", page.CachePath))
+	}
+
+	runes := []rune(page.Content)
+	excerpt := page.Content
+	if len(runes) > excerptChars {
+		excerpt = string(runes[:excerptChars])
+		sb.WriteString(fmt.Sprintf("摘录: 前 %d / %d 字符\n", excerptChars, len(runes)))
+	}
+	if page.Truncated {
+		sb.WriteString("注意: 页面超过大小限制，只下载了部分内容\n")
+	}
+	sb.WriteString("\n")
+	sb.WriteString(excerpt)
+	return sb.String()
+}
+
+// FetchPage 下载页面并转换为 markdown；taskDir 非空时按 URL 缓存到 taskDir/sources 下，已缓存的页面不再下载
+func FetchPage(ctx context.Context, rawURL, taskDir string) (*FetchedPage, error) {
+	cfg := getFetchConfig()
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url: %s", rawURL)
+	}
+	if err := checkHostAllowed(cfg, u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	cachePath := ""
+	if taskDir != "" {
+		cachePath = fetchCachePath(taskDir, u.String())
+		if page, ok := loadFetchedPage(cachePath); ok {
+			fmt.Printf("[MCP] fetchURL cache hit: %s\n", rawURL)
+			return page, nil
+		}
+	}
+
+	if !cfg.IgnoreRobots {
+		allowed, err := robotsAllowed(ctx, cfg, u)
+		if err != nil {
+			fmt.Printf("[MCP] robots.txt check failed for %s: %v\n", u.Host, err)
+		}
+		if !allowed {
+			return nil, fmt.Errorf("robots.txt disallows fetching %s", rawURL)
+		}
+	}
+
+	page, err := downloadPage(ctx, cfg, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if err := storeFetchedPage(cachePath, page); err != nil {
+			fmt.Printf("[MCP] fetchURL cache store failed: %v\n", err)
+		} else {
+			page.CachePath = cachePath
+		}
+	}
+	fmt.Printf("[MCP] Fetched %s (%d chars)\n", page.URL, len([]rune(page.Content)))
+	return page, nil
+}
+
+// checkHostAllowed 按允许/禁止列表检查域名
+func checkHostAllowed(cfg FetchConfig, host string) error {
+	for _, d := range cfg.Deny {
+		if hostMatches(host, d) {
+			return fmt.Errorf("host %s is denied by fetch config", host)
+		}
+	}
+	if len(cfg.Allow) == 0 {
+		return nil
+	}
+	for _, a := range cfg.Allow {
+		if hostMatches(host, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not in the fetch allowlist", host)
+}
+
+// hostMatches 域名等于 pattern 或是其子域名（pattern 可带 "*." 前缀）
+func hostMatches(host, pattern string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pattern), "*."))
+	return pattern != "" && (host == pattern || strings.HasSuffix(host, "."+pattern))
+}
+
+// downloadPage 下载页面（限制大小和时间）并转换为 markdown
+func downloadPage(ctx context.Context, cfg FetchConfig, u *url.URL) (*FetchedPage, error) {
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			if err := checkHostAllowed(cfg, req.URL.Hostname()); err != nil {
+				return err
+			}
+			// 允许抓取的地址可能重定向到 robots.txt 禁止的路径
+			if !cfg.IgnoreRobots {
+				allowed, err := robotsAllowed(req.Context(), cfg, req.URL)
+				if err != nil {
+					fmt.Printf("[MCP] robots.txt check failed for %s: %v\n", req.URL.Host, err)
+				}
+				if !allowed {
+					return fmt.Errorf("robots.txt disallows fetching %s", req.URL)
+				}
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch failed: status %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && !isTextMediaType(mediaType) {
+		return nil, fmt.Errorf("unsupported content type: %s", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %w", err)
+	}
+	page := &FetchedPage{URL: resp.Request.URL.String()}
+	if int64(len(body)) > cfg.MaxBytes {
+		body = body[:cfg.MaxBytes]
+		page.Truncated = true
+	}
+
+	text := string(body)
+	if mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		page.Title = HTMLTitle(text)
+		page.Content = HTMLToMarkdown(text, resp.Request.URL)
+	} else {
+		page.Content = strings.TrimSpace(text)
+	}
+	return page, nil
+}
+
+// isTextMediaType 是否为可转换为文本的内容类型
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/xhtml+xml" ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml"
+}
+
+// ============================================================================
+// 页面缓存
+// ============================================================================
+
+// fetchCachePath 页面缓存路径：taskDir/sources/<url 哈希>.md
+func fetchCachePath(taskDir, rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(taskDir, SourcesSubDir, hex.EncodeToString(sum[:8])+".md")
+}
+
+// 缓存文件头部字段
+const (
+	fetchHeaderURL       = "<!-- url: "
+	fetchHeaderTitle     = "<!-- title: "
+	fetchHeaderTruncated = "<!-- truncated -->"
+)
+
+// storeFetchedPage 写入页面缓存（头部注释记录地址和标题）
+func storeFetchedPage(path string, page *FetchedPage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString(fetchHeaderURL + page.URL + " -->\n")
+	sb.WriteString(fetchHeaderTitle + strings.ReplaceAll(page.Title, "-->", "--") + " -->\n")
+	if page.Truncated {
+		sb.WriteString(fetchHeaderTruncated + "\n")
+	}
+	sb.WriteString("\n")
+	sb.WriteString(page.Content)
+	sb.WriteString("\n")
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// loadFetchedPage 读取页面缓存
+func loadFetchedPage(path string) (*FetchedPage, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	page := &FetchedPage{CachePath: path, Cached: true}
+	header, content, _ := strings.Cut(string(data), "\n\n")
+	for _, line := range strings.Split(header, "\n") {
+		switch {
+		case strings.HasPrefix(line, fetchHeaderURL):
+			page.URL = strings.TrimSuffix(strings.TrimPrefix(line, fetchHeaderURL), " -->")
+		case strings.HasPrefix(line, fetchHeaderTitle):
+			page.Title = strings.TrimSuffix(strings.TrimPrefix(line, fetchHeaderTitle), " -->")
+		case line == fetchHeaderTruncated:
+			page.Truncated = true
+		}
+	}
+	if page.URL == "" {
+		return nil, false
+	}
+	page.Content = strings.TrimSuffix(content, "\n")
+	return page, true
+}
+
+// ============================================================================
+// robots.txt
+// ============================================================================
+
+// robotsCache 按 scheme://host 缓存解析后的规则
+var robotsCache sync.Map
+
+// robotsRule 一条 Allow/Disallow 规则
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsAllowed 检查 robots.txt 是否允许抓取该地址（robots.txt 不存在或无法读取时允许）
+func robotsAllowed(ctx context.Context, cfg FetchConfig, u *url.URL) (bool, error) {
+	origin := u.Scheme + "://" + u.Host
+	rules, ok := robotsCache.Load(origin)
+	if !ok {
+		parsed, err := fetchRobots(ctx, cfg, origin)
+		if err != nil {
+			return true, err
+		}
+		robotsCache.Store(origin, parsed)
+		rules = parsed
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return matchRobots(rules.([]robotsRule), path), nil
+}
+
+// fetchRobots 下载并解析 robots.txt
+func fetchRobots(ctx context.Context, cfg FetchConfig, origin string) ([]robotsRule, error) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil // 没有 robots.txt
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 512<<10))
+	if err != nil {
+		return nil, err
+	}
+	return parseRobots(string(body), robotsUserAgentToken), nil
+}
+
+// parseRobots 解析 robots.txt，返回适用于 agent 的规则（有专门的分组时使用专门分组，否则使用 "*"）
+func parseRobots(body, agent string) []robotsRule {
+	var specific, wildcard []robotsRule
+	var hasSpecific bool
+	var groupAgents []string
+	inRules := false
+
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				groupAgents = nil
+				inRules = false
+			}
+			groupAgents = append(groupAgents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // 空 Disallow 表示不限制
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)}
+			for _, a := range groupAgents {
+				if a == "*" {
+					wildcard = append(wildcard, rule)
+				} else if strings.Contains(agent, a) {
+					specific = append(specific, rule)
+					hasSpecific = true
+				}
+			}
+		}
+	}
+
+	if hasSpecific {
+		return specific
+	}
+	return wildcard
+}
+
+// robotsPattern 把 robots 路径规则（支持 * 和 $）转换为正则
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// matchRobots 最长匹配的规则生效，长度相同时 Allow 优先
+func matchRobots(rules []robotsRule, path string) bool {
+	allowed, best := true, -1
+	for _, r := range rules {
+		if !r.re.MatchString(path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			allowed, best = r.allow, n
+		}
+	}
+	return allowed
+}
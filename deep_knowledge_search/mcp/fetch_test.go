@@ -0,0 +1,210 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><title>Go &amp; Concurrency</title><style>body { color: red }</style>
+<script>alert("x")</script></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<h1>Goroutines</h1>
+<p>A <b>goroutine</b> is a   lightweight thread. See <a href="/doc/effective_go">Effective Go</a>.</p>
+<!-- comment -->
+<ul><li>channels</li><li>select</li></ul>
+<pre><code>go f()
+</code></pre>
+<footer>Copyright</footer>
+</body></html>`
+
+func TestHTMLToMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://go.dev/blog/")
+	got := HTMLToMarkdown(testPage, base)
+	want := "# Goroutines\n\n" +
+		"A **goroutine** is a lightweight thread. See [Effective Go](https://go.dev/doc/effective_go).\n\n" +
+		"- channels\n- select\n\n" +
+		"```\ngo f()\n```"
+	if got != want {
+		t.Errorf("HTMLToMarkdown:\n%s\n--- want ---\n%s", got, want)
+	}
+	if title := HTMLTitle(testPage); title != "Go & Concurrency" {
+		t.Errorf("HTMLTitle = %q", title)
+	}
+}
+
+// newSiteServer 本地测试站点：/robots.txt 禁止 /private，/page 返回测试页面，/moved 重定向到被禁止的 /private/page
+func newSiteServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private\nAllow: /private/ok$\n"))
+		case "/moved":
+			http.Redirect(w, r, "/private/page", http.StatusFound)
+		case "/page", "/private/ok", "/private/page":
+			atomic.AddInt32(hits, 1)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(testPage))
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(strings.Repeat("a", 100)))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// useFetchConfig 临时替换抓取配置
+func useFetchConfig(t *testing.T, cfg FetchConfig) {
+	t.Helper()
+	SetFetchConfig(cfg)
+	t.Cleanup(func() { SetFetchConfig(FetchConfig{}) })
+}
+
+func TestFetchPageCachesUnderTaskDir(t *testing.T) {
+	var hits int32
+	srv := newSiteServer(t, &hits)
+	useFetchConfig(t, FetchConfig{})
+	taskDir := t.TempDir()
+
+	page, err := FetchPage(context.Background(), srv.URL+"/page", taskDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Go & Concurrency" || !strings.HasPrefix(page.Content, "# Goroutines") {
+		t.Errorf("page = %+v", page)
+	}
+	if !strings.HasPrefix(page.CachePath, taskDir) || !strings.Contains(page.CachePath, SourcesSubDir) {
+		t.Errorf("cache path = %s, want under %s/%s", page.CachePath, taskDir, SourcesSubDir)
+	}
+
+	cached, err := FetchPage(context.Background(), srv.URL+"/page", taskDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Cached || cached.Content != page.Content || cached.Title != page.Title {
+		t.Errorf("cached page = %+v", cached)
+	}
+	if hits != 1 {
+		t.Errorf("server hits = %d, want 1", hits)
+	}
+}
+
+func TestFetchPageRobots(t *testing.T) {
+	var hits int32
+	srv := newSiteServer(t, &hits)
+	useFetchConfig(t, FetchConfig{})
+
+	if _, err := FetchPage(context.Background(), srv.URL+"/private/page", ""); err == nil || !strings.Contains(err.Error(), "robots.txt") {
+		t.Errorf("err = %v, want robots.txt disallow", err)
+	}
+	if _, err := FetchPage(context.Background(), srv.URL+"/private/ok", ""); err != nil {
+		t.Errorf("allowed path: %v", err)
+	}
+	// 重定向的目标同样检查 robots.txt
+	if _, err := FetchPage(context.Background(), srv.URL+"/moved", ""); err == nil || !strings.Contains(err.Error(), "robots.txt disallows") {
+		t.Errorf("redirect into disallowed path: err = %v", err)
+	}
+	if hits != 1 {
+		t.Errorf("server hits = %d, want 1 (only /private/ok)", hits)
+	}
+
+	useFetchConfig(t, FetchConfig{IgnoreRobots: true})
+	if _, err := FetchPage(context.Background(), srv.URL+"/private/ok", ""); err != nil {
+		t.Errorf("ignore robots: %v", err)
+	}
+}
+
+func TestFetchPageLimits(t *testing.T) {
+	var hits int32
+	srv := newSiteServer(t, &hits)
+	useFetchConfig(t, FetchConfig{MaxBytes: 10})
+
+	page, err := FetchPage(context.Background(), srv.URL+"/big", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Truncated || page.Content != strings.Repeat("a", 10) {
+		t.Errorf("page = %+v, want truncated to 10 bytes", page)
+	}
+
+	if _, err := FetchPage(context.Background(), srv.URL+"/image", ""); err == nil || !strings.Contains(err.Error(), "image/png") {
+		t.Errorf("err = %v, want unsupported content type", err)
+	}
+	if _, err := FetchPage(context.Background(), "file:///etc/passwd", ""); err == nil {
+		t.Error("file url fetched")
+	}
+}
+
+func TestFetchPageAllowDeny(t *testing.T) {
+	var hits int32
+	srv := newSiteServer(t, &hits)
+
+	useFetchConfig(t, FetchConfig{Allow: []string{"example.com"}})
+	if _, err := FetchPage(context.Background(), srv.URL+"/page", ""); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("err = %v, want allowlist error", err)
+	}
+
+	useFetchConfig(t, FetchConfig{Allow: []string{"127.0.0.1"}, Deny: []string{"127.0.0.1"}})
+	if _, err := FetchPage(context.Background(), srv.URL+"/page", ""); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("err = %v, want denied", err)
+	}
+
+	for _, tt := range []struct {
+		host, pattern string
+		want          bool
+	}{
+		{"docs.go.dev", "go.dev", true},
+		{"go.dev", "*.go.dev", true},
+		{"notgo.dev", "go.dev", false},
+	} {
+		if got := hostMatches(tt.host, tt.pattern); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v", tt.host, tt.pattern, got)
+		}
+	}
+}
+
+func TestFetchURLTool(t *testing.T) {
+	var hits int32
+	srv := newSiteServer(t, &hits)
+	RegisterDefaultTools()
+	useFetchConfig(t, FetchConfig{ExcerptChars: 12})
+	taskDir := t.TempDir()
+
+	ctx := context.WithValue(context.Background(), ContextKeyTaskDir, taskDir)
+	resp := CallMCPTool(ctx, "fetchURL", map[string]interface{}{"url": srv.URL + "/page"})
+	if !resp.Success {
+		t.Fatalf("fetchURL failed: %s", resp.Error)
+	}
+	result := resp.Result.(string)
+	for _, want := range []string{"标题: Go & Concurrency", "完整内容: " + taskDir, "摘录: 前 12 /", "# Goroutines"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("result missing %q:\n%s", want, result)
+		}
+	}
+	if strings.Contains(result, "lightweight") {
+		t.Errorf("excerpt not trimmed:\n%s", result)
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	body := "User-agent: DeepKnowledgeSearch\nDisallow: /\n\nUser-agent: *\nDisallow: /tmp\n"
+	if rules := parseRobots(body, robotsUserAgentToken); matchRobots(rules, "/page") {
+		t.Error("specific group should disallow everything")
+	}
+	if rules := parseRobots(body, "otherbot"); !matchRobots(rules, "/page") || matchRobots(rules, "/tmp/x") {
+		t.Error("wildcard group rules not applied")
+	}
+}
@@ -0,0 +1,636 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 文件工具限制
+const (
+	DefaultReadLines   = 400     // readFile 默认返回的行数
+	MaxReadChars       = 20000   // readFile 单次返回的最大字符数
+	MaxWriteBytes      = 1 << 20 // appendToFile / updateFile 写入后文件的最大字节数
+	MaxReadFileBytes   = 8 << 20 // readFile / updateFile 读取的文件最大字节数（先检查大小再读入内存）
+	MaxListEntries     = 200     // listFiles 最多列出的条目数
+	MaxSearchMatches   = 50      // searchInFiles 最多返回的匹配行数
+	maxSearchFileBytes = 2 << 20 // searchInFiles 跳过更大的文件
+	searchLineChars    = 200     // searchInFiles 匹配行的最大显示字符数
+	readOnlyDir        = "logs"  // 任务目录下只读的子目录（执行日志和检查点）
+)
+
+// fileToolsNote 各工具 path 参数说明的公共部分
+const fileToolsNote = "路径相对任务目录"
+
+// errOutsideSandbox 路径不在任务目录内
+var errOutsideSandbox = errors.New("path is outside the task directory")
+
+// fileSandbox 文件工具可访问的目录（当前任务目录）
+type fileSandbox struct {
+	root string // 规范化后的绝对路径（已解析符号链接）
+	base string // 规范化前的绝对路径，用于识别 LLM 传入的绝对路径
+}
+
+// newFileSandbox 从 context 确定任务目录：优先使用 ContextKeyTaskDir，未设置时使用节点输出目录
+func newFileSandbox(ctx context.Context) (*fileSandbox, error) {
+	dir, _ := ctx.Value(ContextKeyTaskDir).(string)
+	if dir == "" {
+		dir, _ = ctx.Value(ContextKeyOutputPath).(string)
+	}
+	if dir == "" {
+		return nil, errors.New("task directory not set in context")
+	}
+
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create task directory: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSandbox{root: root, base: base}, nil
+}
+
+// resolve 把相对任务目录的路径（或任务目录内的绝对路径）解析为真实路径
+// 路径或其中的符号链接指向任务目录之外时返回 errOutsideSandbox；write 为 true 时拒绝只读目录
+func (s *fileSandbox) resolve(path string, write bool) (string, error) {
+	path = strings.TrimSpace(path)
+	var full string
+	switch {
+	case path == "" || path == ".":
+		full = s.root
+	case filepath.IsAbs(path):
+		full = filepath.Clean(path)
+		if rel, ok := relInside(s.base, full); ok {
+			full = filepath.Join(s.root, rel)
+		}
+	default:
+		full = filepath.Join(s.root, path)
+	}
+	if _, ok := relInside(s.root, full); !ok {
+		return "", errOutsideSandbox
+	}
+
+	// 解析符号链接：不存在的路径解析其最近的已存在上级目录
+	real, err := evalExisting(full)
+	if err != nil {
+		return "", err
+	}
+	rel, ok := relInside(s.root, real)
+	if !ok {
+		return "", errOutsideSandbox
+	}
+	if write && (rel == "." || strings.SplitN(filepath.ToSlash(rel), "/", 2)[0] == readOnlyDir) {
+		return "", fmt.Errorf("path is read-only: %s", filepath.ToSlash(rel))
+	}
+	return real, nil
+}
+
+// rel 真实路径相对任务目录的显示形式
+func (s *fileSandbox) rel(full string) string {
+	rel, err := filepath.Rel(s.root, full)
+	if err != nil {
+		return full
+	}
+	return filepath.ToSlash(rel)
+}
+
+// relInside 返回 path 相对 root 的路径；path 不在 root 内时 ok 为 false
+func relInside(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// evalExisting 解析路径中的符号链接，路径末尾不存在的部分原样保留
+func evalExisting(path string) (string, error) {
+	var rest []string
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			for i := len(rest) - 1; i >= 0; i-- {
+				real = filepath.Join(real, rest[i])
+			}
+			return real, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append(rest, filepath.Base(path))
+		path = parent
+	}
+}
+
+// isBinary 粗略判断内容是否为二进制
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// fileToolError 构造失败响应
+func fileToolError(format string, args ...interface{}) MCPToolResponse {
+	return MCPToolResponse{
+		Success: false,
+		Error:   fmt.Sprintf(format, args...),
+	}
+}
+
+// stringParam 读取字符串参数
+func stringParam(arguments map[string]interface{}, key string) (string, bool) {
+	v, ok := arguments[key].(string)
+	return v, ok
+}
+
+// intParam 读取整数参数（JSON 数字解析为 float64），缺省时返回 def
+func intParam(arguments map[string]interface{}, key string, def int) int {
+	if v, ok := arguments[key].(float64); ok {
+		return int(v)
+	}
+	return def
+}
+
+// ============================================================================
+// 工具定义
+// ============================================================================
+
+// registerFileTools 注册任务目录内的文件工具
+func registerFileTools() {
+	RegisterTool("readFile", LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "readFile",
+			Description: "读取当前任务目录中的文件（自己或其他子任务保存的文档），返回带行号的内容。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "文件路径，" + fileToolsNote + "（如 doc/收集资料/资料清单.md），也可以是 saveToDisk 返回的路径",
+					},
+					"start_line": map[string]interface{}{
+						"type":        "integer",
+						"description": "起始行号（从 1 开始，默认 1）",
+					},
+					"max_lines": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("最多返回行数（默认 %d）", DefaultReadLines),
+					},
+				},
+				"required": []string{"path"},
+			},
+		},
+	}, readFileHandler)
+
+	RegisterTool("listFiles", LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "listFiles",
+			Description: "列出当前任务目录中的文件及大小，用于查看已保存的文档。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "目录，" + fileToolsNote + "（默认为任务目录）",
+					},
+					"recursive": map[string]interface{}{
+						"type":        "boolean",
+						"description": "是否包含子目录中的文件（默认 true）",
+					},
+				},
+			},
+		},
+	}, listFilesHandler)
+
+	RegisterTool("appendToFile", LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "appendToFile",
+			Description: "在当前任务目录中的文件末尾追加内容（文件不存在时创建）。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "文件路径，" + fileToolsNote,
+					},
+					"content": map[string]interface{}{
+						"type":        "string",
+						"description": "要追加的内容",
+					},
+				},
+				"required": []string{"path", "content"},
+			},
+		},
+	}, appendToFileHandler)
+
+	RegisterTool("updateFile", LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "updateFile",
+			Description: "修改当前任务目录中已有的文件：用 new_text 替换 old_text（old_text 必须唯一，除非 replace_all），或用 content 覆盖全文。改进已保存的文档时使用它，不要再用 saveToDisk 保存新文件。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "文件路径，" + fileToolsNote,
+					},
+					"old_text": map[string]interface{}{
+						"type":        "string",
+						"description": "要替换的原文",
+					},
+					"new_text": map[string]interface{}{
+						"type":        "string",
+						"description": "替换后的文本",
+					},
+					"replace_all": map[string]interface{}{
+						"type":        "boolean",
+						"description": "替换所有出现的 old_text（默认 false）",
+					},
+					"content": map[string]interface{}{
+						"type":        "string",
+						"description": "新的全文（不使用 old_text 时）",
+					},
+				},
+				"required": []string{"path"},
+			},
+		},
+	}, updateFileHandler)
+
+	RegisterTool("searchInFiles", LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "searchInFiles",
+			Description: "在当前任务目录的文件中搜索文本，返回匹配的文件、行号和行内容。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "要搜索的文本（不区分大小写）",
+					},
+					"regex": map[string]interface{}{
+						"type":        "boolean",
+						"description": "query 是否为正则表达式（默认 false）",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "搜索的目录，" + fileToolsNote + "（默认为任务目录）",
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}, searchInFilesHandler)
+}
+
+// ============================================================================
+// 工具实现
+// ============================================================================
+
+// readFileHandler handles the readFile tool call
+func readFileHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	path, ok := stringParam(arguments, "path")
+	if !ok || strings.TrimSpace(path) == "" {
+		return fileToolError("missing or invalid 'path' parameter")
+	}
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	full, err := sandbox.resolve(path, false)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+
+	info, err := os.Stat(full)
+	if err != nil {
+		return fileToolError("file not found: %s", path)
+	}
+	if info.IsDir() {
+		return fileToolError("%s is a directory, use listFiles", sandbox.rel(full))
+	}
+	if info.Size() > MaxReadFileBytes {
+		return fileToolError("%s is too large to read (%d bytes, limit %d)", sandbox.rel(full), info.Size(), MaxReadFileBytes)
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return fileToolError("failed to read file: %v", err)
+	}
+	if isBinary(data) {
+		return fileToolError("%s is not a text file", sandbox.rel(full))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	start := intParam(arguments, "start_line", 1)
+	if start < 1 {
+		start = 1
+	}
+	if start > len(lines) {
+		return fileToolError("start_line %d exceeds file length (%d lines)", start, len(lines))
+	}
+	maxLines := intParam(arguments, "max_lines", DefaultReadLines)
+	if maxLines <= 0 {
+		maxLines = DefaultReadLines
+	}
+
+	var sb strings.Builder
+	end := start - 1
+	for i := start - 1; i < len(lines) && i < start-1+maxLines; i++ {
+		line := fmt.Sprintf("%5d  %s\n", i+1, lines[i])
+		if sb.Len()+len(line) > MaxReadChars {
+			if end >= start {
+				break
+			}
+			// 第一行就超过上限（如压缩的 JSON/HTML）：只返回这一行的前 MaxReadChars 个字节
+			sb.WriteString(strings.ToValidUTF8(line[:MaxReadChars], ""))
+			sb.WriteString(fmt.Sprintf("\n...（第 %d 行过长，已截断）\n", i+1))
+			end = i + 1
+			break
+		}
+		sb.WriteString(line)
+		end = i + 1
+	}
+
+	header := fmt.Sprintf("文件: %s（共 %d 行，第 %d-%d 行）\n", sandbox.rel(full), len(lines), start, end)
+	if end < len(lines) {
+		sb.WriteString(fmt.Sprintf("...（未显示完，使用 start_line=%d 继续读取）\n", end+1))
+	}
+	return MCPToolResponse{
+		Success: true,
+		Result:  header + sb.String(),
+	}
+}
+
+// listFilesHandler handles the listFiles tool call
+func listFilesHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	path, _ := stringParam(arguments, "path")
+	recursive := true
+	if v, ok := arguments["recursive"].(bool); ok {
+		recursive = v
+	}
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	dir, err := sandbox.resolve(path, false)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fileToolError("directory not found: %s", path)
+	}
+
+	var entries []string
+	truncated := false
+	// WalkDir 不跟随符号链接，链接本身按文件列出
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return nil
+		}
+		if len(entries) >= MaxListEntries {
+			truncated = true
+			return filepath.SkipAll
+		}
+		if d.IsDir() && d.Name() == runCodeCacheDir {
+			// runCode 的构建缓存不是任务文档
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			entries = append(entries, sandbox.rel(p)+"/")
+			if !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		size := int64(0)
+		if info, err := d.Info(); err == nil {
+			size = info.Size()
+		}
+		entries = append(entries, fmt.Sprintf("%s (%d bytes)", sandbox.rel(p), size))
+		return nil
+	})
+	sort.Strings(entries)
+
+	if len(entries) == 0 {
+		return MCPToolResponse{
+			Success: true,
+			Result:  fmt.Sprintf("%s 为空", sandbox.rel(dir)),
+		}
+	}
+	result := fmt.Sprintf("%s 下的文件:\n%s\n", sandbox.rel(dir), strings.Join(entries, "\n"))
+	if truncated {
+		result += fmt.Sprintf("...（只列出前 %d 项）\n", MaxListEntries)
+	}
+	return MCPToolResponse{
+		Success: true,
+		Result:  result,
+	}
+}
+
+// appendToFileHandler handles the appendToFile tool call
+func appendToFileHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	path, ok := stringParam(arguments, "path")
+	if !ok || strings.TrimSpace(path) == "" {
+		return fileToolError("missing or invalid 'path' parameter")
+	}
+	content, ok := stringParam(arguments, "content")
+	if !ok {
+		return fileToolError("missing or invalid 'content' parameter")
+	}
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	full, err := sandbox.resolve(path, true)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+
+	var size int64
+	if info, err := os.Stat(full); err == nil {
+		if info.IsDir() {
+			return fileToolError("%s is a directory", sandbox.rel(full))
+		}
+		size = info.Size()
+	}
+	if size+int64(len(content)) > MaxWriteBytes {
+		return fileToolError("file would exceed %d bytes", MaxWriteBytes)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return fileToolError("failed to create directory: %v", err)
+	}
+	f, err := os.OpenFile(full, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fileToolError("failed to open file: %v", err)
+	}
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileToolError("failed to write file: %v", err)
+	}
+
+	fmt.Printf("[MCP] Appended %d bytes to %s\n", len(content), full)
+	return MCPToolResponse{
+		Success: true,
+		Result:  fmt.Sprintf("已追加 %d 字节到 %s", len(content), sandbox.rel(full)),
+	}
+}
+
+// updateFileHandler handles the updateFile tool call
+func updateFileHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	path, ok := stringParam(arguments, "path")
+	if !ok || strings.TrimSpace(path) == "" {
+		return fileToolError("missing or invalid 'path' parameter")
+	}
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	full, err := sandbox.resolve(path, true)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	info, err := os.Stat(full)
+	if err != nil || info.IsDir() {
+		return fileToolError("file not found: %s (use saveToDisk or appendToFile to create files)", path)
+	}
+	if info.Size() > MaxReadFileBytes {
+		return fileToolError("%s is too large to update (%d bytes, limit %d)", sandbox.rel(full), info.Size(), MaxReadFileBytes)
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return fileToolError("failed to read file: %v", err)
+	}
+
+	var updated, summary string
+	if oldText, ok := stringParam(arguments, "old_text"); ok && oldText != "" {
+		newText, _ := stringParam(arguments, "new_text")
+		count := strings.Count(string(data), oldText)
+		replaceAll, _ := arguments["replace_all"].(bool)
+		switch {
+		case count == 0:
+			return fileToolError("old_text not found in %s", sandbox.rel(full))
+		case count > 1 && !replaceAll:
+			return fileToolError("old_text occurs %d times in %s; add surrounding text to make it unique or set replace_all", count, sandbox.rel(full))
+		}
+		updated = strings.ReplaceAll(string(data), oldText, newText)
+		summary = fmt.Sprintf("已替换 %d 处", count)
+	} else if content, ok := stringParam(arguments, "content"); ok {
+		updated = content
+		summary = "已覆盖全文"
+	} else {
+		return fileToolError("either 'old_text' or 'content' is required")
+	}
+
+	if len(updated) > MaxWriteBytes {
+		return fileToolError("file would exceed %d bytes", MaxWriteBytes)
+	}
+	if err := os.WriteFile(full, []byte(updated), info.Mode().Perm()); err != nil {
+		return fileToolError("failed to write file: %v", err)
+	}
+
+	fmt.Printf("[MCP] Updated file: %s\n", full)
+	return MCPToolResponse{
+		Success: true,
+		Result:  fmt.Sprintf("%s: %s", sandbox.rel(full), summary),
+	}
+}
+
+// searchInFilesHandler handles the searchInFiles tool call
+func searchInFilesHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	query, ok := stringParam(arguments, "query")
+	if !ok || query == "" {
+		return fileToolError("missing or invalid 'query' parameter")
+	}
+	pattern := regexp.QuoteMeta(query)
+	if isRegex, _ := arguments["regex"].(bool); isRegex {
+		pattern = query
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return fileToolError("invalid regex: %v", err)
+	}
+
+	path, _ := stringParam(arguments, "path")
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	dir, err := sandbox.resolve(path, false)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+
+	var matches []string
+	truncated := false
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		// runCode 的构建缓存不是任务文档
+		if err == nil && d.IsDir() && d.Name() == runCodeCacheDir {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxSearchFileBytes {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), maxSearchFileBytes)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			if !re.Match(scanner.Bytes()) {
+				continue
+			}
+			if len(matches) >= MaxSearchMatches {
+				truncated = true
+				return filepath.SkipAll
+			}
+			line := truncateText(strings.TrimSpace(scanner.Text()), searchLineChars)
+			matches = append(matches, fmt.Sprintf("%s:%d: %s", sandbox.rel(p), lineNo, line))
+		}
+		return nil
+	})
+
+	if len(matches) == 0 {
+		return MCPToolResponse{
+			Success: true,
+			Result:  fmt.Sprintf("没有找到 %q", query),
+		}
+	}
+	result := fmt.Sprintf("找到 %d 处 %q:\n%s\n", len(matches), query, strings.Join(matches, "\n"))
+	if truncated {
+		result += fmt.Sprintf("...（只显示前 %d 处）\n", MaxSearchMatches)
+	}
+	return MCPToolResponse{
+		Success: true,
+		Result:  result,
+	}
+}
@@ -0,0 +1,146 @@
+package mcp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// newFileToolsTask 创建带两个子任务文档的任务目录，返回对应的 context
+func newFileToolsTask(t *testing.T) (context.Context, string) {
+	t.Helper()
+	taskDir := t.TempDir()
+	files := map[string]string{
+		"doc/收集资料/资料清单.md":    "# 资料\n- goroutine\n- channel\n",
+		"doc/撰写报告/报告.md":      "# 报告\nGoroutine 是轻量级线程。\n",
+		"logs/execution.json": "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(taskDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.WithValue(context.Background(), ContextKeyTaskDir, taskDir)
+	ctx = context.WithValue(ctx, ContextKeyOutputPath, filepath.Join(taskDir, "doc", "撰写报告"))
+	return ctx, taskDir
+}
+
+func TestFileToolsReadListSearch(t *testing.T) {
+	ctx, taskDir := newFileToolsTask(t)
+
+	resp := readFileHandler(ctx, map[string]interface{}{"path": "doc/收集资料/资料清单.md", "start_line": float64(2), "max_lines": float64(1)})
+	if !resp.Success || !strings.Contains(resp.Result.(string), "2  - goroutine") || strings.Contains(resp.Result.(string), "channel") {
+		t.Errorf("readFile = %+v", resp)
+	}
+	// saveToDisk 返回的绝对路径也可以读取
+	if resp := readFileHandler(ctx, map[string]interface{}{"path": filepath.Join(taskDir, "doc", "撰写报告", "报告.md")}); !resp.Success {
+		t.Errorf("readFile(abs) = %+v", resp)
+	}
+
+	resp = listFilesHandler(ctx, map[string]interface{}{"path": "doc"})
+	if !resp.Success || !strings.Contains(resp.Result.(string), "doc/收集资料/资料清单.md (") || !strings.Contains(resp.Result.(string), "doc/撰写报告/") {
+		t.Errorf("listFiles = %+v", resp)
+	}
+
+	resp = searchInFilesHandler(ctx, map[string]interface{}{"query": "GOROUTINE"})
+	if !resp.Success || !strings.Contains(resp.Result.(string), "找到 2 处") || !strings.Contains(resp.Result.(string), "doc/撰写报告/报告.md:2:") {
+		t.Errorf("searchInFiles = %+v", resp)
+	}
+}
+
+func TestFileToolsWrite(t *testing.T) {
+	ctx, taskDir := newFileToolsTask(t)
+	report := filepath.Join(taskDir, "doc", "撰写报告", "报告.md")
+
+	if resp := appendToFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/报告.md", "content": "channel 用于通信。\n"}); !resp.Success {
+		t.Fatalf("appendToFile = %+v", resp)
+	}
+	if resp := updateFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/报告.md", "old_text": "轻量级线程", "new_text": "由运行时调度的轻量级线程"}); !resp.Success {
+		t.Fatalf("updateFile = %+v", resp)
+	}
+	data, _ := os.ReadFile(report)
+	if want := "# 报告\nGoroutine 是由运行时调度的轻量级线程。\nchannel 用于通信。\n"; string(data) != want {
+		t.Errorf("report = %q, want %q", data, want)
+	}
+
+	// old_text 不唯一时拒绝
+	updateFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/报告.md", "content": "a\na\n"})
+	if resp := updateFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/报告.md", "old_text": "a", "new_text": "b"}); resp.Success {
+		t.Error("ambiguous old_text should be rejected")
+	}
+	if resp := updateFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/报告.md", "old_text": "a", "new_text": "b", "replace_all": true}); !resp.Success {
+		t.Errorf("replace_all = %+v", resp)
+	}
+	if resp := appendToFileHandler(ctx, map[string]interface{}{"path": "logs/execution.json", "content": "x"}); resp.Success {
+		t.Error("logs should be read-only")
+	}
+}
+
+func TestFileToolsSandbox(t *testing.T) {
+	ctx, taskDir := newFileToolsTask(t)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(taskDir, "doc", "link")); err != nil {
+		t.Skipf("symlink not supported: %v", err)
+	}
+
+	for _, path := range []string{"../secret.txt", filepath.Join(outside, "secret.txt"), "doc/link/secret.txt"} {
+		if resp := readFileHandler(ctx, map[string]interface{}{"path": path}); resp.Success || !strings.Contains(resp.Error, "outside") {
+			t.Errorf("readFile(%s) = %+v", path, resp)
+		}
+	}
+	if resp := appendToFileHandler(ctx, map[string]interface{}{"path": "doc/link/new.md", "content": "x"}); resp.Success {
+		t.Error("write through symlink should be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.md")); !os.IsNotExist(err) {
+		t.Error("file created outside the task directory")
+	}
+	if resp := searchInFilesHandler(ctx, map[string]interface{}{"query": "secret"}); !strings.Contains(resp.Result.(string), "没有找到") {
+		t.Errorf("searchInFiles followed symlink: %+v", resp)
+	}
+}
+
+// TestFileToolsReadLongLine 单行文件超过 MaxReadChars 时也被截断
+func TestFileToolsReadLongLine(t *testing.T) {
+	ctx, taskDir := newFileToolsTask(t)
+	minified := `{"items":[` + strings.Repeat(`"值",`, MaxReadChars) + `"end"]}`
+	if err := os.WriteFile(filepath.Join(taskDir, "doc", "page.json"), []byte(minified), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := readFileHandler(ctx, map[string]interface{}{"path": "doc/page.json"})
+	if !resp.Success {
+		t.Fatalf("readFile = %+v", resp)
+	}
+	result := resp.Result.(string)
+	if len(result) > MaxReadChars+200 || !strings.Contains(result, "第 1 行过长，已截断") || strings.Contains(result, "end") {
+		t.Errorf("readFile returned %d bytes, tail %q", len(result), result[len(result)-80:])
+	}
+	if !utf8.ValidString(result) {
+		t.Error("truncated line is not valid UTF-8")
+	}
+}
+
+// TestFileToolsReadSizeLimit 超过 MaxReadFileBytes 的文件在读入内存前被拒绝
+func TestFileToolsReadSizeLimit(t *testing.T) {
+	ctx, taskDir := newFileToolsTask(t)
+	big := filepath.Join(taskDir, "doc", "撰写报告", "big.txt")
+	if err := os.WriteFile(big, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(big, MaxReadFileBytes+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := readFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/big.txt"}); resp.Success || !strings.Contains(resp.Error, "too large") {
+		t.Errorf("readFile = %+v", resp)
+	}
+	if resp := updateFileHandler(ctx, map[string]interface{}{"path": "doc/撰写报告/big.txt", "content": "small"}); resp.Success || !strings.Contains(resp.Error, "too large") {
+		t.Errorf("updateFile = %+v", resp)
+	}
+}
@@ -0,0 +1,234 @@
+package mcp
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// htmlSkipTags 内容整体丢弃的标签（脚本、样式、导航等非正文部分）
+var htmlSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "head": true, "nav": true, "footer": true, "form": true, "button": true,
+}
+
+// htmlBlockTags 前后换行的块级标签
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "header": true,
+	"aside": true, "blockquote": true, "table": true, "tr": true, "ul": true, "ol": true,
+	"dl": true, "dt": true, "dd": true, "figure": true, "figcaption": true, "hr": true,
+}
+
+// htmlVoidTags 没有结束标签的元素
+var htmlVoidTags = map[string]bool{
+	"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true,
+	"area": true, "base": true, "col": true, "embed": true, "source": true, "wbr": true,
+}
+
+var (
+	htmlTitleRe   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlAttrRe    = regexp.MustCompile(`(?is)([a-z][a-z0-9_:-]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	blankLinesRe  = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlTag 解析出的一个标签
+type htmlTag struct {
+	name    string
+	closing bool
+	attrs   string
+}
+
+// parseHTMLTag 解析 "<...>" 内部的文本
+func parseHTMLTag(raw string) htmlTag {
+	raw = strings.TrimSpace(raw)
+	tag := htmlTag{}
+	if strings.HasPrefix(raw, "/") {
+		tag.closing = true
+		raw = raw[1:]
+	}
+	raw = strings.TrimSuffix(raw, "/")
+	end := strings.IndexAny(raw, " \t\r\n")
+	if end == -1 {
+		tag.name = strings.ToLower(raw)
+	} else {
+		tag.name = strings.ToLower(raw[:end])
+		tag.attrs = raw[end:]
+	}
+	return tag
+}
+
+// attr 获取标签属性值
+func (t htmlTag) attr(name string) string {
+	for _, m := range htmlAttrRe.FindAllStringSubmatch(t.attrs, -1) {
+		if strings.EqualFold(m[1], name) {
+			return html.UnescapeString(strings.Trim(m[2], `"'`))
+		}
+	}
+	return ""
+}
+
+// HTMLTitle 提取页面标题
+func HTMLTitle(page string) string {
+	m := htmlTitleRe.FindStringSubmatch(page)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+}
+
+// HTMLToMarkdown 把 HTML 页面转换为可读的 markdown：去掉脚本、样式和导航，保留标题、段落、列表、链接和代码块
+// base 用于把相对链接解析为绝对地址（可为 nil）
+func HTMLToMarkdown(page string, base *url.URL) string {
+	page = htmlCommentRe.ReplaceAllString(page, "")
+
+	var sb strings.Builder
+	var link string // 当前 <a> 的地址
+	skipDepth := 0  // 处于被丢弃标签内的层数
+	skipTag := ""
+	preDepth := 0 // 处于 <pre> 内的层数
+
+	newline := func(n int) {
+		s := sb.String()
+		trailing := len(s) - len(strings.TrimRight(s, "\n"))
+		for i := trailing; i < n && len(s) > 0; i++ {
+			sb.WriteString("\n")
+		}
+	}
+
+	for len(page) > 0 {
+		lt := strings.IndexByte(page, '<')
+		if lt == -1 {
+			lt = len(page)
+		}
+
+		// 文本
+		if lt > 0 && skipDepth == 0 {
+			text := html.UnescapeString(page[:lt])
+			if preDepth > 0 {
+				sb.WriteString(text)
+			} else if collapsed := strings.Join(strings.Fields(text), " "); collapsed != "" {
+				s := sb.String()
+				if len(s) > 0 && !strings.HasSuffix(s, "\n") && !strings.HasSuffix(s, " ") && (text[0] == ' ' || text[0] == '\n' || text[0] == '\t' || text[0] == '\r') {
+					sb.WriteString(" ")
+				}
+				sb.WriteString(collapsed)
+				if last := text[len(text)-1]; last == ' ' || last == '\n' || last == '\t' || last == '\r' {
+					sb.WriteString(" ")
+				}
+			}
+		}
+		if lt >= len(page) {
+			break
+		}
+		page = page[lt:]
+
+		gt := strings.IndexByte(page, '>')
+		if gt == -1 {
+			break
+		}
+		raw := page[1:gt]
+		page = page[gt+1:]
+		if strings.HasPrefix(raw, "!") || strings.HasPrefix(raw, "?") {
+			continue // doctype、处理指令
+		}
+
+		tag := parseHTMLTag(raw)
+		if skipDepth > 0 {
+			if tag.name == skipTag {
+				if tag.closing {
+					skipDepth--
+				} else if !htmlVoidTags[tag.name] {
+					skipDepth++
+				}
+			}
+			continue
+		}
+		if htmlSkipTags[tag.name] && !tag.closing {
+			if !strings.HasSuffix(strings.TrimSpace(raw), "/") {
+				skipDepth, skipTag = 1, tag.name
+			}
+			continue
+		}
+
+		switch tag.name {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			newline(2)
+			if !tag.closing {
+				sb.WriteString(strings.Repeat("#", int(tag.name[1]-'0')) + " ")
+			}
+		case "br":
+			sb.WriteString("\n")
+		case "li":
+			if !tag.closing {
+				newline(1)
+				sb.WriteString("- ")
+			}
+		case "pre":
+			if tag.closing {
+				preDepth--
+				newline(1)
+				sb.WriteString("```")
+				newline(2)
+			} else {
+				preDepth++
+				newline(2)
+				sb.WriteString("```\n")
+			}
+		case "code":
+			if preDepth == 0 {
+				sb.WriteString("`")
+			}
+		case "strong", "b":
+			sb.WriteString("**")
+		case "em", "i":
+			sb.WriteString("_")
+		case "a":
+			if tag.closing {
+				if link != "" {
+					sb.WriteString("](" + link + ")")
+				}
+				link = ""
+			} else if href := resolveLink(tag.attr("href"), base); href != "" {
+				link = href
+				sb.WriteString("[")
+			}
+		case "td", "th":
+			if !tag.closing {
+				sb.WriteString(" | ")
+			}
+		default:
+			if htmlBlockTags[tag.name] {
+				newline(2)
+			}
+		}
+	}
+
+	// 清理行尾空白和多余空行
+	lines := strings.Split(sb.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text := blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// resolveLink 把链接解析为绝对 http(s) 地址，忽略锚点和脚本链接
+func resolveLink(href string, base *url.URL) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,121 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ProtocolVersion 使用的 MCP 协议版本
+const ProtocolVersion = "2025-03-26"
+
+// JSON-RPC 错误码
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// RPCMessage JSON-RPC 2.0 消息（请求、通知或响应）
+// 有 Method 无 ID 为通知；有 Method 有 ID 为请求；无 Method 为响应
+type RPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// IsRequest 是否为请求（需要响应）
+func (m *RPCMessage) IsRequest() bool {
+	return m.Method != "" && len(m.ID) > 0
+}
+
+// IsNotification 是否为通知
+func (m *RPCMessage) IsNotification() bool {
+	return m.Method != "" && len(m.ID) == 0
+}
+
+// RPCError JSON-RPC 错误
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewRPCResult 构造成功响应
+func NewRPCResult(id json.RawMessage, result interface{}) *RPCMessage {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return NewRPCError(id, RPCInternalError, err.Error())
+	}
+	return &RPCMessage{JSONRPC: "2.0", ID: id, Result: data}
+}
+
+// NewRPCError 构造错误响应
+func NewRPCError(id json.RawMessage, code int, message string) *RPCMessage {
+	return &RPCMessage{JSONRPC: "2.0", ID: id, Error: &RPCError{Code: code, Message: message}}
+}
+
+// ToolInfo tools/list 返回的工具描述
+type ToolInfo struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"inputSchema"`
+}
+
+// ToolContent tools/call 返回的内容块
+type ToolContent struct {
+	Type     string `json:"type"` // text / image / audio / resource
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+// ToolCallResult tools/call 的结果
+type ToolCallResult struct {
+	Content []ToolContent `json:"content"`
+	IsError bool          `json:"isError,omitempty"`
+}
+
+// Text 把内容块拼接为文本，非文本内容以占位说明代替
+func (r *ToolCallResult) Text() string {
+	var parts []string
+	for _, c := range r.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Type == "resource" && c.Resource != nil && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		case c.Type == "resource" && c.Resource != nil:
+			parts = append(parts, fmt.Sprintf("[resource: %s]", c.Resource.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s: %s]", c.Type, c.MimeType))
+		}
+	}
+	return joinNonEmpty(parts, "\n")
+}
+
+// joinNonEmpty 拼接非空字符串
+func joinNonEmpty(parts []string, sep string) string {
+	out := ""
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		if out != "" {
+			out += sep
+		}
+		out += p
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextKeyToolCallBudget 节点工具调用预算（*ToolCallBudget）的 Context Key
+const ContextKeyToolCallBudget contextKey = "tool_call_budget"
+
+// 工具调用默认限制
+const (
+	DefaultToolTimeout        = 2 * time.Minute // 单次工具调用的默认超时
+	DefaultMaxToolResultChars = 12000           // 返回给模型的工具结果最大字符数
+	ToolResultSubDir          = "tool_results"  // 任务目录下保存完整工具结果的子目录
+	toolCancelGrace           = 5 * time.Second // 超时后等待工具响应取消的时间，超过时记录警告
+)
+
+// 当前工具调用限制
+var (
+	toolTimeout        = DefaultToolTimeout
+	toolTimeouts       = map[string]time.Duration{}
+	maxToolResultChars = DefaultMaxToolResultChars
+	limitsMu           sync.RWMutex
+)
+
+// SetToolTimeouts 设置工具调用超时：def 为默认值（<= 0 时使用 DefaultToolTimeout），perTool 按工具名覆盖
+func SetToolTimeouts(def time.Duration, perTool map[string]time.Duration) {
+	if def <= 0 {
+		def = DefaultToolTimeout
+	}
+	timeouts := make(map[string]time.Duration, len(perTool))
+	for name, timeout := range perTool {
+		if timeout > 0 {
+			timeouts[name] = timeout
+		}
+	}
+
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	toolTimeout = def
+	toolTimeouts = timeouts
+}
+
+// GetToolTimeout 工具调用的超时时间
+func GetToolTimeout(toolName string) time.Duration {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	if timeout, ok := toolTimeouts[toolName]; ok {
+		return timeout
+	}
+	return toolTimeout
+}
+
+// SetMaxToolResultChars 设置返回给模型的工具结果最大字符数（<= 0 时使用 DefaultMaxToolResultChars）
+func SetMaxToolResultChars(n int) {
+	if n <= 0 {
+		n = DefaultMaxToolResultChars
+	}
+	limitsMu.Lock()
+	maxToolResultChars = n
+	limitsMu.Unlock()
+}
+
+// ToolCallBudget 一个节点可用的工具调用次数（同一节点的执行、重试和改进共用）
+type ToolCallBudget struct {
+	mu   sync.Mutex
+	max  int
+	used int
+}
+
+// NewToolCallBudget 创建工具调用预算，max <= 0 表示不限制
+func NewToolCallBudget(max int) *ToolCallBudget {
+	return &ToolCallBudget{max: max}
+}
+
+// Take 占用一次调用，次数已用完时返回 false
+func (b *ToolCallBudget) Take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used >= b.max {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// Used 已使用的调用次数
+func (b *ToolCallBudget) Used() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Max 调用次数上限（0 表示不限制）
+func (b *ToolCallBudget) Max() int {
+	return b.max
+}
+
+// Exhausted 调用次数是否已用完
+func (b *ToolCallBudget) Exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.max > 0 && b.used >= b.max
+}
+
+// checkToolCallBudget 检查 ctx 中的预算是否还有剩余，不占用（用于在请求人工批准之前拒绝注定超出预算的调用）
+func checkToolCallBudget(ctx context.Context) (bool, string) {
+	budget, ok := ctx.Value(ContextKeyToolCallBudget).(*ToolCallBudget)
+	if !ok || budget == nil || !budget.Exhausted() {
+		return true, ""
+	}
+	return false, budgetExhaustedReason(budget)
+}
+
+// takeToolCall 从 ctx 中的预算占用一次调用（未设置预算时不限制）
+func takeToolCall(ctx context.Context) (bool, string) {
+	budget, ok := ctx.Value(ContextKeyToolCallBudget).(*ToolCallBudget)
+	if !ok || budget == nil || budget.Take() {
+		return true, ""
+	}
+	return false, budgetExhaustedReason(budget)
+}
+
+// budgetExhaustedReason 预算用完时返回给模型的说明
+func budgetExhaustedReason(budget *ToolCallBudget) string {
+	return fmt.Sprintf("tool call budget exhausted (%d calls for this task node); answer with the information you already have", budget.Max())
+}
+
+// callWithTimeout 在超时 ctx 中执行工具；工具没有及时响应 ctx 时也按超时返回，不等待其结束
+// 工具收到的是带截止时间的 ctx：网络请求随之取消，runCode 终止整个沙箱进程
+func callWithTimeout(ctx context.Context, toolName string, callback ToolCallback, arguments map[string]interface{}) MCPToolResponse {
+	timeout := GetToolTimeout(toolName)
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan MCPToolResponse, 1)
+	go func() {
+		done <- callback(callCtx, arguments)
+	}()
+
+	select {
+	case resp := <-done:
+		return resp
+	case <-callCtx.Done():
+		// Go 无法强制停止 goroutine：工具应在 ctx 结束后尽快返回，未返回时记录以便排查
+		go func() {
+			select {
+			case <-done:
+			case <-time.After(toolCancelGrace):
+				fmt.Printf("[MCP] ⚠️ Tool %s is still running %s after cancellation\n", toolName, toolCancelGrace)
+			}
+		}()
+		if ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			fmt.Printf("[MCP] Tool %s timed out after %s\n", toolName, timeout)
+			return MCPToolResponse{Success: false, Error: fmt.Sprintf("tool '%s' timed out after %s", toolName, timeout)}
+		}
+		return MCPToolResponse{Success: false, Error: fmt.Sprintf("tool '%s' cancelled: %v", toolName, ctx.Err())}
+	}
+}
+
+// LimitToolResult 限制返回给模型的工具结果长度：超长时保留开头和结尾（按行截断），
+// 完整结果保存到任务目录的 tool_results/ 下，模型可用 readFile 分段读取
+func LimitToolResult(ctx context.Context, toolName, result string) string {
+	limitsMu.RLock()
+	limit := maxToolResultChars
+	limitsMu.RUnlock()
+
+	runes := []rune(result)
+	if len(runes) <= limit {
+		return result
+	}
+
+	saved := "完整结果未保存（没有任务目录）"
+	if path, err := saveToolResult(ctx, toolName, result); err != nil {
+		saved = fmt.Sprintf("完整结果保存失败: %v", err)
+	} else if path != "" {
+		saved = fmt.Sprintf("完整结果已保存到 %s，可用 readFile 按行读取", path)
+	}
+
+	head := cutAtLine(string(runes[:limit*2/3]), false)
+	tail := cutAtLine(string(runes[len(runes)-limit/3:]), true)
+	omitted := len(runes) - len([]rune(head)) - len([]rune(tail))
+	fmt.Printf("[MCP] Tool %s result truncated: %d -> %d chars\n", toolName, len(runes), len(runes)-omitted)
+	return fmt.Sprintf("%s\n\n...（结果共 %d 字符，省略中间 %d 字符；%s）...\n\n%s", head, len(runes), omitted, saved, tail)
+}
+
+// cutAtLine 在行边界截断：fromEnd 为 false 时去掉最后一个不完整的行，为 true 时去掉第一个不完整的行
+// 找不到换行（或只剩很少内容）时保持原样
+func cutAtLine(s string, fromEnd bool) string {
+	if fromEnd {
+		if i := strings.IndexByte(s, '\n'); i >= 0 && i < len(s)/2 {
+			return s[i+1:]
+		}
+		return s
+	}
+	if i := strings.LastIndexByte(s, '\n'); i > len(s)/2 {
+		return s[:i]
+	}
+	return s
+}
+
+// saveToolResult 把完整结果保存到任务目录，返回相对任务目录的路径（没有任务目录时返回空）
+func saveToolResult(ctx context.Context, toolName, result string) (string, error) {
+	taskDir, _ := ctx.Value(ContextKeyTaskDir).(string)
+	outputPath, _ := ctx.Value(ContextKeyOutputPath).(string)
+	if taskDir == "" && outputPath == "" {
+		return "", nil
+	}
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(sandbox.root, ToolResultSubDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, toolName)
+	f, err := os.CreateTemp(dir, time.Now().Format("20060102_150405_")+name+"_*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(result); err != nil {
+		return "", err
+	}
+	return sandbox.rel(f.Name()), nil
+}
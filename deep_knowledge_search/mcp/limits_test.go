@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolTimeout(t *testing.T) {
+	// 不响应 ctx 的工具也按超时返回
+	RegisterTool("slowTool", LLMTool{}, func(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+		time.Sleep(time.Second)
+		return MCPToolResponse{Success: true, Result: "late"}
+	})
+	t.Cleanup(func() { UnregisterTool("slowTool") })
+	SetToolTimeouts(0, map[string]time.Duration{"slowTool": 50 * time.Millisecond})
+	t.Cleanup(func() { SetToolTimeouts(0, nil) })
+
+	start := time.Now()
+	resp := CallMCPTool(context.Background(), "slowTool", nil)
+	if resp.Success || !strings.Contains(resp.Error, "timed out after 50ms") {
+		t.Errorf("slowTool = %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call took %s", elapsed)
+	}
+	if got := GetToolTimeout("fetchURL"); got != DefaultToolTimeout {
+		t.Errorf("default timeout = %s", got)
+	}
+}
+
+func TestLimitToolResult(t *testing.T) {
+	SetMaxToolResultChars(300)
+	t.Cleanup(func() { SetMaxToolResultChars(0) })
+	taskDir := t.TempDir()
+	ctx := context.WithValue(context.Background(), ContextKeyTaskDir, taskDir)
+
+	if got := LimitToolResult(ctx, "webSearch", "short"); got != "short" {
+		t.Errorf("short result changed: %q", got)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		sb.WriteString("第 " + strings.Repeat("x", i%7) + " 行\n")
+	}
+	full := sb.String()
+	got := LimitToolResult(ctx, "github__search", full)
+	if !strings.HasPrefix(got, "第  行\n") || !strings.HasSuffix(got, "第 x 行\n") || len([]rune(got)) > 500 {
+		t.Errorf("truncated result = %q", got)
+	}
+
+	// 完整结果保存在任务目录，可用 readFile 读取
+	m := regexp.MustCompile(`已保存到 (tool_results/\S+\.txt)`).FindStringSubmatch(got)
+	if m == nil {
+		t.Fatalf("saved path not reported: %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(taskDir, m[1]))
+	if err != nil || string(data) != full {
+		t.Errorf("saved result = %q, %v", data, err)
+	}
+	if resp := readFileHandler(ctx, map[string]interface{}{"path": m[1]}); !resp.Success {
+		t.Errorf("readFile(%s) = %+v", m[1], resp)
+	}
+}
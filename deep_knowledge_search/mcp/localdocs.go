@@ -0,0 +1,349 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 本地文档检索默认值
+const (
+	DefaultLocalDocsResults = 5
+	MaxLocalDocsResults     = 20
+	DefaultPassageLines     = 20
+	maxLocalDocBytes        = 4 << 20 // 超过该大小的文件不建索引
+	localPassageExcerpt     = 800     // 返回给 LLM 的段落最大字符数
+)
+
+// DefaultLocalDocExtensions 默认索引的文件扩展名（PDF 需先提取为文本）
+var DefaultLocalDocExtensions = []string{".md", ".markdown", ".txt", ".text"}
+
+// DefaultLocalDocExcludeDirs 默认跳过的目录名（logs 为任务输出中的执行日志）
+var DefaultLocalDocExcludeDirs = []string{".git", "node_modules", "logs"}
+
+// LocalDocsConfig 本地文档库配置
+type LocalDocsConfig struct {
+	Paths        []string // 要索引的文件或目录
+	Extensions   []string // 索引的扩展名（为空使用默认值）
+	ExcludeDirs  []string // 跳过的目录名（为空使用默认值）
+	PassageLines int      // 每个段落的最大行数
+	MaxResults   int      // 每次检索默认返回的段落数
+	IndexOnStart bool     // 配置时立即建索引（否则在第一次检索时建立）
+}
+
+// withDefaults 填充默认值
+func (c LocalDocsConfig) withDefaults() LocalDocsConfig {
+	if len(c.Extensions) == 0 {
+		c.Extensions = DefaultLocalDocExtensions
+	}
+	if len(c.ExcludeDirs) == 0 {
+		c.ExcludeDirs = DefaultLocalDocExcludeDirs
+	}
+	if c.PassageLines <= 0 {
+		c.PassageLines = DefaultPassageLines
+	}
+	if c.MaxResults <= 0 {
+		c.MaxResults = DefaultLocalDocsResults
+	}
+	if c.MaxResults > MaxLocalDocsResults {
+		c.MaxResults = MaxLocalDocsResults
+	}
+	return c
+}
+
+// LocalPassage 文件中的一个段落
+type LocalPassage struct {
+	Path      string // 文件路径
+	StartLine int    // 起始行（从 1 开始）
+	EndLine   int    // 结束行（含）
+	Text      string
+}
+
+// Ref 段落的 "文件:行号" 引用
+func (p LocalPassage) Ref() string {
+	if p.StartLine == p.EndLine {
+		return fmt.Sprintf("%s:%d", p.Path, p.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", p.Path, p.StartLine, p.EndLine)
+}
+
+// LocalDocHit 一条检索结果
+type LocalDocHit struct {
+	Passage LocalPassage
+	Score   float64
+}
+
+// LocalDocsIndex 本地文档的段落索引
+type LocalDocsIndex struct {
+	bm25     *BM25Index
+	passages []LocalPassage
+	Files    int       // 已索引的文件数
+	BuiltAt  time.Time // 建立时间
+}
+
+// BuildLocalDocsIndex 遍历路径下的文档，按段落建立 BM25 索引；不存在的路径跳过
+func BuildLocalDocsIndex(cfg LocalDocsConfig) (*LocalDocsIndex, error) {
+	cfg = cfg.withDefaults()
+	idx := &LocalDocsIndex{bm25: NewBM25Index(), BuiltAt: time.Now()}
+
+	exts := make(map[string]bool, len(cfg.Extensions))
+	for _, ext := range cfg.Extensions {
+		exts[strings.ToLower(ext)] = true
+	}
+	exclude := make(map[string]bool, len(cfg.ExcludeDirs))
+	for _, dir := range cfg.ExcludeDirs {
+		exclude[dir] = true
+	}
+
+	for _, root := range cfg.Paths {
+		if _, err := os.Stat(root); err != nil {
+			fmt.Printf("[MCP] ⚠️ 本地文档路径不可用，已跳过: %v\n", err)
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // 跳过无法读取的文件
+			}
+			if d.IsDir() {
+				if path != root && exclude[d.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !exts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			if info, err := d.Info(); err != nil || info.Size() > maxLocalDocBytes {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			idx.addFile(path, string(data), cfg.PassageLines)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", root, err)
+		}
+	}
+	return idx, nil
+}
+
+// addFile 把文件切分为段落后加入索引
+func (idx *LocalDocsIndex) addFile(path, content string, maxLines int) {
+	idx.Files++
+	for _, p := range splitPassages(content, maxLines) {
+		p.Path = path
+		idx.bm25.Add(p.Text)
+		idx.passages = append(idx.passages, p)
+	}
+}
+
+// splitPassages 按空行把文本切分为段落，段落超过 maxLines 行时强制切分
+// 段落不足 maxLines/2 行时遇到空行不切分，以免标题和正文被拆开
+func splitPassages(content string, maxLines int) []LocalPassage {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var passages []LocalPassage
+	start := -1 // 当前段落的起始行下标
+
+	flush := func(end int) {
+		if start == -1 {
+			return
+		}
+		// 去掉末尾空行
+		for end > start && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		passages = append(passages, LocalPassage{
+			StartLine: start + 1,
+			EndLine:   end,
+			Text:      strings.Join(lines[start:end], "\n"),
+		})
+		start = -1
+	}
+
+	for i, line := range lines {
+		blank := strings.TrimSpace(line) == ""
+		if start == -1 {
+			if !blank {
+				start = i
+			}
+			continue
+		}
+		if blank && i-start >= maxLines/2 {
+			flush(i)
+		} else if i-start >= maxLines {
+			flush(i)
+			if !blank {
+				start = i
+			}
+		}
+	}
+	flush(len(lines))
+	return passages
+}
+
+// Len 段落数
+func (idx *LocalDocsIndex) Len() int {
+	return len(idx.passages)
+}
+
+// Search 检索最相关的 limit 个段落
+func (idx *LocalDocsIndex) Search(query string, limit int) []LocalDocHit {
+	var hits []LocalDocHit
+	for _, h := range idx.bm25.Search(query, limit) {
+		hits = append(hits, LocalDocHit{Passage: idx.passages[h.Doc], Score: h.Score})
+	}
+	return hits
+}
+
+// ============================================================================
+// searchLocalDocs 工具
+// ============================================================================
+
+// 当前本地文档配置与索引（索引在第一次检索时建立）
+var (
+	localDocsConfig LocalDocsConfig
+	localDocsIndex  *LocalDocsIndex
+	localDocsMu     sync.Mutex
+)
+
+// SetLocalDocsConfig 设置本地文档库；配置了路径时注册 searchLocalDocs 工具，否则移除该工具
+func SetLocalDocsConfig(cfg LocalDocsConfig) {
+	cfg = cfg.withDefaults()
+
+	localDocsMu.Lock()
+	localDocsConfig = cfg
+	localDocsIndex = nil
+	localDocsMu.Unlock()
+
+	if len(cfg.Paths) == 0 {
+		UnregisterTool("searchLocalDocs")
+		return
+	}
+	RegisterTool("searchLocalDocs", searchLocalDocsTool(), searchLocalDocsHandler)
+	fmt.Printf("[MCP] searchLocalDocs enabled: %s\n", strings.Join(cfg.Paths, ", "))
+
+	if cfg.IndexOnStart {
+		if _, err := RebuildLocalDocsIndex(); err != nil {
+			fmt.Printf("[MCP] ⚠️ 本地文档索引失败: %v\n", err)
+		}
+	}
+}
+
+// RebuildLocalDocsIndex 按当前配置重新建立本地文档索引
+func RebuildLocalDocsIndex() (*LocalDocsIndex, error) {
+	localDocsMu.Lock()
+	defer localDocsMu.Unlock()
+	return rebuildLocalDocsIndexLocked()
+}
+
+// rebuildLocalDocsIndexLocked 重新建立索引（调用方持有 localDocsMu）
+func rebuildLocalDocsIndexLocked() (*LocalDocsIndex, error) {
+	start := time.Now()
+	idx, err := BuildLocalDocsIndex(localDocsConfig)
+	if err != nil {
+		return nil, err
+	}
+	localDocsIndex = idx
+	fmt.Printf("[MCP] 本地文档索引完成: %d 个文件, %d 个段落 (%v)\n", idx.Files, idx.Len(), time.Since(start).Round(time.Millisecond))
+	return idx, nil
+}
+
+// getLocalDocsIndex 获取当前索引，尚未建立或要求重建时建立
+func getLocalDocsIndex(rebuild bool) (*LocalDocsIndex, int, error) {
+	localDocsMu.Lock()
+	defer localDocsMu.Unlock()
+	if localDocsIndex == nil || rebuild {
+		if _, err := rebuildLocalDocsIndexLocked(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return localDocsIndex, localDocsConfig.MaxResults, nil
+}
+
+// searchLocalDocsTool searchLocalDocs 工具定义
+func searchLocalDocsTool() LLMTool {
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "searchLocalDocs",
+			Description: "在本地文档库（团队资料和以往任务的输出）中检索相关段落，返回文件路径、行号和段落内容，不需要网络。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "检索关键词",
+					},
+					"max_results": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("返回段落数（1-%d，默认 %d）", MaxLocalDocsResults, DefaultLocalDocsResults),
+					},
+					"reindex": map[string]interface{}{
+						"type":        "boolean",
+						"description": "检索前重新建立索引（文档有更新时使用）",
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// searchLocalDocsHandler handles the searchLocalDocs tool call
+func searchLocalDocsHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	query, ok := arguments["query"].(string)
+	query = strings.TrimSpace(query)
+	if !ok || query == "" {
+		return MCPToolResponse{
+			Success: false,
+			Error:   "missing or invalid 'query' parameter",
+		}
+	}
+
+	reindex, _ := arguments["reindex"].(bool)
+	idx, limit, err := getLocalDocsIndex(reindex)
+	if err != nil {
+		return MCPToolResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+	if n, ok := arguments["max_results"].(float64); ok && n >= 1 {
+		limit = int(n)
+		if limit > MaxLocalDocsResults {
+			limit = MaxLocalDocsResults
+		}
+	}
+
+	hits := idx.Search(query, limit)
+	fmt.Printf("[MCP] searchLocalDocs %q: %d passages\n", query, len(hits))
+	if len(hits) == 0 {
+		return MCPToolResponse{
+			Success: true,
+			Result:  fmt.Sprintf("本地文档中没有与 %q 相关的内容", query),
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("本地文档中与 %q 相关的段落:\n", query))
+	for i, h := range hits {
+		label := fmt.Sprintf("%d.", i+1)
+		if id := RecordSource(ctx, Source{Title: filepath.Base(h.Passage.Path), Path: h.Passage.Path, Tool: "searchLocalDocs"}); id > 0 {
+			label = fmt.Sprintf("[%d]", id)
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s\n%s\n", label, h.Passage.Ref(), truncateText(h.Passage.Text, localPassageExcerpt)))
+	}
+
+	return MCPToolResponse{
+		Success: true,
+		Result:  sb.String(),
+	}
+}
@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Go 的并发: goroutine_v2, CSP!")
+	want := []string{"go", "的", "的并", "并", "并发", "发", "goroutine", "v2", "csp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestBM25Ranking(t *testing.T) {
+	idx := NewBM25Index()
+	idx.Add("goroutine 是轻量级线程")
+	idx.Add("channel 用于 goroutine 之间通信，channel 可以带缓冲")
+	idx.Add("垃圾回收器使用三色标记")
+
+	hits := idx.Search("channel 通信", 5)
+	if len(hits) != 1 || hits[0].Doc != 1 {
+		t.Fatalf("hits = %+v, want doc 1 only", hits)
+	}
+	hits = idx.Search("goroutine 线程", 5)
+	if len(hits) != 2 || hits[0].Doc != 0 {
+		t.Errorf("hits = %+v, want doc 0 first", hits)
+	}
+	if hits := idx.Search("三色标记", 1); len(hits) != 1 || hits[0].Doc != 2 {
+		t.Errorf("chinese query hits = %+v", hits)
+	}
+}
+
+func TestSplitPassages(t *testing.T) {
+	content := "# 标题\n\n第一段第一行\n第一段第二行\n\n\n第二段\n"
+	var got []string
+	for _, p := range splitPassages(content, 4) {
+		got = append(got, p.Ref())
+	}
+	want := []string{":1-4", ":7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("passages = %v, want %v", got, want)
+	}
+
+	// 没有空行时按最大行数强制切分
+	if n := len(splitPassages(strings.Repeat("x\n", 10), 4)); n != 3 {
+		t.Errorf("forced split passages = %d, want 3", n)
+	}
+}
+
+// writeDocs 在临时目录中写入文档，返回目录
+func writeDocs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSearchLocalDocsTool(t *testing.T) {
+	dir := writeDocs(t, map[string]string{
+		"notes/go.md":              "# Go\n\n介绍\n\n## 调度\n\nGMP 调度模型把 goroutine 分配到线程上。\n",
+		"paper.txt":                "Garbage collection uses a tricolor mark and sweep algorithm.\n",
+		"image.png":                "goroutine",
+		"logs/summary.txt":         "goroutine 调度",
+		"old_task/README.md":       "# 以往任务\n",
+		"old_task/sources/page.md": "unrelated",
+	})
+	SetLocalDocsConfig(LocalDocsConfig{Paths: []string{dir, filepath.Join(dir, "missing")}, PassageLines: 4})
+	t.Cleanup(func() { SetLocalDocsConfig(LocalDocsConfig{}) })
+
+	if !hasTool("searchLocalDocs") {
+		t.Fatal("searchLocalDocs not registered")
+	}
+
+	var recorded []Source
+	ctx := context.WithValue(context.Background(), ContextKeySourceRecorder, SourceRecorder(func(src Source) int {
+		recorded = append(recorded, src)
+		return len(recorded)
+	}))
+	resp := CallMCPTool(ctx, "searchLocalDocs", map[string]interface{}{"query": "goroutine 调度"})
+	if !resp.Success {
+		t.Fatalf("searchLocalDocs failed: %s", resp.Error)
+	}
+	result := resp.Result.(string)
+	if want := "[1] " + filepath.Join(dir, "notes/go.md") + ":5-7\n"; !strings.Contains(result, want) {
+		t.Errorf("result missing %q:\n%s", want, result)
+	}
+	if strings.Contains(result, "summary.txt") || strings.Contains(result, "image.png") {
+		t.Errorf("excluded files indexed:\n%s", result)
+	}
+	if len(recorded) != 1 || recorded[0].Tool != "searchLocalDocs" {
+		t.Errorf("recorded = %+v", recorded)
+	}
+
+	// 新增文件在重建索引后可检索
+	os.WriteFile(filepath.Join(dir, "new.md"), []byte("channel 通信\n"), 0644)
+	resp = CallMCPTool(context.Background(), "searchLocalDocs", map[string]interface{}{"query": "channel"})
+	if !strings.Contains(resp.Result.(string), "没有") {
+		t.Errorf("new file found before reindex:\n%s", resp.Result)
+	}
+	resp = CallMCPTool(context.Background(), "searchLocalDocs", map[string]interface{}{"query": "channel", "reindex": true})
+	if !strings.Contains(resp.Result.(string), "1. "+filepath.Join(dir, "new.md")+":1") {
+		t.Errorf("new file not found after reindex:\n%s", resp.Result)
+	}
+
+	SetLocalDocsConfig(LocalDocsConfig{})
+	if hasTool("searchLocalDocs") {
+		t.Error("searchLocalDocs still registered without paths")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -50,22 +51,46 @@ type MCPToolResponse struct {
 // Tool callback function type
 type ToolCallback func(ctx context.Context, arguments map[string]interface{}) MCPToolResponse
 
+// ToolGate 判断工具在 ctx 对应的任务中是否可用（如 runCode 只对启用了代码执行的任务开放）
+type ToolGate func(ctx context.Context) bool
+
 // Tool registry
 var (
 	toolRegistry = make(map[string]ToolCallback)
 	toolDefs     = make(map[string]LLMTool)
+	toolGates    = make(map[string]ToolGate)
 	registryMu   sync.RWMutex
 )
 
 // RegisterTool registers a tool with its callback and definition
 func RegisterTool(name string, tool LLMTool, callback ToolCallback) {
+	RegisterGatedTool(name, tool, callback, nil)
+}
+
+// RegisterGatedTool 注册只在 gate 返回 true 的任务中可用的工具（gate 为 nil 时始终可用）
+func RegisterGatedTool(name string, tool LLMTool, callback ToolCallback, gate ToolGate) {
 	registryMu.Lock()
 	defer registryMu.Unlock()
 	toolRegistry[name] = callback
 	toolDefs[name] = tool
+	if gate != nil {
+		toolGates[name] = gate
+	} else {
+		delete(toolGates, name)
+	}
 }
 
-// GetAvailableLLMTools returns all registered tools in LLM format
+// UnregisterTool removes a registered tool
+func UnregisterTool(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(toolRegistry, name)
+	delete(toolDefs, name)
+	delete(toolGates, name)
+}
+
+// GetAvailableLLMTools returns all registered tools in LLM format, sorted by name
+// (a stable order keeps prompts and response cache keys identical between runs)
 func GetAvailableLLMTools() []LLMTool {
 	registryMu.RLock()
 	defer registryMu.RUnlock()
@@ -74,13 +99,38 @@ func GetAvailableLLMTools() []LLMTool {
 	for _, tool := range toolDefs {
 		tools = append(tools, tool)
 	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Function.Name < tools[j].Function.Name })
 	return tools
 }
 
+// GetLLMToolsForContext 返回 ctx 对应的任务可用的工具（排除 gate 未放行的工具），按名称排序
+func GetLLMToolsForContext(ctx context.Context) []LLMTool {
+	registryMu.RLock()
+	gates := make(map[string]ToolGate, len(toolGates))
+	for name, gate := range toolGates {
+		gates[name] = gate
+	}
+	registryMu.RUnlock()
+
+	tools := GetAvailableLLMTools()
+	if len(gates) == 0 {
+		return tools
+	}
+	filtered := tools[:0]
+	for _, tool := range tools {
+		if gate, ok := gates[tool.Function.Name]; ok && !gate(ctx) {
+			continue
+		}
+		filtered = append(filtered, tool)
+	}
+	return filtered
+}
+
 // CallMCPTool calls a registered MCP tool and returns the result
 func CallMCPTool(ctx context.Context, toolName string, arguments map[string]interface{}) MCPToolResponse {
 	registryMu.RLock()
 	callback, exists := toolRegistry[toolName]
+	gate := toolGates[toolName]
 	registryMu.RUnlock()
 
 	if !exists {
@@ -89,8 +139,33 @@ func CallMCPTool(ctx context.Context, toolName string, arguments map[string]inte
 			Error:   fmt.Sprintf("tool '%s' not found", toolName),
 		}
 	}
+	if gate != nil && !gate(ctx) {
+		return MCPToolResponse{
+			Success: false,
+			Error:   fmt.Sprintf("tool '%s' is not enabled for this task", toolName),
+		}
+	}
+	// 先检查预算：已用完时不再请求人工批准
+	if ok, reason := checkToolCallBudget(ctx); !ok {
+		return MCPToolResponse{
+			Success: false,
+			Error:   reason,
+		}
+	}
+	if allowed, reason := checkPolicy(ctx, toolName, arguments); !allowed {
+		return MCPToolResponse{
+			Success: false,
+			Error:   reason,
+		}
+	}
+	if ok, reason := takeToolCall(ctx); !ok {
+		return MCPToolResponse{
+			Success: false,
+			Error:   reason,
+		}
+	}
 
-	return callback(ctx, arguments)
+	return callWithTimeout(ctx, toolName, callback, arguments)
 }
 
 // ParseToolArguments parses JSON arguments string to map
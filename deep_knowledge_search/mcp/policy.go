@@ -0,0 +1,235 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ContextKeyToolApprover 人工批准函数的 Context Key
+const ContextKeyToolApprover contextKey = "tool_approver"
+
+// ContextKeyToolAuditor 策略决定登记函数的 Context Key
+const ContextKeyToolAuditor contextKey = "tool_auditor"
+
+// DefaultApprovalTimeout ask 规则等待批准的默认时间，超时视为拒绝
+const DefaultApprovalTimeout = 5 * time.Minute
+
+// PolicyAction 策略对工具调用的处理方式
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow" // 直接执行
+	PolicyDeny  PolicyAction = "deny"  // 拒绝执行
+	PolicyAsk   PolicyAction = "ask"   // 暂停并等待人工批准
+)
+
+// PolicyRule 一条权限规则：工具名和全部参数条件都匹配时生效
+type PolicyRule struct {
+	Tool   string            // 工具名，支持 path.Match 通配（如 github__*、*）
+	Args   map[string]string // 参数名 -> 正则；参数值不是字符串时按 JSON 匹配，缺少参数时不匹配
+	Action PolicyAction
+}
+
+// ToolPolicy 工具调用权限策略：按顺序匹配规则，第一条匹配的规则生效
+type ToolPolicy struct {
+	Rules           []PolicyRule
+	Default         PolicyAction  // 没有规则匹配时的处理方式，为空时允许
+	ApprovalTimeout time.Duration // ask 等待批准的最长时间
+}
+
+// compiledRule 编译后的规则
+type compiledRule struct {
+	PolicyRule
+	args  map[string]*regexp.Regexp
+	label string // 审计日志中的规则描述
+}
+
+// 当前策略
+var (
+	policyRules     []compiledRule
+	policyDefault   = PolicyAllow
+	approvalTimeout = DefaultApprovalTimeout
+	policyMu        sync.RWMutex
+)
+
+// SetToolPolicy 设置工具调用权限策略，规则无效时返回错误且保留原策略
+func SetToolPolicy(policy ToolPolicy) error {
+	def := policy.Default
+	if def == "" {
+		def = PolicyAllow
+	}
+	if !validAction(def) {
+		return fmt.Errorf("invalid default action %q", def)
+	}
+
+	rules := make([]compiledRule, 0, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		if !validAction(rule.Action) {
+			return fmt.Errorf("rule %d: invalid action %q", i+1, rule.Action)
+		}
+		if rule.Tool == "" {
+			rule.Tool = "*"
+		}
+		if _, err := path.Match(rule.Tool, ""); err != nil {
+			return fmt.Errorf("rule %d: invalid tool pattern %q", i+1, rule.Tool)
+		}
+
+		c := compiledRule{PolicyRule: rule, args: make(map[string]*regexp.Regexp, len(rule.Args))}
+		names := make([]string, 0, len(rule.Args))
+		for name, pattern := range rule.Args {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid pattern for argument %s: %w", i+1, name, err)
+			}
+			c.args[name] = re
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		label := fmt.Sprintf("规则 #%d %s", i+1, rule.Tool)
+		for _, name := range names {
+			label += fmt.Sprintf(" %s=~%s", name, rule.Args[name])
+		}
+		c.label = label
+		rules = append(rules, c)
+	}
+
+	timeout := policy.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policyRules = rules
+	policyDefault = def
+	approvalTimeout = timeout
+	if len(rules) > 0 || def != PolicyAllow {
+		fmt.Printf("[MCP] Tool policy: %d rules, default %s\n", len(rules), def)
+	}
+	return nil
+}
+
+// validAction 是否为合法的处理方式
+func validAction(action PolicyAction) bool {
+	switch action {
+	case PolicyAllow, PolicyDeny, PolicyAsk:
+		return true
+	}
+	return false
+}
+
+// evaluatePolicy 返回工具调用的处理方式和命中的规则描述
+func evaluatePolicy(toolName string, arguments map[string]interface{}) (PolicyAction, string) {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	for _, rule := range policyRules {
+		if rule.matches(toolName, arguments) {
+			return rule.Action, rule.label
+		}
+	}
+	return policyDefault, "默认策略"
+}
+
+// matches 工具名和参数是否满足规则
+func (r *compiledRule) matches(toolName string, arguments map[string]interface{}) bool {
+	if ok, _ := path.Match(r.Tool, toolName); !ok {
+		return false
+	}
+	for name, re := range r.args {
+		value, ok := arguments[name]
+		if !ok || !re.MatchString(argumentString(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// argumentString 参数值的匹配文本：字符串原样，其他类型为 JSON
+func argumentString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// ApprovalRequest 等待人工批准的工具调用
+type ApprovalRequest struct {
+	Tool      string
+	Arguments map[string]interface{}
+	Rule      string // 命中的规则描述
+}
+
+// ToolApprover 请求人工批准，ctx 结束（超时或任务取消）时应返回 false
+type ToolApprover func(ctx context.Context, req ApprovalRequest) (approved bool, reason string)
+
+// PolicyAudit 一次工具调用的策略决定
+type PolicyAudit struct {
+	Tool      string
+	Arguments map[string]interface{}
+	Action    PolicyAction // 命中规则的处理方式
+	Rule      string
+	Allowed   bool   // 最终是否执行
+	Reason    string // 批准 / 拒绝的说明
+}
+
+// ToolAuditor 登记策略决定（如写入节点日志）
+type ToolAuditor func(audit PolicyAudit)
+
+// checkPolicy 按策略决定是否执行工具调用（ask 时等待批准），并登记决定；不允许时返回原因
+func checkPolicy(ctx context.Context, toolName string, arguments map[string]interface{}) (bool, string) {
+	action, rule := evaluatePolicy(toolName, arguments)
+	audit := PolicyAudit{Tool: toolName, Arguments: arguments, Action: action, Rule: rule}
+
+	switch action {
+	case PolicyAllow:
+		audit.Allowed = true
+	case PolicyDeny:
+		audit.Reason = "策略拒绝"
+	case PolicyAsk:
+		approver, _ := ctx.Value(ContextKeyToolApprover).(ToolApprover)
+		if approver == nil {
+			audit.Reason = "需要人工批准，但当前调用无法请求批准"
+			break
+		}
+		policyMu.RLock()
+		timeout := approvalTimeout
+		policyMu.RUnlock()
+
+		askCtx, cancel := context.WithTimeout(ctx, timeout)
+		audit.Allowed, audit.Reason = approver(askCtx, ApprovalRequest{Tool: toolName, Arguments: arguments, Rule: rule})
+		if !audit.Allowed && errors.Is(askCtx.Err(), context.DeadlineExceeded) {
+			audit.Reason = "等待批准超时"
+		}
+		cancel()
+	}
+
+	if auditor, ok := ctx.Value(ContextKeyToolAuditor).(ToolAuditor); ok && auditor != nil {
+		auditor(audit)
+	}
+	if audit.Allowed {
+		return true, ""
+	}
+	reason := fmt.Sprintf("tool '%s' was denied by policy (%s)", toolName, rule)
+	if audit.Reason != "" {
+		reason += ": " + audit.Reason
+	}
+	return false, reason
+}
+
+// FormatArguments 参数的紧凑 JSON 文本（超过 maxChars 时截断），用于日志和批准请求
+func FormatArguments(arguments map[string]interface{}, maxChars int) string {
+	data, _ := json.Marshal(arguments)
+	s := string(data)
+	if maxChars > 0 && len([]rune(s)) > maxChars {
+		s = string([]rune(s)[:maxChars]) + "..."
+	}
+	return s
+}
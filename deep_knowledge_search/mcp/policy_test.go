@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestPolicy 设置策略，测试结束后恢复为全部允许
+func setTestPolicy(t *testing.T, policy ToolPolicy) {
+	t.Helper()
+	if err := SetToolPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetToolPolicy(ToolPolicy{}) })
+}
+
+func TestToolPolicyRules(t *testing.T) {
+	setTestPolicy(t, ToolPolicy{
+		Rules: []PolicyRule{
+			{Tool: "saveToDisk", Args: map[string]string{"path": `^/etc/`}, Action: PolicyDeny},
+			{Tool: "saveToDisk", Action: PolicyAllow},
+			{Tool: "github__*", Action: PolicyAsk},
+			{Tool: "search", Args: map[string]string{"limit": `^[0-9]{3,}$`}, Action: PolicyDeny},
+		},
+		Default: PolicyDeny,
+	})
+
+	cases := []struct {
+		tool   string
+		args   map[string]interface{}
+		action PolicyAction
+		rule   string
+	}{
+		{"saveToDisk", map[string]interface{}{"path": "/etc/passwd"}, PolicyDeny, "规则 #1 saveToDisk path=~^/etc/"},
+		{"saveToDisk", map[string]interface{}{"path": "doc/a.md"}, PolicyAllow, "规则 #2 saveToDisk"},
+		{"github__create_issue", nil, PolicyAsk, "规则 #3 github__*"},
+		{"search", map[string]interface{}{"limit": float64(500)}, PolicyDeny, "规则 #4 search limit=~^[0-9]{3,}$"},
+		{"search", map[string]interface{}{"limit": float64(5)}, PolicyDeny, "默认策略"},
+		{"search", nil, PolicyDeny, "默认策略"},
+	}
+	for _, c := range cases {
+		action, rule := evaluatePolicy(c.tool, c.args)
+		if action != c.action || rule != c.rule {
+			t.Errorf("evaluatePolicy(%s, %v) = %s, %q; want %s, %q", c.tool, c.args, action, rule, c.action, c.rule)
+		}
+	}
+
+	for _, bad := range []ToolPolicy{
+		{Default: "maybe"},
+		{Rules: []PolicyRule{{Tool: "x", Action: "block"}}},
+		{Rules: []PolicyRule{{Tool: "[", Action: PolicyDeny}}},
+		{Rules: []PolicyRule{{Tool: "x", Args: map[string]string{"a": "("}, Action: PolicyDeny}}},
+	} {
+		if err := SetToolPolicy(bad); err == nil {
+			t.Errorf("SetToolPolicy(%+v) should fail", bad)
+		}
+	}
+	// 无效策略不影响当前策略
+	if action, _ := evaluatePolicy("saveToDisk", map[string]interface{}{"path": "/etc/x"}); action != PolicyDeny {
+		t.Errorf("policy changed after invalid SetToolPolicy: %s", action)
+	}
+}
+
+func TestToolPolicyCall(t *testing.T) {
+	RegisterTool("policyTestTool", LLMTool{}, func(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+		return MCPToolResponse{Success: true, Result: "ok"}
+	})
+	t.Cleanup(func() { UnregisterTool("policyTestTool") })
+	setTestPolicy(t, ToolPolicy{
+		Rules: []PolicyRule{
+			{Tool: "policyTestTool", Args: map[string]string{"mode": "^deny$"}, Action: PolicyDeny},
+			{Tool: "policyTestTool", Args: map[string]string{"mode": "^ask"}, Action: PolicyAsk},
+		},
+		ApprovalTimeout: 50 * time.Millisecond,
+	})
+
+	var audits []PolicyAudit
+	ctx := context.WithValue(context.Background(), ContextKeyToolAuditor, ToolAuditor(func(audit PolicyAudit) {
+		audits = append(audits, audit)
+	}))
+	call := func(ctx context.Context, mode string) MCPToolResponse {
+		return CallMCPTool(ctx, "policyTestTool", map[string]interface{}{"mode": mode})
+	}
+
+	if resp := call(ctx, "plain"); !resp.Success {
+		t.Errorf("allowed call = %+v", resp)
+	}
+	if resp := call(ctx, "deny"); resp.Success || !strings.Contains(resp.Error, "denied by policy (规则 #1") {
+		t.Errorf("denied call = %+v", resp)
+	}
+	// 没有批准函数时 ask 视为拒绝
+	if resp := call(ctx, "ask"); resp.Success || !strings.Contains(resp.Error, "无法请求批准") {
+		t.Errorf("ask without approver = %+v", resp)
+	}
+
+	approver := func(ctx context.Context, req ApprovalRequest) (bool, string) {
+		switch req.Arguments["mode"] {
+		case "ask-yes":
+			return true, "看过了"
+		case "ask-no":
+			return false, "不需要"
+		}
+		<-ctx.Done()
+		return false, ctx.Err().Error()
+	}
+	askCtx := context.WithValue(ctx, ContextKeyToolApprover, ToolApprover(approver))
+	if resp := call(askCtx, "ask-yes"); !resp.Success {
+		t.Errorf("approved call = %+v", resp)
+	}
+	if resp := call(askCtx, "ask-no"); resp.Success || !strings.Contains(resp.Error, "不需要") {
+		t.Errorf("rejected call = %+v", resp)
+	}
+	if resp := call(askCtx, "ask-wait"); resp.Success || !strings.Contains(resp.Error, "等待批准超时") {
+		t.Errorf("timed out call = %+v", resp)
+	}
+
+	want := []bool{true, false, false, true, false, false}
+	if len(audits) != len(want) {
+		t.Fatalf("got %d audits, want %d", len(audits), len(want))
+	}
+	for i, audit := range audits {
+		if audit.Allowed != want[i] {
+			t.Errorf("audit %d = %+v, want allowed=%v", i, audit, want[i])
+		}
+	}
+	if audits[3].Action != PolicyAsk || audits[3].Reason != "看过了" {
+		t.Errorf("approved audit = %+v", audits[3])
+	}
+}
+
+// TestToolPolicyBudgetBeforeApproval 节点的工具调用预算用完时直接拒绝，不再请求人工批准
+func TestToolPolicyBudgetBeforeApproval(t *testing.T) {
+	RegisterTool("policyBudgetTool", LLMTool{}, func(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+		return MCPToolResponse{Success: true, Result: "ok"}
+	})
+	t.Cleanup(func() { UnregisterTool("policyBudgetTool") })
+	setTestPolicy(t, ToolPolicy{Rules: []PolicyRule{{Tool: "policyBudgetTool", Action: PolicyAsk}}})
+
+	asked := 0
+	ctx := context.WithValue(context.Background(), ContextKeyToolApprover, ToolApprover(func(ctx context.Context, req ApprovalRequest) (bool, string) {
+		asked++
+		return true, ""
+	}))
+	ctx = context.WithValue(ctx, ContextKeyToolCallBudget, NewToolCallBudget(1))
+
+	if resp := CallMCPTool(ctx, "policyBudgetTool", nil); !resp.Success || asked != 1 {
+		t.Fatalf("first call = %+v, asked = %d", resp, asked)
+	}
+	if resp := CallMCPTool(ctx, "policyBudgetTool", nil); resp.Success || !strings.Contains(resp.Error, "budget exhausted") {
+		t.Errorf("second call = %+v", resp)
+	}
+	if asked != 1 {
+		t.Errorf("approver asked %d times, want 1", asked)
+	}
+}
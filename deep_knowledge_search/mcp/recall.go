@@ -0,0 +1,140 @@
+package mcp
+
+import (
+	"context"
+	"deepknowledgesearch/embedding"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 以往调研检索默认值
+const (
+	DefaultRecallResults = 5
+	MaxRecallResults     = 20
+	recallExcerptChars   = 1000 // 返回给 LLM 的分段最大字符数
+)
+
+// 当前语义检索索引（为 nil 时不提供 recallPriorResearch 工具）
+var (
+	recallIndex    *embedding.Index
+	recallTopK     = DefaultRecallResults
+	recallMinScore float64
+	recallMu       sync.RWMutex
+)
+
+// SetRecallIndex 设置以往调研的语义检索索引；index 为 nil 时移除 recallPriorResearch 工具
+func SetRecallIndex(index *embedding.Index, topK int, minScore float64) {
+	if topK <= 0 {
+		topK = DefaultRecallResults
+	}
+	if topK > MaxRecallResults {
+		topK = MaxRecallResults
+	}
+
+	recallMu.Lock()
+	recallIndex = index
+	recallTopK = topK
+	recallMinScore = minScore
+	recallMu.Unlock()
+
+	if index == nil {
+		UnregisterTool("recallPriorResearch")
+		return
+	}
+	RegisterTool("recallPriorResearch", recallPriorResearchTool(), recallPriorResearchHandler)
+	fmt.Println("[MCP] recallPriorResearch enabled")
+}
+
+// RecallPriorResearch 检索以往任务输出中与 query 最相关的 k 个分段（k <= 0 使用默认值）
+// 当前任务目录（ctx 中的 ContextKeyTaskDir）不参与检索；未配置索引时返回空结果
+func RecallPriorResearch(ctx context.Context, query string, k int) ([]embedding.Hit, error) {
+	recallMu.RLock()
+	index, topK, minScore := recallIndex, recallTopK, recallMinScore
+	recallMu.RUnlock()
+
+	if index == nil {
+		return nil, nil
+	}
+	if k <= 0 {
+		k = topK
+	}
+	taskDir, _ := ctx.Value(ContextKeyTaskDir).(string)
+	return index.Recall(ctx, query, k, minScore, taskDir)
+}
+
+// recallPriorResearchTool recallPriorResearch 工具定义
+func recallPriorResearchTool() LLMTool {
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "recallPriorResearch",
+			Description: "按语义检索团队以往调研任务保存的文档，返回最相关的段落及其文件和行号。开始新的调研前先用它查看已有成果，避免重复工作。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "要查找的主题或问题（自然语言）",
+					},
+					"max_results": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("返回段落数（1-%d，默认 %d）", MaxRecallResults, DefaultRecallResults),
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// recallPriorResearchHandler handles the recallPriorResearch tool call
+func recallPriorResearchHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	query, ok := arguments["query"].(string)
+	query = strings.TrimSpace(query)
+	if !ok || query == "" {
+		return MCPToolResponse{
+			Success: false,
+			Error:   "missing or invalid 'query' parameter",
+		}
+	}
+
+	k := 0
+	if n, ok := arguments["max_results"].(float64); ok && n >= 1 {
+		k = int(n)
+		if k > MaxRecallResults {
+			k = MaxRecallResults
+		}
+	}
+
+	hits, err := RecallPriorResearch(ctx, query, k)
+	if err != nil {
+		return MCPToolResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+	fmt.Printf("[MCP] recallPriorResearch %q: %d passages\n", query, len(hits))
+	if len(hits) == 0 {
+		return MCPToolResponse{
+			Success: true,
+			Result:  fmt.Sprintf("以往调研中没有与 %q 相关的内容", query),
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("以往调研中与 %q 相关的段落:\n", query))
+	for i, h := range hits {
+		label := fmt.Sprintf("%d.", i+1)
+		if id := RecordSource(ctx, Source{Title: filepath.Base(h.Chunk.Path), Path: h.Chunk.Path, Tool: "recallPriorResearch"}); id > 0 {
+			label = fmt.Sprintf("[%d]", id)
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s (相似度 %.2f)\n%s\n", label, h.Chunk.Ref(), h.Score, truncateText(h.Chunk.Text, recallExcerptChars)))
+	}
+
+	return MCPToolResponse{
+		Success: true,
+		Result:  sb.String(),
+	}
+}
@@ -0,0 +1,347 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextKeyRunCode 任务是否启用代码执行（bool）的 Context Key
+const ContextKeyRunCode contextKey = "run_code"
+
+// ContextKeyArtifactRecorder 产出文件登记函数的 Context Key
+const ContextKeyArtifactRecorder contextKey = "artifact_recorder"
+
+// runCode 默认限制
+const (
+	DefaultRunCodeTimeout     = 30 * time.Second // 单次执行的最长时间
+	DefaultRunCodeCPUTime     = 30 * time.Second // CPU 时间上限（go run 的编译也计入）
+	DefaultRunCodeMemoryMB    = 1024             // 虚拟内存上限
+	DefaultRunCodeOutputBytes = 16 << 10         // stdout / stderr 各自保留的字节数
+	DefaultRunCodeFileBytes   = 64 << 20         // 脚本写入的单个文件的最大字节数
+	DefaultRunCodeProcesses   = 256              // 沙箱中的进程（线程）数上限
+	MaxRunCodeSourceBytes     = 64 << 10         // 脚本的最大字节数
+	RunCodeSubDir             = "runs"           // 任务目录下存放执行目录的子目录
+	runCodeCacheDir           = ".cache"         // runs/ 下任务专用的构建缓存目录（go 的 GOCACHE / GOPATH），同一任务的执行之间保留
+	maxRunCodeArtifacts       = 50               // 结果中最多列出的产出文件数
+)
+
+// Interpreter 执行某种语言脚本的命令：<Command> <Args...> <脚本文件>
+type Interpreter struct {
+	Command string
+	Args    []string
+	Ext     string   // 脚本文件扩展名，如 .py
+	Mounts  []string // 沙箱中额外只读挂载的目录（如系统目录之外的解释器安装目录）
+}
+
+// BuiltinInterpreters 内置解释器（配置中同名的项覆盖内置项）
+var BuiltinInterpreters = map[string]Interpreter{
+	"go": {Command: "go", Args: []string{"run"}, Ext: ".go"},
+}
+
+// RunCodeConfig runCode 工具配置
+type RunCodeConfig struct {
+	Interpreters   map[string]Interpreter // 语言 -> 解释器，与内置解释器合并
+	Timeout        time.Duration
+	CPUTime        time.Duration
+	MemoryMB       int
+	MaxOutputBytes int
+	MaxFileBytes   int64 // 写入文件的最大字节数（RLIMIT_FSIZE），防止脚本写满宿主磁盘
+	MaxProcesses   int   // 进程数上限（RLIMIT_NPROC），防止 fork 炸弹
+}
+
+// withDefaults 填充默认值并合并内置解释器
+func (c RunCodeConfig) withDefaults() RunCodeConfig {
+	interpreters := make(map[string]Interpreter, len(BuiltinInterpreters)+len(c.Interpreters))
+	for lang, in := range BuiltinInterpreters {
+		interpreters[lang] = in
+	}
+	for lang, in := range c.Interpreters {
+		interpreters[strings.ToLower(lang)] = in
+	}
+	c.Interpreters = interpreters
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultRunCodeTimeout
+	}
+	if c.CPUTime <= 0 {
+		c.CPUTime = DefaultRunCodeCPUTime
+	}
+	if c.MemoryMB <= 0 {
+		c.MemoryMB = DefaultRunCodeMemoryMB
+	}
+	if c.MaxOutputBytes <= 0 {
+		c.MaxOutputBytes = DefaultRunCodeOutputBytes
+	}
+	if c.MaxFileBytes <= 0 {
+		c.MaxFileBytes = DefaultRunCodeFileBytes
+	}
+	if c.MaxProcesses <= 0 {
+		c.MaxProcesses = DefaultRunCodeProcesses
+	}
+	return c
+}
+
+// 当前代码执行配置
+var (
+	runCodeConfig = RunCodeConfig{}.withDefaults()
+	runCodeMu     sync.RWMutex
+)
+
+// SetRunCodeConfig 设置 runCode 工具配置并注册工具（只对 ctx 中启用了代码执行的任务可见）
+// 找不到命令的解释器不提供；没有可用解释器或无法建立隔离文件系统的沙箱时不注册工具
+func SetRunCodeConfig(cfg RunCodeConfig) {
+	cfg = cfg.withDefaults()
+	for lang, in := range cfg.Interpreters {
+		if _, err := exec.LookPath(in.Command); err != nil {
+			delete(cfg.Interpreters, lang)
+		}
+	}
+
+	runCodeMu.Lock()
+	runCodeConfig = cfg
+	runCodeMu.Unlock()
+
+	if len(cfg.Interpreters) == 0 {
+		UnregisterTool("runCode")
+		return
+	}
+	if err := sandboxAvailable(); err != nil {
+		// 不退化为无隔离执行：脚本能读到配置中的密钥和宿主上的其他文件
+		fmt.Printf("[MCP] ⚠️ 无法建立 runCode 沙箱，不提供代码执行: %v\n", err)
+		UnregisterTool("runCode")
+		return
+	}
+	RegisterGatedTool("runCode", runCodeTool(cfg), runCodeHandler, RunCodeEnabled)
+}
+
+// getRunCodeConfig 获取当前代码执行配置
+func getRunCodeConfig() RunCodeConfig {
+	runCodeMu.RLock()
+	defer runCodeMu.RUnlock()
+	return runCodeConfig
+}
+
+// RunCodeEnabled ctx 对应的任务是否启用了代码执行
+func RunCodeEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(ContextKeyRunCode).(bool)
+	return enabled
+}
+
+// ArtifactRecorder 登记工具产出的文件（路径相对任务目录）
+type ArtifactRecorder func(path string)
+
+// RecordArtifact 把产出文件登记到 ctx 中的登记函数
+func RecordArtifact(ctx context.Context, path string) {
+	if record, ok := ctx.Value(ContextKeyArtifactRecorder).(ArtifactRecorder); ok && record != nil {
+		record(path)
+	}
+}
+
+// runCodeLanguages 可用语言（排序后）
+func runCodeLanguages(cfg RunCodeConfig) []string {
+	langs := make([]string, 0, len(cfg.Interpreters))
+	for lang := range cfg.Interpreters {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// runCodeTool runCode 工具定义
+func runCodeTool(cfg RunCodeConfig) LLMTool {
+	langs := runCodeLanguages(cfg)
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name: "runCode",
+			Description: fmt.Sprintf("在隔离的临时目录中运行一段短脚本（无网络，限时 %s、内存 %d MB），返回退出码和 stdout/stderr。"+
+				"用于计算、统计、数据处理等定量子任务；脚本写入当前目录的文件会作为产出保留在任务目录中。可用语言: %s（go 需写完整的 package main）。",
+				cfg.Timeout, cfg.MemoryMB, strings.Join(langs, ", ")),
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"language": map[string]interface{}{
+						"type":        "string",
+						"enum":        langs,
+						"description": "脚本语言",
+					},
+					"code": map[string]interface{}{
+						"type":        "string",
+						"description": "完整的脚本源码",
+					},
+					"timeout_sec": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("超时（秒），不超过 %d", int(cfg.Timeout/time.Second)),
+					},
+				},
+				"required": []string{"language", "code"},
+			},
+		},
+	}
+}
+
+// limitedBuffer 只保留前 max 字节的输出
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String 输出内容，截断时注明
+func (b *limitedBuffer) String() string {
+	s := strings.ToValidUTF8(b.buf.String(), "")
+	if b.truncated {
+		s += fmt.Sprintf("\n...（输出超过 %d 字节，已截断）", b.max)
+	}
+	return s
+}
+
+// runCodeHandler 在任务目录的 runs/ 下创建执行目录，写入脚本并在沙箱中运行
+func runCodeHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	cfg := getRunCodeConfig()
+	lang, _ := stringParam(arguments, "language")
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	interpreter, ok := cfg.Interpreters[lang]
+	if !ok {
+		return fileToolError("unsupported language '%s', available: %s", lang, strings.Join(runCodeLanguages(cfg), ", "))
+	}
+	code, ok := stringParam(arguments, "code")
+	if !ok || strings.TrimSpace(code) == "" {
+		return fileToolError("missing or invalid 'code' parameter")
+	}
+	if len(code) > MaxRunCodeSourceBytes {
+		return fileToolError("code is too large (%d bytes, limit %d)", len(code), MaxRunCodeSourceBytes)
+	}
+	timeout := cfg.Timeout
+	if sec := intParam(arguments, "timeout_sec", 0); sec > 0 && time.Duration(sec)*time.Second < timeout {
+		timeout = time.Duration(sec) * time.Second
+	}
+
+	sandbox, err := newFileSandbox(ctx)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	runsDir := filepath.Join(sandbox.root, RunCodeSubDir)
+	if err := os.MkdirAll(runsDir, 0755); err != nil {
+		return fileToolError("failed to create run directory: %v", err)
+	}
+	workDir, err := os.MkdirTemp(runsDir, time.Now().Format("20060102_150405_"))
+	if err != nil {
+		return fileToolError("failed to create run directory: %v", err)
+	}
+
+	// 写入脚本（go 另需 go.mod，避免落入外层模块）
+	script := "main" + interpreter.Ext
+	inputs := map[string]string{script: code}
+	if lang == "go" {
+		inputs["go.mod"] = "module runcode\n\ngo 1.21\n"
+	}
+	for name, content := range inputs {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644); err != nil {
+			return fileToolError("failed to write script: %v", err)
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	argv := append(append([]string{interpreter.Command}, interpreter.Args...), script)
+	// 构建缓存按任务隔离：一个任务的脚本无法篡改其他任务复用的缓存
+	cacheDir := filepath.Join(runsDir, runCodeCacheDir)
+	cmd, cleanup, err := sandboxCommand(runCtx, cfg, workDir, cacheDir, interpreter.Mounts, argv)
+	if err != nil {
+		return fileToolError("%v", err)
+	}
+	defer cleanup()
+	stdout := &limitedBuffer{max: cfg.MaxOutputBytes}
+	stderr := &limitedBuffer{max: cfg.MaxOutputBytes}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	runErr := cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	// 执行目录中新增的文件作为产出登记
+	artifacts := collectArtifacts(workDir, inputs)
+	for _, path := range artifacts {
+		RecordArtifact(ctx, sandbox.rel(path))
+	}
+
+	var sb strings.Builder
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case runErr == nil:
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		exitCode = -1
+		sb.WriteString(fmt.Sprintf("执行超时（%s），进程已终止\n", timeout))
+	case ctx.Err() != nil:
+		return fileToolError("run cancelled: %v", ctx.Err())
+	case errors.As(runErr, &exitErr):
+		exitCode = exitErr.ExitCode()
+	default:
+		return fileToolError("failed to run %s: %v", interpreter.Command, runErr)
+	}
+
+	sb.WriteString(fmt.Sprintf("退出码: %d，耗时: %s，执行目录: %s\n", exitCode, elapsed, sandbox.rel(workDir)))
+	sb.WriteString("\n[stdout]\n")
+	sb.WriteString(stdout.String())
+	sb.WriteString("\n[stderr]\n")
+	sb.WriteString(stderr.String())
+	if len(artifacts) > 0 {
+		sb.WriteString("\n\n产出文件:\n")
+		for i, path := range artifacts {
+			if i == maxRunCodeArtifacts {
+				sb.WriteString(fmt.Sprintf("... 共 %d 个文件\n", len(artifacts)))
+				break
+			}
+			size := int64(0)
+			if info, err := os.Stat(path); err == nil {
+				size = info.Size()
+			}
+			sb.WriteString(fmt.Sprintf("- %s (%d 字节)\n", sandbox.rel(path), size))
+		}
+	}
+
+	fmt.Printf("[MCP] runCode %s: exit=%d, %s, %d 个产出文件\n", lang, exitCode, elapsed, len(artifacts))
+	if exitCode != 0 {
+		return MCPToolResponse{Success: false, Error: sb.String()}
+	}
+	return MCPToolResponse{Success: true, Result: sb.String()}
+}
+
+// collectArtifacts 执行目录中除输入文件外的普通文件（不跟随符号链接），按路径排序
+func collectArtifacts(workDir string, inputs map[string]string) []string {
+	var files []string
+	filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if rel, _ := filepath.Rel(workDir, path); inputs[rel] != "" {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,414 @@
+//go:build linux
+
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// sandboxSpecEnv 传给沙箱初始化进程的配置（JSON）；带有该变量启动的进程在 init 中建立隔离并执行脚本，不会运行主程序
+const sandboxSpecEnv = "DKS_RUNCODE_SANDBOX"
+
+// sandboxInitExitCode 沙箱建立失败时初始化进程的退出码
+const sandboxInitExitCode = 125
+
+// sandboxEnv 传给脚本的环境变量（不包含代理和密钥等其他变量）
+var sandboxEnv = []string{"PATH", "LANG", "LC_ALL", "TZ", "GOROOT"}
+
+// sandboxSystemPaths 只读挂载进沙箱的系统目录和文件（不存在的跳过，符号链接按原样重建）
+var sandboxSystemPaths = []string{
+	"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32",
+	"/etc/ld.so.cache", "/etc/ld.so.conf", "/etc/ld.so.conf.d", "/etc/alternatives", "/etc/localtime",
+}
+
+// sandboxDevices 绑定进沙箱 /dev 的设备
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom"}
+
+// sandboxMount 沙箱中的一个绑定挂载（沙箱内外路径相同）
+type sandboxMount struct {
+	Path     string `json:"path"`
+	Writable bool   `json:"writable,omitempty"`
+}
+
+// sandboxSpec 沙箱初始化进程的配置
+type sandboxSpec struct {
+	Root       string         `json:"root"` // 新根目录的挂载点（宿主上的空目录）
+	Dir        string         `json:"dir"`  // 脚本的工作目录
+	Mounts     []sandboxMount `json:"mounts"`
+	CPUSeconds uint64         `json:"cpu_seconds"`
+	MemoryMB   int            `json:"memory_mb"`
+	FileBytes  uint64         `json:"file_bytes"`
+	Processes  uint64         `json:"processes"`
+	Argv       []string       `json:"argv"` // 为空时只检查沙箱能否建立
+}
+
+func init() {
+	if data, ok := os.LookupEnv(sandboxSpecEnv); ok {
+		runSandboxInit(data)
+	}
+}
+
+// sandboxCommand 构建在沙箱中执行 argv 的命令：以沙箱初始化模式重新启动当前程序，
+// 在新的 user / mount / pid / network namespace 中以 tmpfs 为根，只挂载系统目录和解释器（只读）、
+// 任务的构建缓存 cacheDir 和执行目录，切换根目录后执行脚本。脚本没有网络，看不到配置文件、任务目录的其他内容和宿主的其他文件；
+// CPU 时间、虚拟内存、写入文件的大小和进程数由 rlimit 限制；进程组在超时或取消时整体终止。
+// readOnly 为额外只读挂载的目录（如解释器的安装目录）；cleanup 在命令结束后调用
+func sandboxCommand(ctx context.Context, cfg RunCodeConfig, dir, cacheDir string, readOnly []string, argv []string) (cmd *exec.Cmd, cleanup func(), err error) {
+	var mounts []sandboxMount
+	covered := make([]string, 0, len(sandboxSystemPaths))
+	for _, path := range sandboxSystemPaths {
+		mounts = append(mounts, sandboxMount{Path: path})
+		covered = append(covered, path)
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			covered = append(covered, real)
+		}
+	}
+	addReadOnly := func(path string) error {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if !pathWithinAny(real, covered) {
+			mounts = append(mounts, sandboxMount{Path: real})
+			covered = append(covered, real)
+		}
+		return nil
+	}
+
+	// 解释器：在系统目录之外时挂载其所在目录，并按真实路径执行
+	if len(argv) > 0 {
+		path, err := exec.LookPath(argv[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return nil, nil, err
+		}
+		if !pathWithinAny(filepath.Dir(path), covered) {
+			if path, err = filepath.EvalSymlinks(path); err != nil {
+				return nil, nil, err
+			}
+			if err := addReadOnly(filepath.Dir(path)); err != nil {
+				return nil, nil, err
+			}
+		}
+		argv = append([]string{path}, argv[1:]...)
+	}
+	for _, path := range readOnly {
+		if err := addReadOnly(path); err != nil {
+			return nil, nil, fmt.Errorf("sandbox mount %s: %w", path, err)
+		}
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("create sandbox cache: %w", err)
+	}
+	cacheDir, err = filepath.EvalSymlinks(cacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, nil, err
+	}
+	mounts = append(mounts, sandboxMount{Path: cacheDir, Writable: true}, sandboxMount{Path: dir, Writable: true})
+
+	root, err := os.MkdirTemp("", "dks-runcode-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create sandbox root: %w", err)
+	}
+	cleanup = func() { os.Remove(root) }
+	spec, err := json.Marshal(sandboxSpec{
+		Root:       root,
+		Dir:        dir,
+		Mounts:     mounts,
+		CPUSeconds: uint64(cfg.CPUTime / time.Second),
+		MemoryMB:   cfg.MemoryMB,
+		FileBytes:  uint64(cfg.MaxFileBytes),
+		Processes:  uint64(cfg.MaxProcesses),
+		Argv:       argv,
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	cmd = exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Dir = dir
+
+	var env []string
+	for _, key := range sandboxEnv {
+		if v, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+v)
+		}
+	}
+	// HOME 和 TMPDIR 指向沙箱内的 tmpfs；go 的构建缓存放在任务专用的目录，不写入宿主或其他任务的缓存
+	cmd.Env = append(env, "HOME=/tmp", "TMPDIR=/tmp",
+		"GOCACHE="+filepath.Join(cacheDir, "go-build"), "GOPATH="+filepath.Join(cacheDir, "go"),
+		"GOTOOLCHAIN=local", "GOPROXY=off",
+		sandboxSpecEnv+"="+string(spec))
+
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		// 初始化进程需要 CAP_SYS_ADMIN 建立挂载，执行脚本前全部放弃
+		AmbientCaps: []uintptr{capSysAdmin},
+		Setpgid:     true,
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// 子进程残留时不无限等待输出管道关闭
+	cmd.WaitDelay = time.Second
+	return cmd, cleanup, nil
+}
+
+// pathWithinAny path 是否是 dirs 中某个目录本身或其下的路径
+func pathWithinAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// 沙箱探测结果（每个进程只探测一次）
+var (
+	sandboxProbeOnce sync.Once
+	sandboxProbeErr  error
+)
+
+// sandboxAvailable 当前环境能否建立 runCode 沙箱：实际启动一次不执行脚本的沙箱，结果缓存
+func sandboxAvailable() error {
+	sandboxProbeOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "dks-runcode-probe-")
+		if err != nil {
+			sandboxProbeErr = err
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		cfg := RunCodeConfig{}.withDefaults()
+		cmd, cleanup, err := sandboxCommand(ctx, cfg, dir, filepath.Join(dir, runCodeCacheDir), nil, nil)
+		if err != nil {
+			sandboxProbeErr = err
+			return
+		}
+		defer cleanup()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			sandboxProbeErr = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+	})
+	return sandboxProbeErr
+}
+
+// runSandboxInit 沙箱初始化进程：建立隔离的文件系统后执行脚本，不返回
+func runSandboxInit(data string) {
+	// 能力和 no_new_privs 按线程生效，必须在执行 exec 的线程上设置
+	runtime.LockOSThread()
+
+	var spec sandboxSpec
+	err := json.Unmarshal([]byte(data), &spec)
+	if err == nil {
+		err = enterSandbox(spec)
+	}
+	if err == nil && len(spec.Argv) > 0 {
+		env := make([]string, 0, len(os.Environ()))
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, sandboxSpecEnv+"=") {
+				env = append(env, kv)
+			}
+		}
+		err = syscall.Exec(spec.Argv[0], spec.Argv, env)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "runCode sandbox: %v\n", err)
+		os.Exit(sandboxInitExitCode)
+	}
+	os.Exit(0)
+}
+
+// enterSandbox 在新的 mount namespace 中以 tmpfs 为根挂载允许访问的路径，切换根目录，
+// 设置资源限制并放弃全部能力
+func enterSandbox(spec sandboxSpec) error {
+	// 挂载变化不传播回宿主
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	root := spec.Root
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755,size=16m"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+
+	// /tmp 为私有 tmpfs，大小不超过内存上限
+	tmpSize := fmt.Sprintf("mode=1777,size=%dm", spec.MemoryMB)
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, tmpSize); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	// /dev 只提供少量设备；/dev/fd 等指向新的 /proc
+	for _, dev := range sandboxDevices {
+		if err := bindInto(root, sandboxMount{Path: dev, Writable: true}); err != nil {
+			return fmt.Errorf("mount %s: %w", dev, err)
+		}
+	}
+	for name, target := range map[string]string{"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2"} {
+		if err := os.Symlink(target, filepath.Join(root, "dev", name)); err != nil {
+			return err
+		}
+	}
+	// 新 pid namespace 的 /proc 只包含沙箱内的进程；无法挂载时不提供 /proc（不绑定宿主的 /proc）
+	if err := os.MkdirAll(filepath.Join(root, "proc"), 0755); err != nil {
+		return err
+	}
+	syscall.Mount("proc", filepath.Join(root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	// 允许访问的路径最后挂载，不会被上面的 /tmp 等覆盖（执行目录可能位于宿主的 /tmp 下）
+	for _, m := range spec.Mounts {
+		if err := bindInto(root, m); err != nil {
+			return fmt.Errorf("mount %s: %w", m.Path, err)
+		}
+	}
+
+	// 切换根目录并卸载原来的根，之后宿主文件系统不可达
+	if err := syscall.Chdir(root); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %w", err)
+	}
+	if err := syscall.Chdir(spec.Dir); err != nil {
+		return fmt.Errorf("chdir %s: %w", spec.Dir, err)
+	}
+
+	if spec.CPUSeconds > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: spec.CPUSeconds, Max: spec.CPUSeconds}); err != nil {
+			return fmt.Errorf("limit cpu time: %w", err)
+		}
+	}
+	if spec.MemoryMB > 0 {
+		limit := uint64(spec.MemoryMB) << 20
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("limit memory: %w", err)
+		}
+	}
+	// 执行目录是宿主上的可写目录：限制单个文件大小，避免写满磁盘
+	if spec.FileBytes > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: spec.FileBytes, Max: spec.FileBytes}); err != nil {
+			return fmt.Errorf("limit file size: %w", err)
+		}
+	}
+	// user namespace 中仍按宿主 uid 计数，限制进程数防止 fork 炸弹
+	if spec.Processes > 0 {
+		if err := syscall.Setrlimit(rlimitNproc, &syscall.Rlimit{Cur: spec.Processes, Max: spec.Processes}); err != nil {
+			return fmt.Errorf("limit processes: %w", err)
+		}
+	}
+	return dropCapabilities()
+}
+
+// bindInto 把 m.Path 绑定到 root 下的同一路径；符号链接按原样重建，不存在的路径跳过
+func bindInto(root string, m sandboxMount) error {
+	info, err := os.Lstat(m.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(root, m.Path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(m.Path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	default:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+
+	if err := syscall.Mount(m.Path, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if m.Writable {
+		return nil
+	}
+	// 重新挂载为只读时必须保留原挂载上被锁定的 nosuid/nodev/noexec 等标志
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags := uintptr(st.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME)
+	if st.Flags&stRelatime != 0 {
+		flags |= syscall.MS_RELATIME
+	}
+	return syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|flags, "")
+}
+
+// 能力和资源限制相关常量（syscall 包未导出）
+const (
+	capSysAdmin              = 21
+	rlimitNproc              = 6
+	stRelatime               = 0x1000
+	prSetNoNewPrivs          = 38
+	prCapAmbient             = 47
+	prCapAmbientClearAll     = 4
+	linuxCapabilityVersion3  = 0x20080522
+	linuxCapabilityU32Blocks = 2
+)
+
+// dropCapabilities 放弃当前线程的全部能力并禁止 exec 重新获得（脚本无法再改动挂载）
+func dropCapabilities() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("clear ambient capabilities: %w", errno)
+	}
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapabilityVersion3}
+	var data [linuxCapabilityU32Blocks]struct{ effective, permitted, inheritable uint32 }
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("drop capabilities: %w", errno)
+	}
+	return nil
+}
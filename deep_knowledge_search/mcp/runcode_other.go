@@ -0,0 +1,19 @@
+//go:build !linux
+
+package mcp
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+// sandboxAvailable 非 Linux 平台无法隔离文件系统和网络，不提供代码执行
+func sandboxAvailable() error {
+	return errors.New("runCode sandbox is only supported on Linux")
+}
+
+// sandboxCommand 非 Linux 平台不提供代码执行
+func sandboxCommand(ctx context.Context, cfg RunCodeConfig, dir, cacheDir string, readOnly []string, argv []string) (*exec.Cmd, func(), error) {
+	return nil, nil, sandboxAvailable()
+}
@@ -0,0 +1,231 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRunCode 注册带 sh 解释器的 runCode，返回启用了代码执行的任务 context 和登记的产出文件
+func useRunCode(t *testing.T, cfg RunCodeConfig) (context.Context, *[]string) {
+	t.Helper()
+	if err := sandboxAvailable(); err != nil {
+		t.Skipf("runCode sandbox unavailable: %v", err)
+	}
+	cfg.Interpreters = map[string]Interpreter{"sh": {Command: "sh", Ext: ".sh"}}
+	SetRunCodeConfig(cfg)
+	t.Cleanup(func() { UnregisterTool("runCode") })
+
+	var artifacts []string
+	ctx := context.WithValue(context.Background(), ContextKeyTaskDir, t.TempDir())
+	ctx = context.WithValue(ctx, ContextKeyRunCode, true)
+	ctx = context.WithValue(ctx, ContextKeyArtifactRecorder, ArtifactRecorder(func(path string) {
+		artifacts = append(artifacts, path)
+	}))
+
+	return ctx, &artifacts
+}
+
+func TestRunCodeGate(t *testing.T) {
+	ctx, _ := useRunCode(t, RunCodeConfig{})
+
+	hasRunCode := func(ctx context.Context) bool {
+		for _, tool := range GetLLMToolsForContext(ctx) {
+			if tool.Function.Name == "runCode" {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasRunCode(ctx) {
+		t.Error("runCode should be available when enabled for the task")
+	}
+	disabled := context.WithValue(ctx, ContextKeyRunCode, false)
+	if hasRunCode(disabled) {
+		t.Error("runCode should be hidden when not enabled for the task")
+	}
+	if resp := CallMCPTool(disabled, "runCode", map[string]interface{}{"language": "sh", "code": "true"}); resp.Success || !strings.Contains(resp.Error, "not enabled") {
+		t.Errorf("CallMCPTool(disabled) = %+v", resp)
+	}
+}
+
+func TestRunCodeShell(t *testing.T) {
+	ctx, artifacts := useRunCode(t, RunCodeConfig{})
+
+	resp := CallMCPTool(ctx, "runCode", map[string]interface{}{
+		"language": "sh",
+		"code":     "echo $((6*7)); echo warn >&2; printf 'a,b\\n1,2\\n' > out.csv; tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' ' >&2",
+	})
+	if !resp.Success {
+		t.Fatalf("runCode = %+v", resp)
+	}
+	result := resp.Result.(string)
+	// 网络隔离：network namespace 中只有 loopback
+	if !strings.Contains(result, "退出码: 0") || !strings.Contains(result, "[stdout]\n42\n") || !strings.Contains(result, "[stderr]\nwarn\nlo\n\n") {
+		t.Errorf("result = %s", result)
+	}
+	if len(*artifacts) != 1 || !strings.HasPrefix((*artifacts)[0], RunCodeSubDir+"/") || !strings.HasSuffix((*artifacts)[0], "/out.csv") {
+		t.Fatalf("artifacts = %v", *artifacts)
+	}
+
+	resp = CallMCPTool(ctx, "runCode", map[string]interface{}{"language": "sh", "code": "echo boom >&2; exit 3"})
+	if resp.Success || !strings.Contains(resp.Error, "退出码: 3") || !strings.Contains(resp.Error, "boom") {
+		t.Errorf("failing script = %+v", resp)
+	}
+
+	resp = CallMCPTool(ctx, "runCode", map[string]interface{}{"language": "sh", "code": "sleep 30", "timeout_sec": float64(1)})
+	if resp.Success || !strings.Contains(resp.Error, "执行超时") {
+		t.Errorf("timeout = %+v", resp)
+	}
+
+	if resp := CallMCPTool(ctx, "runCode", map[string]interface{}{"language": "cobol", "code": "x"}); resp.Success || !strings.Contains(resp.Error, "go, sh") {
+		t.Errorf("unknown language = %+v", resp)
+	}
+}
+
+func TestRunCodeGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	// 沙箱的构建缓存为空时首次执行需要编译标准库
+	ctx, artifacts := useRunCode(t, RunCodeConfig{Timeout: 3 * time.Minute, CPUTime: 3 * time.Minute})
+
+	code := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(6 * 7)
+	os.WriteFile("result.txt", []byte("ok"), 0644)
+}
+`
+	resp := CallMCPTool(ctx, "runCode", map[string]interface{}{"language": "go", "code": code})
+	if !resp.Success || !strings.Contains(resp.Result.(string), "[stdout]\n42\n") {
+		t.Fatalf("runCode(go) = %+v", resp)
+	}
+	if len(*artifacts) != 1 || filepath.Base((*artifacts)[0]) != "result.txt" {
+		t.Fatalf("artifacts = %v", *artifacts)
+	}
+	data, err := os.ReadFile(filepath.Join(ctx.Value(ContextKeyTaskDir).(string), (*artifacts)[0]))
+	if err != nil || string(data) != "ok" {
+		t.Errorf("artifact content = %q, %v", data, err)
+	}
+}
+
+// TestRunCodeConfinement 脚本只能看到执行目录和只读的系统目录：读不到任务目录的其他文件和宿主文件，写不了也无法重新挂载系统目录
+func TestRunCodeConfinement(t *testing.T) {
+	ctx, _ := useRunCode(t, RunCodeConfig{})
+	taskDir := ctx.Value(ContextKeyTaskDir).(string)
+	if err := os.WriteFile(filepath.Join(taskDir, "report.md"), []byte("task secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hostDir := t.TempDir()
+	hostFile := filepath.Join(hostDir, "config.json")
+	if err := os.WriteFile(hostFile, []byte(`{"api_key":"host secret"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code := strings.Join([]string{
+		"cat ../../report.md " + hostFile + " 2>/dev/null",
+		"ls " + hostDir + " >/dev/null 2>&1 && echo host-visible",
+		"touch /usr/dks-probe 2>/dev/null && echo usr-writable",
+		"touch /dks-probe 2>/dev/null && echo root-writable",
+		"echo ok > inside.txt && cat inside.txt",
+		"mount -o remount,rw /usr 2>/dev/null && echo usr-remounted",
+	}, "; ")
+	resp := CallMCPTool(ctx, "runCode", map[string]interface{}{"language": "sh", "code": code})
+	result := fmt.Sprint(resp.Result, resp.Error)
+	for _, leak := range []string{"task secret", "host secret", "host-visible", "usr-writable", "root-writable", "usr-remounted"} {
+		if strings.Contains(result, leak) {
+			t.Errorf("sandbox leaked %q: %s", leak, result)
+		}
+	}
+	// 执行目录可写
+	if !strings.Contains(result, "[stdout]\nok\n") {
+		t.Errorf("work dir should be writable: %s", result)
+	}
+	if _, err := os.Stat("/usr/dks-probe"); err == nil {
+		os.Remove("/usr/dks-probe")
+		t.Error("sandbox wrote to host /usr")
+	}
+}
+
+// TestRunCodeToolTimeout 工具调用超时时终止沙箱中的全部进程（包括脚本启动的后台进程），不会在返回后继续运行
+func TestRunCodeToolTimeout(t *testing.T) {
+	ctx, _ := useRunCode(t, RunCodeConfig{})
+	SetToolTimeouts(0, map[string]time.Duration{"runCode": 300 * time.Millisecond})
+	t.Cleanup(func() { SetToolTimeouts(0, nil) })
+
+	resp := CallMCPTool(ctx, "runCode", map[string]interface{}{
+		"language": "sh",
+		"code":     "(sleep 1; touch late.txt) & sleep 30",
+	})
+	if resp.Success || !strings.Contains(resp.Error, "timed out after 300ms") {
+		t.Fatalf("runCode = %+v", resp)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	late, _ := filepath.Glob(filepath.Join(ctx.Value(ContextKeyTaskDir).(string), RunCodeSubDir, "*", "late.txt"))
+	if len(late) != 0 {
+		t.Errorf("background process kept running after the timeout: %v", late)
+	}
+}
+
+// TestRunCodeResourceLimits 写入超过 MaxFileBytes 的文件失败，进程数受 MaxProcesses 限制
+func TestRunCodeResourceLimits(t *testing.T) {
+	ctx, artifacts := useRunCode(t, RunCodeConfig{MaxFileBytes: 1 << 20, MaxProcesses: 64})
+
+	resp := CallMCPTool(ctx, "runCode", map[string]interface{}{
+		"language": "sh",
+		"code":     "grep 'Max processes' /proc/self/limits | tr -s ' ' | cut -d' ' -f3; head -c 2097152 /dev/zero > big.bin && echo write-ok",
+	})
+	result := fmt.Sprint(resp.Result, resp.Error)
+	if !strings.Contains(result, "[stdout]\n64\n") || strings.Contains(result, "write-ok") {
+		t.Errorf("runCode = %s", result)
+	}
+	if len(*artifacts) != 1 {
+		t.Fatalf("artifacts = %v", *artifacts)
+	}
+	info, err := os.Stat(filepath.Join(ctx.Value(ContextKeyTaskDir).(string), (*artifacts)[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1<<20 {
+		t.Errorf("big.bin is %d bytes, want at most %d", info.Size(), 1<<20)
+	}
+}
+
+// TestRunCodeCachePerTask 构建缓存在任务目录下，一个任务写入的缓存对其他任务不可见，文件工具也不列出缓存
+func TestRunCodeCachePerTask(t *testing.T) {
+	ctxA, _ := useRunCode(t, RunCodeConfig{})
+	ctxB := context.WithValue(ctxA, ContextKeyTaskDir, t.TempDir())
+
+	resp := CallMCPTool(ctxA, "runCode", map[string]interface{}{"language": "sh", "code": `mkdir -p "$GOCACHE" && echo poisoned > "$GOCACHE/entry" && echo "$GOCACHE"`})
+	if !resp.Success {
+		t.Fatalf("task A = %+v", resp)
+	}
+	wantCache := filepath.Join(RunCodeSubDir, runCodeCacheDir, "go-build")
+	if result := resp.Result.(string); !strings.Contains(result, wantCache+"\n") {
+		t.Errorf("GOCACHE should be inside the task folder: %s", result)
+	}
+	if _, err := os.Stat(filepath.Join(ctxA.Value(ContextKeyTaskDir).(string), wantCache, "entry")); err != nil {
+		t.Errorf("cache entry not kept in task A: %v", err)
+	}
+
+	resp = CallMCPTool(ctxB, "runCode", map[string]interface{}{"language": "sh", "code": `cat "$GOCACHE/entry" 2>/dev/null || echo clean`})
+	if result := fmt.Sprint(resp.Result, resp.Error); !strings.Contains(result, "[stdout]\nclean\n") {
+		t.Errorf("task B sees task A's cache: %s", result)
+	}
+
+	if resp := listFilesHandler(ctxA, map[string]interface{}{"path": RunCodeSubDir}); strings.Contains(fmt.Sprint(resp.Result), runCodeCacheDir) {
+		t.Errorf("listFiles shows the build cache: %v", resp.Result)
+	}
+}
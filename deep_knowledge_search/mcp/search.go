@@ -0,0 +1,437 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置搜索后端类型
+const (
+	SearchSearXNG = "searxng" // SearXNG 实例的 JSON 接口
+	SearchBing    = "bing"    // Bing Web Search API
+	SearchBrave   = "brave"   // Brave Search API
+	SearchFixture = "fixture" // 本地 JSON 文件（测试和离线演示）
+)
+
+// 搜索参数默认值
+const (
+	DefaultSearchResults = 5
+	MaxSearchResults     = 10
+	defaultSearchTimeout = 15 * time.Second
+)
+
+// SearchResult 一条搜索结果
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// SearchBackend 搜索后端
+type SearchBackend interface {
+	// Name 返回后端名称
+	Name() string
+	// Search 执行搜索，最多返回 limit 条结果
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// SearchBackendConfig 搜索后端配置
+type SearchBackendConfig struct {
+	Type        string        // searxng / bing / brave / fixture
+	BaseURL     string        // 接口地址（bing、brave 可省略）
+	APIKey      string        // bing、brave 的订阅密钥
+	FixtureFile string        // fixture 后端的 JSON 文件
+	Timeout     time.Duration // 请求超时（0 使用默认值）
+}
+
+// NewSearchBackend 按配置创建搜索后端
+func NewSearchBackend(cfg SearchBackendConfig) (SearchBackend, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultSearchTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case SearchSearXNG:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("searxng backend requires base_url")
+		}
+		return &searxngBackend{baseURL: strings.TrimRight(cfg.BaseURL, "/"), client: client}, nil
+	case SearchBing:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("bing backend requires api_key")
+		}
+		return &bingBackend{baseURL: orDefault(cfg.BaseURL, "https://api.bing.microsoft.com/v7.0/search"), apiKey: cfg.APIKey, client: client}, nil
+	case SearchBrave:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("brave backend requires api_key")
+		}
+		return &braveBackend{baseURL: orDefault(cfg.BaseURL, "https://api.search.brave.com/res/v1/web/search"), apiKey: cfg.APIKey, client: client}, nil
+	case SearchFixture:
+		return LoadFixtureBackend(cfg.FixtureFile)
+	default:
+		return nil, fmt.Errorf("unknown search backend: %s", cfg.Type)
+	}
+}
+
+// orDefault 空字符串时返回默认值
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// ============================================================================
+// webSearch 工具
+// ============================================================================
+
+// 当前搜索配置
+var (
+	searchBackends   []SearchBackend
+	searchMaxResults = DefaultSearchResults
+	searchMu         sync.RWMutex
+)
+
+// SetSearchBackends 设置搜索后端（按顺序尝试，前一个失败时使用下一个）
+// 有后端时注册 webSearch 工具，没有时移除该工具
+func SetSearchBackends(backends []SearchBackend, maxResults int) {
+	if maxResults <= 0 {
+		maxResults = DefaultSearchResults
+	}
+	if maxResults > MaxSearchResults {
+		maxResults = MaxSearchResults
+	}
+
+	searchMu.Lock()
+	searchBackends = append([]SearchBackend(nil), backends...)
+	searchMaxResults = maxResults
+	searchMu.Unlock()
+
+	if len(backends) == 0 {
+		UnregisterTool("webSearch")
+		return
+	}
+	RegisterTool("webSearch", webSearchTool(), webSearchHandler)
+
+	names := make([]string, 0, len(backends))
+	for _, b := range backends {
+		names = append(names, b.Name())
+	}
+	fmt.Printf("[MCP] webSearch enabled: %s\n", strings.Join(names, " -> "))
+}
+
+// webSearchTool webSearch 工具定义
+func webSearchTool() LLMTool {
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        "webSearch",
+			Description: "在互联网上搜索信息，返回结果的标题、URL 和摘要。需要最新事实、数据或来源时使用，引用结果时注明 URL。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "搜索关键词",
+					},
+					"max_results": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("返回结果数（1-%d，默认 %d）", MaxSearchResults, DefaultSearchResults),
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// webSearchHandler handles the webSearch tool call
+func webSearchHandler(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+	query, ok := arguments["query"].(string)
+	query = strings.TrimSpace(query)
+	if !ok || query == "" {
+		return MCPToolResponse{
+			Success: false,
+			Error:   "missing or invalid 'query' parameter",
+		}
+	}
+
+	searchMu.RLock()
+	backends := searchBackends
+	limit := searchMaxResults
+	searchMu.RUnlock()
+
+	if n, ok := arguments["max_results"].(float64); ok && n >= 1 {
+		limit = int(n)
+		if limit > MaxSearchResults {
+			limit = MaxSearchResults
+		}
+	}
+
+	results, err := Search(ctx, backends, query, limit)
+	if err != nil {
+		return MCPToolResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
+	fmt.Printf("[MCP] webSearch %q: %d results\n", query, len(results))
+
+	// 登记来源，结果以来源编号标注，便于正文用 [n] 引用
+	var ids []int
+	for _, r := range results {
+		id := RecordSource(ctx, Source{Title: r.Title, URL: r.URL, Tool: "webSearch"})
+		if id == 0 {
+			ids = nil
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	return MCPToolResponse{
+		Success: true,
+		Result:  FormatSearchResults(query, results, ids),
+	}
+}
+
+// Search 按顺序尝试搜索后端，返回第一个成功后端的结果
+func Search(ctx context.Context, backends []SearchBackend, query string, limit int) ([]SearchResult, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no search backend configured")
+	}
+
+	var errs []string
+	for _, b := range backends {
+		results, err := b.Search(ctx, query, limit)
+		if err == nil {
+			if len(results) > limit {
+				results = results[:limit]
+			}
+			return results, nil
+		}
+		fmt.Printf("[MCP] Search backend %s failed: %v\n", b.Name(), err)
+		errs = append(errs, fmt.Sprintf("%s: %v", b.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("search failed: %s", strings.Join(errs, "; "))
+}
+
+// FormatSearchResults 把搜索结果格式化为 LLM 可读的文本
+// ids 非空时以来源编号 [n] 代替序号
+func FormatSearchResults(query string, results []SearchResult, ids []int) string {
+	if len(results) == 0 {
+		return fmt.Sprintf("搜索 %q 没有结果", query)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("搜索 %q 的结果:\n", query))
+	for i, r := range results {
+		label := fmt.Sprintf("%d.", i+1)
+		if i < len(ids) {
+			label = fmt.Sprintf("[%d]", ids[i])
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s\n   URL: %s\n", label, r.Title, r.URL))
+		if r.Snippet != "" {
+			sb.WriteString(fmt.Sprintf("   摘要: %s\n", r.Snippet))
+		}
+	}
+	return sb.String()
+}
+
+// ============================================================================
+// 后端实现
+// ============================================================================
+
+// getSearchJSON 发送 GET 请求并解析 JSON 响应
+func getSearchJSON(ctx context.Context, client *http.Client, endpoint string, header map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncateText(string(body), 200))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("parse response failed: %w", err)
+	}
+	return nil
+}
+
+// truncateText 截断文本（按字符）
+func truncateText(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen]) + "..."
+}
+
+// searxngBackend SearXNG 后端（需要实例开启 json 格式）
+type searxngBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (b *searxngBackend) Name() string { return SearchSearXNG }
+
+func (b *searxngBackend) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	endpoint := b.baseURL + "/search?" + url.Values{"q": {query}, "format": {"json"}}.Encode()
+	if err := getSearchJSON(ctx, b.client, endpoint, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, limit)
+	for _, r := range resp.Results {
+		if len(results) >= limit {
+			break
+		}
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	return results, nil
+}
+
+// bingBackend Bing Web Search API 后端
+type bingBackend struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func (b *bingBackend) Name() string { return SearchBing }
+
+func (b *bingBackend) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	var resp struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	endpoint := b.baseURL + "?" + url.Values{"q": {query}, "count": {fmt.Sprint(limit)}}.Encode()
+	if err := getSearchJSON(ctx, b.client, endpoint, map[string]string{"Ocp-Apim-Subscription-Key": b.apiKey}, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(resp.WebPages.Value))
+	for _, r := range resp.WebPages.Value {
+		results = append(results, SearchResult{Title: r.Name, URL: r.URL, Snippet: r.Snippet})
+	}
+	return results, nil
+}
+
+// braveBackend Brave Search API 后端
+type braveBackend struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func (b *braveBackend) Name() string { return SearchBrave }
+
+func (b *braveBackend) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	endpoint := b.baseURL + "?" + url.Values{"q": {query}, "count": {fmt.Sprint(limit)}}.Encode()
+	if err := getSearchJSON(ctx, b.client, endpoint, map[string]string{"X-Subscription-Token": b.apiKey}, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(resp.Web.Results))
+	for _, r := range resp.Web.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Description})
+	}
+	return results, nil
+}
+
+// FixtureBackend 固定结果后端：按查询返回预设结果，用于测试和离线演示
+// 查询不区分大小写；没有匹配的查询时返回 "*" 对应的结果
+type FixtureBackend struct {
+	results map[string][]SearchResult
+}
+
+// NewFixtureBackend 使用给定的查询结果创建 fixture 后端
+func NewFixtureBackend(results map[string][]SearchResult) *FixtureBackend {
+	normalized := make(map[string][]SearchResult, len(results))
+	for q, r := range results {
+		normalized[normalizeQuery(q)] = r
+	}
+	return &FixtureBackend{results: normalized}
+}
+
+// LoadFixtureBackend 从 JSON 文件（查询 -> 结果列表）加载 fixture 后端
+func LoadFixtureBackend(path string) (*FixtureBackend, error) {
+	if path == "" {
+		return nil, fmt.Errorf("fixture backend requires fixture_file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fixture file failed: %w", err)
+	}
+	var results map[string][]SearchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("parse fixture file failed: %w", err)
+	}
+	return NewFixtureBackend(results), nil
+}
+
+func (b *FixtureBackend) Name() string { return SearchFixture }
+
+func (b *FixtureBackend) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	results, ok := b.results[normalizeQuery(query)]
+	if !ok {
+		results = b.results["*"]
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return append([]SearchResult(nil), results...), nil
+}
+
+// normalizeQuery 规范化查询（用于 fixture 匹配）
+func normalizeQuery(q string) string {
+	return strings.ToLower(strings.Join(strings.Fields(q), " "))
+}
@@ -0,0 +1,164 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingBackend 总是失败的后端
+type failingBackend struct{}
+
+func (failingBackend) Name() string { return "failing" }
+
+func (failingBackend) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestHTTPBackends(t *testing.T) {
+	tests := []struct {
+		typ    string
+		header string
+		body   string
+	}{
+		{SearchSearXNG, "", `{"results":[{"title":"Go","url":"https://go.dev","content":"The Go language"},{"title":"Tour","url":"https://go.dev/tour","content":"A tour"}]}`},
+		{SearchBing, "Ocp-Apim-Subscription-Key", `{"webPages":{"value":[{"name":"Go","url":"https://go.dev","snippet":"The Go language"}]}}`},
+		{SearchBrave, "X-Subscription-Token", `{"web":{"results":[{"title":"Go","url":"https://go.dev","description":"The Go language"}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("q"); got != "golang" {
+					t.Errorf("q = %q, want golang", got)
+				}
+				if tt.header != "" && r.Header.Get(tt.header) != "key" {
+					t.Errorf("missing %s header", tt.header)
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			backend, err := NewSearchBackend(SearchBackendConfig{Type: tt.typ, BaseURL: srv.URL, APIKey: "key"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := backend.Search(context.Background(), "golang", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := SearchResult{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"}
+			if len(results) != 1 || results[0] != want {
+				t.Errorf("results = %+v, want [%+v]", results, want)
+			}
+		})
+	}
+}
+
+func TestHTTPBackendError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	backend, _ := NewSearchBackend(SearchBackendConfig{Type: SearchBrave, BaseURL: srv.URL, APIKey: "key"})
+	if _, err := backend.Search(context.Background(), "golang", 5); err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Errorf("err = %v, want status 429", err)
+	}
+}
+
+func TestNewSearchBackendValidation(t *testing.T) {
+	for _, cfg := range []SearchBackendConfig{
+		{Type: SearchSearXNG},
+		{Type: SearchBing},
+		{Type: SearchBrave},
+		{Type: SearchFixture},
+		{Type: "altavista"},
+	} {
+		if _, err := NewSearchBackend(cfg); err == nil {
+			t.Errorf("NewSearchBackend(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestFixtureBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.json")
+	os.WriteFile(path, []byte(`{
+		"Go Concurrency": [{"title": "Effective Go", "url": "https://go.dev/doc/effective_go", "snippet": "goroutines"}],
+		"*": [{"title": "Default", "url": "https://example.com"}]
+	}`), 0644)
+
+	backend, err := NewSearchBackend(SearchBackendConfig{Type: SearchFixture, FixtureFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ := backend.Search(context.Background(), "  go   concurrency ", 5)
+	if len(results) != 1 || results[0].Title != "Effective Go" {
+		t.Errorf("exact query results = %+v", results)
+	}
+	results, _ = backend.Search(context.Background(), "unknown", 5)
+	if len(results) != 1 || results[0].Title != "Default" {
+		t.Errorf("fallback results = %+v", results)
+	}
+}
+
+func TestWebSearchTool(t *testing.T) {
+	fixture := NewFixtureBackend(map[string][]SearchResult{
+		"go": {
+			{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"},
+			{Title: "Tour", URL: "https://go.dev/tour"},
+		},
+	})
+	SetSearchBackends([]SearchBackend{failingBackend{}, fixture}, 5)
+	t.Cleanup(func() { SetSearchBackends(nil, 0) })
+
+	if !hasTool("webSearch") {
+		t.Fatal("webSearch not registered")
+	}
+
+	resp := CallMCPTool(context.Background(), "webSearch", map[string]interface{}{"query": "go", "max_results": float64(1)})
+	if !resp.Success {
+		t.Fatalf("webSearch failed: %s", resp.Error)
+	}
+	want := "搜索 \"go\" 的结果:\n\n1. Go\n   URL: https://go.dev\n   摘要: The Go language\n"
+	if resp.Result != want {
+		t.Errorf("result = %q, want %q", resp.Result, want)
+	}
+
+	// 有来源登记函数时以来源编号标注结果
+	var recorded []Source
+	ctx := context.WithValue(context.Background(), ContextKeySourceRecorder, SourceRecorder(func(src Source) int {
+		recorded = append(recorded, src)
+		return len(recorded) + 6
+	}))
+	resp = CallMCPTool(ctx, "webSearch", map[string]interface{}{"query": "go"})
+	if !strings.Contains(resp.Result.(string), "\n[7] Go\n") || !strings.Contains(resp.Result.(string), "\n[8] Tour\n") {
+		t.Errorf("result without source ids:\n%s", resp.Result)
+	}
+	if len(recorded) != 2 || recorded[0].URL != "https://go.dev" || recorded[0].Tool != "webSearch" {
+		t.Errorf("recorded = %+v", recorded)
+	}
+
+	if resp := CallMCPTool(context.Background(), "webSearch", map[string]interface{}{}); resp.Success {
+		t.Error("webSearch without query succeeded")
+	}
+
+	SetSearchBackends(nil, 0)
+	if hasTool("webSearch") {
+		t.Error("webSearch still registered without backends")
+	}
+}
+
+// hasTool 工具是否已注册
+func hasTool(name string) bool {
+	for _, tool := range GetAvailableLLMTools() {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 外部 MCP server 默认值
+const (
+	DefaultServerTimeout = 60 * time.Second // 单次工具调用超时
+	serverConnectTimeout = 30 * time.Second // 启动、握手和列出工具的超时
+	maxToolNameLen       = 64               // LLM 函数名长度上限
+)
+
+// ServerConfig 外部 MCP server 配置（Command 与 URL 二选一）
+type ServerConfig struct {
+	Name    string            // 名称，作为工具名前缀
+	Command string            // stdio server 的启动命令
+	Args    []string          // 启动参数
+	Env     map[string]string // 额外的环境变量
+	Dir     string            // 工作目录
+	URL     string            // streamable HTTP server 的地址
+	Headers map[string]string // HTTP 请求头（如认证）
+	Timeout time.Duration     // 单次工具调用超时（0 使用默认值）
+}
+
+// externalServer 已连接的 server 及其注册的工具
+type externalServer struct {
+	client *Client
+	tools  []string // 注册到 toolRegistry 的工具名
+}
+
+// 已连接的外部 server
+var (
+	externalServers = make(map[string]*externalServer)
+	externalMu      sync.Mutex
+)
+
+// toolNameRe 工具名中不允许的字符
+var toolNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// NamespacedToolName 外部工具注册使用的名称：<server>__<tool>
+func NamespacedToolName(server, tool string) string {
+	name := toolNameRe.ReplaceAllString(server, "_") + "__" + toolNameRe.ReplaceAllString(tool, "_")
+	if len(name) > maxToolNameLen {
+		name = name[:maxToolNameLen]
+	}
+	return name
+}
+
+// SetExternalServers 断开已有的外部 server，连接配置中的 server 并注册它们的工具
+// 各 server 并发连接，总耗时不超过一次连接超时；单个 server 连接失败时跳过，不影响其他 server
+func SetExternalServers(configs []ServerConfig) {
+	CloseExternalServers()
+
+	seen := make(map[string]bool, len(configs))
+	var wg sync.WaitGroup
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			fmt.Println("[MCP] ⚠️ MCP server 缺少 name，已跳过")
+			continue
+		}
+		if seen[cfg.Name] {
+			fmt.Printf("[MCP] ⚠️ MCP server 名称重复，已跳过: %s\n", cfg.Name)
+			continue
+		}
+		seen[cfg.Name] = true
+
+		wg.Add(1)
+		go func(cfg ServerConfig) {
+			defer wg.Done()
+			if err := connectServer(cfg); err != nil {
+				fmt.Printf("[MCP] ⚠️ 连接 MCP server %s 失败: %v\n", cfg.Name, err)
+			}
+		}(cfg)
+	}
+	wg.Wait()
+}
+
+// connectServer 连接一个 server 并注册其工具
+func connectServer(cfg ServerConfig) error {
+	externalMu.Lock()
+	_, exists := externalServers[cfg.Name]
+	externalMu.Unlock()
+	if exists {
+		return fmt.Errorf("duplicate server name")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverConnectTimeout)
+	defer cancel()
+
+	client, err := Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("tools/list: %w", err)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultServerTimeout
+	}
+
+	server := &externalServer{client: client}
+	for _, tool := range tools {
+		name := NamespacedToolName(cfg.Name, tool.Name)
+		RegisterTool(name, externalToolDef(name, cfg.Name, tool), externalToolHandler(client, tool.Name, timeout))
+		server.tools = append(server.tools, name)
+	}
+
+	externalMu.Lock()
+	externalServers[cfg.Name] = server
+	externalMu.Unlock()
+
+	fmt.Printf("[MCP] ✓ 已连接 MCP server %s (%s %s): %d 个工具\n", cfg.Name, client.ServerName, client.ServerVersion, len(tools))
+	return nil
+}
+
+// externalToolDef 把外部工具描述转换为 LLM 工具定义
+func externalToolDef(name, server string, tool ToolInfo) LLMTool {
+	schema := tool.InputSchema
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	description := strings.TrimSpace(tool.Description)
+	if description == "" {
+		description = tool.Name
+	}
+	return LLMTool{
+		Type: "function",
+		Function: LLMFunction{
+			Name:        name,
+			Description: fmt.Sprintf("[%s] %s", server, description),
+			Parameters:  schema,
+		},
+	}
+}
+
+// externalToolHandler 把工具调用转发给外部 server
+func externalToolHandler(client *Client, tool string, timeout time.Duration) ToolCallback {
+	return func(ctx context.Context, arguments map[string]interface{}) MCPToolResponse {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		result, err := client.CallTool(ctx, tool, arguments)
+		if err != nil {
+			return MCPToolResponse{
+				Success: false,
+				Error:   err.Error(),
+			}
+		}
+		if result.IsError {
+			return MCPToolResponse{
+				Success: false,
+				Error:   result.Text(),
+			}
+		}
+		return MCPToolResponse{
+			Success: true,
+			Result:  result.Text(),
+		}
+	}
+}
+
+// CloseExternalServers 移除外部工具并断开所有外部 server
+func CloseExternalServers() {
+	externalMu.Lock()
+	servers := externalServers
+	externalServers = make(map[string]*externalServer)
+	externalMu.Unlock()
+
+	for name, server := range servers {
+		for _, tool := range server.tools {
+			UnregisterTool(tool)
+		}
+		server.client.Close()
+		fmt.Printf("[MCP] 已断开 MCP server %s\n", name)
+	}
+}
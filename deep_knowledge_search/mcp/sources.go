@@ -0,0 +1,53 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+)
+
+// ContextKeySourceRecorder 来源登记函数的 Context Key
+const ContextKeySourceRecorder contextKey = "source_recorder"
+
+// Source 检索类工具（搜索、网页抓取、本地文档）返回的一条来源
+type Source struct {
+	ID    int    `json:"id"` // 在所属节点内的编号，从 1 开始，对应正文中的 [n] 引用
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"`
+	Path  string `json:"path,omitempty"` // 本地文件或缓存路径
+	Tool  string `json:"tool"`
+}
+
+// Key 来源的去重键（优先使用 URL）
+func (s Source) Key() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return s.Path
+}
+
+// Label 来源在参考列表中的显示文本
+func (s Source) Label() string {
+	title := s.Title
+	if title == "" {
+		title = s.Key()
+	}
+	switch {
+	case s.URL != "" && s.URL != title:
+		return fmt.Sprintf("%s - %s", title, s.URL)
+	case s.URL == "" && s.Path != "" && s.Path != title:
+		return fmt.Sprintf("%s - %s", title, s.Path)
+	}
+	return title
+}
+
+// SourceRecorder 登记来源并返回其编号（同一来源重复登记返回相同编号）
+type SourceRecorder func(src Source) int
+
+// RecordSource 把来源登记到 ctx 中的登记函数，没有登记函数时返回 0
+func RecordSource(ctx context.Context, src Source) int {
+	record, ok := ctx.Value(ContextKeySourceRecorder).(SourceRecorder)
+	if !ok || record == nil {
+		return 0
+	}
+	return record(src)
+}
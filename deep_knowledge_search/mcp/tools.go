@@ -33,6 +33,12 @@ func RegisterDefaultTools() {
 			},
 		},
 	}, saveToDiskHandler)
+
+	// Register fetchURL tool
+	RegisterTool("fetchURL", fetchURLTool(), fetchURLHandler)
+
+	// Register task directory file tools
+	registerFileTools()
 }
 
 // saveToDiskHandler handles the saveToDisk tool call
@@ -0,0 +1,194 @@
+// Package mcpserver 以 MCP server 的形式（stdio 传输）对外提供深度搜索，供其他 agent 和 IDE 调用
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// server 信息（initialize 返回）
+const (
+	ServerName    = "deep-knowledge-search"
+	ServerVersion = "1.0.0"
+)
+
+// Server MCP server：从输入逐行读取 JSON-RPC 消息，把响应和通知逐行写到输出
+type Server struct {
+	out     io.Writer
+	writeMu sync.Mutex
+
+	// 本次会话启动的任务
+	tasks   map[string]*taskRun
+	tasksMu sync.RWMutex
+
+	// 处理中的请求（按请求 ID），收到 notifications/cancelled 时取消
+	inflight   map[string]context.CancelFunc
+	inflightMu sync.Mutex
+
+	runMu sync.Mutex     // 显示事件是全局的，deep_research 任务逐个执行以便区分进度
+	wg    sync.WaitGroup // 处理中的请求和后台任务
+}
+
+// NewServer 创建 MCP server，out 为协议输出（stdio 模式下为原始 stdout）
+func NewServer(out io.Writer) *Server {
+	return &Server{
+		out:      out,
+		tasks:    make(map[string]*taskRun),
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+// Serve 处理输入中的消息直到输入结束；结束时取消进行中的任务，并等待已收到的请求处理完毕
+func (s *Server) Serve(in io.Reader) error {
+	reader := bufio.NewReaderSize(in, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.handleLine(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.shutdown()
+			return err
+		}
+	}
+	s.shutdown()
+	return nil
+}
+
+// shutdown 取消进行中的任务，等待已收到的请求处理完毕
+func (s *Server) shutdown() {
+	s.tasksMu.RLock()
+	for _, run := range s.tasks {
+		run.executor.Cancel()
+	}
+	s.tasksMu.RUnlock()
+
+	s.wg.Wait()
+}
+
+// handleLine 处理一条输入消息
+func (s *Server) handleLine(line []byte) {
+	var msg mcp.RPCMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		s.write(mcp.NewRPCError(json.RawMessage("null"), mcp.RPCParseError, err.Error()))
+		return
+	}
+
+	switch {
+	case msg.IsNotification():
+		if msg.Method == "notifications/cancelled" {
+			var params struct {
+				RequestID json.RawMessage `json:"requestId"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			s.inflightMu.Lock()
+			if cancel, ok := s.inflight[string(params.RequestID)]; ok {
+				cancel()
+			}
+			s.inflightMu.Unlock()
+		}
+		// notifications/initialized 等其他通知无需处理
+	case msg.IsRequest():
+		key := string(msg.ID)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.inflightMu.Lock()
+		s.inflight[key] = cancel
+		s.inflightMu.Unlock()
+
+		// 请求并发处理（deep_research 可能运行很久）
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			reply := s.handle(ctx, &msg)
+
+			s.inflightMu.Lock()
+			delete(s.inflight, key)
+			s.inflightMu.Unlock()
+
+			// 被取消的请求不再响应
+			if ctx.Err() == nil {
+				s.write(reply)
+			}
+			cancel()
+		}()
+	default:
+		// 客户端的响应：server 不发起请求，忽略
+	}
+}
+
+// handle 处理一个请求
+func (s *Server) handle(ctx context.Context, msg *mcp.RPCMessage) *mcp.RPCMessage {
+	switch msg.Method {
+	case "initialize":
+		return mcp.NewRPCResult(msg.ID, map[string]interface{}{
+			"protocolVersion": mcp.ProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]interface{}{"name": ServerName, "version": ServerVersion},
+			"instructions":    "deep_research 对任务进行多层拆解、检索和整合，耗时较长；可设置 background 后用 get_task_status 查询进度。",
+		})
+	case "ping":
+		return mcp.NewRPCResult(msg.ID, map[string]interface{}{})
+	case "tools/list":
+		return mcp.NewRPCResult(msg.ID, map[string]interface{}{"tools": toolInfos()})
+	case "tools/call":
+		var params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+			Meta      struct {
+				ProgressToken json.RawMessage `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return mcp.NewRPCError(msg.ID, mcp.RPCInvalidParams, err.Error())
+		}
+		if !hasTool(params.Name) {
+			return mcp.NewRPCError(msg.ID, mcp.RPCInvalidParams, fmt.Sprintf("unknown tool: %s", params.Name))
+		}
+		if params.Arguments == nil {
+			params.Arguments = map[string]interface{}{}
+		}
+		return mcp.NewRPCResult(msg.ID, s.callTool(ctx, params.Name, params.Arguments, s.progressFunc(params.Meta.ProgressToken)))
+	}
+	return mcp.NewRPCError(msg.ID, mcp.RPCMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method))
+}
+
+// progressFunc 返回发送 notifications/progress 的函数（请求未带 progressToken 时为 nil）
+func (s *Server) progressFunc(token json.RawMessage) func(message string) {
+	if len(token) == 0 || string(token) == "null" {
+		return nil
+	}
+	var mu sync.Mutex
+	progress := 0
+	return func(message string) {
+		// 加锁直到写出，保证 progress 按顺序递增
+		mu.Lock()
+		defer mu.Unlock()
+		progress++
+		params, _ := json.Marshal(map[string]interface{}{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       message,
+		})
+		s.write(&mcp.RPCMessage{JSONRPC: "2.0", Method: "notifications/progress", Params: params})
+	}
+}
+
+// write 写出一条消息
+func (s *Server) write(msg *mcp.RPCMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.out.Write(append(data, '\n'))
+}
@@ -0,0 +1,252 @@
+package mcpserver
+
+import (
+	"deepknowledgesearch/config"
+	"deepknowledgesearch/llm"
+	"deepknowledgesearch/llm/llmtest"
+	"deepknowledgesearch/mcp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient 通过管道驱动 Server 的测试客户端
+type testClient struct {
+	t       *testing.T
+	in      *io.PipeWriter
+	out     chan *mcp.RPCMessage
+	nextID  int
+	pending []*mcp.RPCMessage // 等待响应时收到的通知
+}
+
+// chanWriter 把 server 写出的每行消息解析后送入 channel
+type chanWriter chan *mcp.RPCMessage
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	var msg mcp.RPCMessage
+	if err := json.Unmarshal(p, &msg); err != nil {
+		return 0, err
+	}
+	w <- &msg
+	return len(p), nil
+}
+
+// startServer 启动 server，测试结束时关闭输入并等待退出
+func startServer(t *testing.T) *testClient {
+	t.Helper()
+	in, inWriter := io.Pipe()
+	out := make(chan *mcp.RPCMessage, 100)
+	done := make(chan error, 1)
+	go func() { done <- NewServer(chanWriter(out)).Serve(in) }()
+	t.Cleanup(func() {
+		inWriter.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return &testClient{t: t, in: inWriter, out: out}
+}
+
+// call 发送请求并等待响应
+func (c *testClient) call(method string, params interface{}) *mcp.RPCMessage {
+	c.t.Helper()
+	c.nextID++
+	id := fmt.Sprint(c.nextID)
+	data, _ := json.Marshal(params)
+	line, _ := json.Marshal(&mcp.RPCMessage{JSONRPC: "2.0", ID: json.RawMessage(id), Method: method, Params: data})
+	if _, err := c.in.Write(append(line, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-c.out:
+			if msg.Method != "" {
+				c.pending = append(c.pending, msg)
+				continue
+			}
+			if string(msg.ID) != id {
+				c.t.Fatalf("unexpected response id %s, want %s", msg.ID, id)
+			}
+			return msg
+		case <-timeout:
+			c.t.Fatalf("%s: no response", method)
+		}
+	}
+}
+
+// callTool 调用工具并返回结果
+func (c *testClient) callTool(name string, args map[string]interface{}, meta map[string]interface{}) *mcp.ToolCallResult {
+	c.t.Helper()
+	params := map[string]interface{}{"name": name, "arguments": args}
+	if meta != nil {
+		params["_meta"] = meta
+	}
+	reply := c.call("tools/call", params)
+	if reply.Error != nil {
+		c.t.Fatalf("%s: %v", name, reply.Error)
+	}
+	var result mcp.ToolCallResult
+	if err := json.Unmarshal(reply.Result, &result); err != nil {
+		c.t.Fatal(err)
+	}
+	return &result
+}
+
+// useFakeLLM 让任务使用假 LLM：根任务不拆解，直接执行并通过验证
+func useFakeLLM(t *testing.T) {
+	t.Helper()
+	mcp.Init()
+
+	srv := llmtest.NewServer(t)
+	model := srv.Model("fake-model")
+	cfg := llm.GetConfig()
+	prevModels, prevCurrent := cfg.Models, cfg.CurrentModel
+	llm.InitWithConfig([]llm.ModelConfig{model}, model.Name)
+	llm.SetRetryPolicy(llm.RetryPolicy{})
+	t.Cleanup(func() {
+		cfg.Models, cfg.CurrentModel = prevModels, prevCurrent
+		llm.SetRetryPolicy(llm.DefaultRetryPolicy)
+	})
+
+	srv.On(llmtest.System("任务规划专家"), llmtest.JSON(map[string]interface{}{
+		"title":          "Go 并发",
+		"goal":           "说明 goroutine",
+		"execution_mode": "sequential",
+		"subtasks":       []interface{}{},
+		"reasoning":      "足够简单，直接执行",
+	}))
+	srv.On(llmtest.System("任务执行助手"), llmtest.Text("goroutine 是轻量级线程。"))
+	srv.On(llmtest.System("任务验证专家"), llmtest.Text("VERIFICATION_PASSED"))
+}
+
+func TestProtocol(t *testing.T) {
+	c := startServer(t)
+
+	reply := c.call("initialize", map[string]interface{}{"protocolVersion": mcp.ProtocolVersion, "capabilities": map[string]interface{}{}})
+	if reply.Error != nil || !strings.Contains(string(reply.Result), ServerName) {
+		t.Fatalf("initialize = %s %v", reply.Result, reply.Error)
+	}
+
+	reply = c.call("tools/list", nil)
+	var list struct {
+		Tools []mcp.ToolInfo `json:"tools"`
+	}
+	json.Unmarshal(reply.Result, &list)
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "deep_research,get_task_status,list_history,read_document" {
+		t.Errorf("tools = %s", got)
+	}
+
+	if reply := c.call("resources/list", nil); reply.Error == nil || reply.Error.Code != mcp.RPCMethodNotFound {
+		t.Errorf("unknown method reply = %+v", reply)
+	}
+	if result := c.callTool("read_document", map[string]interface{}{"path": "../config.json"}, nil); !result.IsError {
+		t.Errorf("path traversal should be rejected: %s", result.Text())
+	}
+}
+
+func TestDeepResearch(t *testing.T) {
+	cfg := config.GetConfig()
+	prevOutput := cfg.OutputDir
+	cfg.OutputDir = t.TempDir()
+	t.Cleanup(func() { cfg.OutputDir = prevOutput })
+	useFakeLLM(t)
+
+	c := startServer(t)
+	result := c.callTool("deep_research", map[string]interface{}{"task": "介绍 goroutine", "max_depth": 1}, map[string]interface{}{"progressToken": "p1"})
+	if result.IsError || !strings.Contains(result.Text(), "goroutine 是轻量级线程。") {
+		t.Fatalf("deep_research = %s", result.Text())
+	}
+
+	// 进度通知来自显示事件，progress 递增
+	progress := 0
+	for _, msg := range c.pending {
+		var params struct {
+			ProgressToken string `json:"progressToken"`
+			Progress      int    `json:"progress"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		if msg.Method != "notifications/progress" || params.ProgressToken != "p1" || params.Progress != progress+1 {
+			t.Fatalf("unexpected notification %s %s", msg.Method, msg.Params)
+		}
+		progress = params.Progress
+	}
+	if progress == 0 {
+		t.Error("no progress notifications")
+	}
+
+	// 任务 ID 与输出目录
+	var taskID, folder string
+	for _, line := range strings.Split(result.Text(), "\n") {
+		if v, ok := strings.CutPrefix(line, "任务 ID: "); ok {
+			taskID = v
+		}
+		if v, ok := strings.CutPrefix(line, "输出目录: "); ok {
+			folder = v
+		}
+	}
+	if taskID == "" || folder == "" {
+		t.Fatalf("result lacks task id or folder: %s", result.Text())
+	}
+
+	if status := c.callTool("get_task_status", map[string]interface{}{"id": taskID}, nil); !strings.Contains(status.Text(), "状态: done") {
+		t.Errorf("get_task_status = %s", status.Text())
+	}
+	if history := c.callTool("list_history", nil, nil); !strings.Contains(history.Text(), folder) {
+		t.Errorf("list_history = %s", history.Text())
+	}
+	if listing := c.callTool("read_document", map[string]interface{}{"path": folder}, nil); !strings.Contains(listing.Text(), "logs/") {
+		t.Errorf("read_document(dir) = %s", listing.Text())
+	}
+	if summary := c.callTool("read_document", map[string]interface{}{"path": filepath.Join(folder, "logs", "summary.txt"), "max_chars": 10}, nil); summary.IsError || !strings.Contains(summary.Text(), "已截断") {
+		t.Errorf("read_document(file) = %s", summary.Text())
+	}
+	if status := c.callTool("get_task_status", map[string]interface{}{"id": "missing"}, nil); !status.IsError {
+		t.Errorf("unknown task should be an error: %s", status.Text())
+	}
+}
+
+// TestReadDocumentConfinement read_document 不跟随指向输出目录之外的符号链接，大文件只读入 max_chars 对应的部分
+func TestReadDocumentConfinement(t *testing.T) {
+	cfg := config.GetConfig()
+	prevOutput := cfg.OutputDir
+	cfg.OutputDir = t.TempDir()
+	t.Cleanup(func() { cfg.OutputDir = prevOutput })
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("host secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	task := filepath.Join(cfg.OutputDir, "task")
+	os.MkdirAll(task, 0755)
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(task, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(task, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(task, "big.md"), []byte(strings.Repeat("文档", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := startServer(t)
+	for _, path := range []string{"task/link.txt", "task/linkdir", "task/linkdir/secret.txt"} {
+		if result := c.callTool("read_document", map[string]interface{}{"path": path}, nil); !result.IsError || strings.Contains(result.Text(), "host secret") {
+			t.Errorf("read_document(%s) = %s", path, result.Text())
+		}
+	}
+	result := c.callTool("read_document", map[string]interface{}{"path": "task/big.md", "max_chars": 5}, nil)
+	if result.IsError || !strings.HasPrefix(result.Text(), "文档文档文\n\n...[已截断，全文 6000 字节]") {
+		t.Errorf("read_document(big.md) = %s", result.Text())
+	}
+}
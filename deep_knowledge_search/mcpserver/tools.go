@@ -0,0 +1,492 @@
+package mcpserver
+
+import (
+	"context"
+	"deepknowledgesearch/agent"
+	"deepknowledgesearch/config"
+	"deepknowledgesearch/mcp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 工具默认值
+const (
+	DefaultHistoryLimit = 20
+	DefaultDocChars     = 50000   // read_document 默认返回的最大字符数
+	MaxDocBytes         = 8 << 20 // read_document 最多读入的字节数（max_chars 更大时也不超过）
+)
+
+// taskRun 本次会话启动的一个任务
+type taskRun struct {
+	node     *agent.TaskNode
+	executor *agent.TaskExecutor
+	started  time.Time
+	done     chan struct{} // 任务结束后关闭
+	err      error         // 任务结束后有效
+}
+
+// toolInfos tools/list 返回的工具列表
+func toolInfos() []mcp.ToolInfo {
+	return []mcp.ToolInfo{
+		{
+			Name:        "deep_research",
+			Description: "对一个调研任务进行深度搜索：自动拆解子任务、检索资料、整合结果并验证，返回带引用的结论和输出目录。耗时较长（通常数分钟）。",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"task": map[string]interface{}{
+						"type":        "string",
+						"description": "调研任务描述",
+					},
+					"max_depth": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("最大拆解深度（默认 %d）", agent.DefaultMaxDepth),
+					},
+					"budget": map[string]interface{}{
+						"type":        "object",
+						"description": "任务预算，未设置的项使用服务端默认值",
+						"properties": map[string]interface{}{
+							"max_tokens":       map[string]interface{}{"type": "integer"},
+							"max_cost":         map[string]interface{}{"type": "number"},
+							"max_duration_sec": map[string]interface{}{"type": "integer"},
+							"max_llm_calls":    map[string]interface{}{"type": "integer"},
+						},
+					},
+					"run_code": map[string]interface{}{
+						"type":        "boolean",
+						"description": "是否允许任务在沙箱中执行脚本（默认使用服务端配置）",
+					},
+					"background": map[string]interface{}{
+						"type":        "boolean",
+						"description": "为 true 时立即返回任务 ID，之后用 get_task_status 查询",
+					},
+				},
+				"required": []string{"task"},
+			},
+		},
+		{
+			Name:        "get_task_status",
+			Description: "查询任务状态和结果，id 为任务 ID 或历史任务的目录名。",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id": map[string]interface{}{
+						"type":        "string",
+						"description": "任务 ID 或目录名",
+					},
+				},
+				"required": []string{"id"},
+			},
+		},
+		{
+			Name:        "list_history",
+			Description: "列出已完成的历史任务（目录名、标题、时间、是否成功），最近的在前。",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"limit": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("最多返回条数（默认 %d）", DefaultHistoryLimit),
+					},
+				},
+			},
+		},
+		{
+			Name:        "read_document",
+			Description: "读取输出目录中的文档，path 为相对输出目录的路径（如 <任务目录>/README.md）；path 为目录时列出其内容。",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "相对输出目录的路径，空表示输出目录本身",
+					},
+					"max_chars": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("最多返回字符数（默认 %d）", DefaultDocChars),
+					},
+				},
+			},
+		},
+	}
+}
+
+// hasTool 是否为本 server 提供的工具
+func hasTool(name string) bool {
+	for _, tool := range toolInfos() {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// callTool 执行工具调用，progress 非 nil 时发送进度通知
+func (s *Server) callTool(ctx context.Context, name string, args map[string]interface{}, progress func(string)) *mcp.ToolCallResult {
+	switch name {
+	case "deep_research":
+		return s.deepResearch(ctx, args, progress)
+	case "get_task_status":
+		return s.getTaskStatus(args)
+	case "list_history":
+		return listHistory(args)
+	case "read_document":
+		return readDocument(args)
+	}
+	return errorResult("unknown tool: %s", name)
+}
+
+// deepResearch 创建并执行任务
+func (s *Server) deepResearch(ctx context.Context, args map[string]interface{}, progress func(string)) *mcp.ToolCallResult {
+	task, _ := args["task"].(string)
+	task = strings.TrimSpace(task)
+	if task == "" {
+		return errorResult("missing or invalid 'task' parameter")
+	}
+	maxDepth := intArg(args, "max_depth")
+
+	planner := agent.NewTaskPlanner()
+	node, executor := planner.NewTask(task, maxDepth)
+	if b, ok := args["budget"].(map[string]interface{}); ok {
+		budget := agent.GetDefaultBudget()
+		if n := intArg(b, "max_tokens"); n > 0 {
+			budget.MaxTokens = n
+		}
+		if v, ok := b["max_cost"].(float64); ok && v > 0 {
+			budget.MaxCost = v
+		}
+		if n := intArg(b, "max_duration_sec"); n > 0 {
+			budget.MaxDurationSec = n
+		}
+		if n := intArg(b, "max_llm_calls"); n > 0 {
+			budget.MaxLLMCalls = n
+		}
+		executor.SetBudgetLimits(budget.MaxTokens, budget.MaxCost, budget.MaxDurationSec, budget.MaxLLMCalls)
+	}
+	if runCode, ok := args["run_code"].(bool); ok {
+		executor.SetRunCode(runCode)
+	}
+
+	run := &taskRun{node: node, executor: executor, started: time.Now(), done: make(chan struct{})}
+	s.tasksMu.Lock()
+	s.tasks[node.ID] = run
+	s.tasksMu.Unlock()
+
+	if background, _ := args["background"].(bool); background {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runTask(run, nil)
+		}()
+		return textResult(fmt.Sprintf("任务已开始: %s\n任务 ID: %s\n使用 get_task_status 查询进度和结果。", node.Title, node.ID))
+	}
+
+	// 请求被取消时取消任务
+	stop := context.AfterFunc(ctx, executor.Cancel)
+	defer stop()
+
+	s.runTask(run, progress)
+	result := formatRun(run)
+	result.IsError = run.err != nil
+	return result
+}
+
+// runTask 执行任务（同一时间只执行一个），把显示事件转换为进度通知
+func (s *Server) runTask(run *taskRun, progress func(string)) {
+	defer close(run.done)
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if progress != nil {
+		remove := agent.AddDisplayListener(func(eventType string, data interface{}) {
+			if message := progressMessage(eventType, data); message != "" {
+				progress(message)
+			}
+		})
+		defer remove()
+	}
+	run.err = run.executor.Execute()
+}
+
+// progressMessage 把显示事件转换为进度说明（不需要通知的事件返回空）
+func progressMessage(eventType string, data interface{}) string {
+	m, _ := data.(map[string]interface{})
+	title, _ := m["title"].(string)
+	switch eventType {
+	case "task_start":
+		return "任务开始: " + title
+	case "node_start":
+		return "开始: " + title
+	case "node_complete":
+		return "完成: " + title
+	case "node_failed":
+		return fmt.Sprintf("失败: %s (%v)", title, m["error"])
+	case "node_skipped":
+		return "跳过: " + title
+	case "subtasks":
+		return fmt.Sprintf("拆分为 %v 个子任务", m["count"])
+	case "log":
+		message, _ := m["message"].(string)
+		return message
+	}
+	return ""
+}
+
+// getTaskStatus 查询本次会话的任务或历史任务
+func (s *Server) getTaskStatus(args map[string]interface{}) *mcp.ToolCallResult {
+	id, _ := args["id"].(string)
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return errorResult("missing or invalid 'id' parameter")
+	}
+
+	s.tasksMu.RLock()
+	run, ok := s.tasks[id]
+	s.tasksMu.RUnlock()
+	if ok {
+		return formatRun(run)
+	}
+
+	for _, h := range loadHistory() {
+		if h.folder == id || h.log.TaskID == id {
+			return textResult(formatHistoryEntry(h, true))
+		}
+	}
+	return errorResult("task not found: %s", id)
+}
+
+// formatRun 本次会话任务的状态和结果
+func formatRun(run *taskRun) *mcp.ToolCallResult {
+	finished := false
+	select {
+	case <-run.done:
+		finished = true
+	default:
+	}
+
+	node := run.node
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("任务: %s\n任务 ID: %s\n", node.Title, node.ID))
+	if folder := run.executor.TaskFolder(); folder != "" {
+		sb.WriteString(fmt.Sprintf("输出目录: %s\n", folder))
+	}
+
+	status := node.GetStatus()
+	if finished && run.err != nil {
+		status = agent.NodeFailed
+	}
+	done, total := countNodes(node)
+	usage := node.TotalUsage()
+	sb.WriteString(fmt.Sprintf("状态: %s (节点 %d/%d 已结束，已用 %s，%d tokens)\n",
+		status, done, total, time.Since(run.started).Round(time.Second), usage.TotalTokens))
+
+	switch {
+	case !finished:
+	case run.err != nil:
+		sb.WriteString(fmt.Sprintf("\n错误: %v\n", run.err))
+	case node.Result != nil:
+		sb.WriteString("\n" + node.Result.Summary + "\n")
+		sb.WriteString(formatSources(node.GetSources()))
+		if len(node.Result.Artifacts) > 0 {
+			sb.WriteString("\n产出文件（相对任务目录）:\n")
+			for _, path := range node.Result.Artifacts {
+				sb.WriteString("- " + path + "\n")
+			}
+		}
+	}
+	return textResult(sb.String())
+}
+
+// countNodes 统计已结束的节点数和节点总数
+func countNodes(node *agent.TaskNode) (done, total int) {
+	total = 1
+	switch node.GetStatus() {
+	case agent.NodeDone, agent.NodeFailed, agent.NodeCanceled, agent.NodeSkipped:
+		done = 1
+	}
+	for _, child := range node.Children {
+		d, t := countNodes(child)
+		done += d
+		total += t
+	}
+	return done, total
+}
+
+// formatSources 参考来源列表
+func formatSources(sources []mcp.Source) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n参考来源:\n")
+	for _, src := range sources {
+		sb.WriteString(fmt.Sprintf("[%d] %s\n", src.ID, src.Label()))
+	}
+	return sb.String()
+}
+
+// historyEntry 输出目录中的一个历史任务
+type historyEntry struct {
+	folder string
+	log    *agent.TaskExecutionLog
+}
+
+// loadHistory 读取输出目录中所有带执行日志的任务，最近的在前
+func loadHistory() []historyEntry {
+	outputDir := config.GetOutputDir()
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil
+	}
+
+	var history []historyEntry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		log, err := agent.LoadExecutionLog(filepath.Join(outputDir, entry.Name(), agent.LogSubDir, "execution.json"))
+		if err != nil {
+			continue
+		}
+		history = append(history, historyEntry{folder: entry.Name(), log: log})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].log.StartTime.After(history[j].log.StartTime)
+	})
+	return history
+}
+
+// formatHistoryEntry 历史任务说明，detail 为 true 时附上结果摘要和来源
+func formatHistoryEntry(h historyEntry, detail bool) string {
+	status := "失败"
+	if h.log.Success {
+		status = "成功"
+	}
+	text := fmt.Sprintf("- %s\n  标题: %s\n  任务 ID: %s\n  时间: %s\n  结果: %s，%d tokens\n",
+		h.folder, h.log.Title, h.log.TaskID, h.log.StartTime.Format("2006-01-02 15:04:05"), status, h.log.Usage.TotalTokens)
+	if detail && h.log.Result != nil {
+		text += "\n" + h.log.Result.Summary + "\n" + formatSources(h.log.Sources)
+	}
+	return text
+}
+
+// listHistory 列出历史任务
+func listHistory(args map[string]interface{}) *mcp.ToolCallResult {
+	limit := intArg(args, "limit")
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	history := loadHistory()
+	if len(history) == 0 {
+		return textResult("没有历史任务")
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("共 %d 个历史任务:\n", len(history)))
+	for i, h := range history {
+		if i >= limit {
+			sb.WriteString(fmt.Sprintf("... 其余 %d 个未列出\n", len(history)-limit))
+			break
+		}
+		sb.WriteString(formatHistoryEntry(h, false))
+	}
+	return textResult(sb.String())
+}
+
+// readDocument 读取输出目录中的文档或列出目录
+func readDocument(args map[string]interface{}) *mcp.ToolCallResult {
+	path, _ := args["path"].(string)
+	clean := filepath.Clean(strings.TrimSpace(path))
+	// 只允许访问输出目录内的路径
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return errorResult("path must be relative to the output directory: %s", path)
+	}
+	// 解析符号链接后仍须位于输出目录内（输出目录中的链接可能指向其他位置）
+	root, err := filepath.EvalSymlinks(config.GetOutputDir())
+	if err != nil {
+		return errorResult("document not found: %s", path)
+	}
+	fullPath, err := filepath.EvalSymlinks(filepath.Join(root, clean))
+	if err != nil {
+		return errorResult("document not found: %s", path)
+	}
+	if rel, err := filepath.Rel(root, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errorResult("path must be inside the output directory: %s", path)
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return errorResult("document not found: %s", path)
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return errorResult("%v", err)
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("%s:\n", filepath.ToSlash(clean)))
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() {
+				name += "/"
+			}
+			sb.WriteString("  " + name + "\n")
+		}
+		return textResult(sb.String())
+	}
+
+	maxChars := intArg(args, "max_chars")
+	if maxChars <= 0 {
+		maxChars = DefaultDocChars
+	}
+	// 先按文件大小确定读取量：只读入 max_chars 个字符可能占用的字节数，且不超过 MaxDocBytes
+	limit := int64(maxChars) * utf8.UTFMax
+	if limit > MaxDocBytes {
+		limit = MaxDocBytes
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return errorResult("%v", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit))
+	if err != nil {
+		return errorResult("%v", err)
+	}
+	text := strings.ToValidUTF8(string(data), "")
+	if runes := []rune(text); len(runes) > maxChars || info.Size() > limit {
+		if len(runes) > maxChars {
+			runes = runes[:maxChars]
+		}
+		text = string(runes) + fmt.Sprintf("\n\n...[已截断，全文 %d 字节]", info.Size())
+	}
+	return textResult(text)
+}
+
+// intArg 读取整数参数（JSON 数字解析为 float64）
+func intArg(args map[string]interface{}, key string) int {
+	if v, ok := args[key].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// textResult 文本结果
+func textResult(text string) *mcp.ToolCallResult {
+	return &mcp.ToolCallResult{Content: []mcp.ToolContent{{Type: "text", Text: text}}}
+}
+
+// errorResult 工具执行错误（isError 结果，而不是协议错误）
+func errorResult(format string, args ...interface{}) *mcp.ToolCallResult {
+	result := textResult(fmt.Sprintf(format, args...))
+	result.IsError = true
+	return result
+}
@@ -76,6 +76,10 @@ func (s *Server) Start() error {
 	http.HandleFunc("/api/task/recoverable", s.handleTaskRecoverable)
 	http.HandleFunc("/api/task/recover/", s.handleTaskRecover)
 	http.HandleFunc("/api/task/running", s.handleTaskRunning)
+	http.HandleFunc("/api/task/budget/", s.handleTaskBudget)
+	http.HandleFunc("/api/task/approvals/", s.handleTaskApprovals)
+	http.HandleFunc("/api/task/approve/", s.handleTaskApprove)
+	http.HandleFunc("/api/task/deny/", s.handleTaskDeny)
 
 	addr := fmt.Sprintf(":%d", s.port)
 	fmt.Printf("[Web] Dashboard 启动: http://localhost%s\n", addr)
@@ -179,6 +183,12 @@ func (s *Server) handleHistoryDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 用量子路由：/api/history/{id}/usage
+	if strings.HasSuffix(id, "/usage") {
+		s.handleHistoryUsage(w, strings.TrimSuffix(id, "/usage"))
+		return
+	}
+
 	// 新结构：output/{id}/logs/execution.json
 	execFile := filepath.Join("output", id, "logs", "execution.json")
 	data, err := ioutil.ReadFile(execFile)
@@ -200,6 +210,49 @@ func (s *Server) handleHistoryDetail(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(execData)
 }
 
+// handleHistoryUsage 获取历史任务的 token 用量与费用（按任务树展开）
+func (s *Server) handleHistoryUsage(w http.ResponseWriter, id string) {
+	execFile := filepath.Join("output", id, "logs", "execution.json")
+	data, err := ioutil.ReadFile(execFile)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "未找到历史记录",
+		})
+		return
+	}
+
+	var execData map[string]interface{}
+	if err := json.Unmarshal(data, &execData); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "解析历史记录失败",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(buildUsageTree(execData))
+}
+
+// buildUsageTree 从 execution.json 提取每个节点的用量
+func buildUsageTree(data map[string]interface{}) map[string]interface{} {
+	node := map[string]interface{}{
+		"task_id": data["task_id"],
+		"title":   data["title"],
+		"usage":   data["usage"],
+	}
+
+	if children, ok := data["children"].([]interface{}); ok && len(children) > 0 {
+		var childUsage []map[string]interface{}
+		for _, child := range children {
+			if childMap, ok := child.(map[string]interface{}); ok {
+				childUsage = append(childUsage, buildUsageTree(childMap))
+			}
+		}
+		node["children"] = childUsage
+	}
+
+	return node
+}
+
 // handleDocsList 列出输出文档
 func (s *Server) handleDocsList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -217,6 +270,10 @@ func (s *Server) handleDocsList(w http.ResponseWriter, r *http.Request) {
 		if relPath == "." {
 			return nil
 		}
+		// 隐藏目录（如 runCode 的构建缓存 runs/.cache）不列出
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
 
 		doc := map[string]interface{}{
 			"path":   relPath,
@@ -11,6 +11,7 @@ let logCount = 0;
 let taskData = null;
 let selectedNodeId = null;
 let collapsedNodes = new Set();
+let liveOutputs = {}; // nodeId -> { callType, text } 流式输出缓存
 
 function connect() {
     ws = new WebSocket('ws://' + location.host + '/ws');
@@ -33,6 +34,7 @@ function handleMessage(msg) {
     switch (msg.type) {
         case 'task_start':
             taskData = { title: msg.data.title, status: 'running', children: [] };
+            liveOutputs = {};
             clearLogs();
             addLog('info', '任务开始: ' + msg.data.title, msg.time);
             renderTree();
@@ -50,10 +52,11 @@ function handleMessage(msg) {
         case 'node_start':
         case 'node_complete':
         case 'node_failed':
+        case 'node_skipped':
+        case 'node_queued':
             updateTaskData(msg.data);
-            addLog(msg.type === 'node_failed' ? 'error' : 'info',
-                (msg.type === 'node_start' ? '▶ ' : msg.type === 'node_complete' ? '✓ ' : '✗ ') + msg.data.title,
-                msg.time);
+            const prefixes = { node_start: '▶ ', node_complete: '✓ ', node_failed: '✗ ', node_skipped: '⏭ ', node_queued: '⏳ ' };
+            addLog(msg.type === 'node_failed' ? 'error' : 'info', prefixes[msg.type] + msg.data.title, msg.time);
             renderTree();
             break;
         case 'tree_update':
@@ -68,6 +71,55 @@ function handleMessage(msg) {
         case 'log':
             addLog(msg.data.level, msg.data.message, msg.time);
             break;
+        case 'llm_delta':
+            appendLiveOutput(msg.data);
+            break;
+        case 'tool_approval':
+            pendingApprovals[msg.data.id] = msg.data;
+            addLog('warn', '🔐 等待批准: ' + msg.data.tool + ' (' + msg.data.node_title + ')', msg.time);
+            renderApprovals();
+            break;
+        case 'tool_approval_resolved':
+            delete pendingApprovals[msg.data.id];
+            addLog(msg.data.approved ? 'info' : 'warn', '🔐 ' + msg.data.tool + (msg.data.approved ? ' 已批准' : ' 已拒绝') + (msg.data.reason ? ': ' + msg.data.reason : ''), msg.time);
+            renderApprovals();
+            break;
+        case 'llm_stream_reset':
+            resetLiveOutput(msg.data);
+            break;
+        case 'llm_fallback':
+            addLog('warn', '↪ ' + msg.data.call_type + ': ' + msg.data.from + ' → ' + msg.data.to + ' (' + msg.data.reason + ')', msg.time);
+            break;
+    }
+}
+
+// 追加节点的流式输出，重试时（call_type 变化）重新开始
+function appendLiveOutput(data) {
+    let live = liveOutputs[data.node_id];
+    if (!live || live.callType !== data.call_type) {
+        live = { callType: data.call_type, text: '' };
+        liveOutputs[data.node_id] = live;
+    }
+    live.text += data.delta;
+
+    if (selectedNodeId === data.node_id) {
+        const el = document.getElementById('live-output');
+        if (el) {
+            el.textContent = live.text;
+            el.scrollTop = el.scrollHeight;
+        } else {
+            const node = findNode(taskData, data.node_id);
+            if (node) showNodeDetail(node);
+        }
+    }
+}
+
+// 丢弃节点已推送的流式输出（回退到其他模型重新生成）
+function resetLiveOutput(data) {
+    liveOutputs[data.node_id] = { callType: data.call_type, text: '' };
+    if (selectedNodeId === data.node_id) {
+        const el = document.getElementById('live-output');
+        if (el) el.textContent = '';
     }
 }
 
@@ -172,15 +224,27 @@ function renderNode(node, isRoot) {
         html += '<span class="node-badge">LLM: ' + node.llm_calls.length + '</span>';
     }
 
+    // 显示依赖数量
+    if (node.depends_on && node.depends_on.length > 0) {
+        html += '<span class="node-badge" title="依赖 ' + node.depends_on.length + ' 个同级任务">⛓️ ' + node.depends_on.length + '</span>';
+    }
+
     // 显示执行模式徽章（仅对有子节点的节点显示）
     if (hasChildren && node.execution_mode) {
         if (node.execution_mode === 'parallel') {
             html += '<span class="node-badge" style="background:rgba(59,130,246,0.2);color:#3b82f6">🔀 并行</span>';
+        } else if (node.execution_mode === 'dag') {
+            html += '<span class="node-badge" style="background:rgba(168,85,247,0.2);color:#a855f7">🕸️ 按依赖</span>';
         } else {
             html += '<span class="node-badge" style="background:rgba(156,163,175,0.2);color:#9ca3af">➡️ 串行</span>';
         }
     }
 
+    // 显示预算耗尽徽章
+    if (node.budget_exhausted) {
+        html += '<span class="node-badge" style="background:rgba(239,68,68,0.2);color:#ef4444" title="' + escapeHtml(node.budget_exhausted) + '">💸 预算耗尽</span>';
+    }
+
     // 显示验证徽章
     if (node.verification) {
         if (node.verification.passed) {
@@ -321,6 +385,10 @@ function showNodeDetail(node) {
             html += '<div class="info-row"><span class="info-label">总耗时:</span><span class="info-value">' + durationStr + '</span></div>';
         }
     }
+    if (node.usage && node.usage.calls > 0) {
+        html += '<div class="info-row"><span class="info-label">Token:</span><span class="info-value">' + node.usage.total_tokens + ' (输入 ' + node.usage.prompt_tokens + ' / 输出 ' + node.usage.completion_tokens + ', ' + node.usage.calls + ' 次调用)</span></div>';
+        html += '<div class="info-row"><span class="info-label">费用:</span><span class="info-value">' + node.usage.cost.toFixed(4) + '</span></div>';
+    }
     if (node.description) {
         html += '<div class="info-row"><span class="info-label">描述:</span><span class="info-value">' + escapeHtml(node.description) + '</span></div>';
     }
@@ -329,20 +397,34 @@ function showNodeDetail(node) {
     }
     html += '</div></div>';
 
+    // 流式输出（节点执行中）
+    const live = liveOutputs[node.id];
+    if (live && node.status === 'running') {
+        html += '<div class="panel-section">';
+        html += '<div class="section-title">✍️ 实时输出</div>';
+        html += '<div class="code-block live" id="live-output">' + escapeHtml(live.text) + '</div>';
+        html += '</div>';
+    }
+
     if (node.llm_calls && node.llm_calls.length > 0) {
         html += '<div class="panel-section">';
         html += '<div class="section-title">🤖 LLM 调用记录 (' + node.llm_calls.length + ')</div>';
 
-        const typeLabels = { plan: '规划', execute: '执行', synthesize: '整合', verify: '验证' };
+        const typeLabels = { plan: '规划', plan_repair: '规划修复', execute: '执行', synthesize: '整合', verify: '验证', improve: '改进' };
         node.llm_calls.forEach((call, idx) => {
             html += '<div class="llm-call">';
             html += '<div class="llm-call-header" onclick="toggleLLMCall(' + idx + ')">';
             html += '<span class="llm-type">' + (typeLabels[call.type] || call.type) + '</span>';
-            html += '<span class="llm-duration">' + call.duration_ms + 'ms</span>';
+            if (call.model) html += '<span class="llm-model">' + escapeHtml(call.model) + (call.fallback_from ? ' <span class="llm-fallback" title="' + escapeHtml(call.fallback_reason || '') + '">↪ ' + escapeHtml(call.fallback_from) + '</span>' : '') + (call.cache_hit ? ' <span class="llm-cache" title="命中响应缓存">⚡ 缓存</span>' : '') + (call.error ? ' <span class="llm-error" title="' + escapeHtml(call.error) + '">✗ 失败</span>' : '') + (call.tool_calls ? ' <span class="llm-tools">🔧 ' + call.tool_calls + '</span>' : '') + '</span>';
+            html += '<span class="llm-duration">' + (call.total_tokens ? call.total_tokens + ' tok · ' : '') + call.duration_ms + 'ms</span>';
             html += '</div>';
             html += '<div class="llm-call-body" id="llm-call-' + idx + '">';
             html += '<div class="sub-label">请求:</div>';
             html += '<div class="code-block request">' + escapeHtml(JSON.stringify(call.messages, null, 2)) + '</div>';
+            if (call.turns && call.turns.length > 0) {
+                html += '<div class="sub-label">工具调用时间线:</div>';
+                html += renderTurns(call.turns, idx);
+            }
             html += '<div class="sub-label">响应:</div>';
             html += '<div class="code-block response">' + escapeHtml(call.response) + '</div>';
             html += '</div></div>';
@@ -391,6 +473,50 @@ function toggleLLMCall(idx) {
     body.classList.toggle('open');
 }
 
+// 工具调用循环的时间线：每轮的模型回复和工具调用（点击工具调用展开参数和结果）
+function renderTurns(turns, callIdx) {
+    let html = '<div class="turn-timeline">';
+    turns.forEach((turn, t) => {
+        html += '<div class="turn">';
+        html += '<div class="turn-header">第 ' + (t + 1) + ' 轮 · ' + turn.start_time + ' · ' + turn.duration_ms + 'ms';
+        if (turn.total_tokens) html += ' · ' + turn.total_tokens + ' tok';
+        if (turn.cache_hit) html += ' · <span class="llm-cache">⚡ 缓存</span>';
+        html += '</div>';
+        if (turn.content) html += '<div class="turn-content">' + escapeHtml(turn.content) + '</div>';
+        (turn.tool_calls || []).forEach((tc, k) => {
+            const id = 'tool-call-' + callIdx + '-' + t + '-' + k;
+            html += '<div class="tool-call ' + (tc.success ? 'ok' : 'failed') + '">';
+            html += '<div class="tool-call-header" onclick="toggleToolCall(\'' + id + '\')">';
+            html += '<span class="tool-call-status">' + (tc.success ? '✓' : '✗') + '</span>';
+            html += '<span class="tool-call-name">' + escapeHtml(tc.name) + '</span>';
+            html += '<span class="llm-duration">' + tc.start_time + ' · ' + tc.duration_ms + 'ms</span>';
+            html += '</div>';
+            html += '<div class="tool-call-body" id="' + id + '">';
+            html += '<div class="sub-label">参数:</div>';
+            html += '<div class="code-block request">' + escapeHtml(formatToolArguments(tc.arguments)) + '</div>';
+            html += '<div class="sub-label">结果:</div>';
+            html += '<div class="code-block response">' + escapeHtml(tc.result) + '</div>';
+            html += '</div></div>';
+        });
+        html += '</div>';
+    });
+    return html + '</div>';
+}
+
+function toggleToolCall(id) {
+    const body = document.getElementById(id);
+    body.classList.toggle('open');
+}
+
+// 工具参数（JSON 文本）格式化显示，无法解析时原样返回
+function formatToolArguments(args) {
+    try {
+        return JSON.stringify(JSON.parse(args), null, 2);
+    } catch (e) {
+        return args;
+    }
+}
+
 function closePanel() {
     detailPanel.classList.remove('open');
     mainContent.classList.remove('panel-open');
@@ -508,6 +634,7 @@ function convertHistoryToTree(data) {
         description: data.description,
         status: data.success ? 'done' : 'failed',
         result: data.result,
+        usage: data.usage,
         children: []
     };
 
@@ -758,6 +885,54 @@ async function recoverTask(taskFolder) {
     }
 }
 
+// =========================================
+// 工具调用批准
+// =========================================
+let pendingApprovals = {}; // approvalId -> 批准请求
+
+function renderApprovals() {
+    const list = Object.values(pendingApprovals);
+    const section = document.getElementById('toolApprovals');
+    if (list.length === 0) {
+        section.style.display = 'none';
+        return;
+    }
+
+    let html = '';
+    list.forEach(a => {
+        html += '<div class="recoverable-item">';
+        html += '<div class="recoverable-info">';
+        html += '<span class="recoverable-status paused"></span>';
+        html += '<span class="recoverable-title">' + escapeHtml(a.tool) + ' · ' + escapeHtml(a.node_title) + '</span>';
+        html += '<span class="recoverable-folder" title="' + escapeHtml(a.rule) + '">' + escapeHtml(JSON.stringify(a.arguments)) + '</span>';
+        html += '</div>';
+        html += '<button class="btn btn-primary btn-small" onclick="answerApproval(\'' + a.task_id + '\', \'' + a.id + '\', true)">批准</button>';
+        html += '<button class="btn btn-danger btn-small" onclick="answerApproval(\'' + a.task_id + '\', \'' + a.id + '\', false)">拒绝</button>';
+        html += '</div>';
+    });
+    document.getElementById('approvalList').innerHTML = html;
+    section.style.display = 'block';
+}
+
+async function answerApproval(taskId, approvalId, approved) {
+    const action = approved ? 'approve' : 'deny';
+    try {
+        const response = await fetch('/api/task/' + action + '/' + encodeURIComponent(taskId) + '/' + encodeURIComponent(approvalId), {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: '{}'
+        });
+        const data = await response.json();
+        if (!data.success) {
+            alert('操作失败: ' + (data.error || data.message));
+            delete pendingApprovals[approvalId];
+            renderApprovals();
+        }
+    } catch (e) {
+        alert('操作失败: ' + e.message);
+    }
+}
+
 // 页面加载时尝试加载可恢复任务
 setTimeout(loadRecoverableTasks, 1000);
 
@@ -4,8 +4,11 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 )
@@ -17,6 +20,31 @@ type TaskExecutorInterface interface {
 	IsPaused() bool
 }
 
+// TaskBudgetInterface 支持预算控制的任务执行器
+type TaskBudgetInterface interface {
+	SetBudgetLimits(maxTokens int, maxCost float64, maxDurationSec int, maxLLMCalls int)
+	BudgetStatus() map[string]interface{}
+}
+
+// TaskApprovalInterface 支持工具调用批准的任务执行器
+type TaskApprovalInterface interface {
+	AnswerToolApproval(id string, approved bool, reason string) error
+	PendingToolApprovals() []map[string]interface{}
+}
+
+// ApprovalRequest 批准 / 拒绝请求（必须以 application/json 发送，无理由时为 {}）
+type ApprovalRequest struct {
+	Reason string `json:"reason"`
+}
+
+// BudgetRequest 预算设置请求（0 表示不限制）
+type BudgetRequest struct {
+	MaxTokens      int     `json:"max_tokens"`
+	MaxCost        float64 `json:"max_cost"`
+	MaxDurationSec int     `json:"max_duration_sec"`
+	MaxLLMCalls    int     `json:"max_llm_calls"`
+}
+
 // RecoverableTaskInfo 可恢复的任务信息（避免导入 agent 包）
 type RecoverableTaskInfo struct {
 	TaskID         string `json:"task_id"`
@@ -199,6 +227,181 @@ func (s *Server) handleTaskResume(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleTaskBudget 查询（GET）或设置（POST）运行中任务的预算
+func (s *Server) handleTaskBudget(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 从路径获取 taskID
+	taskID := strings.TrimPrefix(r.URL.Path, "/api/task/budget/")
+	if taskID == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "缺少任务ID",
+		})
+		return
+	}
+
+	// 获取执行器
+	executor, ok := GetTaskExecutor(taskID).(TaskBudgetInterface)
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "任务不存在或已完成",
+		})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req BudgetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("请求格式错误: %v", err),
+			})
+			return
+		}
+		executor.SetBudgetLimits(req.MaxTokens, req.MaxCost, req.MaxDurationSec, req.MaxLLMCalls)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"budget":  executor.BudgetStatus(),
+	})
+}
+
+// handleTaskApprovals 列出任务中等待批准的工具调用
+// 批准接口是工具执行前唯一的人工检查，不设置 CORS 头，并拒绝跨域请求
+func (s *Server) handleTaskApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := checkSameOrigin(r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	taskID := strings.TrimPrefix(r.URL.Path, "/api/task/approvals/")
+	executor, ok := GetTaskExecutor(taskID).(TaskApprovalInterface)
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "任务不存在或已完成",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"approvals": executor.PendingToolApprovals(),
+	})
+}
+
+// handleTaskApprove 批准工具调用: POST /api/task/approve/{task_id}/{approval_id}
+func (s *Server) handleTaskApprove(w http.ResponseWriter, r *http.Request) {
+	s.answerToolApproval(w, r, "/api/task/approve/", true)
+}
+
+// handleTaskDeny 拒绝工具调用: POST /api/task/deny/{task_id}/{approval_id}
+func (s *Server) handleTaskDeny(w http.ResponseWriter, r *http.Request) {
+	s.answerToolApproval(w, r, "/api/task/deny/", false)
+}
+
+// answerToolApproval 处理批准 / 拒绝请求
+// 只接受同源、Content-Type 为 application/json 且 body 可解析的 POST，
+// 使其他网页无法用简单请求（表单、无 body 的 fetch）代替操作者批准
+func (s *Server) answerToolApproval(w http.ResponseWriter, r *http.Request, prefix string, approved bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "请使用 POST",
+		})
+		return
+	}
+
+	var req ApprovalRequest
+	if err := decodeApprovalRequest(r, &req); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	taskID, approvalID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if taskID == "" || approvalID == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "缺少任务ID或请求ID",
+		})
+		return
+	}
+
+	executor, ok := GetTaskExecutor(taskID).(TaskApprovalInterface)
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "任务不存在或已完成",
+		})
+		return
+	}
+
+	if err := executor.AnswerToolApproval(approvalID, approved, req.Reason); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	message := "已批准"
+	if !approved {
+		message = "已拒绝"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": message,
+	})
+}
+
+// checkSameOrigin 拒绝 Origin 与请求 Host 不一致的浏览器跨域请求（无 Origin 的非浏览器请求放行）
+func checkSameOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("拒绝跨域请求: %s", origin)
+	}
+	return nil
+}
+
+// decodeApprovalRequest 校验批准请求的来源和格式并解析 body
+func decodeApprovalRequest(r *http.Request, req *ApprovalRequest) error {
+	if err := checkSameOrigin(r); err != nil {
+		return err
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errors.New("请求必须使用 Content-Type: application/json")
+	}
+	if r.Body == nil {
+		return errors.New("请求格式错误: 缺少 body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return fmt.Errorf("请求格式错误: %v", err)
+	}
+	return nil
+}
+
 // handleTaskRecoverable 列出可恢复的任务
 func (s *Server) handleTaskRecoverable(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeApprovals 记录批准结果的任务执行器
+type fakeApprovals struct {
+	answered []string
+}
+
+func (f *fakeApprovals) AnswerToolApproval(id string, approved bool, reason string) error {
+	f.answered = append(f.answered, id)
+	return nil
+}
+
+func (f *fakeApprovals) PendingToolApprovals() []map[string]interface{} {
+	return []map[string]interface{}{{"id": "a1"}}
+}
+
+// TestTaskApproveRejectsForgedRequests 跨域请求、无 body 或非 JSON 的批准请求被拒绝，同源 JSON 请求才生效
+func TestTaskApproveRejectsForgedRequests(t *testing.T) {
+	executor := &fakeApprovals{}
+	RegisterTaskExecutor("task-approve", executor)
+	t.Cleanup(func() { UnregisterTaskExecutor("task-approve") })
+	s := &Server{}
+
+	const path = "/api/task/approve/task-approve/a1"
+	cases := []struct {
+		name        string
+		origin      string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"no body", "", "", "", http.StatusForbidden},
+		{"form post", "", "application/x-www-form-urlencoded", "reason=x", http.StatusForbidden},
+		{"text plain", "", "text/plain", "{}", http.StatusForbidden},
+		{"json without body", "", "application/json", "", http.StatusForbidden},
+		{"cross origin", "http://evil.example", "application/json", "{}", http.StatusForbidden},
+		{"same origin", "http://localhost:8080", "application/json; charset=utf-8", `{"reason":"ok"}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor.answered = nil
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+path, strings.NewReader(tc.body))
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			rec := httptest.NewRecorder()
+			s.handleTaskApprove(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tc.wantStatus, rec.Body.String())
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+			}
+			if approved := len(executor.answered) > 0; approved != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("approval answered = %v", executor.answered)
+			}
+		})
+	}
+
+	// 跨域页面也不能读取等待中的请求 ID
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/task/approvals/task-approve", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	rec := httptest.NewRecorder()
+	s.handleTaskApprovals(rec, req)
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "a1") {
+		t.Errorf("cross-origin approvals list: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}